@@ -2,15 +2,21 @@ package bytedance
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"sync"
 	"time"
+
+	"github.com/duoland/chatapi"
 )
 
+// FeiShuLarkBaseURL is the base url of Lark, the international version of feishu, use it with chatapi.WithBaseURL
+const FeiShuLarkBaseURL = "https://open.larksuite.com"
+
 // FeiShuAppTenantAccessTokenAPI is the api to get access token
 const FeiShuAppTenantAccessTokenAPI = "https://open.feishu.cn/open-apis/auth/v3/tenant_access_token/internal/"
 
@@ -29,6 +35,21 @@ const FeiShuAppStatusOK = 0
 // See doc https://open.feishu.cn/document/ukTMukTMukTM/ugjM14COyUjL4ITN
 const FeishuCodeAccessTokenExpired = 99991663
 
+// feiShuAppOperations is the logical operation of each api seen by the interceptors
+var feiShuAppOperations = map[string]string{
+	FeiShuAppTenantAccessTokenAPI: "FeiShuApp.GetAccessToken",
+	FeiShuAppSendMessageAPI:       "FeiShuApp.SendMessage",
+	FeiShuAppCreateGroupAPI:       "FeiShuApp.CreateGroupChat",
+}
+
+// feiShuDryRunResp is the synthetic success response of the requests captured in the dry run
+const feiShuDryRunResp = `{"code":0,"msg":"ok"}`
+
+type feiShuStatusResp struct {
+	Code    int    `json:"code"`
+	Message string `json:"msg"`
+}
+
 type FeiShuAppGetTokenResp struct {
 	Code              int    `json:"code"`
 	Message           string `json:"msg"`
@@ -94,6 +115,8 @@ type FeiShuAppMessageSendResp struct {
 	Code    int                          `json:"code"`
 	Message string                       `json:"msg"`
 	Data    FeiShuAppMessageSendRespData `json:"data"`
+
+	MessageIDs []string `json:"-"` // the message ids of all the parts of the split message
 }
 
 type FeiShuAppMessageSendRespData struct {
@@ -124,36 +147,55 @@ type FeiShuAppCreateGroupRespData struct {
 // See doc https://open.feishu.cn/document/ukTMukTMukTM/uIjNz4iM2MjLyYzM
 
 type FeiShuApp struct {
-	appID            string
-	appSecret        string
-	client           *http.Client
-	tokenRefreshLock sync.RWMutex // lock to refresh the access token which can expire in a period of time
-	accessToken      string       // cached access token
-	expiredAt        time.Time    // token expire time
+	appID        string
+	appSecret    string
+	client       *http.Client
+	tokenManager *chatapi.TokenManager // cached access token refreshed ahead of the expiry
+	options      chatapi.Options
 }
 
 func (r *FeiShuApp) IsAccessTokenExpired() bool {
-	return time.Now().After(r.expiredAt)
+	return r.tokenManager.IsExpired()
+}
+
+// TokenManager returns the manager of the app access token, e.g. to inspect the current token and expiry
+func (r *FeiShuApp) TokenManager() *chatapi.TokenManager {
+	return r.tokenManager
 }
 
 // NewFeiShuApp create a new feishu app
-func NewFeiShuApp(appID, appSecret string) *FeiShuApp {
-	return NewFeiShuAppWithTimeout(appID, appSecret, FeiShuAppTimeout)
+func NewFeiShuApp(appID, appSecret string, opts ...chatapi.Option) *FeiShuApp {
+	return NewFeiShuAppWithTimeout(appID, appSecret, FeiShuAppTimeout, opts...)
 }
 
 // NewFeiShuAppWithTimeout create a new feishu app with timeout
-func NewFeiShuAppWithTimeout(appID, appSecret string, timeout time.Duration) *FeiShuApp {
+func NewFeiShuAppWithTimeout(appID, appSecret string, timeout time.Duration, opts ...chatapi.Option) *FeiShuApp {
 	client := http.Client{}
 	client.Timeout = timeout
-	return &FeiShuApp{appID: appID, appSecret: appSecret, client: &client, tokenRefreshLock: sync.RWMutex{}}
+	return NewFeiShuAppWithClient(appID, appSecret, &client, opts...)
 }
 
 // NewFeiShuAppWithClient create a new feishu app with http.Client
-func NewFeiShuAppWithClient(appID, appSecret string, client *http.Client) *FeiShuApp {
-	return &FeiShuApp{appID: appID, appSecret: appSecret, client: client, tokenRefreshLock: sync.RWMutex{}}
+func NewFeiShuAppWithClient(appID, appSecret string, client *http.Client, opts ...chatapi.Option) *FeiShuApp {
+	app := FeiShuApp{appID: appID, appSecret: appSecret, client: client, options: chatapi.NewOptions(opts...)}
+	switch {
+	case app.options.DryRun != nil:
+		// the dry run builds the requests with the placeholder token instead of fetching one
+		app.tokenManager = chatapi.NewTokenManager(chatapi.DryRunTokenFetcher)
+	case app.options.TokenStore != nil:
+		app.tokenManager = chatapi.NewTokenManagerWithStore(app.fetchAccessToken, app.options.TokenStore, app.clientKey())
+	default:
+		app.tokenManager = chatapi.NewTokenManager(app.fetchAccessToken)
+	}
+	return &app
 }
 
 func (r *FeiShuApp) CreateGroupChat(name, description string, userIDList []string, options *FeiShuAppCreateGroupOptions) (newChatID string, err error) {
+	return r.CreateGroupChatCtx(context.Background(), name, description, userIDList, options)
+}
+
+// CreateGroupChatCtx is CreateGroupChat with the context to control the request
+func (r *FeiShuApp) CreateGroupChatCtx(ctx context.Context, name, description string, userIDList []string, options *FeiShuAppCreateGroupOptions) (newChatID string, err error) {
 	createGroupReqObject := make(map[string]interface{})
 	createGroupReqObject["name"] = name
 	createGroupReqObject["description"] = description
@@ -166,18 +208,10 @@ func (r *FeiShuApp) CreateGroupChat(name, description string, userIDList []strin
 		createGroupReqObject["only_owner_edit"] = options.OnlyOwnerEdit
 	}
 	var createGroupResp FeiShuAppCreateGroupResp
-	err = r.fireRequest(http.MethodPost, FeiShuAppCreateGroupAPI, &createGroupReqObject, &createGroupResp)
+	err = r.fireRequest(ctx, http.MethodPost, FeiShuAppCreateGroupAPI, &createGroupReqObject, &createGroupResp)
 	if err != nil {
 		return
 	}
-	if createGroupResp.Code != FeiShuAppStatusOK {
-		if createGroupResp.Code == FeishuCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call feishu app create group api error, %d %s", createGroupResp.Code, createGroupResp.Message)
-		return
-	}
 	newChatID = createGroupResp.Data.ChatID
 	return
 }
@@ -185,6 +219,11 @@ func (r *FeiShuApp) CreateGroupChat(name, description string, userIDList []strin
 // See doc https://open.feishu.cn/document/ukTMukTMukTM/uUjNz4SN2MjL1YzM
 // options only support root_id now if you want to replay a specified message
 func (r *FeiShuApp) SendTextMessage(target *FeiShuAppMessageSendTarget, content string, options map[string]string) (messageResp FeiShuAppMessageSendResp, err error) {
+	return r.SendTextMessageCtx(context.Background(), target, content, options)
+}
+
+// SendTextMessageCtx is SendTextMessage with the context to control the request
+func (r *FeiShuApp) SendTextMessageCtx(ctx context.Context, target *FeiShuAppMessageSendTarget, content string, options map[string]string) (messageResp FeiShuAppMessageSendResp, err error) {
 	messageReq := FeiShuAppMessageSendReq{
 		OpenID: target.OpenID,
 		UserID: target.UserID,
@@ -198,10 +237,15 @@ func (r *FeiShuApp) SendTextMessage(target *FeiShuAppMessageSendTarget, content
 	}
 	messageReq.MessageType = FeiShuAppMessageTypeText
 	messageReq.Content = map[string]string{"text": content}
-	return r.sendMessage(&messageReq)
+	return r.sendMessage(ctx, &messageReq)
 }
 
 func (r *FeiShuApp) SendImageMessage(target *FeiShuAppMessageSendTarget, imageKey string, options map[string]string) (messageResp FeiShuAppMessageSendResp, err error) {
+	return r.SendImageMessageCtx(context.Background(), target, imageKey, options)
+}
+
+// SendImageMessageCtx is SendImageMessage with the context to control the request
+func (r *FeiShuApp) SendImageMessageCtx(ctx context.Context, target *FeiShuAppMessageSendTarget, imageKey string, options map[string]string) (messageResp FeiShuAppMessageSendResp, err error) {
 	messageReq := FeiShuAppMessageSendReq{
 		OpenID: target.OpenID,
 		UserID: target.UserID,
@@ -215,10 +259,16 @@ func (r *FeiShuApp) SendImageMessage(target *FeiShuAppMessageSendTarget, imageKe
 	}
 	messageReq.MessageType = FeiShuAppMessageTypeImage
 	messageReq.Content = map[string]string{"image_key": imageKey}
-	return r.sendMessage(&messageReq)
+	return r.sendMessage(ctx, &messageReq)
 }
 
 func (r *FeiShuApp) SendPostMessage(target *FeiShuAppMessageSendTarget, title, i18nKey string, messageLines [][]FeishuAppPostMessageContentItem,
+	options map[string]string) (messageResp FeiShuAppMessageSendResp, err error) {
+	return r.SendPostMessageCtx(context.Background(), target, title, i18nKey, messageLines, options)
+}
+
+// SendPostMessageCtx is SendPostMessage with the context to control the request
+func (r *FeiShuApp) SendPostMessageCtx(ctx context.Context, target *FeiShuAppMessageSendTarget, title, i18nKey string, messageLines [][]FeishuAppPostMessageContentItem,
 	options map[string]string) (messageResp FeiShuAppMessageSendResp, err error) {
 	messageReq := FeiShuAppMessageSendReq{
 		OpenID: target.OpenID,
@@ -240,109 +290,193 @@ func (r *FeiShuApp) SendPostMessage(target *FeiShuAppMessageSendTarget, title, i
 			},
 		},
 	}
-	return r.sendMessage(&messageReq)
+	return r.sendMessage(ctx, &messageReq)
 }
 
-func (r *FeiShuApp) refreshAccessToken() (err error) {
+func (r *FeiShuApp) fetchAccessToken(ctx context.Context) (accessToken string, expiresIn time.Duration, err error) {
 	reqBody := map[string]string{
 		"app_id":     r.appID,
 		"app_secret": r.appSecret,
 	}
 	reqBodyBytes, _ := json.Marshal(&reqBody)
-	req, newErr := http.NewRequest(http.MethodPost, FeiShuAppTenantAccessTokenAPI, bytes.NewReader(reqBodyBytes))
+	req, newErr := http.NewRequestWithContext(ctx, http.MethodPost, r.options.URL(FeiShuAppTenantAccessTokenAPI), bytes.NewReader(reqBodyBytes))
 	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
+		err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
 		return
 	}
 	req.Header.Add("Content-Type", "application/json; charset=utf-8")
-	resp, getErr := r.client.Do(req)
-	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
-		return
-	}
-	defer resp.Body.Close()
-
-	// check http code
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("feishu request error, %s", resp.Status)
-		io.Copy(ioutil.Discard, resp.Body)
-		return
-	}
-	// parse response body
-	decoder := json.NewDecoder(resp.Body)
 	var accessTokenResp FeiShuAppGetTokenResp
-	if decodeErr := decoder.Decode(&accessTokenResp); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
-		return
-	}
-	if accessTokenResp.Code != FeiShuAppStatusOK {
-		err = fmt.Errorf("call feishu app api error, %d %s", accessTokenResp.Code, accessTokenResp.Message)
+	if err = r.invoke(req, FeiShuAppTenantAccessTokenAPI, nil, &accessTokenResp); err != nil {
 		return
 	}
-	// set access token and expired at
-	r.accessToken = accessTokenResp.TenantAccessToken
-	r.expiredAt = time.Now().Add(time.Second * time.Duration(accessTokenResp.Expire))
+	accessToken = accessTokenResp.TenantAccessToken
+	expiresIn = time.Second * time.Duration(accessTokenResp.Expire)
 	return
 }
 
-func (r *FeiShuApp) fireRequest(reqMethod, reqURL string, reqBodyObject interface{}, respObject interface{}) (err error) {
-	// check the token expired or not
-	if r.accessToken == "" || r.IsAccessTokenExpired() {
-		r.tokenRefreshLock.Lock()
-		if r.accessToken == "" || r.IsAccessTokenExpired() {
-			err = r.refreshAccessToken()
+func (r *FeiShuApp) fireRequest(ctx context.Context, reqMethod, reqURL string, reqBodyObject interface{}, respObject interface{}) (err error) {
+	var reqBody []byte
+	if reqBodyObject != nil {
+		reqBody, _ = json.Marshal(reqBodyObject)
+	}
+	return r.callWithRetry(ctx, reqMethod == http.MethodGet, func(ctx context.Context, accessToken string) (err error) {
+		var reqBodyReader io.Reader
+		if reqBody != nil {
+			reqBodyReader = bytes.NewReader(reqBody)
 		}
-		r.tokenRefreshLock.Unlock()
-		if err != nil {
-			err = fmt.Errorf("refresh access token error, %s", err.Error())
+		req, newErr := http.NewRequestWithContext(ctx, reqMethod, r.options.URL(reqURL), reqBodyReader)
+		if newErr != nil {
+			err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
 			return
 		}
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		req.Header.Add("Content-Type", "application/json")
+		return r.invoke(req, reqURL, reqBodyObject, respObject)
+	})
+}
+
+// callWithRetry run the api call with the access token under the rate limit and retry it by the retry policy,
+// the idempotent call is also retried on the errors which may have reached the server
+func (r *FeiShuApp) callWithRetry(ctx context.Context, idempotent bool, call func(ctx context.Context, accessToken string) error) error {
+	return r.options.Retry.Do(ctx, idempotent, func() error {
+		if err := r.options.RateLimiter.Wait(ctx, r.clientKey()); err != nil {
+			return err
+		}
+		return r.callWithToken(ctx, call)
+	})
+}
+
+// clientKey identify the app in the token store and the rate limiter
+func (r *FeiShuApp) clientKey() string {
+	return chatapi.ProviderFeiShu + ":" + r.appID
+}
+
+// callWithToken run the api call with the access token, if the token is rejected by the server,
+// it is refreshed and the call is replayed once
+func (r *FeiShuApp) callWithToken(ctx context.Context, call func(ctx context.Context, accessToken string) error) (err error) {
+	accessToken, err := r.tokenManager.Token(ctx)
+	if err != nil {
+		err = fmt.Errorf("refresh access token error, %w", err)
+		return
 	}
-	var reqBodyReader io.Reader
-	if reqBodyObject != nil {
-		reqBody, _ := json.Marshal(reqBodyObject)
-		reqBodyReader = bytes.NewReader(reqBody)
+	err = call(ctx, accessToken)
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) || !isFeiShuTokenRejectedCode(apiErr.ErrCode) {
+		return
 	}
-	req, newErr := http.NewRequest(reqMethod, reqURL, reqBodyReader)
-	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
+	r.options.Log().InfoContext(ctx, "access token rejected, replay the call", "provider", chatapi.ProviderFeiShu,
+		"endpoint", apiErr.Endpoint, "errcode", apiErr.ErrCode)
+	r.tokenManager.Invalidate(accessToken)
+	if accessToken, err = r.tokenManager.Token(ctx); err != nil {
+		err = fmt.Errorf("replay the call error, refresh access token error, %w", err)
 		return
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", r.accessToken))
-	req.Header.Add("Content-Type", "application/json")
-	resp, getErr := r.client.Do(req)
+	// the replay is marked on the call seen by the interceptors and on the returned error
+	err = call(chatapi.WithReplay(ctx), accessToken)
+	switch {
+	case errors.As(err, &apiErr):
+		apiErr.Replayed = true
+	case err != nil:
+		err = fmt.Errorf("replay the call error, %w", err)
+	}
+	return
+}
+
+// invoke send the request through the interceptors and decode the response into respObject
+func (r *FeiShuApp) invoke(req *http.Request, endpoint string, reqObject, respObject interface{}) error {
+	call := chatapi.Call{Provider: chatapi.ProviderFeiShu, Operation: feiShuAppOperations[endpoint], Endpoint: endpoint,
+		Header: req.Header, Request: reqObject, Response: respObject, TokenRefresh: endpoint == FeiShuAppTenantAccessTokenAPI,
+		Replayed: chatapi.IsReplay(req.Context())}
+	return chatapi.Intercept(req.Context(), r.options.Interceptors, &call, func(ctx context.Context, call *chatapi.Call) error {
+		if r.options.DryRun != nil {
+			return chatapi.DryRun(r.options.DryRun, call, req, feiShuDryRunResp)
+		}
+		return doFeiShuRequest(r.client, req.WithContext(ctx), endpoint, respObject)
+	})
+}
+
+// doFeiShuRequest send the request and parse the response, the code other than ok is returned as error
+func doFeiShuRequest(client *http.Client, req *http.Request, endpoint string, respObject interface{}) (err error) {
+	resp, getErr := client.Do(req)
 	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
+		err = chatapi.NewRequestError(chatapi.ProviderFeiShu, endpoint, 0, fmt.Errorf("get response error, %w", getErr))
 		return
 	}
 	defer resp.Body.Close()
-	// check http code
+	// check http code, the gateway answers the code of the rejected tokens and rate limits with the 4xx status
 	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("feishu request error, %s", resp.Status)
+		statusErr := chatapi.NewStatusError(chatapi.ProviderFeiShu, endpoint, resp)
+		var statusResp feiShuStatusResp
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
 		io.Copy(ioutil.Discard, resp.Body)
+		if json.Unmarshal(respBody, &statusResp) != nil || statusResp.Code == FeiShuAppStatusOK {
+			err = statusErr
+			return
+		}
+		// the retry is decided by the code only, the 5xx with a code may be sent already
+		codeErr := newFeiShuCodeError(endpoint, statusResp.Code, statusResp.Message)
+		codeErr.HTTPStatus, codeErr.RetryAfter = resp.StatusCode, statusErr.RetryAfter
+		err = codeErr
 		return
 	}
 	// parse response body
-	decoder := json.NewDecoder(resp.Body)
-	if decodeErr := decoder.Decode(respObject); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
+	respBody, readErr := ioutil.ReadAll(resp.Body)
+	if readErr != nil {
+		err = chatapi.NewRequestError(chatapi.ProviderFeiShu, endpoint, 0, fmt.Errorf("get response error, %w", readErr))
+		return
+	}
+	var statusResp feiShuStatusResp
+	if decodeErr := json.Unmarshal(respBody, &statusResp); decodeErr != nil {
+		err = chatapi.NewRequestError(chatapi.ProviderFeiShu, endpoint, resp.StatusCode, fmt.Errorf("parse response error, %w", decodeErr))
+		return
+	}
+	if decodeErr := json.Unmarshal(respBody, respObject); decodeErr != nil {
+		err = chatapi.NewRequestError(chatapi.ProviderFeiShu, endpoint, resp.StatusCode, fmt.Errorf("parse response error, %w", decodeErr))
+		return
+	}
+	if statusResp.Code != FeiShuAppStatusOK {
+		err = newFeiShuCodeError(endpoint, statusResp.Code, statusResp.Message)
 		return
 	}
 	return
 }
 
-func (r *FeiShuApp) sendMessage(messageObj interface{}) (messageResp FeiShuAppMessageSendResp, err error) {
-	err = r.fireRequest(http.MethodPost, FeiShuAppSendMessageAPI, messageObj, &messageResp)
-	if err != nil {
-		return
+func (r *FeiShuApp) sendMessage(ctx context.Context, messageObj interface{}) (messageResp FeiShuAppMessageSendResp, err error) {
+	var parts []interface{}
+	switch {
+	case r.options.SplitLongMessages:
+		parts = splitFeiShuAppMessage(r.options.RecipientPolicy, messageObj)
+	case r.options.TruncateLongMessages:
+		truncateFeiShuAppMessage(&r.options, messageObj)
+	}
+	if len(parts) == 0 {
+		return r.sendSingleMessage(ctx, messageObj)
 	}
-	if messageResp.Code != FeiShuAppStatusOK {
-		if messageResp.Code == FeishuCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
+	// the message id of the last part is kept for the compatibility, the message ids of all the parts are listed
+	for i, part := range parts {
+		partResp, partErr := r.sendSingleMessage(ctx, part)
+		if partErr != nil {
+			err = fmt.Errorf("send part %d/%d error, %w", i+1, len(parts), partErr)
+			return
+		}
+		messageIDs := append(messageResp.MessageIDs, partResp.Data.MessageID)
+		messageResp = partResp
+		messageResp.MessageIDs = messageIDs
+	}
+	return
+}
+
+func (r *FeiShuApp) sendSingleMessage(ctx context.Context, messageObj interface{}) (messageResp FeiShuAppMessageSendResp, err error) {
+	if policy := r.options.RecipientPolicy; policy != nil {
+		if err = applyFeiShuAppPolicy(policy, messageObj); err != nil {
+			return
+		}
+	}
+	if !r.options.SkipValidation {
+		if err = validateFeiShuAppMessage(messageObj); err != nil {
+			return
 		}
-		err = fmt.Errorf("call feishu app message api error, %d %s", messageResp.Code, messageResp.Message)
-		return
 	}
+	err = r.fireRequest(ctx, http.MethodPost, FeiShuAppSendMessageAPI, messageObj, &messageResp)
 	return
 }
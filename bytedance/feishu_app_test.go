@@ -1,46 +1,86 @@
 package bytedance
 
 import (
-	"os"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/duoland/chatapi"
+	"github.com/duoland/chatapi/chatapitest"
 )
 
 var userID = "da6e7g5d"
 var chatID = "oc_84971ebabfe5bd9c8cb3a1cb76b6248a"
-var appID = os.Getenv("FEISHU_APP_ID")
-var appSecret = os.Getenv("FEISHU_APP_SECRET")
+var appID = "cli_a0b1c2d3e4f5"
+var appSecret = "feishu-app-secret"
 var groupName = "一个不简单的测试群"
 var groupDescription = "我就是一个机器人创建的测试群"
 var groupUserIDList = []string{"da6e7g5d"}
 
+// newTestFeiShuApp create the app on a fake feishu server closed with the test
+func newTestFeiShuApp(t *testing.T, opts ...chatapi.Option) (*FeiShuApp, *chatapitest.Server) {
+	server := chatapitest.NewFeiShuServer()
+	t.Cleanup(server.Close)
+	server.SetCredential(appID, appSecret)
+	opts = append([]chatapi.Option{chatapi.WithBaseURL(server.URL)}, opts...)
+	return NewFeiShuApp(appID, appSecret, opts...), server
+}
+
+// expectFields check the fields of the json body of the last request to the api
+func expectFields(t *testing.T, server *chatapitest.Server, api string, fields map[string]interface{}) {
+	t.Helper()
+	req, ok := server.LastRequest(api)
+	if !ok {
+		t.Fatalf("expect a request to %s", api)
+	}
+	for path, expect := range fields {
+		if value := req.Field(path); fmt.Sprint(value) != fmt.Sprint(expect) {
+			t.Errorf("expect %s to be %v, got %v", path, expect, value)
+		}
+	}
+}
+
 func TestFeiShuApp_CreateGroupChat(t *testing.T) {
-	feishuApp := NewFeiShuApp(appID, appSecret)
+	feishuApp, server := newTestFeiShuApp(t)
 
 	chatId, err := feishuApp.CreateGroupChat(groupName, groupDescription, groupUserIDList, nil)
 	if err != nil {
 		t.Fatal(err.Error())
 		return
 	}
-	t.Logf("Chat ID: %s", chatId)
+	group, ok := server.Group(chatId)
+	if !ok || group.Name != groupName || group.Description != groupDescription || fmt.Sprint(group.Users) != fmt.Sprint(groupUserIDList) {
+		t.Fatalf("unexpected group %s, %+v", chatId, group)
+	}
 }
 
 func TestFeiShuApp_SendTextMessage(t *testing.T) {
-	feishuApp := NewFeiShuApp(appID, appSecret)
+	feishuApp, server := newTestFeiShuApp(t)
 	target := FeiShuAppMessageSendTarget{
 		//UserID: userID,
 		ChatID: chatID,
 	}
 	content := "<h1>hello master<h1><a href='https://www.baidu.com'>baidu</a>"
 	resp, err := feishuApp.SendTextMessage(&target, content, nil)
-	t.Logf("%v\n", resp)
 	if err != nil {
 		t.Fatal(err.Error())
 		return
 	}
+	if resp.Data.MessageID == "" {
+		t.Fatalf("expect the message id, got %v", resp)
+	}
+	expectFields(t, server, FeiShuAppSendMessageAPI, map[string]interface{}{"chat_id": chatID, "user_id": nil,
+		"msg_type": "text", "content.text": content})
+	if req, _ := server.LastRequest(FeiShuAppSendMessageAPI); req.Header.Get("Authorization") != "Bearer token-1" {
+		t.Fatalf("expect the tenant access token sent, got %v", req.Header)
+	}
 }
 
 func TestFeiShuApp_SendPostMessage(t *testing.T) {
-	feishuApp := NewFeiShuApp(appID, appSecret)
+	feishuApp, server := newTestFeiShuApp(t)
 	title := "hello, i am a robot"
 	target := FeiShuAppMessageSendTarget{
 		//UserID: userID,
@@ -61,10 +101,110 @@ func TestFeiShuApp_SendPostMessage(t *testing.T) {
 			},
 		},
 	}
-	resp, err := feishuApp.SendPostMessage(&target, title, FeiShuAppI18nChinese, postItems, nil)
-	t.Logf("%v\n", resp)
+	_, err := feishuApp.SendPostMessage(&target, title, FeiShuAppI18nChinese, postItems, nil)
 	if err != nil {
 		t.Fatal(err.Error())
 		return
 	}
+	expectFields(t, server, FeiShuAppSendMessageAPI, map[string]interface{}{"msg_type": "post",
+		"content.post.zh_cn.title": title, "content.post.zh_cn.content.1.0.user_id": userID})
+}
+
+func TestFeiShuApp_ReplayOnTokenRejected(t *testing.T) {
+	feishuApp, server := newTestFeiShuApp(t)
+	if _, err := feishuApp.SendTextMessage(&FeiShuAppMessageSendTarget{ChatID: chatID}, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	// the token cached by the client is expired by the server
+	server.ExpireTokens()
+	resp, err := feishuApp.SendTextMessage(&FeiShuAppMessageSendTarget{ChatID: chatID}, "hello, master", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenCalls, messageCalls := server.TokenCount(), len(server.RequestsTo(FeiShuAppSendMessageAPI))
+	if resp.Data.MessageID == "" || tokenCalls != 2 || messageCalls != 3 {
+		t.Fatalf("expect the token refreshed and message replayed, got %d token calls and %d message calls", tokenCalls, messageCalls)
+	}
+}
+
+func TestFeiShuApp_StatusErrorCode(t *testing.T) {
+	feishuApp, server := newTestFeiShuApp(t, chatapi.WithRetryPolicy(chatapi.RetryPolicy{}))
+	server.Fail(FeiShuAppSendMessageAPI, chatapitest.Fault{HTTPStatus: http.StatusBadRequest, ErrCode: FeishuCodeMessageRateLimit,
+		ErrMessage: "message rate limit"})
+
+	_, err := feishuApp.SendTextMessage(&FeiShuAppMessageSendTarget{ChatID: chatID}, "hello, master", nil)
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expect the api error, got %v", err)
+	}
+	if apiErr.ErrCode != FeishuCodeMessageRateLimit || apiErr.HTTPStatus != http.StatusBadRequest || !apiErr.Retryable {
+		t.Fatalf("expect the rate limit code decoded from the 400 response, got %+v", apiErr)
+	}
+}
+
+func TestFeiShuApp_ServerErrorCodeNotRetried(t *testing.T) {
+	feishuApp, server := newTestFeiShuApp(t, chatapi.WithRetryPolicy(chatapi.RetryPolicy{MaxAttempts: 3}))
+	server.Fail(FeiShuAppSendMessageAPI, chatapitest.Fault{HTTPStatus: http.StatusInternalServerError, ErrCode: 1000,
+		ErrMessage: "internal error"})
+
+	if _, err := feishuApp.SendTextMessage(&FeiShuAppMessageSendTarget{ChatID: chatID}, "hello, master", nil); err == nil {
+		t.Fatal("expect the internal error")
+	}
+	if count := len(server.RequestsTo(FeiShuAppSendMessageAPI)); count != 1 {
+		t.Fatalf("expect the message maybe sent not sent again, got %d requests", count)
+	}
+}
+
+func TestFeiShuApp_BaseURL(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, "/lark/open-apis/auth/") {
+			w.Write([]byte(`{"code":0,"msg":"ok","tenant_access_token":"token","expire":7200}`))
+			return
+		}
+		w.Write([]byte(`{"code":0,"msg":"ok","data":{"message_id":"om_1"}}`))
+	}))
+	defer server.Close()
+	feishuApp := NewFeiShuApp(appID, appSecret, chatapi.WithBaseURL(server.URL+"/lark"))
+
+	if _, err := feishuApp.SendTextMessage(&FeiShuAppMessageSendTarget{ChatID: chatID}, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	expectPaths := []string{"/lark/open-apis/auth/v3/tenant_access_token/internal/", "/lark/open-apis/message/v4/send/"}
+	if fmt.Sprint(paths) != fmt.Sprint(expectPaths) {
+		t.Fatalf("expect the requests sent to %v, got %v", expectPaths, paths)
+	}
+}
+
+func TestFeiShuApp_Metrics(t *testing.T) {
+	metrics := chatapi.NewExpvarMetrics("chatapi_feishu_test")
+	feishuApp, server := newTestFeiShuApp(t, chatapi.WithMetrics(metrics), chatapi.WithRetryPolicy(chatapi.RetryPolicy{}))
+	server.Fail(FeiShuAppSendMessageAPI, chatapitest.Fault{ErrCode: FeishuCodeMessageRateLimit, ErrMessage: "message rate limit"})
+
+	if _, err := feishuApp.SendTextMessage(&FeiShuAppMessageSendTarget{ChatID: chatID}, "hello, master", nil); err == nil {
+		t.Fatal("expect the rate limit error")
+	}
+	if count := metrics.Calls(chatapi.ProviderFeiShu, "FeiShuApp.SendMessage", "11232"); count != 1 {
+		t.Fatalf("expect the failed send counted, got %d", count)
+	}
+	if count := metrics.TokenRefreshes(chatapi.ProviderFeiShu, "0"); count != 1 {
+		t.Fatalf("expect the token refresh counted, got %d", count)
+	}
+}
+
+func TestFeiShuApp_DryRun(t *testing.T) {
+	recorder := chatapi.NewDryRunRecorder()
+	app, server := newTestFeiShuApp(t, chatapi.WithDryRun(recorder))
+	if _, err := app.SendTextMessage(&FeiShuAppMessageSendTarget{ChatID: chatID}, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect no request sent in the dry run, got %d", len(server.Requests()))
+	}
+	requests := recorder.Requests()
+	if len(requests) != 1 || requests[0].Operation != "FeiShuApp.SendMessage" || requests[0].URL != server.URL+"/open-apis/message/v4/send/" ||
+		requests[0].Header.Get("Authorization") != "Bearer REDACTED" || !strings.Contains(string(requests[0].Body), `"msg_type":"text"`) {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
 }
@@ -0,0 +1,41 @@
+package bytedance
+
+import (
+	"context"
+	"strings"
+
+	"github.com/duoland/chatapi"
+)
+
+// NewFeiShuCommandHandler returns the OnMessageReceived func running the commands of the text messages to the bot,
+// the messages in the p2p chats or mentioning the bot need no prefix. The bot open id is the open id of the bot of
+// the app, the mentions of the other users are not the mentions of the bot. The replies are sent to the chat by the app.
+// The sender id is the user id of the sender, or the open id without the permission of the user ids.
+func NewFeiShuCommandHandler(router *chatapi.CommandRouter, app *FeiShuApp, botOpenID string) func(ctx context.Context, event *FeiShuMessageReceivedEvent) error {
+	return func(ctx context.Context, event *FeiShuMessageReceivedEvent) error {
+		if event.MessageType != FeiShuAppMessageTypeText {
+			return nil
+		}
+		text, mentioned := event.Text, event.ChatType == FeiShuChatTypeP2P
+		for _, mention := range event.Mentions {
+			if botOpenID != "" && mention.ID.OpenID == botOpenID {
+				text, mentioned = strings.ReplaceAll(text, mention.Key, ""), true
+			} else {
+				text = strings.ReplaceAll(text, mention.Key, "@"+mention.Name)
+			}
+		}
+		request := chatapi.CommandRequest{Provider: chatapi.ProviderFeiShu, SenderID: event.Sender.UserID, Text: text,
+			Mentioned: mentioned, Message: event}
+		if request.SenderID == "" {
+			request.SenderID = event.Sender.OpenID
+		}
+		if event.ChatType == FeiShuChatTypeGroup {
+			request.ChatID = event.ChatID
+		}
+		router.Serve(ctx, &request, func(ctx context.Context, content string) error {
+			_, sendErr := app.SendTextMessageCtx(ctx, &FeiShuAppMessageSendTarget{ChatID: event.ChatID}, content, nil)
+			return sendErr
+		})
+		return nil
+	}
+}
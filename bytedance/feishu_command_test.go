@@ -0,0 +1,49 @@
+package bytedance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestFeiShuCommandHandler(t *testing.T) {
+	feishuApp, server := newTestFeiShuApp(t)
+	router := chatapi.NewCommandRouter()
+	router.Register(chatapi.Command{Name: "status", Run: func(ctx context.Context, request *chatapi.CommandRequest) (string, error) {
+		return "all green for " + request.SenderID, nil
+	}})
+	handler := NewFeiShuEventHandler(eventVerificationToken, eventEncryptKey)
+	handler.OnMessageReceived(NewFeiShuCommandHandler(router, feishuApp, "ou_bot"))
+	events := []string{
+		// the mention of the bot in the group chat needs no prefix
+		`{"schema":"2.0","header":{"event_id":"e1","event_type":"im.message.receive_v1","token":"feishuverificationtoken"},
+"event":{"sender":{"sender_id":{"user_id":"e33ggbyz","open_id":"ou_84aad35d"},"sender_type":"user"},
+"message":{"message_id":"om_1","chat_id":"oc_group","chat_type":"group","message_type":"text",
+"content":"{\"text\":\"@_user_1 status\"}","mentions":[{"key":"@_user_1","id":{"open_id":"ou_bot"},"name":"ops bot"}]}}}`,
+		// the mention of a colleague in the group chat is not the mention of the bot
+		`{"schema":"2.0","header":{"event_id":"e3","event_type":"im.message.receive_v1","token":"feishuverificationtoken"},
+"event":{"sender":{"sender_id":{"open_id":"ou_84aad35d"},"sender_type":"user"},
+"message":{"message_id":"om_3","chat_id":"oc_group","chat_type":"group","message_type":"text",
+"content":"{\"text\":\"@_user_1 status\"}","mentions":[{"key":"@_user_1","id":{"open_id":"ou_colleague"},"name":"bob"}]}}}`,
+		// the chatter in the group chat is not a command
+		`{"schema":"2.0","header":{"event_id":"e2","event_type":"im.message.receive_v1","token":"feishuverificationtoken"},
+"event":{"sender":{"sender_id":{"open_id":"ou_84aad35d"},"sender_type":"user"},
+"message":{"message_id":"om_2","chat_id":"oc_group","chat_type":"group","message_type":"text","content":"{\"text\":\"status?\"}"}}}`,
+	}
+	for _, event := range events {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newFeiShuEventRequest(event))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expect the event acknowledged, got %d %s", recorder.Code, recorder.Body)
+		}
+	}
+	router.Wait()
+	if requests := server.RequestsTo(FeiShuAppSendMessageAPI); len(requests) != 1 {
+		t.Fatalf("expect only the command replied, got %d", len(requests))
+	}
+	expectFields(t, server, FeiShuAppSendMessageAPI, map[string]interface{}{"chat_id": "oc_group", "msg_type": "text",
+		"content.text": "all green for e33ggbyz"})
+}
@@ -0,0 +1,45 @@
+package bytedance
+
+import (
+	"net/http"
+
+	"github.com/duoland/chatapi"
+)
+
+// See doc https://open.feishu.cn/document/ukTMukTMukTM/ugjM14COyUjL4ITN
+const (
+	FeishuCodeRequestRateLimit      = 99991400
+	FeishuCodeAppAccessTokenInvalid = 99991664
+	FeishuCodeAccessTokenOutdated   = 99991677
+	FeishuCodeMessageRateLimit      = 11232
+)
+
+// newFeiShuCodeError create the api error of the code returned by feishu
+func newFeiShuCodeError(endpoint string, code int, message string) *chatapi.APIError {
+	return &chatapi.APIError{
+		Provider:   chatapi.ProviderFeiShu,
+		Endpoint:   endpoint,
+		HTTPStatus: http.StatusOK,
+		ErrCode:    code,
+		ErrMessage: message,
+		Retryable:  isFeiShuRetryableCode(code),
+	}
+}
+
+// isFeiShuTokenRejectedCode report whether the server rejects the access token,
+// the token should be refreshed and the request replayed
+func isFeiShuTokenRejectedCode(code int) bool {
+	switch code {
+	case FeishuCodeAccessTokenExpired, FeishuCodeAppAccessTokenInvalid, FeishuCodeAccessTokenOutdated:
+		return true
+	}
+	return false
+}
+
+func isFeiShuRetryableCode(code int) bool {
+	switch code {
+	case FeishuCodeRequestRateLimit, FeishuCodeMessageRateLimit:
+		return true
+	}
+	return false
+}
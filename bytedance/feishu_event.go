@@ -0,0 +1,259 @@
+package bytedance
+
+// See doc https://open.feishu.cn/document/ukTMukTMukTM/uUTNz4SN1MjL1UzM
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/duoland/chatapi"
+)
+
+// The headers of the signature of the events, sent when the encrypt key is set
+const (
+	FeiShuHeaderRequestTimestamp = "X-Lark-Request-Timestamp"
+	FeiShuHeaderRequestNonce     = "X-Lark-Request-Nonce"
+	FeiShuHeaderSignature        = "X-Lark-Signature"
+)
+
+// FeiShuEventDedupeTTL is how long the handled event ids are kept, longer than the retries of the platform
+var FeiShuEventDedupeTTL = 24 * time.Hour
+
+// FeiShuEventSignature returns the X-Lark-Signature of the event body
+func FeiShuEventSignature(timestamp, nonce, encryptKey string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(timestamp + nonce + encryptKey))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// DecryptFeiShuEvent returns the event of the encrypt field, the aes key is the sha256 of the encrypt key
+// and the iv is the first block of the cipher text
+func DecryptFeiShuEvent(encryptKey, encrypted string) (event []byte, err error) {
+	cipherText, decodeErr := base64.StdEncoding.DecodeString(encrypted)
+	if decodeErr != nil {
+		err = fmt.Errorf("%w, decode base64 error, %v", chatapi.ErrInvalidCallback, decodeErr)
+		return
+	}
+	if len(cipherText) < 2*aes.BlockSize || len(cipherText)%aes.BlockSize != 0 {
+		err = fmt.Errorf("%w, invalid cipher text size %d", chatapi.ErrInvalidCallback, len(cipherText))
+		return
+	}
+	aesKey := sha256.Sum256([]byte(encryptKey))
+	block, _ := aes.NewCipher(aesKey[:])
+	plainText := make([]byte, len(cipherText)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, cipherText[:aes.BlockSize]).CryptBlocks(plainText, cipherText[aes.BlockSize:])
+	event, err = chatapi.PKCS7Unpad(plainText, aes.BlockSize)
+	return
+}
+
+// FeiShuEvent is the event of the v1 or the v2 schema, with the fields of the two schemas merged
+type FeiShuEvent struct {
+	EventID    string          // the uuid of the v1 schema or the event_id of the v2 schema
+	EventType  string          // the type of the event of the v1 schema or the event_type of the v2 schema
+	Schema     string          // 2.0 for the v2 schema, empty for the v1 schema
+	AppID      string          // the app receiving the event
+	TenantKey  string          // the tenant of the event
+	CreateTime string          // the ts of the v1 schema or the create_time of the v2 schema
+	Event      json.RawMessage // the json of the event field
+}
+
+// feiShuEventEnvelope is the json of the events of the v1 and the v2 schemas and the url verification
+type feiShuEventEnvelope struct {
+	Encrypt   string `json:"encrypt"`
+	Challenge string `json:"challenge"`
+	Token     string `json:"token"`
+	Type      string `json:"type"`
+	UUID      string `json:"uuid"`
+	TS        string `json:"ts"`
+	Schema    string `json:"schema"`
+	Header    struct {
+		EventID    string `json:"event_id"`
+		EventType  string `json:"event_type"`
+		CreateTime string `json:"create_time"`
+		Token      string `json:"token"`
+		AppID      string `json:"app_id"`
+		TenantKey  string `json:"tenant_key"`
+	} `json:"header"`
+	Event json.RawMessage `json:"event"`
+}
+
+// FeiShuEventHandler is the http.Handler of the event subscription url of the feishu app, it answers the url
+// verification, checks the token and the signature, decrypts the events, drops the events already handled and
+// dispatches the others to the handlers registered by the event type
+type FeiShuEventHandler struct {
+	verificationToken string
+	encryptKey        string
+
+	lock     sync.Mutex
+	handlers map[string]func(ctx context.Context, event *FeiShuEvent) error
+	handled  map[string]time.Time
+	inFlight map[string]struct{} // the events being handled, the redeliveries are answered with the error to be pushed again
+	pruned   time.Time
+}
+
+// NewFeiShuEventHandler create the handler by the verification token and the encrypt key on the event subscription
+// settings of the app, the encrypt key is empty if the events are not encrypted. With the encrypt key the events
+// must be signed and encrypted, and without both of them all the requests are rejected.
+func NewFeiShuEventHandler(verificationToken, encryptKey string) *FeiShuEventHandler {
+	return &FeiShuEventHandler{
+		verificationToken: verificationToken,
+		encryptKey:        encryptKey,
+		handlers:          make(map[string]func(ctx context.Context, event *FeiShuEvent) error),
+		handled:           make(map[string]time.Time),
+		inFlight:          make(map[string]struct{}),
+	}
+}
+
+// Handle register the handler of the events of the types, e.g. the events without a typed callback yet
+func (h *FeiShuEventHandler) Handle(handle func(ctx context.Context, event *FeiShuEvent) error, eventTypes ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, eventType := range eventTypes {
+		h.handlers[eventType] = handle
+	}
+}
+
+func (h *FeiShuEventHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, readErr := ioutil.ReadAll(io.LimitReader(r.Body, chatapi.CallbackMaxBodyBytes))
+	if readErr != nil {
+		http.Error(w, readErr.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.verificationToken == "" && h.encryptKey == "" {
+		// nothing to authenticate the events by
+		http.Error(w, chatapi.ErrInvalidSignature.Error(), http.StatusForbidden)
+		return
+	}
+	if h.encryptKey != "" {
+		// the events of the apps with the encrypt key are signed, the requests without the signature are forged
+		timestamp, nonce := r.Header.Get(FeiShuHeaderRequestTimestamp), r.Header.Get(FeiShuHeaderRequestNonce)
+		signature := r.Header.Get(FeiShuHeaderSignature)
+		if timestamp == "" || nonce == "" || signature == "" ||
+			subtle.ConstantTimeCompare([]byte(signature), []byte(FeiShuEventSignature(timestamp, nonce, h.encryptKey, body))) != 1 {
+			http.Error(w, chatapi.ErrInvalidSignature.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	var envelope feiShuEventEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		http.Error(w, chatapi.ErrInvalidCallback.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.encryptKey != "" && envelope.Encrypt == "" {
+		http.Error(w, fmt.Sprintf("%s, event not encrypted", chatapi.ErrInvalidCallback), http.StatusBadRequest)
+		return
+	}
+	if envelope.Encrypt != "" {
+		data, err := DecryptFeiShuEvent(h.encryptKey, envelope.Encrypt)
+		if err == nil {
+			envelope = feiShuEventEnvelope{}
+			err = json.NewDecoder(bytes.NewReader(data)).Decode(&envelope)
+		}
+		if err != nil {
+			http.Error(w, chatapi.ErrInvalidCallback.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	token := envelope.Token
+	if envelope.Schema != "" {
+		token = envelope.Header.Token
+	}
+	if h.verificationToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.verificationToken)) != 1 {
+		http.Error(w, chatapi.ErrInvalidSignature.Error(), http.StatusForbidden)
+		return
+	}
+	if envelope.Type == "url_verification" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]string{"challenge": envelope.Challenge})
+		return
+	}
+	event := newFeiShuEvent(&envelope)
+	if err := h.dispatch(r.Context(), event); err != nil {
+		// the event failed is pushed again by the platform
+		http.Error(w, fmt.Sprintf("handle event %s error, %v", event.EventType, err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write([]byte("{}"))
+}
+
+// newFeiShuEvent returns the event of the envelope of the v1 or the v2 schema
+func newFeiShuEvent(envelope *feiShuEventEnvelope) *FeiShuEvent {
+	if envelope.Schema != "" {
+		return &FeiShuEvent{EventID: envelope.Header.EventID, EventType: envelope.Header.EventType, Schema: envelope.Schema,
+			AppID: envelope.Header.AppID, TenantKey: envelope.Header.TenantKey, CreateTime: envelope.Header.CreateTime, Event: envelope.Event}
+	}
+	var head struct {
+		Type      string `json:"type"`
+		AppID     string `json:"app_id"`
+		TenantKey string `json:"tenant_key"`
+	}
+	json.Unmarshal(envelope.Event, &head)
+	return &FeiShuEvent{EventID: envelope.UUID, EventType: head.Type, AppID: head.AppID, TenantKey: head.TenantKey,
+		CreateTime: envelope.TS, Event: envelope.Event}
+}
+
+// errFeiShuEventInFlight is returned for the redelivery of the event being handled, so the platform pushes it again
+var errFeiShuEventInFlight = errors.New("event is being handled")
+
+// dispatch the event to the handler of its type once, the event is handled again if the handler failed, and the
+// redelivery during the handling fails to be pushed again in case the handling fails
+func (h *FeiShuEventHandler) dispatch(ctx context.Context, event *FeiShuEvent) (err error) {
+	h.lock.Lock()
+	handle := h.handlers[event.EventType]
+	now := time.Now()
+	if now.Sub(h.pruned) > time.Minute {
+		for eventID, handledAt := range h.handled {
+			if now.Sub(handledAt) > FeiShuEventDedupeTTL {
+				delete(h.handled, eventID)
+			}
+		}
+		h.pruned = now
+	}
+	if _, duplicated := h.handled[event.EventID]; duplicated || handle == nil {
+		h.lock.Unlock()
+		return
+	}
+	if _, handling := h.inFlight[event.EventID]; handling {
+		h.lock.Unlock()
+		err = errFeiShuEventInFlight
+		return
+	}
+	if event.EventID != "" {
+		h.inFlight[event.EventID] = struct{}{}
+		// the event is not left in flight by the panic of the handler
+		defer func() {
+			h.lock.Lock()
+			delete(h.inFlight, event.EventID)
+			h.lock.Unlock()
+		}()
+	}
+	h.lock.Unlock()
+
+	if err = handle(ctx, event); err == nil && event.EventID != "" {
+		h.lock.Lock()
+		h.handled[event.EventID] = time.Now()
+		h.lock.Unlock()
+	}
+	return
+}
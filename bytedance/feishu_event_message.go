@@ -0,0 +1,222 @@
+package bytedance
+
+// See doc https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/im-v1/message/events/receive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/duoland/chatapi"
+)
+
+// The types of the events of the v2 schema and the v1 schema
+const (
+	FeiShuEventMessageReceived  = "im.message.receive_v1"
+	FeiShuEventBotAdded         = "im.chat.member.bot.added_v1"
+	FeiShuEventUserEnteredChat  = "im.chat.access_event.bot_p2p_chat_entered_v1"
+	FeiShuEventV1Message        = "message"
+	FeiShuEventV1AddBot         = "add_bot"
+	FeiShuEventV1P2PChatCreated = "p2p_chat_create"
+)
+
+// The types of the chats, the private chats of the v1 schema are p2p
+const (
+	FeiShuChatTypeP2P   = "p2p"
+	FeiShuChatTypeGroup = "group"
+)
+
+// FeiShuUserID is the ids of the user, the user id is set only if the app has the permission
+type FeiShuUserID struct {
+	UnionID string `json:"union_id"`
+	UserID  string `json:"user_id"`
+	OpenID  string `json:"open_id"`
+}
+
+// FeiShuMention is the user mentioned in the message, the key is the placeholder in the text, e.g. @_user_1
+type FeiShuMention struct {
+	Key  string       `json:"key"`
+	ID   FeiShuUserID `json:"id"`
+	Name string       `json:"name"`
+}
+
+// FeiShuMessageReceivedEvent is the message sent to the bot, in the p2p chat or mentioning the bot in the group chat.
+// The text is set for the text messages, the content is the json of the message of the v2 schema
+type FeiShuMessageReceivedEvent struct {
+	FeiShuEvent
+	Sender      FeiShuUserID
+	SenderType  string
+	MessageID   string
+	RootID      string
+	ParentID    string
+	ChatID      string
+	ChatType    string
+	MessageType string
+	Content     string
+	Text        string
+	Mentions    []FeiShuMention
+}
+
+// FeiShuBotAddedEvent is the bot added to the group chat
+type FeiShuBotAddedEvent struct {
+	FeiShuEvent
+	ChatID   string
+	ChatName string
+	Operator FeiShuUserID
+}
+
+// FeiShuUserEnteredChatEvent is the user entering the p2p chat with the bot, only once in the v1 schema
+type FeiShuUserEnteredChatEvent struct {
+	FeiShuEvent
+	ChatID   string
+	Operator FeiShuUserID
+}
+
+// OnMessageReceived register the handler of the messages received by the bot
+func (h *FeiShuEventHandler) OnMessageReceived(handle func(ctx context.Context, event *FeiShuMessageReceivedEvent) error) {
+	h.Handle(func(ctx context.Context, event *FeiShuEvent) error {
+		typed, err := parseFeiShuMessageReceivedEvent(event)
+		if err != nil {
+			return err
+		}
+		return handle(ctx, typed)
+	}, FeiShuEventMessageReceived, FeiShuEventV1Message)
+}
+
+// OnBotAdded register the handler of the bot added to the group chats
+func (h *FeiShuEventHandler) OnBotAdded(handle func(ctx context.Context, event *FeiShuBotAddedEvent) error) {
+	h.Handle(func(ctx context.Context, event *FeiShuEvent) error {
+		typed, err := parseFeiShuBotAddedEvent(event)
+		if err != nil {
+			return err
+		}
+		return handle(ctx, typed)
+	}, FeiShuEventBotAdded, FeiShuEventV1AddBot)
+}
+
+// OnUserEnteredChat register the handler of the users entering the p2p chats with the bot
+func (h *FeiShuEventHandler) OnUserEnteredChat(handle func(ctx context.Context, event *FeiShuUserEnteredChatEvent) error) {
+	h.Handle(func(ctx context.Context, event *FeiShuEvent) error {
+		typed, err := parseFeiShuUserEnteredChatEvent(event)
+		if err != nil {
+			return err
+		}
+		return handle(ctx, typed)
+	}, FeiShuEventUserEnteredChat, FeiShuEventV1P2PChatCreated)
+}
+
+func parseFeiShuMessageReceivedEvent(event *FeiShuEvent) (typed *FeiShuMessageReceivedEvent, err error) {
+	typed = &FeiShuMessageReceivedEvent{FeiShuEvent: *event}
+	if event.Schema == "" {
+		var v1 struct {
+			OpenMessageID    string `json:"open_message_id"`
+			RootID           string `json:"root_id"`
+			ParentID         string `json:"parent_id"`
+			OpenChatID       string `json:"open_chat_id"`
+			ChatType         string `json:"chat_type"`
+			MsgType          string `json:"msg_type"`
+			OpenID           string `json:"open_id"`
+			UserID           string `json:"employee_id"`
+			UnionID          string `json:"union_id"`
+			Text             string `json:"text"`
+			TextWithoutAtBot string `json:"text_without_at_bot"`
+		}
+		if err = json.Unmarshal(event.Event, &v1); err != nil {
+			err = fmt.Errorf("%w, decode %s event error, %v", chatapi.ErrInvalidCallback, event.EventType, err)
+			return
+		}
+		typed.Sender = FeiShuUserID{UnionID: v1.UnionID, UserID: v1.UserID, OpenID: v1.OpenID}
+		typed.SenderType = "user"
+		typed.MessageID, typed.RootID, typed.ParentID = v1.OpenMessageID, v1.RootID, v1.ParentID
+		typed.ChatID, typed.ChatType, typed.MessageType = v1.OpenChatID, v1.ChatType, v1.MsgType
+		if typed.ChatType == "private" {
+			typed.ChatType = FeiShuChatTypeP2P
+		}
+		typed.Text = v1.TextWithoutAtBot
+		if typed.Text == "" {
+			typed.Text = v1.Text
+		}
+		return
+	}
+	var v2 struct {
+		Sender struct {
+			SenderID   FeiShuUserID `json:"sender_id"`
+			SenderType string       `json:"sender_type"`
+		} `json:"sender"`
+		Message struct {
+			MessageID   string          `json:"message_id"`
+			RootID      string          `json:"root_id"`
+			ParentID    string          `json:"parent_id"`
+			ChatID      string          `json:"chat_id"`
+			ChatType    string          `json:"chat_type"`
+			MessageType string          `json:"message_type"`
+			Content     string          `json:"content"`
+			Mentions    []FeiShuMention `json:"mentions"`
+		} `json:"message"`
+	}
+	if err = json.Unmarshal(event.Event, &v2); err != nil {
+		err = fmt.Errorf("%w, decode %s event error, %v", chatapi.ErrInvalidCallback, event.EventType, err)
+		return
+	}
+	typed.Sender, typed.SenderType = v2.Sender.SenderID, v2.Sender.SenderType
+	typed.MessageID, typed.RootID, typed.ParentID = v2.Message.MessageID, v2.Message.RootID, v2.Message.ParentID
+	typed.ChatID, typed.ChatType, typed.MessageType = v2.Message.ChatID, v2.Message.ChatType, v2.Message.MessageType
+	typed.Content, typed.Mentions = v2.Message.Content, v2.Message.Mentions
+	if typed.MessageType == FeiShuAppMessageTypeText {
+		var content struct {
+			Text string `json:"text"`
+		}
+		if json.Unmarshal([]byte(typed.Content), &content) == nil {
+			typed.Text = content.Text
+		}
+	}
+	return
+}
+
+func parseFeiShuBotAddedEvent(event *FeiShuEvent) (typed *FeiShuBotAddedEvent, err error) {
+	typed = &FeiShuBotAddedEvent{FeiShuEvent: *event}
+	if event.Schema == "" {
+		var v1 struct {
+			OpenChatID         string `json:"open_chat_id"`
+			ChatName           string `json:"chat_name"`
+			OperatorOpenID     string `json:"operator_open_id"`
+			OperatorEmployeeID string `json:"operator_employee_id"`
+		}
+		if err = json.Unmarshal(event.Event, &v1); err != nil {
+			err = fmt.Errorf("%w, decode %s event error, %v", chatapi.ErrInvalidCallback, event.EventType, err)
+			return
+		}
+		typed.ChatID, typed.ChatName = v1.OpenChatID, v1.ChatName
+		typed.Operator = FeiShuUserID{UserID: v1.OperatorEmployeeID, OpenID: v1.OperatorOpenID}
+		return
+	}
+	var v2 struct {
+		ChatID     string       `json:"chat_id"`
+		Name       string       `json:"name"`
+		OperatorID FeiShuUserID `json:"operator_id"`
+	}
+	if err = json.Unmarshal(event.Event, &v2); err != nil {
+		err = fmt.Errorf("%w, decode %s event error, %v", chatapi.ErrInvalidCallback, event.EventType, err)
+		return
+	}
+	typed.ChatID, typed.ChatName, typed.Operator = v2.ChatID, v2.Name, v2.OperatorID
+	return
+}
+
+func parseFeiShuUserEnteredChatEvent(event *FeiShuEvent) (typed *FeiShuUserEnteredChatEvent, err error) {
+	typed = &FeiShuUserEnteredChatEvent{FeiShuEvent: *event}
+	var fields struct {
+		ChatID     string       `json:"chat_id"`
+		OperatorID FeiShuUserID `json:"operator_id"` // the v2 schema
+		Operator   FeiShuUserID `json:"operator"`    // the v1 schema
+	}
+	if err = json.Unmarshal(event.Event, &fields); err != nil {
+		err = fmt.Errorf("%w, decode %s event error, %v", chatapi.ErrInvalidCallback, event.EventType, err)
+		return
+	}
+	typed.ChatID, typed.Operator = fields.ChatID, fields.OperatorID
+	if event.Schema == "" {
+		typed.Operator = fields.Operator
+	}
+	return
+}
@@ -0,0 +1,103 @@
+package bytedance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFeiShuEventHandler_OnMessageReceived(t *testing.T) {
+	handler := NewFeiShuEventHandler(eventVerificationToken, eventEncryptKey)
+	var events []*FeiShuMessageReceivedEvent
+	handler.OnMessageReceived(func(ctx context.Context, event *FeiShuMessageReceivedEvent) error {
+		events = append(events, event)
+		return nil
+	})
+
+	v2 := `{"schema":"2.0","header":{"event_id":"5e3702a84e847582be8db7fb73283c02","event_type":"im.message.receive_v1",
+"create_time":"1608725989000","token":"feishuverificationtoken","app_id":"cli_9f5343c580712544","tenant_key":"2ca1d211f64f6438"},
+"event":{"sender":{"sender_id":{"union_id":"on_8ed6aa67826108097d9ee143816345","user_id":"e33ggbyz","open_id":"ou_84aad35d084aa403a838cf73ee18467"},"sender_type":"user"},
+"message":{"message_id":"om_5ce6d572455d361153b7cb51da133945","chat_id":"oc_5ce6d572455d361153b7xx51da133945","chat_type":"group","message_type":"text",
+"content":"{\"text\":\"@_user_1 /deploy api prod\"}","mentions":[{"key":"@_user_1","id":{"open_id":"ou_bot"},"name":"deploy bot"}]}}}`
+	v1 := `{"uuid":"41b5f371157e3d5341b38b20396e77a3","token":"feishuverificationtoken","ts":"1550038209.428520","type":"event_callback",
+"event":{"type":"message","app_id":"cli_9f5343c580712544","tenant_key":"2ca1d211f64f6438","open_chat_id":"oc_5ce6d572455d361153b7cb51da133945",
+"chat_type":"private","msg_type":"text","open_id":"ou_18eac85d35a26f989317ad4f02e8bbbb","employee_id":"e33ggbyz","union_id":"on_8ed6aa67826108097d9ee143816345",
+"open_message_id":"om_36ec3ff52b3c9adb3c4a4b2e3e2e3f3e","is_mention":false,"text":"/deploy api prod","text_without_at_bot":"/deploy api prod"}}`
+	for _, event := range []string{v2, v1} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newFeiShuEventRequest(event))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expect the event handled, got %d %s", recorder.Code, recorder.Body)
+		}
+	}
+	if len(events) != 2 {
+		t.Fatalf("expect the v2 and the v1 messages, got %d", len(events))
+	}
+	if event := events[0]; event.EventID != "5e3702a84e847582be8db7fb73283c02" || event.Schema != "2.0" || event.TenantKey != "2ca1d211f64f6438" ||
+		event.Sender.UserID != "e33ggbyz" || event.ChatType != FeiShuChatTypeGroup || event.Text != "@_user_1 /deploy api prod" ||
+		len(event.Mentions) != 1 || event.Mentions[0].ID.OpenID != "ou_bot" {
+		t.Fatalf("unexpected v2 message %+v", event)
+	}
+	if event := events[1]; event.EventID != "41b5f371157e3d5341b38b20396e77a3" || event.EventType != FeiShuEventV1Message || event.AppID != "cli_9f5343c580712544" ||
+		event.Sender.OpenID != "ou_18eac85d35a26f989317ad4f02e8bbbb" || event.ChatType != FeiShuChatTypeP2P || event.Text != "/deploy api prod" ||
+		event.MessageID != "om_36ec3ff52b3c9adb3c4a4b2e3e2e3f3e" {
+		t.Fatalf("unexpected v1 message %+v", event)
+	}
+}
+
+func TestFeiShuEventHandler_OnBotAdded(t *testing.T) {
+	handler := NewFeiShuEventHandler(eventVerificationToken, eventEncryptKey)
+	var events []*FeiShuBotAddedEvent
+	handler.OnBotAdded(func(ctx context.Context, event *FeiShuBotAddedEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	v2 := `{"schema":"2.0","header":{"event_id":"e1","event_type":"im.chat.member.bot.added_v1","token":"feishuverificationtoken"},
+"event":{"chat_id":"oc_9e9619b938c9571c1c3165681cdaead5","operator_id":{"open_id":"ou_operator"},"name":"ops"}}`
+	v1 := `{"uuid":"e2","token":"feishuverificationtoken","type":"event_callback",
+"event":{"type":"add_bot","open_chat_id":"oc_9e9619b938c9571c1c3165681cdaead5","chat_name":"ops","operator_open_id":"ou_operator"}}`
+	for _, event := range []string{v2, v1} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newFeiShuEventRequest(event))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expect the event handled, got %d %s", recorder.Code, recorder.Body)
+		}
+	}
+	for _, event := range events {
+		if event.ChatID != "oc_9e9619b938c9571c1c3165681cdaead5" || event.ChatName != "ops" || event.Operator.OpenID != "ou_operator" {
+			t.Fatalf("unexpected bot added event %+v", event)
+		}
+	}
+	if len(events) != 2 {
+		t.Fatalf("expect the v2 and the v1 events, got %d", len(events))
+	}
+}
+
+func TestFeiShuEventHandler_OnUserEnteredChat(t *testing.T) {
+	handler := NewFeiShuEventHandler(eventVerificationToken, eventEncryptKey)
+	var events []*FeiShuUserEnteredChatEvent
+	handler.OnUserEnteredChat(func(ctx context.Context, event *FeiShuUserEnteredChatEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	v2 := `{"schema":"2.0","header":{"event_id":"e1","event_type":"im.chat.access_event.bot_p2p_chat_entered_v1","token":"feishuverificationtoken"},
+"event":{"chat_id":"oc_p2p","operator_id":{"open_id":"ou_user"}}}`
+	v1 := `{"uuid":"e2","token":"feishuverificationtoken","type":"event_callback",
+"event":{"type":"p2p_chat_create","chat_id":"oc_p2p","operator":{"open_id":"ou_user"}}}`
+	for _, event := range []string{v2, v1} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newFeiShuEventRequest(event))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expect the event handled, got %d %s", recorder.Code, recorder.Body)
+		}
+	}
+	for _, event := range events {
+		if event.ChatID != "oc_p2p" || event.Operator.OpenID != "ou_user" {
+			t.Fatalf("unexpected user entered chat event %+v", event)
+		}
+	}
+	if len(events) != 2 {
+		t.Fatalf("expect the v2 and the v1 events, got %d", len(events))
+	}
+}
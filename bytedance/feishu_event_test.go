@@ -0,0 +1,235 @@
+package bytedance
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+var eventVerificationToken = "feishuverificationtoken"
+var eventEncryptKey = "feishuencryptkey"
+
+// encryptFeiShuEvent returns the encrypt field of the event encrypted as the platform
+func encryptFeiShuEvent(encryptKey, event string) string {
+	aesKey := sha256.Sum256([]byte(encryptKey))
+	block, _ := aes.NewCipher(aesKey[:])
+	plainText := chatapi.PKCS7Pad([]byte(event), aes.BlockSize)
+	cipherText := make([]byte, aes.BlockSize+len(plainText))
+	copy(cipherText, "0123456789abcdef")
+	cipher.NewCBCEncrypter(block, cipherText[:aes.BlockSize]).CryptBlocks(cipherText[aes.BlockSize:], plainText)
+	return base64.StdEncoding.EncodeToString(cipherText)
+}
+
+// newFeiShuEventRequest returns the event request encrypted and signed as the platform
+func newFeiShuEventRequest(event string) *http.Request {
+	body, _ := json.Marshal(map[string]string{"encrypt": encryptFeiShuEvent(eventEncryptKey, event)})
+	request := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(body))
+	request.Header.Set(FeiShuHeaderRequestTimestamp, "1605695694")
+	request.Header.Set(FeiShuHeaderRequestNonce, "WelUQl6bCqcBa2fM")
+	request.Header.Set(FeiShuHeaderSignature, FeiShuEventSignature("1605695694", "WelUQl6bCqcBa2fM", eventEncryptKey, body))
+	return request
+}
+
+func TestDecryptFeiShuEvent(t *testing.T) {
+	// the sample of the doc
+	if event, err := DecryptFeiShuEvent("test key", "P37w+VZImNgPEO1RBhJ6RtKl7n6zymIbEG1pReEzghk="); err != nil || string(event) != "hello world" {
+		t.Fatalf("expect the sample decrypted, got %q, %v", event, err)
+	}
+	encrypted := encryptFeiShuEvent(eventEncryptKey, `{"type":"url_verification"}`)
+	if event, err := DecryptFeiShuEvent(eventEncryptKey, encrypted); err != nil || string(event) != `{"type":"url_verification"}` {
+		t.Fatalf("expect the event decrypted, got %q, %v", event, err)
+	}
+	if _, err := DecryptFeiShuEvent("otherencryptkey", encrypted); !errors.Is(err, chatapi.ErrInvalidCallback) {
+		t.Fatalf("expect the event of the other key rejected, got %v", err)
+	}
+	if _, err := DecryptFeiShuEvent(eventEncryptKey, "bm90IGVuY3J5cHRlZA=="); !errors.Is(err, chatapi.ErrInvalidCallback) {
+		t.Fatalf("expect the invalid cipher text rejected, got %v", err)
+	}
+}
+
+func TestFeiShuEventHandler_URLVerification(t *testing.T) {
+	handler := NewFeiShuEventHandler(eventVerificationToken, "")
+	body := `{"challenge":"ajls384kdjx98XX","token":"feishuverificationtoken","type":"url_verification"}`
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != `{"challenge":"ajls384kdjx98XX"}` {
+		t.Fatalf("expect the challenge answered, got %d %s", recorder.Code, recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	body = strings.Replace(body, eventVerificationToken, "othertoken", 1)
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expect the other token rejected, got %d", recorder.Code)
+	}
+
+	encryptedHandler := NewFeiShuEventHandler(eventVerificationToken, eventEncryptKey)
+	recorder = httptest.NewRecorder()
+	encryptedHandler.ServeHTTP(recorder, newFeiShuEventRequest(`{"challenge":"ajls384kdjx98XX","token":"feishuverificationtoken","type":"url_verification"}`))
+	if recorder.Code != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != `{"challenge":"ajls384kdjx98XX"}` {
+		t.Fatalf("expect the encrypted challenge answered, got %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestFeiShuEventHandler_Signature(t *testing.T) {
+	handler := NewFeiShuEventHandler(eventVerificationToken, eventEncryptKey)
+	request := newFeiShuEventRequest(`{"schema":"2.0","header":{"event_id":"e1","event_type":"im.message.receive_v1","token":"feishuverificationtoken"},"event":{}}`)
+	request.Header.Set(FeiShuHeaderRequestNonce, "othernonce")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expect the invalid signature rejected, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expect the get rejected, got %d", recorder.Code)
+	}
+}
+
+func TestFeiShuEventHandler_Forged(t *testing.T) {
+	event := `{"schema":"2.0","header":{"event_id":"e1","event_type":"im.chat.disbanded_v1","token":"feishuverificationtoken"},"event":{}}`
+	var handled []string
+	handle := func(ctx context.Context, event *FeiShuEvent) error {
+		handled = append(handled, event.EventID)
+		return nil
+	}
+	handler := NewFeiShuEventHandler(eventVerificationToken, eventEncryptKey)
+	handler.Handle(handle, "im.chat.disbanded_v1")
+
+	// the encrypted event without the signature
+	unsigned := newFeiShuEventRequest(event)
+	unsigned.Header.Del(FeiShuHeaderSignature)
+	// the plain event signed by the body
+	plain := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(event))
+	plain.Header.Set(FeiShuHeaderRequestTimestamp, "1605695694")
+	plain.Header.Set(FeiShuHeaderRequestNonce, "WelUQl6bCqcBa2fM")
+	plain.Header.Set(FeiShuHeaderSignature, FeiShuEventSignature("1605695694", "WelUQl6bCqcBa2fM", eventEncryptKey, []byte(event)))
+	for i, request := range []*http.Request{unsigned, plain, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(event))} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code/100 != 4 {
+			t.Fatalf("expect the forged request %d rejected, got %d", i+1, recorder.Code)
+		}
+	}
+
+	// the handler without the token and the encrypt key authenticates nothing
+	open := NewFeiShuEventHandler("", "")
+	open.Handle(handle, "im.chat.disbanded_v1")
+	recorder := httptest.NewRecorder()
+	open.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(event)))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expect the request rejected without the token and the encrypt key, got %d", recorder.Code)
+	}
+	if len(handled) != 0 {
+		t.Fatalf("expect no forged event handled, got %v", handled)
+	}
+}
+
+func TestFeiShuEventHandler_Dedupe(t *testing.T) {
+	handler := NewFeiShuEventHandler(eventVerificationToken, eventEncryptKey)
+	var handled []string
+	failed := true
+	handler.Handle(func(ctx context.Context, event *FeiShuEvent) error {
+		handled = append(handled, event.EventID)
+		if failed {
+			failed = false
+			return errors.New("database unavailable")
+		}
+		return nil
+	}, "im.chat.disbanded_v1")
+
+	event := `{"schema":"2.0","header":{"event_id":"e1","event_type":"im.chat.disbanded_v1","token":"feishuverificationtoken"},"event":{}}`
+	codes := []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK}
+	for i, code := range codes {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newFeiShuEventRequest(event))
+		if recorder.Code != code {
+			t.Fatalf("expect the delivery %d replied %d, got %d %s", i+1, code, recorder.Code, recorder.Body)
+		}
+	}
+	if len(handled) != 2 {
+		t.Fatalf("expect the failed event handled again and the duplicate dropped, got %v", handled)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newFeiShuEventRequest(`{"schema":"2.0","header":{"event_id":"e2","event_type":"im.chat.updated_v1","token":"feishuverificationtoken"},"event":{}}`))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect the event without a handler acknowledged, got %d", recorder.Code)
+	}
+}
+
+func TestFeiShuEventHandler_DedupePanic(t *testing.T) {
+	handler := NewFeiShuEventHandler(eventVerificationToken, eventEncryptKey)
+	panicked := true
+	handler.Handle(func(ctx context.Context, event *FeiShuEvent) error {
+		if panicked {
+			panicked = false
+			panic("nil map")
+		}
+		return nil
+	}, "im.chat.disbanded_v1")
+
+	event := `{"schema":"2.0","header":{"event_id":"e1","event_type":"im.chat.disbanded_v1","token":"feishuverificationtoken"},"event":{}}`
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expect the panic of the handler passed to the server")
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), newFeiShuEventRequest(event))
+	}()
+	// the redelivery after the panic is handled again
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newFeiShuEventRequest(event))
+	if recorder.Code != http.StatusOK || panicked {
+		t.Fatalf("expect the redelivery handled after the panic, got %d", recorder.Code)
+	}
+}
+
+func TestFeiShuEventHandler_DedupeInFlight(t *testing.T) {
+	handler := NewFeiShuEventHandler(eventVerificationToken, eventEncryptKey)
+	started, release := make(chan struct{}), make(chan struct{})
+	handler.Handle(func(ctx context.Context, event *FeiShuEvent) error {
+		close(started)
+		<-release
+		return nil
+	}, "im.chat.disbanded_v1")
+
+	event := `{"schema":"2.0","header":{"event_id":"e1","event_type":"im.chat.disbanded_v1","token":"feishuverificationtoken"},"event":{}}`
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(first, newFeiShuEventRequest(event))
+	}()
+	<-started
+	// the redelivery during the handling is pushed again in case the handling fails
+	redelivery := httptest.NewRecorder()
+	handler.ServeHTTP(redelivery, newFeiShuEventRequest(event))
+	if redelivery.Code/100 == 2 {
+		t.Fatalf("expect the redelivery of the event in flight not acknowledged, got %d", redelivery.Code)
+	}
+	close(release)
+	<-done
+	if first.Code != http.StatusOK {
+		t.Fatalf("expect the first delivery acknowledged, got %d", first.Code)
+	}
+	redelivery = httptest.NewRecorder()
+	handler.ServeHTTP(redelivery, newFeiShuEventRequest(event))
+	if redelivery.Code != http.StatusOK {
+		t.Fatalf("expect the redelivery of the handled event acknowledged, got %d", redelivery.Code)
+	}
+}
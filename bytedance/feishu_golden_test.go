@@ -0,0 +1,56 @@
+package bytedance
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/duoland/chatapi/chatapitest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden payload files in testdata")
+
+func TestFeiShuApp_GoldenPayloads(t *testing.T) {
+	app, server := newTestFeiShuApp(t)
+	target := FeiShuAppMessageSendTarget{ChatID: chatID}
+	postLines := [][]FeishuAppPostMessageContentItem{
+		{
+			{Tag: FeiShuAppPostMessageText, UnEscape: true, Text: "hello master &nbsp;"},
+			{Tag: FeiShuAppPostMessageHref, Text: "baidu", Href: "https://www.baidu.com"},
+		},
+		{
+			{Tag: FeiShuAppPostMessageAt, UserID: userID},
+			{Tag: FeiShuAppPostMessageImage, ImageKey: "img_12345", Width: 300, Height: 200},
+		},
+	}
+	chatapitest.RunGoldenCases(t, server, []chatapitest.GoldenCase{
+		{Name: "feishu_app_text", API: FeiShuAppSendMessageAPI, Send: func() error {
+			_, err := app.SendTextMessage(&target, "hello, master", nil)
+			return err
+		}},
+		{Name: "feishu_app_text_to_user", API: FeiShuAppSendMessageAPI, Send: func() error {
+			_, err := app.SendTextMessage(&FeiShuAppMessageSendTarget{UserID: userID}, "hello, master", map[string]string{"robot_id": "cli_robot"})
+			return err
+		}},
+		{Name: "feishu_app_image", API: FeiShuAppSendMessageAPI, Send: func() error {
+			_, err := app.SendImageMessage(&target, "img_12345", nil)
+			return err
+		}},
+		{Name: "feishu_app_post", API: FeiShuAppSendMessageAPI, Send: func() error {
+			_, err := app.SendPostMessage(&target, "hello, i am a robot", FeiShuAppI18nChinese, postLines, nil)
+			return err
+		}},
+		{Name: "feishu_app_create_group", API: FeiShuAppCreateGroupAPI, Send: func() error {
+			_, err := app.CreateGroupChat(groupName, groupDescription, groupUserIDList, &FeiShuAppCreateGroupOptions{OnlyOwnerAdd: true})
+			return err
+		}},
+	}, *update)
+}
+
+func TestFeiShuRobot_GoldenPayloads(t *testing.T) {
+	robot, server := newTestFeiShuRobot(t)
+	chatapitest.RunGoldenCases(t, server, []chatapitest.GoldenCase{
+		{Name: "feishu_robot_text", API: FeiShuRobotMessageAPI, Send: func() error {
+			return robot.SendTextMessage(feishuShortcutKey, "this is a robot message", "great dreams comes from little steps")
+		}},
+	}, *update)
+}
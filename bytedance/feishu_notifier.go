@@ -0,0 +1,104 @@
+package bytedance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/duoland/chatapi"
+)
+
+var _ chatapi.Notifier = (*FeiShuAppNotifier)(nil)
+var _ chatapi.Notifier = (*FeiShuRobotNotifier)(nil)
+
+// FeiShuAppNotifier adapts the FeiShuApp to the chatapi.Notifier interface
+type FeiShuAppNotifier struct {
+	app    *FeiShuApp
+	target FeiShuAppMessageSendTarget
+}
+
+// NewFeiShuAppNotifier create a notifier sending messages to the target
+func NewFeiShuAppNotifier(app *FeiShuApp, target FeiShuAppMessageSendTarget) *FeiShuAppNotifier {
+	return &FeiShuAppNotifier{app: app, target: target}
+}
+
+// SendText send the text message
+func (n *FeiShuAppNotifier) SendText(ctx context.Context, content string) (err error) {
+	_, err = n.app.SendTextMessageCtx(ctx, &n.target, content, nil)
+	return
+}
+
+// SendMarkdown send the markdown content line by line as a post message
+func (n *FeiShuAppNotifier) SendMarkdown(ctx context.Context, title, content string) (err error) {
+	var messageLines [][]FeishuAppPostMessageContentItem
+	for _, line := range strings.Split(content, "\n") {
+		messageLines = append(messageLines, []FeishuAppPostMessageContentItem{
+			{Tag: FeiShuAppPostMessageText, Text: line},
+		})
+	}
+	_, err = n.app.SendPostMessageCtx(ctx, &n.target, title, FeiShuAppI18nChinese, messageLines, nil)
+	return
+}
+
+// SendLink send the link as a post message
+func (n *FeiShuAppNotifier) SendLink(ctx context.Context, link *chatapi.Link) (err error) {
+	messageLines := [][]FeishuAppPostMessageContentItem{
+		{{Tag: FeiShuAppPostMessageText, Text: link.Description}},
+		{{Tag: FeiShuAppPostMessageHref, Text: link.URL, Href: link.URL}},
+	}
+	_, err = n.app.SendPostMessageCtx(ctx, &n.target, link.Title, FeiShuAppI18nChinese, messageLines, nil)
+	return
+}
+
+// SendImage send the image message, only the uploaded image key is supported
+func (n *FeiShuAppNotifier) SendImage(ctx context.Context, image *chatapi.Image) (err error) {
+	if image.MediaID == "" {
+		return fmt.Errorf("%w, feishu app requires the image key", chatapi.ErrUnsupported)
+	}
+	_, err = n.app.SendImageMessageCtx(ctx, &n.target, image.MediaID, nil)
+	return
+}
+
+// FeiShuRobotNotifier adapts the FeiShuRobot to the chatapi.Notifier interface,
+// the shortcut robot only supports the title and content, other messages are sent as text
+type FeiShuRobotNotifier struct {
+	robot *FeiShuRobot
+	key   string
+	title string
+}
+
+// NewFeiShuRobotNotifier create a notifier sending messages with the shortcut key,
+// the title is used for the messages without their own title
+func NewFeiShuRobotNotifier(robot *FeiShuRobot, key, title string) *FeiShuRobotNotifier {
+	return &FeiShuRobotNotifier{robot: robot, key: key, title: title}
+}
+
+// SendText send the text message
+func (n *FeiShuRobotNotifier) SendText(ctx context.Context, content string) error {
+	return n.robot.SendTextMessageCtx(ctx, n.key, n.title, content)
+}
+
+// SendMarkdown send the markdown content as the text message
+func (n *FeiShuRobotNotifier) SendMarkdown(ctx context.Context, title, content string) error {
+	return n.robot.SendTextMessageCtx(ctx, n.key, n.titleOr(title), content)
+}
+
+// SendLink send the link description and url as the text message
+func (n *FeiShuRobotNotifier) SendLink(ctx context.Context, link *chatapi.Link) error {
+	return n.robot.SendTextMessageCtx(ctx, n.key, n.titleOr(link.Title), fmt.Sprintf("%s\n%s", link.Description, link.URL))
+}
+
+// SendImage send the image url as the text message
+func (n *FeiShuRobotNotifier) SendImage(ctx context.Context, image *chatapi.Image) error {
+	if image.URL == "" {
+		return fmt.Errorf("%w, feishu robot requires the image url", chatapi.ErrUnsupported)
+	}
+	return n.robot.SendTextMessageCtx(ctx, n.key, n.titleOr(image.Name), image.URL)
+}
+
+func (n *FeiShuRobotNotifier) titleOr(title string) string {
+	if title == "" {
+		return n.title
+	}
+	return title
+}
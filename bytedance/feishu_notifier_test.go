@@ -0,0 +1,22 @@
+package bytedance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestFeiShuRobotNotifier_SendMarkdown(t *testing.T) {
+	robot, server := newTestFeiShuRobot(t)
+
+	var notifier chatapi.Notifier = NewFeiShuRobotNotifier(robot, "shortcut-key", "default title")
+	if err := notifier.SendMarkdown(context.Background(), "", "**content**"); err != nil {
+		t.Fatal(err)
+	}
+	expectFields(t, server, FeiShuRobotMessageAPI+"shortcut-key", map[string]interface{}{"title": "default title", "content": "**content**"})
+	if err := notifier.SendImage(context.Background(), &chatapi.Image{}); !errors.Is(err, chatapi.ErrUnsupported) {
+		t.Fatalf("expect ErrUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package bytedance
+
+import (
+	"github.com/duoland/chatapi"
+)
+
+// applyFeiShuAppPolicy rewrite the target and the content of the app message by the recipient policy
+func applyFeiShuAppPolicy(policy *chatapi.RecipientPolicy, messageObj interface{}) (err error) {
+	messageReq, ok := messageObj.(*FeiShuAppMessageSendReq)
+	if !ok {
+		return
+	}
+	switch {
+	case policy.RedirectChat != "":
+		messageReq.OpenID, messageReq.UserID, messageReq.Email = "", "", ""
+		messageReq.ChatID = policy.RedirectChat
+	case policy.Allowlist != nil:
+		messageReq.OpenID = allowedFeiShuRecipient(messageReq.OpenID, policy.Allowlist.Users)
+		messageReq.UserID = allowedFeiShuRecipient(messageReq.UserID, policy.Allowlist.Users)
+		messageReq.Email = allowedFeiShuRecipient(messageReq.Email, policy.Allowlist.Users)
+		messageReq.ChatID = allowedFeiShuRecipient(messageReq.ChatID, policy.Allowlist.Chats)
+		if messageReq.OpenID == "" && messageReq.UserID == "" && messageReq.Email == "" && messageReq.ChatID == "" {
+			err = chatapi.ErrRecipientBlocked
+			return
+		}
+	}
+	if policy.Banner == "" {
+		return
+	}
+	switch content := messageReq.Content.(type) {
+	case map[string]string:
+		if messageReq.MessageType == FeiShuAppMessageTypeText {
+			content["text"] = policy.WithBanner(content["text"])
+		}
+	case map[string]interface{}:
+		// the banner is the first line of the post, the lines of the caller are kept as they are
+		if post, ok := content["post"].(map[string]FeishuAppPostMessageContent); ok {
+			for i18nKey, postContent := range post {
+				bannerLine := []FeishuAppPostMessageContentItem{{Tag: FeiShuAppPostMessageText, Text: policy.Banner}}
+				postContent.Content = append([][]FeishuAppPostMessageContentItem{bannerLine}, postContent.Content...)
+				post[i18nKey] = postContent
+			}
+		}
+	}
+	return
+}
+
+// allowedFeiShuRecipient returns the recipient if it is allowed, empty otherwise
+func allowedFeiShuRecipient(recipient string, allowed []string) string {
+	if recipient == "" || len(chatapi.AllowedRecipients([]string{recipient}, allowed)) == 0 {
+		return ""
+	}
+	return recipient
+}
+
+// feiShuRobotKey returns the webhook key to send to by the recipient policy
+func feiShuRobotKey(policy *chatapi.RecipientPolicy, key string) (string, error) {
+	switch {
+	case policy == nil:
+		return key, nil
+	case policy.RedirectWebhook != "":
+		return policy.RedirectWebhook, nil
+	case !policy.AllowWebhook(key):
+		return "", chatapi.ErrRecipientBlocked
+	}
+	return key, nil
+}
@@ -0,0 +1,43 @@
+package bytedance
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestFeiShuApp_RecipientPolicy(t *testing.T) {
+	policy := chatapi.RecipientPolicy{Allowlist: &chatapi.RecipientAllowlist{Users: []string{"qa001"}}, Banner: "[staging]"}
+	app, server := newTestFeiShuApp(t, chatapi.WithRecipientPolicy(&policy))
+	if _, err := app.SendTextMessage(&FeiShuAppMessageSendTarget{UserID: userID}, "hello, master", nil); !errors.Is(err, chatapi.ErrRecipientBlocked) {
+		t.Fatalf("expect the unlisted user blocked, got %v", err)
+	}
+	if _, err := app.SendTextMessage(&FeiShuAppMessageSendTarget{UserID: "qa001"}, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	expectFields(t, server, FeiShuAppSendMessageAPI, map[string]interface{}{"user_id": "qa001", "content.text": "[staging]\nhello, master"})
+
+	policy.RedirectChat = "oc_qa"
+	lines := [][]FeishuAppPostMessageContentItem{{{Tag: FeiShuAppPostMessageText, Text: "hello, master"}}}
+	if _, err := app.SendPostMessage(&FeiShuAppMessageSendTarget{Email: "boss@example.com"}, "hello", FeiShuAppI18nChinese, lines, nil); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := server.LastRequest(FeiShuAppSendMessageAPI)
+	if req.Field("chat_id") != "oc_qa" || req.Field("email") != nil || req.Field("content.post.zh_cn.content.0.0.text") != "[staging]" ||
+		req.Field("content.post.zh_cn.content.1.0.text") != "hello, master" || len(lines) != 1 {
+		t.Fatalf("unexpected redirected message %s", req.Body)
+	}
+}
+
+func TestFeiShuRobot_RecipientPolicy(t *testing.T) {
+	policy := chatapi.RecipientPolicy{RedirectWebhook: "qa-key", Banner: "[staging]"}
+	robot, server := newTestFeiShuRobot(t, chatapi.WithRecipientPolicy(&policy))
+	if err := robot.SendTextMessage(feishuShortcutKey, "title", "hello, master"); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.RequestsTo(FeiShuRobotMessageAPI+"qa-key")) != 1 {
+		t.Fatal("expect the message redirected")
+	}
+	expectFields(t, server, FeiShuRobotMessageAPI+"qa-key", map[string]interface{}{"content": "[staging]\nhello, master"})
+}
@@ -4,24 +4,29 @@ package bytedance
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"time"
+
+	"github.com/duoland/chatapi"
 )
 
 // FeiShuRobotMessageAPI is the api to send the shortcut messages
 const FeiShuRobotMessageAPI = "https://www.feishu.cn/flow/api/trigger-webhook/"
 
+// FeiShuRobotRateLimit is the rate limit of the feishu robot, 100 messages per minute and 5 per second for each webhook
+var FeiShuRobotRateLimit = chatapi.RateLimit{Rate: 100, Period: time.Minute, Burst: 5}
+
 // FeiShuRobotTimeout is the feishu shortcut default timeout
 const FeiShuRobotTimeout = time.Second * 10
 const FeiShuRobotStatusOK = 0
 
 // FeiShuRobot is a robot to send feishu shortcut messages
 type FeiShuRobot struct {
-	client *http.Client
+	client  *http.Client
+	options chatapi.Options
 }
 
 type FeiShuRobotMessageResp struct {
@@ -30,60 +35,93 @@ type FeiShuRobotMessageResp struct {
 }
 
 // NewFeiShuRobot create a new feishu shortcut robot
-func NewFeiShuRobot() *FeiShuRobot {
-	return NewFeiShuRobotWithTimeout(FeiShuRobotTimeout)
+func NewFeiShuRobot(opts ...chatapi.Option) *FeiShuRobot {
+	return NewFeiShuRobotWithTimeout(FeiShuRobotTimeout, opts...)
 }
 
 // NewFeiShuRobotWithTimeout create a new feishu shortcut robot with timeout
-func NewFeiShuRobotWithTimeout(timeout time.Duration) *FeiShuRobot {
+func NewFeiShuRobotWithTimeout(timeout time.Duration, opts ...chatapi.Option) *FeiShuRobot {
 	client := http.Client{}
 	client.Timeout = timeout
-	return &FeiShuRobot{client: &client}
+	return NewFeiShuRobotWithClient(&client, opts...)
 }
 
 // NewFeiShuRobotWithClient create a new feishu shortcut robot with http.Client
-func NewFeiShuRobotWithClient(client *http.Client) *FeiShuRobot {
-	return &FeiShuRobot{client: client}
+func NewFeiShuRobotWithClient(client *http.Client, opts ...chatapi.Option) *FeiShuRobot {
+	return &FeiShuRobot{client: client, options: chatapi.NewOptions(opts...)}
 }
 
 // SendTextMessage send the text message
 func (r *FeiShuRobot) SendTextMessage(key, title, content string) (err error) {
-	messageObj := map[string]string{"title": title, "content": content}
-	return r.sendMessage(key, &messageObj)
+	return r.SendTextMessageCtx(context.Background(), key, title, content)
 }
 
-func (r *FeiShuRobot) sendMessage(key string, messageObj interface{}) (err error) {
-	reqURL := fmt.Sprintf("%s%s", FeiShuRobotMessageAPI, key)
-	reqBody, _ := json.Marshal(messageObj)
+// SendTextMessageCtx is SendTextMessage with the context to control the request
+func (r *FeiShuRobot) SendTextMessageCtx(ctx context.Context, key, title, content string) (err error) {
+	messageObj := map[string]string{"title": title, "content": content}
+	return r.sendMessage(ctx, key, &messageObj)
+}
 
-	req, newErr := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(reqBody))
-	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
-		return
+// sendMessage send the message, or its parts in order when the long messages are split
+func (r *FeiShuRobot) sendMessage(ctx context.Context, key string, messageObj interface{}) (err error) {
+	var parts []interface{}
+	switch {
+	case r.options.SplitLongMessages:
+		parts = splitFeiShuRobotMessage(r.options.RecipientPolicy, messageObj)
+	case r.options.TruncateLongMessages:
+		truncateFeiShuRobotMessage(&r.options, messageObj)
 	}
-	req.Header.Add("Content-Type", "application/json")
-	resp, getErr := r.client.Do(req)
-	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
-		return
+	if len(parts) == 0 {
+		return r.sendSingleMessage(ctx, key, messageObj)
 	}
-	defer resp.Body.Close()
-	// check http code
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("feishu shortcut request error, %s", resp.Status)
-		io.Copy(ioutil.Discard, resp.Body)
-		return
+	for i, part := range parts {
+		if partErr := r.sendSingleMessage(ctx, key, part); partErr != nil {
+			err = fmt.Errorf("send part %d/%d error, %w", i+1, len(parts), partErr)
+			return
+		}
 	}
-	// parse response body
-	decoder := json.NewDecoder(resp.Body)
-	var messageResp FeiShuRobotMessageResp
-	if decodeErr := decoder.Decode(&messageResp); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
+	return
+}
+
+// sendSingleMessage post the message under the rate limit of the webhook and retry it by the retry policy, the message is only resent
+// when the platform rejected it without handling, e.g. by the rate limits
+func (r *FeiShuRobot) sendSingleMessage(ctx context.Context, key string, messageObj interface{}) (err error) {
+	if key, err = feiShuRobotKey(r.options.RecipientPolicy, key); err != nil {
 		return
 	}
-	if messageResp.Code != FeiShuRobotStatusOK {
-		err = fmt.Errorf("call feishu shortcut api error, %d %s", messageResp.Code, messageResp.Message)
+	if message, ok := messageObj.(*map[string]string); ok && r.options.RecipientPolicy != nil {
+		(*message)["content"] = r.options.RecipientPolicy.WithBanner((*message)["content"])
+	}
+	if !r.options.SkipValidation {
+		if err = validateFeiShuRobotMessage(messageObj); err != nil {
+			return
+		}
+	}
+	return r.options.Retry.Do(ctx, false, func() error {
+		if err := r.options.RateLimiter.Wait(ctx, chatapi.ProviderFeiShu+":robot:"+key); err != nil {
+			return err
+		}
+		return r.postMessage(ctx, key, messageObj)
+	})
+}
+
+func (r *FeiShuRobot) postMessage(ctx context.Context, key string, messageObj interface{}) (err error) {
+	reqURL := fmt.Sprintf("%s%s", r.options.URL(FeiShuRobotMessageAPI), key)
+	reqBody, _ := json.Marshal(messageObj)
+
+	req, newErr := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(reqBody))
+	if newErr != nil {
+		err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
 		return
 	}
-	return
+	req.Header.Add("Content-Type", "application/json")
+	var messageResp FeiShuRobotMessageResp
+	call := chatapi.Call{Provider: chatapi.ProviderFeiShu, Operation: "FeiShuRobot.SendMessage", Endpoint: FeiShuRobotMessageAPI,
+		Header: req.Header, Request: messageObj, Response: &messageResp}
+	return chatapi.Intercept(ctx, r.options.Interceptors, &call, func(ctx context.Context, call *chatapi.Call) error {
+		if r.options.DryRun != nil {
+			return chatapi.DryRun(r.options.DryRun, call, req, feiShuDryRunResp)
+		}
+		return doFeiShuRequest(r.client, req.WithContext(ctx), FeiShuRobotMessageAPI, &messageResp)
+	})
 }
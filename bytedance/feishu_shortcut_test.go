@@ -1,14 +1,24 @@
 package bytedance
 
 import (
-	"os"
 	"testing"
+
+	"github.com/duoland/chatapi"
+	"github.com/duoland/chatapi/chatapitest"
 )
 
-var feishuShortcutKey = os.Getenv("FEISHU_SHORTCUT_KEY")
+var feishuShortcutKey = "shortcut-key"
+
+// newTestFeiShuRobot create the robot on a fake feishu server closed with the test
+func newTestFeiShuRobot(t *testing.T, opts ...chatapi.Option) (*FeiShuRobot, *chatapitest.Server) {
+	server := chatapitest.NewFeiShuServer()
+	t.Cleanup(server.Close)
+	opts = append([]chatapi.Option{chatapi.WithBaseURL(server.URL)}, opts...)
+	return NewFeiShuRobot(opts...), server
+}
 
 func TestNewFeiShuRobot_SendTextMessage(t *testing.T) {
-	robot := NewFeiShuRobot()
+	robot, server := newTestFeiShuRobot(t)
 	title := "this is a robot message"
 	content := "great dreams comes from little steps"
 	err := robot.SendTextMessage(feishuShortcutKey, title, content)
@@ -16,4 +26,26 @@ func TestNewFeiShuRobot_SendTextMessage(t *testing.T) {
 		t.Fatal(err)
 		return
 	}
+	expectFields(t, server, FeiShuRobotMessageAPI+feishuShortcutKey, map[string]interface{}{"title": title, "content": content})
+
+	server.Fail(FeiShuRobotMessageAPI, chatapitest.Fault{HTTPStatus: 500})
+	if err = robot.SendTextMessage(feishuShortcutKey, title, content); err == nil {
+		t.Fatal("expect the server error")
+	}
+}
+
+func TestFeiShuRobot_DryRun(t *testing.T) {
+	recorder := chatapi.NewDryRunRecorder()
+	robot, server := newTestFeiShuRobot(t, chatapi.WithDryRun(recorder))
+	if err := robot.SendTextMessage(feishuShortcutKey, "title", "hello, master"); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect no request sent in the dry run, got %d", len(server.Requests()))
+	}
+	requests := recorder.Requests()
+	if len(requests) != 1 || requests[0].URL != server.URL+"/flow/api/trigger-webhook/REDACTED" ||
+		string(requests[0].Body) != `{"content":"hello, master","title":"title"}` {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
 }
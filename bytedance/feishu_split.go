@@ -0,0 +1,54 @@
+package bytedance
+
+import (
+	"github.com/duoland/chatapi"
+)
+
+// feiShuSplitter returns the splitter of the text limit, leaving room for the banner of the policy
+func feiShuSplitter(policy *chatapi.RecipientPolicy) chatapi.Splitter {
+	splitter := chatapi.Splitter{MaxBytes: FeiShuTextMaxBytes}
+	if policy != nil {
+		splitter = splitter.Reserve(policy.WithBanner(""))
+	}
+	return splitter
+}
+
+// splitFeiShuAppMessage returns the messages of the parts of the long text app message, nil if it fits.
+// The post messages are rich text and not split.
+func splitFeiShuAppMessage(policy *chatapi.RecipientPolicy, messageObj interface{}) (parts []interface{}) {
+	messageReq, ok := messageObj.(*FeiShuAppMessageSendReq)
+	if !ok || messageReq.MessageType != FeiShuAppMessageTypeText {
+		return
+	}
+	content, ok := messageReq.Content.(map[string]string)
+	if !ok {
+		return
+	}
+	contents := feiShuSplitter(policy).Split(content["text"])
+	if len(contents) < 2 {
+		return
+	}
+	for _, text := range contents {
+		partReq := *messageReq
+		partReq.Content = map[string]string{"text": text}
+		parts = append(parts, &partReq)
+	}
+	return
+}
+
+// splitFeiShuRobotMessage returns the messages of the parts of the long robot message, nil if it fits
+func splitFeiShuRobotMessage(policy *chatapi.RecipientPolicy, messageObj interface{}) (parts []interface{}) {
+	message, ok := messageObj.(*map[string]string)
+	if !ok {
+		return
+	}
+	contents := feiShuSplitter(policy).Split((*message)["content"])
+	if len(contents) < 2 {
+		return
+	}
+	for _, content := range contents {
+		partMessage := map[string]string{"title": (*message)["title"], "content": content}
+		parts = append(parts, &partMessage)
+	}
+	return
+}
@@ -0,0 +1,45 @@
+package bytedance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestFeiShuApp_SplitLongMessages(t *testing.T) {
+	feishuApp, server := newTestFeiShuApp(t, chatapi.WithSplit())
+	content := strings.Repeat("告警内容 ", FeiShuTextMaxBytes/13+1)
+	resp, err := feishuApp.SendTextMessage(&FeiShuAppMessageSendTarget{ChatID: chatID}, content, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := server.RequestsTo(FeiShuAppSendMessageAPI)
+	if len(requests) != 2 || len(resp.MessageIDs) != 2 || resp.Data.MessageID != resp.MessageIDs[1] {
+		t.Fatalf("expect the message sent in 2 parts with the message ids, got %d, %+v", len(requests), resp)
+	}
+	for i, req := range requests {
+		body := req.JSON()
+		text, _ := body["content"].(map[string]interface{})["text"].(string)
+		if body["chat_id"] != chatID || len(text) > FeiShuTextMaxBytes || !strings.HasSuffix(text, []string{"\n(1/2)", "\n(2/2)"}[i]) {
+			t.Fatalf("expect every part sent to the same chat within the limit, got part %d of %d bytes", i+1, len(text))
+		}
+	}
+}
+
+func TestFeiShuRobot_SplitLongMessages(t *testing.T) {
+	feishuRobot, server := newTestFeiShuRobot(t, chatapi.WithSplit())
+	content := strings.Repeat("a\n", FeiShuTextMaxBytes/2+1)
+	if err := feishuRobot.SendTextMessage(feishuShortcutKey, "alert", content); err != nil {
+		t.Fatal(err)
+	}
+	requests := server.RequestsTo(FeiShuRobotMessageAPI)
+	if len(requests) != 2 {
+		t.Fatalf("expect the message sent in 2 parts, got %d", len(requests))
+	}
+	for _, req := range requests {
+		if body := req.JSON(); !strings.HasSuffix(req.Path, feishuShortcutKey) || body["title"] != "alert" {
+			t.Fatalf("expect every part sent to the same webhook with the title, got %s", req.Path)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package bytedance
+
+import (
+	"github.com/duoland/chatapi"
+)
+
+// FeiShuTruncator returns the truncator of the content limit of the message type, false for the types without
+// a content limit. The robot messages are text.
+func FeiShuTruncator(messageType, moreURL string) (truncator chatapi.Truncator, ok bool) {
+	if messageType == FeiShuAppMessageTypeText {
+		return chatapi.Truncator{MaxBytes: FeiShuTextMaxBytes, MoreURL: moreURL}, true
+	}
+	return
+}
+
+// TruncateFeiShuContent cut the content of the message to the byte limit of the message type,
+// the content of the types without a content limit is returned as is
+func TruncateFeiShuContent(messageType, content, moreURL string) string {
+	if truncator, ok := FeiShuTruncator(messageType, moreURL); ok {
+		return truncator.Truncate(content)
+	}
+	return content
+}
+
+// feiShuTextTruncator returns the truncator of the text, leaving room for the banner of the policy
+func feiShuTextTruncator(options *chatapi.Options) chatapi.Truncator {
+	truncator, _ := FeiShuTruncator(FeiShuAppMessageTypeText, options.TruncateMoreURL)
+	if options.RecipientPolicy != nil {
+		truncator = truncator.Reserve(options.RecipientPolicy.WithBanner(""))
+	}
+	return truncator
+}
+
+// truncateFeiShuAppMessage cut the content of the text app message to the limit
+func truncateFeiShuAppMessage(options *chatapi.Options, messageObj interface{}) {
+	messageReq, ok := messageObj.(*FeiShuAppMessageSendReq)
+	if !ok || messageReq.MessageType != FeiShuAppMessageTypeText {
+		return
+	}
+	if content, ok := messageReq.Content.(map[string]string); ok {
+		content["text"] = feiShuTextTruncator(options).Truncate(content["text"])
+	}
+}
+
+// truncateFeiShuRobotMessage cut the content of the robot message to the limit
+func truncateFeiShuRobotMessage(options *chatapi.Options, messageObj interface{}) {
+	if message, ok := messageObj.(*map[string]string); ok {
+		(*message)["content"] = feiShuTextTruncator(options).Truncate((*message)["content"])
+	}
+}
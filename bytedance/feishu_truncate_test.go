@@ -0,0 +1,27 @@
+package bytedance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestFeiShuRobot_TruncateLongMessages(t *testing.T) {
+	feishuRobot, server := newTestFeiShuRobot(t, chatapi.WithTruncation("https://ci.example.com/builds/1"))
+	content := strings.Repeat("告警内容 ", FeiShuTextMaxBytes/13+1)
+	if err := feishuRobot.SendTextMessage(feishuShortcutKey, "alert", content); err != nil {
+		t.Fatal(err)
+	}
+	req, ok := server.LastRequest(FeiShuRobotMessageAPI)
+	if !ok {
+		t.Fatal("expect the message sent")
+	}
+	truncated, _ := req.JSON()["content"].(string)
+	if len(truncated) > FeiShuTextMaxBytes || !strings.HasSuffix(truncated, "…\nview more: https://ci.example.com/builds/1") {
+		t.Fatalf("unexpected truncated content of %d bytes", len(truncated))
+	}
+	if TruncateFeiShuContent(FeiShuAppMessageTypePost, content, "") != content {
+		t.Fatal("expect the post content kept")
+	}
+}
@@ -0,0 +1,49 @@
+package bytedance
+
+import (
+	"github.com/duoland/chatapi"
+)
+
+// FeiShuTextMaxBytes is the limit of the text message, see doc https://open.feishu.cn/document/ukTMukTMukTM/uUjNz4SN2MjL1YzM
+const FeiShuTextMaxBytes = 150 * 1024
+
+// feiShuAppRules is the rules of the app messages
+var feiShuAppRules = map[string][]chatapi.FieldRule{
+	FeiShuAppMessageTypeText: {
+		{Field: "content.text", Required: true, MaxBytes: FeiShuTextMaxBytes},
+	},
+	FeiShuAppMessageTypeImage: {
+		{Field: "content.image_key", Required: true},
+	},
+	FeiShuAppMessageTypePost: {
+		{Field: "content.post", Required: true},
+		{Field: "content.post.*.content", Required: true},
+		{Field: "content.post.*.content.*.*.tag", Required: true},
+		{Field: "content.post.*.content.*.*.href", URL: true},
+	},
+}
+
+// feiShuAppTargetRules is the rules of the target of the app messages
+var feiShuAppTargetRules = []chatapi.FieldRule{
+	{Field: "chat_id", Required: true, Alternatives: []string{"open_id", "user_id", "email"}},
+}
+
+// feiShuRobotRules is the rules of the robot messages
+var feiShuRobotRules = []chatapi.FieldRule{
+	{Field: "content", Required: true, MaxBytes: FeiShuTextMaxBytes},
+}
+
+// validateFeiShuAppMessage check the app message against the limits of feishu
+func validateFeiShuAppMessage(messageObj interface{}) error {
+	messageReq, ok := messageObj.(*FeiShuAppMessageSendReq)
+	if !ok {
+		return nil
+	}
+	rules := append(append([]chatapi.FieldRule(nil), feiShuAppTargetRules...), feiShuAppRules[messageReq.MessageType]...)
+	return chatapi.ValidateMessage(chatapi.ProviderFeiShu, messageReq.MessageType, messageObj, rules)
+}
+
+// validateFeiShuRobotMessage check the robot message against the limits of feishu
+func validateFeiShuRobotMessage(messageObj interface{}) error {
+	return chatapi.ValidateMessage(chatapi.ProviderFeiShu, FeiShuAppMessageTypeText, messageObj, feiShuRobotRules)
+}
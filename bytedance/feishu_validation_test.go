@@ -0,0 +1,45 @@
+package bytedance
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+// expectFieldError check the err is the validation error of the field and the rule
+func expectFieldError(t *testing.T, err error, field, rule string) {
+	t.Helper()
+	var validationErr *chatapi.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expect the validation error, got %v", err)
+	}
+	for _, fieldErr := range validationErr.Fields {
+		if fieldErr.Field == field && fieldErr.Rule == rule {
+			return
+		}
+	}
+	t.Fatalf("expect %s breaks %s, got %v", field, rule, validationErr)
+}
+
+func TestFeiShuApp_Validation(t *testing.T) {
+	app, server := newTestFeiShuApp(t)
+	_, err := app.SendTextMessage(&FeiShuAppMessageSendTarget{}, "hello, master", nil)
+	expectFieldError(t, err, "chat_id", chatapi.RuleRequired)
+	_, err = app.SendImageMessage(&FeiShuAppMessageSendTarget{ChatID: chatID}, "", nil)
+	expectFieldError(t, err, "content.image_key", chatapi.RuleRequired)
+	lines := [][]FeishuAppPostMessageContentItem{{{Tag: FeiShuAppPostMessageHref, Text: "baidu", Href: "www.baidu.com"}}}
+	_, err = app.SendPostMessage(&FeiShuAppMessageSendTarget{ChatID: chatID}, "hello", FeiShuAppI18nChinese, lines, nil)
+	expectFieldError(t, err, "content.post.zh_cn.content.0.0.href", chatapi.RuleURL)
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect the invalid messages not sent, got %d requests", len(server.Requests()))
+	}
+}
+
+func TestFeiShuRobot_Validation(t *testing.T) {
+	robot, server := newTestFeiShuRobot(t)
+	expectFieldError(t, robot.SendTextMessage(feishuShortcutKey, "title", ""), "content", chatapi.RuleRequired)
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect the invalid message not sent, got %d requests", len(server.Requests()))
+	}
+}
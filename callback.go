@@ -0,0 +1,147 @@
+package chatapi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidSignature is returned when the signature of the callback from the platform does not match
+var ErrInvalidSignature = errors.New("invalid callback signature")
+
+// ErrInvalidCallback is returned when the callback from the platform can not be decrypted or decoded
+var ErrInvalidCallback = errors.New("invalid callback")
+
+// ErrSessionExpired is returned when the reply is sent after the session of the inbound message expired
+var ErrSessionExpired = errors.New("reply session expired")
+
+// CallbackMaxBodyBytes is the max body of the callback requests read by the handlers
+const CallbackMaxBodyBytes = 1 << 20
+
+// PKCS7Pad returns the data padded to the multiple of the block size
+func PKCS7Pad(data []byte, blockSize int) []byte {
+	padding := blockSize - len(data)%blockSize
+	padded := make([]byte, len(data), len(data)+padding)
+	copy(padded, data)
+	for i := 0; i < padding; i++ {
+		padded = append(padded, byte(padding))
+	}
+	return padded
+}
+
+// PKCS7Unpad returns the data without the padding up to the block size
+func PKCS7Unpad(data []byte, blockSize int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w, empty padded data", ErrInvalidCallback)
+	}
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > blockSize || padding > len(data) {
+		return nil, fmt.Errorf("%w, invalid padding %d", ErrInvalidCallback, padding)
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, fmt.Errorf("%w, invalid padding %d", ErrInvalidCallback, padding)
+		}
+	}
+	return data[:len(data)-padding], nil
+}
+
+// callbackBlockSize is the block size of the pkcs7 padding of the callback messages, not the aes block size
+const callbackBlockSize = 32
+
+// CallbackCrypto sign, encrypt and decrypt the callback messages of the wxwork and dingding envelope: the aes-cbc
+// of the 16 random bytes, the big endian message size of 4 bytes, the message and the receiver id, with the iv
+// of the first 16 bytes of the key. The signature is the sha1 of the sorted token, timestamp, nonce and encrypt.
+type CallbackCrypto struct {
+	token      string
+	aesKey     []byte
+	receiverID string
+}
+
+// NewCallbackCrypto create the crypto by the token and the EncodingAESKey of 43 characters set on the callback
+// settings, the receiver id is appended to the messages by the platform, e.g. the corp id or the app key
+func NewCallbackCrypto(token, encodingAESKey, receiverID string) (crypto *CallbackCrypto, err error) {
+	aesKey, decodeErr := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if decodeErr != nil || len(aesKey) != 32 {
+		err = errors.New("invalid encoding aes key, expect 43 characters of base64")
+		return
+	}
+	crypto = &CallbackCrypto{token: token, aesKey: aesKey, receiverID: receiverID}
+	return
+}
+
+// Signature returns the signature of the encrypted message
+func (c *CallbackCrypto) Signature(timestamp, nonce, encrypted string) string {
+	parts := []string{c.token, timestamp, nonce, encrypted}
+	sort.Strings(parts)
+	sum := sha1.Sum([]byte(strings.Join(parts, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifySignature check the signature of the encrypted message
+func (c *CallbackCrypto) VerifySignature(signature, timestamp, nonce, encrypted string) bool {
+	return subtle.ConstantTimeCompare([]byte(signature), []byte(c.Signature(timestamp, nonce, encrypted))) == 1
+}
+
+// Decrypt returns the message of the encrypted base64 text, the receiver id in the message must match
+func (c *CallbackCrypto) Decrypt(encrypted string) (message []byte, err error) {
+	cipherText, decodeErr := base64.StdEncoding.DecodeString(encrypted)
+	if decodeErr != nil {
+		err = fmt.Errorf("%w, decode base64 error, %v", ErrInvalidCallback, decodeErr)
+		return
+	}
+	if len(cipherText) == 0 || len(cipherText)%aes.BlockSize != 0 {
+		err = fmt.Errorf("%w, invalid cipher text size %d", ErrInvalidCallback, len(cipherText))
+		return
+	}
+	block, _ := aes.NewCipher(c.aesKey)
+	plainText := make([]byte, len(cipherText))
+	cipher.NewCBCDecrypter(block, c.aesKey[:aes.BlockSize]).CryptBlocks(plainText, cipherText)
+	if plainText, err = PKCS7Unpad(plainText, callbackBlockSize); err != nil {
+		return
+	}
+	// the plain text is 16 random bytes, the message size of 4 bytes, the message and the receiver id
+	if len(plainText) < 20 {
+		err = fmt.Errorf("%w, plain text too short", ErrInvalidCallback)
+		return
+	}
+	size := int(binary.BigEndian.Uint32(plainText[16:20]))
+	if size > len(plainText)-20 {
+		err = fmt.Errorf("%w, invalid message size %d", ErrInvalidCallback, size)
+		return
+	}
+	message = plainText[20 : 20+size]
+	if receiverID := string(plainText[20+size:]); receiverID != c.receiverID {
+		err = fmt.Errorf("%w, unexpected receiver id %s", ErrInvalidCallback, receiverID)
+		message = nil
+	}
+	return
+}
+
+// Encrypt returns the encrypted base64 text of the message
+func (c *CallbackCrypto) Encrypt(message []byte) (encrypted string, err error) {
+	plainText := make([]byte, 20, 20+len(message)+len(c.receiverID))
+	if _, err = io.ReadFull(rand.Reader, plainText[:16]); err != nil {
+		err = fmt.Errorf("generate random error, %w", err)
+		return
+	}
+	binary.BigEndian.PutUint32(plainText[16:20], uint32(len(message)))
+	plainText = append(append(plainText, message...), c.receiverID...)
+	plainText = PKCS7Pad(plainText, callbackBlockSize)
+
+	block, _ := aes.NewCipher(c.aesKey)
+	cipherText := make([]byte, len(plainText))
+	cipher.NewCBCEncrypter(block, c.aesKey[:aes.BlockSize]).CryptBlocks(cipherText, plainText)
+	encrypted = base64.StdEncoding.EncodeToString(cipherText)
+	return
+}
@@ -0,0 +1,52 @@
+package chatapi
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPKCS7(t *testing.T) {
+	for _, size := range []int{0, 1, 31, 32, 33} {
+		data := bytes.Repeat([]byte("a"), size)
+		padded := PKCS7Pad(data, 32)
+		if len(padded)%32 != 0 || len(padded) <= size {
+			t.Fatalf("unexpected padded size %d of %d bytes", len(padded), size)
+		}
+		unpadded, err := PKCS7Unpad(padded, 32)
+		if err != nil || !bytes.Equal(unpadded, data) {
+			t.Fatalf("expect the data of %d bytes unpadded, got %d bytes, %v", size, len(unpadded), err)
+		}
+	}
+	for _, padded := range [][]byte{nil, {0}, {33}, {1, 2, 2, 3}} {
+		if _, err := PKCS7Unpad(padded, 32); !errors.Is(err, ErrInvalidCallback) {
+			t.Fatalf("expect the invalid padding %v rejected, got %v", padded, err)
+		}
+	}
+}
+
+func TestCallbackCrypto(t *testing.T) {
+	if _, err := NewCallbackCrypto("token", "short", "ww1"); err == nil {
+		t.Fatal("expect the invalid aes key rejected")
+	}
+	encodingAESKey := "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C"
+	crypto, err := NewCallbackCrypto("QDG6eK", encodingAESKey, "ww1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := crypto.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message, err := crypto.Decrypt(encrypted); err != nil || string(message) != "hello" {
+		t.Fatalf("expect the message decrypted, got %q, %v", message, err)
+	}
+	other, _ := NewCallbackCrypto("QDG6eK", encodingAESKey, "ww2")
+	if _, err := other.Decrypt(encrypted); !errors.Is(err, ErrInvalidCallback) {
+		t.Fatalf("expect the message of the other receiver rejected, got %v", err)
+	}
+	signature := crypto.Signature("1409659589", "263014780", encrypted)
+	if !crypto.VerifySignature(signature, "1409659589", "263014780", encrypted) || crypto.VerifySignature(signature, "1409659590", "263014780", encrypted) {
+		t.Fatal("expect the signature verified by the timestamp and the nonce")
+	}
+}
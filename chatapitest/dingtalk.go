@@ -0,0 +1,143 @@
+package chatapitest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/duoland/chatapi"
+)
+
+// the errcodes answered by the fake dingtalk server
+const (
+	dingDingCodeInvalidCredential  = 40089
+	dingDingCodeAccessTokenInvalid = 40014
+	dingDingCodeAccessTokenExpired = 42001
+	dingDingCodeChatNotFound       = 4000003
+	dingDingCodeRobotNotFound      = 300001
+	dingDingCodeRobotSignNotMatch  = 310000
+)
+
+// NewDingTalkServer start a fake of the dingtalk apis: the app access token, the corp conversation message
+// send, progress, result and recall, group chat create, update, get and send, the robot webhook send and the
+// outgoing robot session webhook send.
+// The robot sign is checked for the access tokens set by SetRobotSecret. The server should be closed after use.
+func NewDingTalkServer() *Server {
+	return newServer(&platform{
+		provider:   chatapi.ProviderDingTalk,
+		codeKey:    "errcode",
+		messageKey: "errmsg",
+		token: func(r *http.Request) string {
+			return r.URL.Query().Get("access_token")
+		},
+		expiredCode:  dingDingCodeAccessTokenExpired,
+		invalidCode:  dingDingCodeAccessTokenInvalid,
+		notFoundCode: dingDingCodeChatNotFound,
+		routes: map[string]route{
+			"/gettoken":    {handle: dingDingToken},
+			"/chat/create": {auth: true, handle: dingDingCreateGroup},
+			"/chat/update": {auth: true, handle: dingDingUpdateGroup},
+			"/chat/get":    {auth: true, handle: dingDingGetGroup},
+			"/chat/send":   {auth: true, handle: dingDingSendGroupMessage},
+			"/topapi/message/corpconversation/asyncsend_v2":    {auth: true, handle: dingDingSendMessage},
+			"/topapi/message/corpconversation/getsendprogress": {auth: true, handle: dingDingSendProgress},
+			"/topapi/message/corpconversation/getsendresult":   {auth: true, handle: dingDingSendResult},
+			"/topapi/message/corpconversation/recall":          {auth: true, handle: dingDingRecall},
+			"/robot/send":          {handle: dingDingRobotSend},
+			"/robot/sendBySession": {handle: dingDingRobotSendBySession},
+		},
+	})
+}
+
+// SetRobotSecret check the sign of the robot messages sent with the access token by the secret
+func (s *Server) SetRobotSecret(accessToken, secret string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.robotSecrets == nil {
+		s.robotSecrets = make(map[string]string)
+	}
+	s.robotSecrets[accessToken] = secret
+}
+
+func dingDingToken(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	token, ttl, ok := s.issueToken(req.Query.Get("appkey"), req.Query.Get("appsecret"))
+	if !ok {
+		return nil, &Fault{ErrCode: dingDingCodeInvalidCredential, ErrMessage: "invalid appkey or appsecret"}
+	}
+	return map[string]interface{}{"access_token": token, "expires_in": int(ttl / time.Second)}, nil
+}
+
+func dingDingCreateGroup(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	body := req.JSON()
+	group := Group{ChatID: s.nextID("chat"), Name: stringValue(body["name"]),
+		Owner: stringValue(body["owner"]), Users: stringList(body["useridlist"])}
+	s.groups[group.ChatID] = &group
+	return map[string]interface{}{"chatid": group.ChatID, "conversationTag": 2}, nil
+}
+
+func dingDingUpdateGroup(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	body := req.JSON()
+	chatID := stringValue(body["chatid"])
+	if !s.updateGroup(chatID, stringValue(body["name"]), stringValue(body["owner"]),
+		stringList(body["add_useridlist"]), stringList(body["del_useridlist"])) {
+		return nil, s.notFound("chat " + chatID)
+	}
+	return nil, nil
+}
+
+func dingDingGetGroup(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	chatID := req.Query.Get("chatid")
+	group, ok := s.groups[chatID]
+	if !ok {
+		return nil, s.notFound("chat " + chatID)
+	}
+	return map[string]interface{}{"chat_info": map[string]interface{}{
+		"chatid": group.ChatID, "name": group.Name, "owner": group.Owner, "useridlist": group.Users}}, nil
+}
+
+func dingDingSendGroupMessage(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	return map[string]interface{}{"messageId": s.nextID("msg")}, nil
+}
+
+func dingDingSendMessage(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	s.seq++
+	return map[string]interface{}{"task_id": s.seq}, nil
+}
+
+func dingDingSendProgress(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	// the async sends are done at once
+	return map[string]interface{}{"progress": map[string]interface{}{"progress_in_percent": 100, "status": 2}}, nil
+}
+
+func dingDingSendResult(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	return map[string]interface{}{"send_result": map[string]interface{}{}}, nil
+}
+
+func dingDingRecall(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	return nil, nil
+}
+
+func dingDingRobotSend(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	accessToken := req.Query.Get("access_token")
+	if accessToken == "" {
+		return nil, &Fault{ErrCode: dingDingCodeRobotNotFound, ErrMessage: "token is not exist"}
+	}
+	if secret, ok := s.robotSecrets[accessToken]; ok {
+		timestamp := req.Query.Get("timestamp")
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "\n" + secret))
+		if req.Query.Get("sign") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+			return nil, &Fault{ErrCode: dingDingCodeRobotSignNotMatch, ErrMessage: "sign not match"}
+		}
+	}
+	return nil, nil
+}
+
+func dingDingRobotSendBySession(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	if req.Query.Get("session") == "" {
+		return nil, &Fault{ErrCode: dingDingCodeRobotNotFound, ErrMessage: "session is not exist"}
+	}
+	return nil, nil
+}
@@ -0,0 +1,74 @@
+package chatapitest
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/duoland/chatapi"
+)
+
+// the codes answered by the fake feishu server
+const (
+	feiShuCodeAppSecretInvalid      = 10014
+	feiShuCodeAccessTokenInvalid    = 99991663
+	feiShuCodeAccessTokenOutdated   = 99991677
+	feiShuCodeChatNotFound          = 19001
+	feiShuCodeInvalidWebhookKey     = 9499
+	feiShuRobotMessageAPIPathPrefix = "/flow/api/trigger-webhook/"
+)
+
+// NewFeiShuServer start a fake of the feishu apis: the tenant access token, message send, group chat create,
+// and the shortcut robot webhook send. The rejected access tokens are answered with the code and the http
+// status 400 as the gateway of the open apis. The server should be closed after use.
+func NewFeiShuServer() *Server {
+	return newServer(&platform{
+		provider:   chatapi.ProviderFeiShu,
+		codeKey:    "code",
+		messageKey: "msg",
+		token: func(r *http.Request) string {
+			return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		},
+		expiredCode:  feiShuCodeAccessTokenOutdated,
+		invalidCode:  feiShuCodeAccessTokenInvalid,
+		tokenStatus:  http.StatusBadRequest,
+		notFoundCode: feiShuCodeChatNotFound,
+		routes: map[string]route{
+			"/open-apis/auth/v3/tenant_access_token/internal/": {handle: feiShuToken},
+			"/open-apis/message/v4/send/":                      {auth: true, handle: feiShuSendMessage},
+			"/open-apis/chat/v4/create/":                       {auth: true, handle: feiShuCreateGroup},
+		},
+		prefixRoutes: map[string]route{
+			feiShuRobotMessageAPIPathPrefix: {handle: feiShuRobotSend},
+		},
+	})
+}
+
+func feiShuToken(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	body := req.JSON()
+	token, ttl, ok := s.issueToken(stringValue(body["app_id"]), stringValue(body["app_secret"]))
+	if !ok {
+		return nil, &Fault{ErrCode: feiShuCodeAppSecretInvalid, ErrMessage: "app secret invalid"}
+	}
+	return map[string]interface{}{"tenant_access_token": token, "expire": int(ttl / time.Second)}, nil
+}
+
+func feiShuSendMessage(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	return map[string]interface{}{"data": map[string]interface{}{"message_id": s.nextID("om_")}}, nil
+}
+
+func feiShuCreateGroup(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	body := req.JSON()
+	group := Group{ChatID: s.nextID("oc_"), Name: stringValue(body["name"]),
+		Description: stringValue(body["description"]), Users: stringList(body["user_ids"])}
+	s.groups[group.ChatID] = &group
+	return map[string]interface{}{"data": map[string]interface{}{"chat_id": group.ChatID,
+		"invalid_open_ids": []string{}, "invalid_user_ids": []string{}}}, nil
+}
+
+func feiShuRobotSend(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	if strings.TrimPrefix(req.Path, feiShuRobotMessageAPIPathPrefix) == "" {
+		return nil, &Fault{ErrCode: feiShuCodeInvalidWebhookKey, ErrMessage: "invalid webhook key"}
+	}
+	return nil, nil
+}
@@ -0,0 +1,63 @@
+package chatapitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// CompareGolden check the json payload against the golden file, with update the golden file is rewritten instead.
+// The payload is indented to keep the golden files readable, the keys, their order and the values are kept as sent.
+func CompareGolden(t testing.TB, goldenFile string, payload []byte, update bool) {
+	t.Helper()
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, payload, "", "  "); err != nil {
+		t.Fatalf("parse payload error, %s", err)
+	}
+	indented.WriteByte('\n')
+	if update {
+		if err := os.MkdirAll(filepath.Dir(goldenFile), 0755); err != nil {
+			t.Fatalf("create golden dir error, %s", err)
+		}
+		if err := ioutil.WriteFile(goldenFile, indented.Bytes(), 0644); err != nil {
+			t.Fatalf("write golden file error, %s", err)
+		}
+		return
+	}
+	expect, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("read golden file error, %s, run the test with -update to create it", err)
+	}
+	if !bytes.Equal(expect, indented.Bytes()) {
+		t.Errorf("payload differs from the golden file %s, run the test with -update if the change is intended\ngot:\n%s\nexpect:\n%s",
+			goldenFile, indented.Bytes(), expect)
+	}
+}
+
+// GoldenCase is a message sent to compare its payload with the golden file testdata/golden/<Name>.json
+type GoldenCase struct {
+	Name string
+	API  string // the api of the request compared
+	Send func() error
+}
+
+// RunGoldenCases send the message of each case in a subtest and compare the payload of its last request to the
+// api with the golden file, with update the golden files are rewritten instead
+func RunGoldenCases(t *testing.T, server *Server, cases []GoldenCase, update bool) {
+	t.Helper()
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if err := c.Send(); err != nil {
+				t.Fatal(err)
+			}
+			req, ok := server.LastRequest(c.API)
+			if !ok {
+				t.Fatalf("expect a request to %s", c.API)
+			}
+			CompareGolden(t, filepath.Join("testdata", "golden", c.Name+".json"), req.Body, update)
+		})
+	}
+}
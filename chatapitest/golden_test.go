@@ -0,0 +1,23 @@
+package chatapitest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareGolden(t *testing.T) {
+	goldenFile := filepath.Join(t.TempDir(), "golden", "text.json")
+	payload := []byte(`{"msgtype":"text","text":{"content":"a<b"}}`)
+	CompareGolden(t, goldenFile, payload, true)
+
+	written, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "{\n  \"msgtype\": \"text\",\n  \"text\": {\n    \"content\": \"a<b\"\n  }\n}\n"
+	if string(written) != expect {
+		t.Fatalf("expect the indented payload written, got %s", written)
+	}
+	CompareGolden(t, goldenFile, payload, false)
+}
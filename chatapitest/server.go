@@ -0,0 +1,440 @@
+// Package chatapitest provides fakes of the wxwork, dingtalk and feishu apis for the tests,
+// point the clients at a fake server with chatapi.WithBaseURL(server.URL).
+package chatapitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTokenTTL is the lifetime of the access tokens issued by the fake servers
+const DefaultTokenTTL = time.Hour * 2
+
+// Request is a request received by the fake server
+type Request struct {
+	Method    string
+	Path      string
+	Query     url.Values
+	Header    http.Header
+	Body      []byte // the request body, or the file content of a multipart upload
+	FieldName string // the form field of a multipart upload
+	FileName  string // the file name of a multipart upload
+}
+
+// Decode unmarshal the json body of the request into v
+func (r *Request) Decode(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// JSON returns the json body of the request as a map, nil if the body is not a json object.
+// The json numbers are kept as json.Number.
+func (r *Request) JSON() map[string]interface{} {
+	var body map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(r.Body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return nil
+	}
+	return body
+}
+
+// Field returns the value at the dotted path of the json body, e.g. "text.content" or "news.articles.0.title",
+// nil if absent. The json numbers are json.Number, the objects map[string]interface{} and the arrays []interface{}.
+func (r *Request) Field(path string) interface{} {
+	var value interface{} = r.JSON()
+	for _, key := range strings.Split(path, ".") {
+		switch container := value.(type) {
+		case map[string]interface{}:
+			value = container[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil
+			}
+			value = container[index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// Fault is the failure answered by the fake server instead of handling the request
+type Fault struct {
+	HTTPStatus int           // the http status, 0 for 200 with the errcode
+	ErrCode    int           // the errcode in the response body
+	ErrMessage string        // the errmsg in the response body
+	RetryAfter time.Duration // the Retry-After header in seconds, 0 for none
+}
+
+// Group is a group chat kept by the fake server
+type Group struct {
+	ChatID      string
+	Name        string
+	Description string
+	Owner       string
+	Users       []string
+}
+
+// Server is a fake chat platform backed by httptest.Server. It issues access tokens and rejects
+// the expired or unknown ones, handles the message sends, uploads and group chats,
+// records the received requests and answers the queued faults.
+type Server struct {
+	*httptest.Server
+	platform *platform
+
+	lock       sync.Mutex
+	requests   []Request
+	faults     map[string][]Fault
+	tokens     map[string]time.Time
+	tokenCount int
+	tokenTTL   time.Duration
+	clientID   string
+	secret     string
+	groups     map[string]*Group
+	seq        int
+
+	robotSecrets map[string]string // the dingtalk robot secrets by the access token
+}
+
+// route handles the requests of an api path
+type route struct {
+	auth   bool // whether the app access token is required
+	handle func(s *Server, req *Request) (resp map[string]interface{}, fault *Fault)
+}
+
+// platform is the behavior of a chat platform faked by the server
+type platform struct {
+	provider     string
+	codeKey      string // the json key of the errcode
+	messageKey   string // the json key of the errmsg
+	token        func(r *http.Request) string
+	expiredCode  int
+	invalidCode  int
+	tokenStatus  int // the http status of the rejected access tokens, 0 for 200 with the errcode
+	notFoundCode int
+	routes       map[string]route
+	prefixRoutes map[string]route // the routes with the path parameters, e.g. the webhook key
+}
+
+func newServer(p *platform) *Server {
+	s := Server{platform: p, faults: make(map[string][]Fault), tokens: make(map[string]time.Time),
+		tokenTTL: DefaultTokenTTL, groups: make(map[string]*Group)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return &s
+}
+
+// Provider returns the platform faked by the server, see the chatapi Provider constants
+func (s *Server) Provider() string {
+	return s.platform.provider
+}
+
+// SetCredential only issue the access token to the app id and secret, by default any credential is accepted
+func (s *Server) SetCredential(clientID, secret string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clientID = clientID
+	s.secret = secret
+}
+
+// SetTokenTTL set the lifetime of the access tokens issued afterwards
+func (s *Server) SetTokenTTL(ttl time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokenTTL = ttl
+}
+
+// ExpireTokens expire all the issued access tokens, the calls with them are rejected with the expired errcode
+func (s *Server) ExpireTokens() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for token := range s.tokens {
+		s.tokens[token] = now
+	}
+}
+
+// TokenCount returns how many access tokens are issued
+func (s *Server) TokenCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tokenCount
+}
+
+// Fail answer the next requests to the api with the faults in order, the api is the default api url or its path
+func (s *Server) Fail(api string, faults ...Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	path := apiPath(api)
+	s.faults[path] = append(s.faults[path], faults...)
+}
+
+// Requests returns the requests received so far in order
+func (s *Server) Requests() []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestsTo returns the requests received by the api in order, the api is the default api url or its path
+func (s *Server) RequestsTo(api string) (requests []Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	path := apiPath(api)
+	for _, req := range s.requests {
+		if req.Path == path || strings.HasSuffix(path, "/") && strings.HasPrefix(req.Path, path) {
+			requests = append(requests, req)
+		}
+	}
+	return
+}
+
+// LastRequest returns the last request received by the api, false if none received
+func (s *Server) LastRequest(api string) (req Request, ok bool) {
+	requests := s.RequestsTo(api)
+	if len(requests) == 0 {
+		return
+	}
+	return requests[len(requests)-1], true
+}
+
+// Reset forget the received requests and the faults not answered yet
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = nil
+	s.faults = make(map[string][]Fault)
+}
+
+// AddGroup add the group chat as if it was created before
+func (s *Server) AddGroup(group Group) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	group.Users = append([]string(nil), group.Users...)
+	s.groups[group.ChatID] = &group
+}
+
+// Group returns the group chat by the chat id, false if not found
+func (s *Server) Group(chatID string) (group Group, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	found, ok := s.groups[chatID]
+	if !ok {
+		return
+	}
+	group = *found
+	group.Users = append([]string(nil), found.Users...)
+	return
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := readRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = append(s.requests, req)
+
+	routePath := req.Path
+	route, ok := s.platform.routes[routePath]
+	if !ok {
+		for prefix, prefixRoute := range s.platform.prefixRoutes {
+			if strings.HasPrefix(req.Path, prefix) {
+				routePath, route, ok = prefix, prefixRoute, true
+				break
+			}
+		}
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// the faults of a webhook are queued by its full path or the path prefix of all the webhooks
+	if fault := s.popFault(req.Path, routePath); fault != nil {
+		s.writeFault(w, fault)
+		return
+	}
+	if route.auth {
+		if fault := s.checkToken(s.platform.token(r)); fault != nil {
+			s.writeFault(w, fault)
+			return
+		}
+	}
+	resp, fault := route.handle(s, &req)
+	if fault != nil {
+		s.writeFault(w, fault)
+		return
+	}
+	s.writeResp(w, 0, "ok", resp)
+}
+
+func (s *Server) popFault(paths ...string) *Fault {
+	for _, path := range paths {
+		if faults := s.faults[path]; len(faults) > 0 {
+			s.faults[path] = faults[1:]
+			return &faults[0]
+		}
+	}
+	return nil
+}
+
+// checkToken reject the access token not issued or expired
+func (s *Server) checkToken(token string) *Fault {
+	expiredAt, ok := s.tokens[token]
+	if !ok {
+		return &Fault{HTTPStatus: s.platform.tokenStatus, ErrCode: s.platform.invalidCode, ErrMessage: "invalid access token"}
+	}
+	if !time.Now().Before(expiredAt) {
+		return &Fault{HTTPStatus: s.platform.tokenStatus, ErrCode: s.platform.expiredCode, ErrMessage: "access token expired"}
+	}
+	return nil
+}
+
+// issueToken issue a new access token to the credential, false if the credential is not accepted
+func (s *Server) issueToken(clientID, secret string) (token string, ttl time.Duration, ok bool) {
+	if s.clientID != "" && (clientID != s.clientID || secret != s.secret) {
+		return
+	}
+	s.tokenCount++
+	token = fmt.Sprintf("token-%d", s.tokenCount)
+	s.tokens[token] = time.Now().Add(s.tokenTTL)
+	return token, s.tokenTTL, true
+}
+
+// nextID returns an id unique in the server with the prefix
+func (s *Server) nextID(prefix string) string {
+	s.seq++
+	return prefix + strconv.Itoa(s.seq)
+}
+
+// updateGroup apply the changes to the group chat, false if not found
+func (s *Server) updateGroup(chatID, name, owner string, addUsers, delUsers []string) bool {
+	group, ok := s.groups[chatID]
+	if !ok {
+		return false
+	}
+	if name != "" {
+		group.Name = name
+	}
+	if owner != "" {
+		group.Owner = owner
+	}
+	group.Users = append(group.Users, addUsers...)
+	users := group.Users[:0]
+	for _, user := range group.Users {
+		if !contains(delUsers, user) {
+			users = append(users, user)
+		}
+	}
+	group.Users = users
+	return true
+}
+
+func (s *Server) notFound(what string) *Fault {
+	return &Fault{ErrCode: s.platform.notFoundCode, ErrMessage: what + " not found"}
+}
+
+func (s *Server) writeFault(w http.ResponseWriter, fault *Fault) {
+	if fault.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(fault.RetryAfter/time.Second)))
+	}
+	status := http.StatusOK
+	if fault.HTTPStatus != 0 {
+		status = fault.HTTPStatus
+	}
+	if status != http.StatusOK && fault.ErrCode == 0 {
+		w.WriteHeader(status)
+		return
+	}
+	message := fault.ErrMessage
+	if message == "" {
+		message = "fault injected by chatapitest"
+	}
+	body := map[string]interface{}{s.platform.codeKey: fault.ErrCode, s.platform.messageKey: message}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) writeResp(w http.ResponseWriter, code int, message string, resp map[string]interface{}) {
+	body := map[string]interface{}{s.platform.codeKey: code, s.platform.messageKey: message}
+	for k, v := range resp {
+		body[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// readRequest read the request, the file content is kept as the body of a multipart upload
+func readRequest(r *http.Request) (req Request, err error) {
+	req = Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header.Clone()}
+	body, readErr := ioutil.ReadAll(r.Body)
+	if readErr != nil {
+		err = fmt.Errorf("read body error, %w", readErr)
+		return
+	}
+	req.Body = body
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return
+	}
+	part, partErr := multipart.NewReader(bytes.NewReader(body), params["boundary"]).NextPart()
+	if partErr != nil {
+		err = fmt.Errorf("read multipart error, %w", partErr)
+		return
+	}
+	defer part.Close()
+	if req.Body, err = ioutil.ReadAll(part); err != nil {
+		err = fmt.Errorf("read multipart error, %w", err)
+		return
+	}
+	req.FieldName = part.FormName()
+	req.FileName = part.FileName()
+	return
+}
+
+// apiPath returns the path of the api url, or the api itself if it is a path
+func apiPath(api string) string {
+	if apiURL, err := url.Parse(api); err == nil && apiURL.Host != "" {
+		return apiURL.Path
+	}
+	return api
+}
+
+// stringList returns the strings of a json array in the request body
+func stringList(value interface{}) (list []string) {
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return
+}
+
+func stringValue(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
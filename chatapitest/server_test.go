@@ -0,0 +1,105 @@
+package chatapitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func getJSON(t *testing.T, reqURL string) (resp map[string]interface{}) {
+	t.Helper()
+	httpResp, err := http.Get(reqURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	json.NewDecoder(httpResp.Body).Decode(&resp)
+	return
+}
+
+func TestServer_TokenExpiry(t *testing.T) {
+	server := NewWxWorkServer()
+	defer server.Close()
+	server.SetCredential("corp", "secret")
+
+	if resp := getJSON(t, server.URL+"/cgi-bin/gettoken?corpid=corp&corpsecret=wrong"); resp["errcode"] != float64(wxWorkCodeInvalidCredential) {
+		t.Fatalf("expect the wrong secret refused, got %v", resp)
+	}
+	resp := getJSON(t, server.URL+"/cgi-bin/gettoken?corpid=corp&corpsecret=secret")
+	if resp["access_token"] != "token-1" || resp["expires_in"] != float64(7200) || server.TokenCount() != 1 {
+		t.Fatalf("unexpected token %v", resp)
+	}
+	if resp := getJSON(t, server.URL+"/cgi-bin/appchat/get?access_token=unknown&chatid=c1"); resp["errcode"] != float64(wxWorkCodeAccessTokenInvalid) {
+		t.Fatalf("expect the unknown token refused, got %v", resp)
+	}
+	server.AddGroup(Group{ChatID: "c1", Name: "group"})
+	if resp := getJSON(t, server.URL+"/cgi-bin/appchat/get?access_token=token-1&chatid=c1"); resp["errcode"] != float64(0) {
+		t.Fatalf("expect the group got, got %v", resp)
+	}
+	server.ExpireTokens()
+	if resp := getJSON(t, server.URL+"/cgi-bin/appchat/get?access_token=token-1&chatid=c1"); resp["errcode"] != float64(wxWorkCodeAccessTokenExpired) {
+		t.Fatalf("expect the expired token refused, got %v", resp)
+	}
+}
+
+func TestServer_Fail(t *testing.T) {
+	server := NewDingTalkServer()
+	defer server.Close()
+	server.Fail("https://oapi.dingtalk.com/robot/send", Fault{ErrCode: 130101, ErrMessage: "send too fast"},
+		Fault{HTTPStatus: http.StatusTooManyRequests, RetryAfter: time.Second * 3})
+
+	if resp := getJSON(t, server.URL+"/robot/send?access_token=robot"); resp["errcode"] != float64(130101) || resp["errmsg"] != "send too fast" {
+		t.Fatalf("expect the first fault, got %v", resp)
+	}
+	httpResp, err := http.Get(server.URL + "/robot/send?access_token=robot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusTooManyRequests || httpResp.Header.Get("Retry-After") != "3" {
+		t.Fatalf("expect the second fault, got %d %v", httpResp.StatusCode, httpResp.Header)
+	}
+	if resp := getJSON(t, server.URL+"/robot/send?access_token=robot"); resp["errcode"] != float64(0) {
+		t.Fatalf("expect the faults used up, got %v", resp)
+	}
+	if requests := server.RequestsTo("/robot/send"); len(requests) != 3 || requests[0].Query.Get("access_token") != "robot" {
+		t.Fatalf("expect all the requests recorded, got %v", requests)
+	}
+}
+
+func TestServer_RecordUpload(t *testing.T) {
+	server := NewWxWorkServer()
+	defer server.Close()
+	body := bytes.NewBuffer(nil)
+	writer := multipart.NewWriter(body)
+	fileWriter, _ := writer.CreateFormFile("media", "hello.txt")
+	fileWriter.Write([]byte("hello, master"))
+	writer.Close()
+
+	httpResp, err := http.Post(server.URL+"/cgi-bin/webhook/upload_media?key=robot&type=file", writer.FormDataContentType(), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	req, ok := server.LastRequest("/cgi-bin/webhook/upload_media")
+	if !ok || req.FieldName != "media" || req.FileName != "hello.txt" || string(req.Body) != "hello, master" {
+		t.Fatalf("unexpected upload %+v", req)
+	}
+
+	httpResp, err = http.Post(server.URL+"/cgi-bin/webhook/send?key=robot", "application/json",
+		bytes.NewReader([]byte(`{"msgtype":"news","news":{"articles":[{"title":"hello"}]}}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	req, _ = server.LastRequest("/cgi-bin/webhook/send")
+	if title := req.Field("news.articles.0.title"); title != "hello" || req.Field("news.articles.1.title") != nil {
+		t.Fatalf("unexpected field %v", title)
+	}
+	if len(server.Requests()) != 2 {
+		t.Fatalf("expect 2 requests, got %d", len(server.Requests()))
+	}
+}
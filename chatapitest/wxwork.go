@@ -0,0 +1,118 @@
+package chatapitest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/duoland/chatapi"
+)
+
+// the errcodes answered by the fake wxwork server
+const (
+	wxWorkCodeInvalidCredential  = 40001
+	wxWorkCodeAccessTokenInvalid = 40014
+	wxWorkCodeAccessTokenExpired = 42001
+	wxWorkCodeChatNotFound       = 86003
+	wxWorkCodeInvalidWebhookKey  = 93000
+)
+
+// NewWxWorkServer start a fake of the wxwork apis: the app access token, message send, group chat
+// create, update, get and send, media and image upload, and the robot webhook send and upload.
+// The server should be closed after use.
+func NewWxWorkServer() *Server {
+	return newServer(&platform{
+		provider:   chatapi.ProviderWxWork,
+		codeKey:    "errcode",
+		messageKey: "errmsg",
+		token: func(r *http.Request) string {
+			return r.URL.Query().Get("access_token")
+		},
+		expiredCode:  wxWorkCodeAccessTokenExpired,
+		invalidCode:  wxWorkCodeAccessTokenInvalid,
+		notFoundCode: wxWorkCodeChatNotFound,
+		routes: map[string]route{
+			"/cgi-bin/gettoken":             {handle: wxWorkToken},
+			"/cgi-bin/message/send":         {auth: true, handle: wxWorkSendMessage},
+			"/cgi-bin/appchat/send":         {auth: true, handle: wxWorkSendGroupMessage},
+			"/cgi-bin/appchat/create":       {auth: true, handle: wxWorkCreateGroup},
+			"/cgi-bin/appchat/update":       {auth: true, handle: wxWorkUpdateGroup},
+			"/cgi-bin/appchat/get":          {auth: true, handle: wxWorkGetGroup},
+			"/cgi-bin/media/upload":         {auth: true, handle: wxWorkUploadMedia},
+			"/cgi-bin/media/uploadimg":      {auth: true, handle: wxWorkUploadImage},
+			"/cgi-bin/webhook/send":         {handle: wxWorkRobotSend},
+			"/cgi-bin/webhook/upload_media": {handle: wxWorkRobotUpload},
+		},
+	})
+}
+
+func wxWorkToken(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	token, ttl, ok := s.issueToken(req.Query.Get("corpid"), req.Query.Get("corpsecret"))
+	if !ok {
+		return nil, &Fault{ErrCode: wxWorkCodeInvalidCredential, ErrMessage: "invalid credential"}
+	}
+	return map[string]interface{}{"access_token": token, "expires_in": int(ttl / time.Second)}, nil
+}
+
+func wxWorkSendMessage(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	return map[string]interface{}{"invaliduser": "", "invalidparty": "", "invalidtag": ""}, nil
+}
+
+func wxWorkSendGroupMessage(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	return nil, nil
+}
+
+func wxWorkCreateGroup(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	body := req.JSON()
+	group := Group{ChatID: stringValue(body["chatid"]), Name: stringValue(body["name"]),
+		Owner: stringValue(body["owner"]), Users: stringList(body["userlist"])}
+	if group.ChatID == "" {
+		group.ChatID = s.nextID("chat")
+	}
+	s.groups[group.ChatID] = &group
+	return map[string]interface{}{"chatid": group.ChatID}, nil
+}
+
+func wxWorkUpdateGroup(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	body := req.JSON()
+	chatID := stringValue(body["chatid"])
+	if !s.updateGroup(chatID, stringValue(body["name"]), stringValue(body["owner"]),
+		stringList(body["add_user_list"]), stringList(body["del_user_list"])) {
+		return nil, s.notFound("chat " + chatID)
+	}
+	return nil, nil
+}
+
+func wxWorkGetGroup(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	chatID := req.Query.Get("chatid")
+	group, ok := s.groups[chatID]
+	if !ok {
+		return nil, s.notFound("chat " + chatID)
+	}
+	return map[string]interface{}{"chat_info": map[string]interface{}{
+		"chatid": group.ChatID, "name": group.Name, "owner": group.Owner, "userlist": group.Users}}, nil
+}
+
+func wxWorkUploadMedia(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	return map[string]interface{}{"type": req.Query.Get("type"), "media_id": s.nextID("media"),
+		"created_at": strconv.FormatInt(time.Now().Unix(), 10)}, nil
+}
+
+func wxWorkUploadImage(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	return map[string]interface{}{"url": s.URL + "/images/" + s.nextID("image")}, nil
+}
+
+func wxWorkRobotSend(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	if req.Query.Get("key") == "" {
+		return nil, &Fault{ErrCode: wxWorkCodeInvalidWebhookKey, ErrMessage: "invalid webhook url"}
+	}
+	return nil, nil
+}
+
+func wxWorkRobotUpload(s *Server, req *Request) (resp map[string]interface{}, fault *Fault) {
+	if req.Query.Get("key") == "" {
+		return nil, &Fault{ErrCode: wxWorkCodeInvalidWebhookKey, ErrMessage: "invalid webhook url"}
+	}
+	return map[string]interface{}{"type": req.Query.Get("type"), "media_id": s.nextID("media"),
+		"created_at": strconv.FormatInt(time.Now().Unix(), 10)}, nil
+}
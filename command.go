@@ -0,0 +1,297 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// CommandTimeout is the default timeout of the commands without their own timeout
+var CommandTimeout = 30 * time.Second
+
+// CommandRequest is the command parsed from the inbound message of a chat platform, the provider adapters fill
+// the sender and the text, the router fills the name and the arguments
+type CommandRequest struct {
+	Provider   string
+	SenderID   string // the user id checked against the allowed users of the command
+	SenderName string
+	ChatID     string // the group chat of the message, empty for the direct message
+	Text       string // the text of the message, with the mention of the bot removed
+	Mentioned  bool   // the bot was mentioned or the message was sent to the bot directly
+	Name       string
+	Args       []string
+	Message    interface{} // the inbound message of the provider, e.g. a *dingtalk.DingDingOutgoingMessage
+}
+
+// CommandFunc run the command and returns the text replied to the chat
+type CommandFunc func(ctx context.Context, request *CommandRequest) (reply string, err error)
+
+// CommandReplyFunc send the reply to the chat of the command by the client of the provider
+type CommandReplyFunc func(ctx context.Context, reply string) error
+
+// Command is a chat command, e.g. the "deploy" command with the args "service" and "[env]" is sent as
+// "/deploy api prod" or "@bot deploy api". The args in brackets are optional and the last arg ending
+// with "..." takes all the remaining words.
+type Command struct {
+	Name         string
+	Args         []string
+	Description  string
+	AllowedUsers []string      // the sender ids allowed to run the command, empty for everyone
+	Timeout      time.Duration // the timeout of the run, CommandTimeout if zero
+	Run          CommandFunc
+}
+
+// Usage returns the usage line of the command with the prefix, e.g. "/deploy <service> [env]"
+func (c *Command) Usage(prefix string) string {
+	usage := prefix + c.Name
+	for _, arg := range c.Args {
+		if !strings.HasPrefix(arg, "[") {
+			arg = "<" + arg + ">"
+		}
+		usage += " " + arg
+	}
+	return usage
+}
+
+// argRange returns the min and the max number of the args, max is -1 for no limit
+func (c *Command) argRange() (min, max int) {
+	max = len(c.Args)
+	for i, arg := range c.Args {
+		if !strings.HasPrefix(arg, "[") {
+			min = i + 1
+		}
+		if i == len(c.Args)-1 && strings.HasSuffix(strings.TrimSuffix(arg, "]"), "...") {
+			max = -1
+		}
+	}
+	return
+}
+
+// allow check the sender against the allowed users
+func (c *Command) allow(senderID string) bool {
+	return len(c.AllowedUsers) == 0 || len(AllowedRecipients([]string{senderID}, c.AllowedUsers)) > 0
+}
+
+// CommandRouter parses the commands of the inbound messages of any platform and runs them, the replies are
+// sent back by the CommandReplyFunc of the provider. The "help" command lists the commands unless registered.
+type CommandRouter struct {
+	Prefix string       // the prefix of the commands sent without the mention, "/" by default
+	Logger *slog.Logger // log the failed commands and replies, nil for no logging
+
+	lock     sync.RWMutex
+	commands map[string]*Command
+	running  sync.WaitGroup
+}
+
+// NewCommandRouter create the router of the commands with the "/" prefix
+func NewCommandRouter() *CommandRouter {
+	return &CommandRouter{Prefix: "/", commands: make(map[string]*Command)}
+}
+
+// Register add the command, the command of the same name is replaced
+func (r *CommandRouter) Register(command Command) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.commands[strings.ToLower(command.Name)] = &command
+}
+
+// Parse returns the command name and the args of the text, the text is a command if it starts with the prefix
+// or the bot is mentioned. The args are split by the spaces, the quoted ones may contain spaces.
+func (r *CommandRouter) Parse(text string, mentioned bool) (name string, args []string, ok bool) {
+	text = strings.TrimSpace(text)
+	switch {
+	case r.Prefix != "" && strings.HasPrefix(text, r.Prefix):
+		text = strings.TrimPrefix(text, r.Prefix)
+	case !mentioned:
+		return
+	}
+	words := splitCommandArgs(text)
+	if len(words) == 0 {
+		return
+	}
+	name, args, ok = strings.ToLower(words[0]), words[1:], true
+	return
+}
+
+// Dispatch run the command of the request and returns its reply, ok is false if the text is not a command.
+// The unknown commands, wrong args, denied senders, timeouts, panics and errors are replied to the chat too.
+// The reply of the timeout is not blocked by the run, the run ignoring its context is left in the background.
+func (r *CommandRouter) Dispatch(ctx context.Context, request *CommandRequest) (reply string, ok bool) {
+	if request.Name, request.Args, ok = r.Parse(request.Text, request.Mentioned); !ok {
+		return
+	}
+	r.lock.RLock()
+	command := r.commands[request.Name]
+	r.lock.RUnlock()
+	if command == nil {
+		if request.Name == "help" {
+			reply = r.help(request.Args)
+			return
+		}
+		reply = fmt.Sprintf("unknown command %s%s, send %shelp for the commands", r.Prefix, request.Name, r.Prefix)
+		return
+	}
+	if !command.allow(request.SenderID) {
+		reply = fmt.Sprintf("permission denied for %s%s", r.Prefix, command.Name)
+		return
+	}
+	if min, max := command.argRange(); len(request.Args) < min || max >= 0 && len(request.Args) > max {
+		reply = "usage: " + command.Usage(r.Prefix)
+		return
+	}
+	timeout := command.Timeout
+	if timeout <= 0 {
+		timeout = CommandTimeout
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// the run is left in the background on the timeout, so the commands ignoring the context are replied too
+	done := make(chan commandResult, 1)
+	go func() {
+		done <- runCommand(runCtx, command, request)
+	}()
+	var result commandResult
+	select {
+	case result = <-done:
+	case <-runCtx.Done():
+		result.err = runCtx.Err()
+	}
+	reply = result.reply
+	if err := result.err; err != nil {
+		switch {
+		case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+			reply = fmt.Sprintf("command %s%s timed out after %s", r.Prefix, command.Name, timeout)
+		case errors.Is(err, errCommandPanic):
+			reply = fmt.Sprintf("command %s%s failed", r.Prefix, command.Name)
+		default:
+			reply = fmt.Sprintf("command %s%s failed, %v", r.Prefix, command.Name, err)
+		}
+		r.log().WarnContext(ctx, "command failed", slog.String("provider", request.Provider), slog.String("command", command.Name),
+			slog.String("sender_id", request.SenderID), slog.String("error", err.Error()))
+	}
+	return
+}
+
+// Serve dispatch the command in the background and send the reply, so the inbound handler returns before the
+// platform times out. The run is not canceled with the inbound request, use Wait to drain the runs on shutdown.
+// The panics of the run and the reply are recovered and logged.
+func (r *CommandRouter) Serve(ctx context.Context, request *CommandRequest, replyFunc CommandReplyFunc) {
+	if _, _, ok := r.Parse(request.Text, request.Mentioned); !ok {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	r.running.Add(1)
+	go func() {
+		defer r.running.Done()
+		defer func() {
+			if p := recover(); p != nil {
+				r.log().ErrorContext(ctx, "command panicked", slog.String("provider", request.Provider), slog.String("command", request.Name),
+					slog.String("panic", fmt.Sprint(p)))
+			}
+		}()
+		reply, ok := r.Dispatch(ctx, request)
+		if !ok || reply == "" {
+			return
+		}
+		if err := replyFunc(ctx, reply); err != nil {
+			r.log().WarnContext(ctx, "command reply failed", slog.String("provider", request.Provider), slog.String("command", request.Name),
+				slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// Wait block until the commands started by Serve are done and replied
+func (r *CommandRouter) Wait() {
+	r.running.Wait()
+}
+
+// help returns the usage of the command in the args, or the usages of all the commands
+func (r *CommandRouter) help(args []string) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(args) > 0 {
+		command := r.commands[strings.ToLower(strings.TrimPrefix(args[0], r.Prefix))]
+		if command == nil {
+			return fmt.Sprintf("unknown command %s%s, send %shelp for the commands", r.Prefix, args[0], r.Prefix)
+		}
+		return command.Usage(r.Prefix) + "\n" + command.Description
+	}
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := []string{"commands:"}
+	for _, name := range names {
+		command := r.commands[name]
+		line := command.Usage(r.Prefix)
+		if command.Description != "" {
+			line += " - " + command.Description
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// errCommandPanic is the error of the command runs recovered from the panic
+var errCommandPanic = errors.New("command panicked")
+
+// commandResult is the reply and the error of the command run
+type commandResult struct {
+	reply string
+	err   error
+}
+
+// runCommand run the command, the panic of the run is recovered and returned as the error
+func runCommand(ctx context.Context, command *Command, request *CommandRequest) (result commandResult) {
+	defer func() {
+		if p := recover(); p != nil {
+			result.err = fmt.Errorf("%w, %v", errCommandPanic, p)
+		}
+	}()
+	result.reply, result.err = command.Run(ctx, request)
+	return
+}
+
+func (r *CommandRouter) log() *slog.Logger {
+	if r.Logger == nil {
+		return discardLogger
+	}
+	return r.Logger
+}
+
+// splitCommandArgs split the text by the spaces, the words quoted by " or ' may contain spaces
+func splitCommandArgs(text string) (words []string) {
+	var word strings.Builder
+	var quote rune
+	inWord := false
+	for _, c := range text {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case unicode.IsSpace(c):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return
+}
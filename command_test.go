@@ -0,0 +1,156 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCommandRouter returns the router with the deploy and status commands, the deploy runs are recorded
+func newTestCommandRouter(deploys *[][]string) *CommandRouter {
+	router := NewCommandRouter()
+	router.Register(Command{Name: "deploy", Args: []string{"service", "[env]"}, Description: "deploy the service",
+		AllowedUsers: []string{"alice"}, Run: func(ctx context.Context, request *CommandRequest) (string, error) {
+			*deploys = append(*deploys, request.Args)
+			return "deploying " + strings.Join(request.Args, " "), nil
+		}})
+	router.Register(Command{Name: "status", Description: "show the status", Run: func(ctx context.Context, request *CommandRequest) (string, error) {
+		return "all green", nil
+	}})
+	return router
+}
+
+func TestCommandRouter_Parse(t *testing.T) {
+	router := NewCommandRouter()
+	cases := []struct {
+		text      string
+		mentioned bool
+		name      string
+		args      []string
+		ok        bool
+	}{
+		{"/deploy api prod", false, "deploy", []string{"api", "prod"}, true},
+		{"  /Deploy  api\tprod ", false, "deploy", []string{"api", "prod"}, true},
+		{"status", true, "status", []string{}, true},
+		{"/echo \"hello world\" 'it is' x", false, "echo", []string{"hello world", "it is", "x"}, true},
+		{"status", false, "", nil, false},
+		{"/", false, "", nil, false},
+	}
+	for _, c := range cases {
+		name, args, ok := router.Parse(c.text, c.mentioned)
+		if name != c.name || ok != c.ok || len(args) != len(c.args) || len(args) > 0 && !reflect.DeepEqual(args, c.args) {
+			t.Errorf("expect %q parsed to %q %q %v, got %q %q %v", c.text, c.name, c.args, c.ok, name, args, ok)
+		}
+	}
+}
+
+func TestCommandRouter_Dispatch(t *testing.T) {
+	var deploys [][]string
+	router := newTestCommandRouter(&deploys)
+	ctx := context.Background()
+	cases := []struct {
+		request CommandRequest
+		reply   string
+		ok      bool
+	}{
+		{CommandRequest{SenderID: "alice", Text: "/deploy api prod"}, "deploying api prod", true},
+		{CommandRequest{SenderID: "alice", Text: "deploy api", Mentioned: true}, "deploying api", true},
+		{CommandRequest{SenderID: "bob", Text: "/deploy api prod"}, "permission denied for /deploy", true},
+		{CommandRequest{SenderID: "alice", Text: "/deploy"}, "usage: /deploy <service> [env]", true},
+		{CommandRequest{SenderID: "alice", Text: "/deploy api prod now"}, "usage: /deploy <service> [env]", true},
+		{CommandRequest{SenderID: "bob", Text: "status", Mentioned: true}, "all green", true},
+		{CommandRequest{SenderID: "bob", Text: "/rollback"}, "unknown command /rollback, send /help for the commands", true},
+		{CommandRequest{SenderID: "bob", Text: "/help deploy"}, "/deploy <service> [env]\ndeploy the service", true},
+		{CommandRequest{SenderID: "bob", Text: "/help"}, "commands:\n/deploy <service> [env] - deploy the service\n/status - show the status", true},
+		{CommandRequest{SenderID: "bob", Text: "good morning"}, "", false},
+	}
+	for _, c := range cases {
+		request := c.request
+		if reply, ok := router.Dispatch(ctx, &request); reply != c.reply || ok != c.ok {
+			t.Errorf("expect %q replied %q %v, got %q %v", c.request.Text, c.reply, c.ok, reply, ok)
+		}
+	}
+	if len(deploys) != 2 {
+		t.Fatalf("expect only the allowed deploys run, got %v", deploys)
+	}
+}
+
+func TestCommandRouter_DispatchVariadic(t *testing.T) {
+	router := NewCommandRouter()
+	router.Register(Command{Name: "notify", Args: []string{"user", "message..."}, Run: func(ctx context.Context, request *CommandRequest) (string, error) {
+		return strings.Join(request.Args[1:], " "), nil
+	}})
+	if reply, _ := router.Dispatch(context.Background(), &CommandRequest{Text: "/notify bob the build is green"}); reply != "the build is green" {
+		t.Fatalf("expect the remaining words taken, got %q", reply)
+	}
+	if reply, _ := router.Dispatch(context.Background(), &CommandRequest{Text: "/notify bob"}); reply != "usage: /notify <user> <message...>" {
+		t.Fatalf("expect the usage, got %q", reply)
+	}
+}
+
+func TestCommandRouter_DispatchError(t *testing.T) {
+	router := NewCommandRouter()
+	router.Register(Command{Name: "fail", Run: func(ctx context.Context, request *CommandRequest) (string, error) {
+		return "", errors.New("database unavailable")
+	}})
+	router.Register(Command{Name: "slow", Timeout: 10 * time.Millisecond, Run: func(ctx context.Context, request *CommandRequest) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}})
+	if reply, _ := router.Dispatch(context.Background(), &CommandRequest{Text: "/fail"}); reply != "command /fail failed, database unavailable" {
+		t.Fatalf("expect the error replied, got %q", reply)
+	}
+	if reply, _ := router.Dispatch(context.Background(), &CommandRequest{Text: "/slow"}); reply != "command /slow timed out after 10ms" {
+		t.Fatalf("expect the timeout replied, got %q", reply)
+	}
+}
+
+func TestCommandRouter_DispatchPanicAndStuck(t *testing.T) {
+	router := NewCommandRouter()
+	router.Register(Command{Name: "crash", Run: func(ctx context.Context, request *CommandRequest) (string, error) {
+		panic("nil map")
+	}})
+	release := make(chan struct{})
+	defer close(release)
+	router.Register(Command{Name: "stuck", Timeout: 10 * time.Millisecond, Run: func(ctx context.Context, request *CommandRequest) (string, error) {
+		// the context is ignored
+		<-release
+		return "done", nil
+	}})
+	if reply, _ := router.Dispatch(context.Background(), &CommandRequest{Text: "/crash"}); reply != "command /crash failed" {
+		t.Fatalf("expect the panic replied as failed, got %q", reply)
+	}
+	if reply, _ := router.Dispatch(context.Background(), &CommandRequest{Text: "/stuck"}); reply != "command /stuck timed out after 10ms" {
+		t.Fatalf("expect the timeout replied without the run, got %q", reply)
+	}
+}
+
+func TestCommandRouter_Serve(t *testing.T) {
+	var deploys [][]string
+	router := newTestCommandRouter(&deploys)
+	var lock sync.Mutex
+	var replies []string
+	replyFunc := func(ctx context.Context, reply string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		replies = append(replies, reply)
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	router.Serve(ctx, &CommandRequest{SenderID: "alice", Text: "/deploy api prod"}, replyFunc)
+	router.Serve(ctx, &CommandRequest{SenderID: "alice", Text: "good morning"}, replyFunc)
+	// the panic of the reply is recovered
+	router.Serve(ctx, &CommandRequest{SenderID: "bob", Text: "/status"}, func(ctx context.Context, reply string) error {
+		panic("reply panicked")
+	})
+	// the inbound request is done before the command
+	cancel()
+	router.Wait()
+	if !reflect.DeepEqual(replies, []string{"deploying api prod"}) {
+		t.Fatalf("expect only the command replied, got %q", replies)
+	}
+}
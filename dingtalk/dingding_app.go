@@ -2,7 +2,9 @@ package dingtalk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,8 +12,9 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+
+	"github.com/duoland/chatapi"
 )
 
 // DingDingAppTokenAPI is the api to get app access token
@@ -50,6 +53,19 @@ const DingDingAppStatusOK = 0
 // See doc https://ding-doc.dingtalk.com/doc#/faquestions/rftpfg
 const DingDingCodeAccessTokenExpired = 42001
 
+// dingDingAppOperations is the logical operation of each api seen by the interceptors
+var dingDingAppOperations = map[string]string{
+	DingDingAppTokenAPI:                  "DingDingApp.GetAccessToken",
+	DingDingAppCreateGroupAPI:            "DingDingApp.CreateGroupChat",
+	DingDingAppUpdateGroupAPI:            "DingDingApp.UpdateGroupChat",
+	DingDingAppGetGroupAPI:               "DingDingApp.GetGroupChat",
+	DingDingAppSendMessageAPI:            "DingDingApp.SendMessage",
+	DingDingAppGetMessageSendProgressAPI: "DingDingApp.GetMessageSendProgress",
+	DingDingAppGetMessageSendResultAPI:   "DingDingApp.GetMessageSendResult",
+	DingDingAppRecallMessageAPI:          "DingDingApp.RecallMessage",
+	DingDingAppSendGroupMessageAPI:       "DingDingApp.SendGroupMessage",
+}
+
 const (
 	DingDingOptionYes = 1
 	DingDingOptionNo  = 0
@@ -73,6 +89,14 @@ type DingDingAppTokenResp struct {
 	ExpiresIn   int    `json:"expires_in"`
 }
 
+// dingDingDryRunResp is the synthetic success response of the requests captured in the dry run
+const dingDingDryRunResp = `{"errcode":0,"errmsg":"ok"}`
+
+type dingDingStatusResp struct {
+	ErrCode    int    `json:"errcode"`
+	ErrMessage string `json:"errmsg"`
+}
+
 type DingDingAppCreateGroupResp struct {
 	ErrCode         int    `json:"errcode"`
 	ErrMessage      string `json:"errmsg"`
@@ -127,6 +151,7 @@ type DingDingAppMessageSendResp struct {
 	ErrCode    int    `json:"errcode"`
 	ErrMessage string `json:"errmsg"`
 	TaskID     int    `json:"task_id"`
+	TaskIDs    []int  `json:"-"` // the task ids of all the parts of the split message
 }
 
 type DingDingAppMessageSendProgressResp struct {
@@ -182,43 +207,64 @@ type DingDingAppActionCardButton struct {
 }
 
 type DingDingAppGroupMessageSendResp struct {
-	ErrCode    int    `json:"errcode"`
-	ErrMessage string `json:"errmsg"`
-	MessageID  string `json:"messageId"`
+	ErrCode    int      `json:"errcode"`
+	ErrMessage string   `json:"errmsg"`
+	MessageID  string   `json:"messageId"`
+	MessageIDs []string `json:"-"` // the message ids of all the parts of the split message
 }
 
 type DingDingApp struct {
-	agentID          string
-	appKey           string
-	appSecret        string
-	client           *http.Client
-	tokenRefreshLock sync.RWMutex
-	accessToken      string
-	expiredAt        time.Time
+	agentID      string
+	appKey       string
+	appSecret    string
+	client       *http.Client
+	tokenManager *chatapi.TokenManager // cached access token refreshed ahead of the expiry
+	options      chatapi.Options
 }
 
 func (r *DingDingApp) IsAccessTokenExpired() bool {
-	return time.Now().After(r.expiredAt)
+	return r.tokenManager.IsExpired()
+}
+
+// TokenManager returns the manager of the app access token, e.g. to inspect the current token and expiry
+func (r *DingDingApp) TokenManager() *chatapi.TokenManager {
+	return r.tokenManager
 }
 
 // NewDingDingApp create a new dingding app
-func NewDingDingApp(appKey, appSecret, agentID string) *DingDingApp {
-	return NewDingDingAppWithTimeout(appKey, appSecret, agentID, DingDingAppTimeout)
+func NewDingDingApp(appKey, appSecret, agentID string, opts ...chatapi.Option) *DingDingApp {
+	return NewDingDingAppWithTimeout(appKey, appSecret, agentID, DingDingAppTimeout, opts...)
 }
 
 // NewDingDingAppWithTimeout create a new dingding app with timeout
-func NewDingDingAppWithTimeout(appKey, appSecret, agentID string, timeout time.Duration) *DingDingApp {
+func NewDingDingAppWithTimeout(appKey, appSecret, agentID string, timeout time.Duration, opts ...chatapi.Option) *DingDingApp {
 	client := http.Client{}
 	client.Timeout = timeout
-	return &DingDingApp{appKey: appKey, appSecret: appSecret, agentID: agentID, client: &client, tokenRefreshLock: sync.RWMutex{}}
+	return NewDingDingAppWithClient(appKey, appSecret, agentID, &client, opts...)
 }
 
 // NewDingDingAppWithClient create a new dingding app with http.Client
-func NewDingDingAppWithClient(appKey, appSecret, agentID string, client *http.Client) *DingDingApp {
-	return &DingDingApp{appKey: appKey, appSecret: appSecret, agentID: agentID, client: client, tokenRefreshLock: sync.RWMutex{}}
+func NewDingDingAppWithClient(appKey, appSecret, agentID string, client *http.Client, opts ...chatapi.Option) *DingDingApp {
+	app := DingDingApp{appKey: appKey, appSecret: appSecret, agentID: agentID, client: client, options: chatapi.NewOptions(opts...)}
+	switch {
+	case app.options.DryRun != nil:
+		// the dry run builds the requests with the placeholder token instead of fetching one
+		app.tokenManager = chatapi.NewTokenManager(chatapi.DryRunTokenFetcher)
+	case app.options.TokenStore != nil:
+		app.tokenManager = chatapi.NewTokenManagerWithStore(app.fetchAccessToken, app.options.TokenStore, app.clientKey())
+	default:
+		app.tokenManager = chatapi.NewTokenManager(app.fetchAccessToken)
+	}
+	return &app
 }
 
 func (r *DingDingApp) SendTextMessage(userIDList []string, departmentIDList []string, toAllUser bool, content string) (
+	resp DingDingAppMessageSendResp, err error) {
+	return r.SendTextMessageCtx(context.Background(), userIDList, departmentIDList, toAllUser, content)
+}
+
+// SendTextMessageCtx is SendTextMessage with the context to control the request
+func (r *DingDingApp) SendTextMessageCtx(ctx context.Context, userIDList []string, departmentIDList []string, toAllUser bool, content string) (
 	resp DingDingAppMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["agent_id"] = r.agentID
@@ -233,10 +279,16 @@ func (r *DingDingApp) SendTextMessage(userIDList []string, departmentIDList []st
 		"msgtype": DingDingAppMessageTypeText,
 		"text":    map[string]string{"content": content},
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendMarkdownMessage(userIDList []string, departmentIDList []string, toAllUser bool, title, content string) (
+	resp DingDingAppMessageSendResp, err error) {
+	return r.SendMarkdownMessageCtx(context.Background(), userIDList, departmentIDList, toAllUser, title, content)
+}
+
+// SendMarkdownMessageCtx is SendMarkdownMessage with the context to control the request
+func (r *DingDingApp) SendMarkdownMessageCtx(ctx context.Context, userIDList []string, departmentIDList []string, toAllUser bool, title, content string) (
 	resp DingDingAppMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["agent_id"] = r.agentID
@@ -251,10 +303,16 @@ func (r *DingDingApp) SendMarkdownMessage(userIDList []string, departmentIDList
 		"msgtype":  DingDingAppMessageTypeMarkdown,
 		"markdown": map[string]string{"title": title, "text": content},
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendImageMessage(userIDList []string, departmentIDList []string, toAllUser bool, mediaID string) (
+	resp DingDingAppMessageSendResp, err error) {
+	return r.SendImageMessageCtx(context.Background(), userIDList, departmentIDList, toAllUser, mediaID)
+}
+
+// SendImageMessageCtx is SendImageMessage with the context to control the request
+func (r *DingDingApp) SendImageMessageCtx(ctx context.Context, userIDList []string, departmentIDList []string, toAllUser bool, mediaID string) (
 	resp DingDingAppMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["agent_id"] = r.agentID
@@ -269,10 +327,16 @@ func (r *DingDingApp) SendImageMessage(userIDList []string, departmentIDList []s
 		"msgtype": DingDingAppMessageTypeImage,
 		"image":   map[string]string{"media_id": mediaID},
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendVoiceMessage(userIDList []string, departmentIDList []string, toAllUser bool, mediaID string, duration int) (
+	resp DingDingAppMessageSendResp, err error) {
+	return r.SendVoiceMessageCtx(context.Background(), userIDList, departmentIDList, toAllUser, mediaID, duration)
+}
+
+// SendVoiceMessageCtx is SendVoiceMessage with the context to control the request
+func (r *DingDingApp) SendVoiceMessageCtx(ctx context.Context, userIDList []string, departmentIDList []string, toAllUser bool, mediaID string, duration int) (
 	resp DingDingAppMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["agent_id"] = r.agentID
@@ -287,10 +351,16 @@ func (r *DingDingApp) SendVoiceMessage(userIDList []string, departmentIDList []s
 		"msgtype": DingDingAppMessageTypeVoice,
 		"voice":   map[string]string{"media_id": mediaID, "duration": strconv.Itoa(duration)},
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendFileMessage(userIDList []string, departmentIDList []string, toAllUser bool, mediaID string) (
+	resp DingDingAppMessageSendResp, err error) {
+	return r.SendFileMessageCtx(context.Background(), userIDList, departmentIDList, toAllUser, mediaID)
+}
+
+// SendFileMessageCtx is SendFileMessage with the context to control the request
+func (r *DingDingApp) SendFileMessageCtx(ctx context.Context, userIDList []string, departmentIDList []string, toAllUser bool, mediaID string) (
 	resp DingDingAppMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["agent_id"] = r.agentID
@@ -305,10 +375,16 @@ func (r *DingDingApp) SendFileMessage(userIDList []string, departmentIDList []st
 		"msgtype": DingDingAppMessageTypeFile,
 		"file":    map[string]string{"media_id": mediaID},
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendLinkMessage(userIDList []string, departmentIDList []string, toAllUser bool, linkMessage *DingDingAppLinkMessage) (
+	resp DingDingAppMessageSendResp, err error) {
+	return r.SendLinkMessageCtx(context.Background(), userIDList, departmentIDList, toAllUser, linkMessage)
+}
+
+// SendLinkMessageCtx is SendLinkMessage with the context to control the request
+func (r *DingDingApp) SendLinkMessageCtx(ctx context.Context, userIDList []string, departmentIDList []string, toAllUser bool, linkMessage *DingDingAppLinkMessage) (
 	resp DingDingAppMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["agent_id"] = r.agentID
@@ -323,10 +399,16 @@ func (r *DingDingApp) SendLinkMessage(userIDList []string, departmentIDList []st
 		"msgtype": DingDingAppMessageTypeLink,
 		"link":    linkMessage,
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendActionCardMessage(userIDList []string, departmentIDList []string, toAllUser bool, actionCardMessage *DingDingAppActionCardMessage) (
+	resp DingDingAppMessageSendResp, err error) {
+	return r.SendActionCardMessageCtx(context.Background(), userIDList, departmentIDList, toAllUser, actionCardMessage)
+}
+
+// SendActionCardMessageCtx is SendActionCardMessage with the context to control the request
+func (r *DingDingApp) SendActionCardMessageCtx(ctx context.Context, userIDList []string, departmentIDList []string, toAllUser bool, actionCardMessage *DingDingAppActionCardMessage) (
 	resp DingDingAppMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["agent_id"] = r.agentID
@@ -341,89 +423,108 @@ func (r *DingDingApp) SendActionCardMessage(userIDList []string, departmentIDLis
 		"msgtype":     DingDingAppMessageTypeActionCard,
 		"action_card": actionCardMessage,
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
-func (r *DingDingApp) sendMessage(messageObj interface{}) (messageResp DingDingAppMessageSendResp, err error) {
-	err = r.fireRequest(http.MethodPost, DingDingAppSendMessageAPI, nil, messageObj, &messageResp)
-	if err != nil {
-		return
+func (r *DingDingApp) sendMessage(ctx context.Context, messageObj interface{}) (messageResp DingDingAppMessageSendResp, err error) {
+	var parts []interface{}
+	switch {
+	case r.options.SplitLongMessages:
+		parts = splitDingDingAppMessage(r.options.RecipientPolicy, messageObj)
+	case r.options.TruncateLongMessages:
+		truncateDingDingAppMessage(&r.options, messageObj)
+	}
+	if len(parts) == 0 {
+		return r.sendSingleMessage(ctx, messageObj)
+	}
+	// the task id of the last part is kept for the compatibility, the task ids of all the parts are listed
+	for i, part := range parts {
+		partResp, partErr := r.sendSingleMessage(ctx, part)
+		if partErr != nil {
+			err = fmt.Errorf("send part %d/%d error, %w", i+1, len(parts), partErr)
+			return
+		}
+		taskIDs := append(messageResp.TaskIDs, partResp.TaskID)
+		messageResp = partResp
+		messageResp.TaskIDs = taskIDs
+	}
+	return
+}
+
+func (r *DingDingApp) sendSingleMessage(ctx context.Context, messageObj interface{}) (messageResp DingDingAppMessageSendResp, err error) {
+	var redirected bool
+	if policy := r.options.RecipientPolicy; policy != nil {
+		if redirected, err = applyDingDingAppPolicy(policy, messageObj); err != nil {
+			return
+		}
 	}
-	if messageResp.ErrCode != DingDingAppStatusOK {
-		if messageResp.ErrCode == DingDingCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
+	if !r.options.SkipValidation {
+		if err = validateDingDingAppMessage(messageObj, redirected); err != nil {
+			return
 		}
-		err = fmt.Errorf("call dingding app message api error, %d %s", messageResp.ErrCode, messageResp.ErrMessage)
+	}
+	if redirected {
+		// the message redirected to the test chat has no send task
+		var groupMessageResp DingDingAppGroupMessageSendResp
+		err = r.fireRequest(ctx, http.MethodPost, DingDingAppSendGroupMessageAPI, nil, messageObj, &groupMessageResp)
 		return
 	}
+	err = r.fireRequest(ctx, http.MethodPost, DingDingAppSendMessageAPI, nil, messageObj, &messageResp)
 	return
 }
 
 func (r *DingDingApp) GetMessageSendProgress(taskID int) (sendProgressResp DingDingAppMessageSendProgressResp, err error) {
+	return r.GetMessageSendProgressCtx(context.Background(), taskID)
+}
+
+// GetMessageSendProgressCtx is GetMessageSendProgress with the context to control the request
+func (r *DingDingApp) GetMessageSendProgressCtx(ctx context.Context, taskID int) (sendProgressResp DingDingAppMessageSendProgressResp, err error) {
 	agentID, _ := strconv.Atoi(r.agentID)
 	reqBody := map[string]int{
 		"agent_id": agentID,
 		"task_id":  taskID,
 	}
-	err = r.fireRequest(http.MethodPost, DingDingAppGetMessageSendProgressAPI, nil, &reqBody, &sendProgressResp)
-	if err != nil {
-		return
-	}
-	if sendProgressResp.ErrCode != DingDingAppStatusOK {
-		if sendProgressResp.ErrCode == DingDingCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call dingding app message api error, %d %s", sendProgressResp.ErrCode, sendProgressResp.ErrMessage)
-		return
-	}
+	err = r.fireRequest(ctx, http.MethodPost, DingDingAppGetMessageSendProgressAPI, nil, &reqBody, &sendProgressResp)
 	return
 }
 
 func (r *DingDingApp) GetMessageSendResult(taskID int) (sendResultResp DingDingAppMessageSendResultResp, err error) {
+	return r.GetMessageSendResultCtx(context.Background(), taskID)
+}
+
+// GetMessageSendResultCtx is GetMessageSendResult with the context to control the request
+func (r *DingDingApp) GetMessageSendResultCtx(ctx context.Context, taskID int) (sendResultResp DingDingAppMessageSendResultResp, err error) {
 	agentID, _ := strconv.Atoi(r.agentID)
 	reqBody := map[string]int{
 		"agent_id": agentID,
 		"task_id":  taskID,
 	}
-	err = r.fireRequest(http.MethodPost, DingDingAppGetMessageSendResultAPI, nil, &reqBody, &sendResultResp)
-	if err != nil {
-		return
-	}
-	if sendResultResp.ErrCode != DingDingAppStatusOK {
-		if sendResultResp.ErrCode == DingDingCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call dingding app message api error, %d %s", sendResultResp.ErrCode, sendResultResp.ErrMessage)
-		return
-	}
+	err = r.fireRequest(ctx, http.MethodPost, DingDingAppGetMessageSendResultAPI, nil, &reqBody, &sendResultResp)
 	return
 }
 
 func (r *DingDingApp) RecallMessage(taskID int) (revokeResp DingDingAppMessageRecallResp, err error) {
+	return r.RecallMessageCtx(context.Background(), taskID)
+}
+
+// RecallMessageCtx is RecallMessage with the context to control the request
+func (r *DingDingApp) RecallMessageCtx(ctx context.Context, taskID int) (revokeResp DingDingAppMessageRecallResp, err error) {
 	agentID, _ := strconv.Atoi(r.agentID)
 	reqBody := map[string]int{
 		"agent_id": agentID,
 		"task_id":  taskID,
 	}
-	err = r.fireRequest(http.MethodPost, DingDingAppRecallMessageAPI, nil, &reqBody, &revokeResp)
-	if err != nil {
-		return
-	}
-	if revokeResp.ErrCode != DingDingAppStatusOK {
-		if revokeResp.ErrCode == DingDingCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call dingding app message api error, %d %s", revokeResp.ErrCode, revokeResp.ErrMessage)
-		return
-	}
+	err = r.fireRequest(ctx, http.MethodPost, DingDingAppRecallMessageAPI, nil, &reqBody, &revokeResp)
 	return
 }
 
 func (r *DingDingApp) SendGroupTextMessage(chatID string, content string) (
+	resp DingDingAppGroupMessageSendResp, err error) {
+	return r.SendGroupTextMessageCtx(context.Background(), chatID, content)
+}
+
+// SendGroupTextMessageCtx is SendGroupTextMessage with the context to control the request
+func (r *DingDingApp) SendGroupTextMessageCtx(ctx context.Context, chatID string, content string) (
 	resp DingDingAppGroupMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
@@ -431,10 +532,16 @@ func (r *DingDingApp) SendGroupTextMessage(chatID string, content string) (
 		"msgtype": DingDingAppMessageTypeText,
 		"text":    map[string]string{"content": content},
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendGroupMarkdownMessage(chatID, title, content string) (
+	resp DingDingAppGroupMessageSendResp, err error) {
+	return r.SendGroupMarkdownMessageCtx(context.Background(), chatID, title, content)
+}
+
+// SendGroupMarkdownMessageCtx is SendGroupMarkdownMessage with the context to control the request
+func (r *DingDingApp) SendGroupMarkdownMessageCtx(ctx context.Context, chatID, title, content string) (
 	resp DingDingAppGroupMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
@@ -442,10 +549,16 @@ func (r *DingDingApp) SendGroupMarkdownMessage(chatID, title, content string) (
 		"msgtype":  DingDingAppMessageTypeMarkdown,
 		"markdown": map[string]string{"title": title, "text": content},
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendGroupImageMessage(chatID, mediaID string) (
+	resp DingDingAppGroupMessageSendResp, err error) {
+	return r.SendGroupImageMessageCtx(context.Background(), chatID, mediaID)
+}
+
+// SendGroupImageMessageCtx is SendGroupImageMessage with the context to control the request
+func (r *DingDingApp) SendGroupImageMessageCtx(ctx context.Context, chatID, mediaID string) (
 	resp DingDingAppGroupMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
@@ -453,10 +566,16 @@ func (r *DingDingApp) SendGroupImageMessage(chatID, mediaID string) (
 		"msgtype": DingDingAppMessageTypeImage,
 		"image":   map[string]string{"media_id": mediaID},
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendGroupVoiceMessage(chatID, mediaID string, duration int) (
+	resp DingDingAppGroupMessageSendResp, err error) {
+	return r.SendGroupVoiceMessageCtx(context.Background(), chatID, mediaID, duration)
+}
+
+// SendGroupVoiceMessageCtx is SendGroupVoiceMessage with the context to control the request
+func (r *DingDingApp) SendGroupVoiceMessageCtx(ctx context.Context, chatID, mediaID string, duration int) (
 	resp DingDingAppGroupMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
@@ -464,10 +583,16 @@ func (r *DingDingApp) SendGroupVoiceMessage(chatID, mediaID string, duration int
 		"msgtype": DingDingAppMessageTypeVoice,
 		"voice":   map[string]string{"media_id": mediaID, "duration": strconv.Itoa(duration)},
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendGroupFileMessage(chatID, mediaID string) (
+	resp DingDingAppGroupMessageSendResp, err error) {
+	return r.SendGroupFileMessageCtx(context.Background(), chatID, mediaID)
+}
+
+// SendGroupFileMessageCtx is SendGroupFileMessage with the context to control the request
+func (r *DingDingApp) SendGroupFileMessageCtx(ctx context.Context, chatID, mediaID string) (
 	resp DingDingAppGroupMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
@@ -475,10 +600,16 @@ func (r *DingDingApp) SendGroupFileMessage(chatID, mediaID string) (
 		"msgtype": DingDingAppMessageTypeFile,
 		"file":    map[string]string{"media_id": mediaID},
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendGroupLinkMessage(chatID string, linkMessage *DingDingAppLinkMessage) (
+	resp DingDingAppGroupMessageSendResp, err error) {
+	return r.SendGroupLinkMessageCtx(context.Background(), chatID, linkMessage)
+}
+
+// SendGroupLinkMessageCtx is SendGroupLinkMessage with the context to control the request
+func (r *DingDingApp) SendGroupLinkMessageCtx(ctx context.Context, chatID string, linkMessage *DingDingAppLinkMessage) (
 	resp DingDingAppGroupMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
@@ -486,10 +617,16 @@ func (r *DingDingApp) SendGroupLinkMessage(chatID string, linkMessage *DingDingA
 		"msgtype": DingDingAppMessageTypeLink,
 		"link":    linkMessage,
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *DingDingApp) SendGroupActionCardMessage(chatID string, actionCardMessage *DingDingAppActionCardMessage) (
+	resp DingDingAppGroupMessageSendResp, err error) {
+	return r.SendGroupActionCardMessageCtx(context.Background(), chatID, actionCardMessage)
+}
+
+// SendGroupActionCardMessageCtx is SendGroupActionCardMessage with the context to control the request
+func (r *DingDingApp) SendGroupActionCardMessageCtx(ctx context.Context, chatID string, actionCardMessage *DingDingAppActionCardMessage) (
 	resp DingDingAppGroupMessageSendResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
@@ -497,27 +634,56 @@ func (r *DingDingApp) SendGroupActionCardMessage(chatID string, actionCardMessag
 		"msgtype":     DingDingAppMessageTypeActionCard,
 		"action_card": actionCardMessage,
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
-func (r *DingDingApp) sendGroupMessage(messageObj interface{}) (messageResp DingDingAppGroupMessageSendResp, err error) {
-	err = r.fireRequest(http.MethodPost, DingDingAppSendGroupMessageAPI, nil, messageObj, &messageResp)
-	if err != nil {
-		return
+func (r *DingDingApp) sendGroupMessage(ctx context.Context, messageObj interface{}) (messageResp DingDingAppGroupMessageSendResp, err error) {
+	var parts []interface{}
+	switch {
+	case r.options.SplitLongMessages:
+		parts = splitDingDingAppMessage(r.options.RecipientPolicy, messageObj)
+	case r.options.TruncateLongMessages:
+		truncateDingDingAppMessage(&r.options, messageObj)
+	}
+	if len(parts) == 0 {
+		return r.sendSingleGroupMessage(ctx, messageObj)
+	}
+	// the message id of the last part is kept for the compatibility, the message ids of all the parts are listed
+	for i, part := range parts {
+		partResp, partErr := r.sendSingleGroupMessage(ctx, part)
+		if partErr != nil {
+			err = fmt.Errorf("send part %d/%d error, %w", i+1, len(parts), partErr)
+			return
+		}
+		messageIDs := append(messageResp.MessageIDs, partResp.MessageID)
+		messageResp = partResp
+		messageResp.MessageIDs = messageIDs
 	}
-	if messageResp.ErrCode != DingDingAppStatusOK {
-		if messageResp.ErrCode == DingDingCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
+	return
+}
+
+func (r *DingDingApp) sendSingleGroupMessage(ctx context.Context, messageObj interface{}) (messageResp DingDingAppGroupMessageSendResp, err error) {
+	if policy := r.options.RecipientPolicy; policy != nil {
+		if err = applyDingDingGroupPolicy(policy, messageObj); err != nil {
+			return
 		}
-		err = fmt.Errorf("call dingding app group message api error, %d %s", messageResp.ErrCode, messageResp.ErrMessage)
-		return
 	}
+	if !r.options.SkipValidation {
+		if err = validateDingDingAppMessage(messageObj, true); err != nil {
+			return
+		}
+	}
+	err = r.fireRequest(ctx, http.MethodPost, DingDingAppSendGroupMessageAPI, nil, messageObj, &messageResp)
 	return
 }
 
 // CreateGroupChat create a new group chat
 func (r *DingDingApp) CreateGroupChat(name, ownerID string, userIDList []string, options *DingDingAppCreateGroupOptions) (newChatID string, err error) {
+	return r.CreateGroupChatCtx(context.Background(), name, ownerID, userIDList, options)
+}
+
+// CreateGroupChatCtx is CreateGroupChat with the context to control the request
+func (r *DingDingApp) CreateGroupChatCtx(ctx context.Context, name, ownerID string, userIDList []string, options *DingDingAppCreateGroupOptions) (newChatID string, err error) {
 	createGroupReqObject := make(map[string]interface{})
 	createGroupReqObject["name"] = name
 	createGroupReqObject["owner"] = ownerID
@@ -531,23 +697,20 @@ func (r *DingDingApp) CreateGroupChat(name, ownerID string, userIDList []string,
 		createGroupReqObject["managementType"] = options.ManagementType
 	}
 	var createGroupResp DingDingAppCreateGroupResp
-	err = r.fireRequest(http.MethodPost, DingDingAppCreateGroupAPI, nil, &createGroupReqObject, &createGroupResp)
+	err = r.fireRequest(ctx, http.MethodPost, DingDingAppCreateGroupAPI, nil, &createGroupReqObject, &createGroupResp)
 	if err != nil {
 		return
 	}
-	if createGroupResp.ErrCode != DingDingAppStatusOK {
-		if createGroupResp.ErrCode == DingDingCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call dingding app create group api error, %d %s", createGroupResp.ErrCode, createGroupResp.ErrMessage)
-		return
-	}
 	newChatID = createGroupResp.ChatID
 	return
 }
 
 func (r *DingDingApp) UpdateGroupChat(chatID string, options *DingDingAppUpdateGroupOptions) (err error) {
+	return r.UpdateGroupChatCtx(context.Background(), chatID, options)
+}
+
+// UpdateGroupChatCtx is UpdateGroupChat with the context to control the request
+func (r *DingDingApp) UpdateGroupChatCtx(ctx context.Context, chatID string, options *DingDingAppUpdateGroupOptions) (err error) {
 	updateGroupReqObject := make(map[string]interface{})
 	updateGroupReqObject["chatid"] = chatID
 	if options != nil {
@@ -564,127 +727,157 @@ func (r *DingDingApp) UpdateGroupChat(chatID string, options *DingDingAppUpdateG
 		updateGroupReqObject["managementType"] = options.ManagementType
 	}
 	var updateGroupResp DingDingAppUpdateGroupResp
-	err = r.fireRequest(http.MethodPost, DingDingAppUpdateGroupAPI, nil, &updateGroupReqObject, &updateGroupResp)
-	if err != nil {
-		return
-	}
-	if updateGroupResp.ErrCode != DingDingAppStatusOK {
-		if updateGroupResp.ErrCode == DingDingCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call dingding app update group api error, %d %s", updateGroupResp.ErrCode, updateGroupResp.ErrMessage)
-		return
-	}
+	err = r.fireRequest(ctx, http.MethodPost, DingDingAppUpdateGroupAPI, nil, &updateGroupReqObject, &updateGroupResp)
 	return
 }
 
 func (r *DingDingApp) GetGroupChat(chatID string) (group DingDingAppGroup, err error) {
+	return r.GetGroupChatCtx(context.Background(), chatID)
+}
+
+// GetGroupChatCtx is GetGroupChat with the context to control the request
+func (r *DingDingApp) GetGroupChatCtx(ctx context.Context, chatID string) (group DingDingAppGroup, err error) {
 	var getGroupResp DingDingAppGetGroupResp
-	err = r.fireRequest(http.MethodGet, DingDingAppGetGroupAPI, map[string]string{"chatid": chatID}, nil, &getGroupResp)
+	err = r.fireRequest(ctx, http.MethodGet, DingDingAppGetGroupAPI, map[string]string{"chatid": chatID}, nil, &getGroupResp)
 	if err != nil {
 		return
 	}
-	if getGroupResp.ErrCode != DingDingAppStatusOK {
-		if getGroupResp.ErrCode == DingDingCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call dingding app get group api error, %d %s", getGroupResp.ErrCode, getGroupResp.ErrMessage)
-		return
-	}
 	group = getGroupResp.ChatInfo
 	return
 }
 
-func (r *DingDingApp) refreshAccessToken() (err error) {
-	reqURL := fmt.Sprintf("%s?appkey=%s&appsecret=%s", DingDingAppTokenAPI, r.appKey, r.appSecret)
-	req, newErr := http.NewRequest(http.MethodGet, reqURL, nil)
+func (r *DingDingApp) fetchAccessToken(ctx context.Context) (accessToken string, expiresIn time.Duration, err error) {
+	reqURL := fmt.Sprintf("%s?appkey=%s&appsecret=%s", r.options.URL(DingDingAppTokenAPI), r.appKey, r.appSecret)
+	req, newErr := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
+		err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
 		return
 	}
-	resp, getErr := r.client.Do(req)
-	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
-		return
-	}
-	defer resp.Body.Close()
-
-	// check http code
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("dingding request error, %s", resp.Status)
-		io.Copy(ioutil.Discard, resp.Body)
-		return
-	}
-	// parse response body
-	decoder := json.NewDecoder(resp.Body)
 	var accessTokenResp DingDingAppTokenResp
-	if decodeErr := decoder.Decode(&accessTokenResp); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
-		return
-	}
-	if accessTokenResp.ErrCode != DingDingAppStatusOK {
-		err = fmt.Errorf("call dingding app api error, %d %s", accessTokenResp.ErrCode, accessTokenResp.ErrMessage)
+	if err = r.invoke(req, DingDingAppTokenAPI, nil, &accessTokenResp); err != nil {
 		return
 	}
-	// set access token and expired at
-	r.accessToken = accessTokenResp.AccessToken
-	r.expiredAt = time.Now().Add(time.Second * time.Duration(accessTokenResp.ExpiresIn))
+	accessToken = accessTokenResp.AccessToken
+	expiresIn = time.Second * time.Duration(accessTokenResp.ExpiresIn)
 	return
 }
 
-func (r *DingDingApp) fireRequest(reqMethod, reqURL string, reqParams map[string]string, reqBodyObject interface{}, respObject interface{}) (err error) {
-	// check the token expired or not
-	if r.accessToken == "" || r.IsAccessTokenExpired() {
-		r.tokenRefreshLock.Lock()
-		if r.accessToken == "" || r.IsAccessTokenExpired() {
-			err = r.refreshAccessToken()
+func (r *DingDingApp) fireRequest(ctx context.Context, reqMethod, reqURL string, reqParams map[string]string, reqBodyObject interface{}, respObject interface{}) (err error) {
+	var reqBody []byte
+	if reqBodyObject != nil {
+		reqBody, _ = json.Marshal(reqBodyObject)
+	}
+	return r.callWithRetry(ctx, reqMethod == http.MethodGet, func(ctx context.Context, accessToken string) (err error) {
+		queryString := url.Values{}
+		queryString.Add("access_token", accessToken)
+		for k, v := range reqParams {
+			queryString.Add(k, v)
+		}
+		var reqBodyReader io.Reader
+		if reqBody != nil {
+			reqBodyReader = bytes.NewReader(reqBody)
 		}
-		r.tokenRefreshLock.Unlock()
-		if err != nil {
-			err = fmt.Errorf("refresh access token error, %s", err.Error())
+		req, newErr := http.NewRequestWithContext(ctx, reqMethod, fmt.Sprintf("%s?%s", r.options.URL(reqURL), queryString.Encode()), reqBodyReader)
+		if newErr != nil {
+			err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
 			return
 		}
-	}
+		req.Header.Add("Content-Type", "application/json")
+		return r.invoke(req, reqURL, reqBodyObject, respObject)
+	})
+}
 
-	queryString := url.Values{}
-	queryString.Add("access_token", r.accessToken)
-	if reqParams != nil {
-		for k, v := range reqParams {
-			queryString.Add(k, v)
+// callWithRetry run the api call with the access token under the rate limit and retry it by the retry policy,
+// the idempotent call is also retried on the errors which may have reached the server
+func (r *DingDingApp) callWithRetry(ctx context.Context, idempotent bool, call func(ctx context.Context, accessToken string) error) error {
+	return r.options.Retry.Do(ctx, idempotent, func() error {
+		if err := r.options.RateLimiter.Wait(ctx, r.clientKey()); err != nil {
+			return err
 		}
-	}
+		return r.callWithToken(ctx, call)
+	})
+}
 
-	reqURL = fmt.Sprintf("%s?%s", reqURL, queryString.Encode())
-	var reqBodyReader io.Reader
-	if reqBodyObject != nil {
-		reqBody, _ := json.Marshal(reqBodyObject)
-		reqBodyReader = bytes.NewReader(reqBody)
-	}
+// clientKey identify the app in the token store and the rate limiter
+func (r *DingDingApp) clientKey() string {
+	return chatapi.ProviderDingTalk + ":" + r.appKey
+}
 
-	req, newErr := http.NewRequest(reqMethod, reqURL, reqBodyReader)
-	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
+// callWithToken run the api call with the access token, if the token is rejected by the server,
+// it is refreshed and the call is replayed once
+func (r *DingDingApp) callWithToken(ctx context.Context, call func(ctx context.Context, accessToken string) error) (err error) {
+	accessToken, err := r.tokenManager.Token(ctx)
+	if err != nil {
+		err = fmt.Errorf("refresh access token error, %w", err)
+		return
+	}
+	err = call(ctx, accessToken)
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) || !isDingDingTokenRejectedCode(apiErr.ErrCode) {
 		return
 	}
-	req.Header.Add("Content-Type", "application/json")
-	resp, getErr := r.client.Do(req)
+	r.options.Log().InfoContext(ctx, "access token rejected, replay the call", "provider", chatapi.ProviderDingTalk,
+		"endpoint", apiErr.Endpoint, "errcode", apiErr.ErrCode)
+	r.tokenManager.Invalidate(accessToken)
+	if accessToken, err = r.tokenManager.Token(ctx); err != nil {
+		err = fmt.Errorf("replay the call error, refresh access token error, %w", err)
+		return
+	}
+	// the replay is marked on the call seen by the interceptors and on the returned error
+	err = call(chatapi.WithReplay(ctx), accessToken)
+	switch {
+	case errors.As(err, &apiErr):
+		apiErr.Replayed = true
+	case err != nil:
+		err = fmt.Errorf("replay the call error, %w", err)
+	}
+	return
+}
+
+// invoke send the request through the interceptors and decode the response into respObject
+func (r *DingDingApp) invoke(req *http.Request, endpoint string, reqObject, respObject interface{}) error {
+	call := chatapi.Call{Provider: chatapi.ProviderDingTalk, Operation: dingDingAppOperations[endpoint], Endpoint: endpoint,
+		Header: req.Header, Request: reqObject, Response: respObject, TokenRefresh: endpoint == DingDingAppTokenAPI,
+		Replayed: chatapi.IsReplay(req.Context())}
+	return chatapi.Intercept(req.Context(), r.options.Interceptors, &call, func(ctx context.Context, call *chatapi.Call) error {
+		if r.options.DryRun != nil {
+			return chatapi.DryRun(r.options.DryRun, call, req, dingDingDryRunResp)
+		}
+		return doDingDingRequest(r.client, req.WithContext(ctx), endpoint, respObject)
+	})
+}
+
+// doDingDingRequest send the request and parse the response, the errcode other than ok is returned as error
+func doDingDingRequest(client *http.Client, req *http.Request, endpoint string, respObject interface{}) (err error) {
+	resp, getErr := client.Do(req)
 	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
+		err = chatapi.NewRequestError(chatapi.ProviderDingTalk, endpoint, 0, fmt.Errorf("get response error, %w", getErr))
 		return
 	}
 	defer resp.Body.Close()
 	// check http code
 	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("dingding request error, %s", resp.Status)
+		err = chatapi.NewStatusError(chatapi.ProviderDingTalk, endpoint, resp)
 		io.Copy(ioutil.Discard, resp.Body)
 		return
 	}
 	// parse response body
-	decoder := json.NewDecoder(resp.Body)
-	if decodeErr := decoder.Decode(respObject); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
+	respBody, readErr := ioutil.ReadAll(resp.Body)
+	if readErr != nil {
+		err = chatapi.NewRequestError(chatapi.ProviderDingTalk, endpoint, 0, fmt.Errorf("get response error, %w", readErr))
+		return
+	}
+	var statusResp dingDingStatusResp
+	if decodeErr := json.Unmarshal(respBody, &statusResp); decodeErr != nil {
+		err = chatapi.NewRequestError(chatapi.ProviderDingTalk, endpoint, resp.StatusCode, fmt.Errorf("parse response error, %w", decodeErr))
+		return
+	}
+	if decodeErr := json.Unmarshal(respBody, respObject); decodeErr != nil {
+		err = chatapi.NewRequestError(chatapi.ProviderDingTalk, endpoint, resp.StatusCode, fmt.Errorf("parse response error, %w", decodeErr))
+		return
+	}
+	if statusResp.ErrCode != DingDingAppStatusOK {
+		err = newDingDingCodeError(endpoint, statusResp.ErrCode, statusResp.ErrMessage)
 		return
 	}
 	return
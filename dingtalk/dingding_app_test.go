@@ -1,111 +1,200 @@
 package dingtalk
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"strings"
 	"testing"
+
+	"github.com/duoland/chatapi"
+	"github.com/duoland/chatapi/chatapitest"
 )
 
 var chatID = "chat52999a8e1bdedfe94bb6e9841d581c9e"
-var appKey = os.Getenv("DINGDING_APP_KEY")
-var appSecret = os.Getenv("DINGDING_APP_SECRET")
-var agentID = os.Getenv("DINGDING_APP_AGENT_ID")
+var appKey = "dingappkey"
+var appSecret = "ding-app-secret"
+var agentID = "840001"
 
 var userIDList = []string{"manager2159"}
 var departmentIDList = []string{"381323914"}
 var toAllUser = false
 
-func init() {
-	fmt.Println("==> AgentID:", agentID)
-	fmt.Println("==> AppKey:", appKey)
-	fmt.Println("==> AppSecret:", appSecret)
-	fmt.Println("")
+// newTestDingDingApp create the app on a fake dingtalk server closed with the test
+func newTestDingDingApp(t *testing.T, opts ...chatapi.Option) (*DingDingApp, *chatapitest.Server) {
+	server := chatapitest.NewDingTalkServer()
+	t.Cleanup(server.Close)
+	server.SetCredential(appKey, appSecret)
+	opts = append([]chatapi.Option{chatapi.WithBaseURL(server.URL)}, opts...)
+	return NewDingDingApp(appKey, appSecret, agentID, opts...), server
+}
+
+// expectFields check the fields of the json body of the last request to the api
+func expectFields(t *testing.T, server *chatapitest.Server, api string, fields map[string]interface{}) {
+	t.Helper()
+	req, ok := server.LastRequest(api)
+	if !ok {
+		t.Fatalf("expect a request to %s", api)
+	}
+	for path, expect := range fields {
+		if value := req.Field(path); fmt.Sprint(value) != fmt.Sprint(expect) {
+			t.Errorf("expect %s to be %v, got %v", path, expect, value)
+		}
+	}
 }
-func TestDingDingApp_refreshAccessToken(t *testing.T) {
-	dingdingApp := NewDingDingApp(appKey, appSecret, agentID)
-	err := dingdingApp.refreshAccessToken()
+
+func TestDingDingApp_fetchAccessToken(t *testing.T) {
+	dingdingApp, _ := newTestDingDingApp(t)
+	accessToken, expiresIn, err := dingdingApp.fetchAccessToken(context.Background())
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
+	if accessToken != "token-1" || expiresIn != chatapitest.DefaultTokenTTL {
+		t.Fatalf("unexpected token %s expires in %v", accessToken, expiresIn)
+	}
+
+	dingdingApp = NewDingDingApp(appKey, "wrong-secret", agentID, chatapi.WithBaseURL(dingdingApp.options.BaseURL))
+	if _, _, err = dingdingApp.fetchAccessToken(context.Background()); err == nil {
+		t.Fatal("expect the wrong secret refused")
+	}
 }
 
 func TestDingDingApp_SendTextMessage(t *testing.T) {
-	dingdingApp := NewDingDingApp(appKey, appSecret, agentID)
+	dingdingApp, server := newTestDingDingApp(t)
 	content := "hello, master, i am robot for your service"
 	resp, err := dingdingApp.SendTextMessage(userIDList, nil, toAllUser, content)
-	t.Logf("%v", resp)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
+	if resp.TaskID == 0 {
+		t.Fatalf("expect the task id, got %v", resp)
+	}
+	expectFields(t, server, DingDingAppSendMessageAPI, map[string]interface{}{"agent_id": agentID, "userid_list": "manager2159",
+		"to_all_user": false, "msg.msgtype": "text", "msg.text.content": content})
 }
 
 func TestDingDingApp_SendMarkdownMessage(t *testing.T) {
-	dingdingApp := NewDingDingApp(appKey, appSecret, agentID)
+	dingdingApp, server := newTestDingDingApp(t)
 	content := "# hello, master, i am robot for your service"
-	resp, err := dingdingApp.SendMarkdownMessage(userIDList, nil, toAllUser, "hello master", content)
-	t.Logf("%v", resp)
+	_, err := dingdingApp.SendMarkdownMessage(nil, departmentIDList, toAllUser, "hello master", content)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
+	expectFields(t, server, DingDingAppSendMessageAPI, map[string]interface{}{"dept_id_list": "381323914", "userid_list": nil,
+		"msg.msgtype": "markdown", "msg.markdown.title": "hello master", "msg.markdown.text": content})
 }
 
 func TestDingDingApp_GetMessageSendProgress(t *testing.T) {
 	taskID := 240072433293
-	dingdingApp := NewDingDingApp(appKey, appSecret, agentID)
+	dingdingApp, server := newTestDingDingApp(t)
 	resp, err := dingdingApp.GetMessageSendProgress(taskID)
-	t.Logf("%v", resp)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
+	if resp.Progress.ProgressInPercent != 100 {
+		t.Fatalf("unexpected progress %v", resp)
+	}
+	expectFields(t, server, DingDingAppGetMessageSendProgressAPI, map[string]interface{}{"agent_id": 840001, "task_id": taskID})
 }
 
 func TestDingDingApp_GetMessageSendResult(t *testing.T) {
 	taskID := 240072433293
-	dingdingApp := NewDingDingApp(appKey, appSecret, agentID)
-	resp, err := dingdingApp.GetMessageSendResult(taskID)
-	t.Logf("%v", resp)
-	if err != nil {
+	dingdingApp, server := newTestDingDingApp(t)
+	if _, err := dingdingApp.GetMessageSendResult(taskID); err != nil {
 		t.Fatalf(err.Error())
 	}
+	expectFields(t, server, DingDingAppGetMessageSendResultAPI, map[string]interface{}{"task_id": taskID})
 }
 
 func TestDingDingApp_RecallMessage(t *testing.T) {
 	taskID := 240072433293
-	dingdingApp := NewDingDingApp(appKey, appSecret, agentID)
-	resp, err := dingdingApp.RecallMessage(taskID)
-	t.Logf("%v", resp)
-	if err != nil {
+	dingdingApp, server := newTestDingDingApp(t)
+	if _, err := dingdingApp.RecallMessage(taskID); err != nil {
 		t.Fatalf(err.Error())
 	}
+	expectFields(t, server, DingDingAppRecallMessageAPI, map[string]interface{}{"task_id": taskID})
 }
 
-// ChatID: chat52999a8e1bdedfe94bb6e9841d581c9e
 func TestDingDingApp_CreateGroupChat(t *testing.T) {
-	dingdingApp := NewDingDingApp(appKey, appSecret, agentID)
+	dingdingApp, server := newTestDingDingApp(t)
 	name := "一个不简单的测试群"
-	resp, err := dingdingApp.CreateGroupChat(name, userIDList[0], userIDList, nil)
-	t.Logf("%v", resp)
+	newChatID, err := dingdingApp.CreateGroupChat(name, userIDList[0], userIDList, nil)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
+	group, err := dingdingApp.GetGroupChat(newChatID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if group.Name != name || group.Owner != userIDList[0] || fmt.Sprint(group.UserIDList) != fmt.Sprint(userIDList) {
+		t.Fatalf("unexpected group %+v", group)
+	}
+	if err = dingdingApp.UpdateGroupChat(newChatID, &DingDingAppUpdateGroupOptions{AddUserList: []string{"manager2160"}}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if group, _ := server.Group(newChatID); len(group.Users) != 2 {
+		t.Fatalf("expect the user added, got %+v", group)
+	}
 }
 
 func TestDingDingApp_SendGroupTextMessage(t *testing.T) {
-	dingdingApp := NewDingDingApp(appKey, appSecret, agentID)
+	dingdingApp, server := newTestDingDingApp(t)
 	content := "hello, master, i am robot for your service"
 	resp, err := dingdingApp.SendGroupTextMessage(chatID, content)
-	t.Logf("%v", resp)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
+	if resp.MessageID == "" {
+		t.Fatalf("expect the message id, got %v", resp)
+	}
+	expectFields(t, server, DingDingAppSendGroupMessageAPI, map[string]interface{}{"chatid": chatID, "msg.text.content": content})
 }
 
 func TestDingDingApp_SendGroupMarkdownMessage(t *testing.T) {
-	dingdingApp := NewDingDingApp(appKey, appSecret, agentID)
+	dingdingApp, server := newTestDingDingApp(t)
 	content := "# hello, master, i am robot for your service"
-	resp, err := dingdingApp.SendGroupMarkdownMessage(chatID, "hello master", content)
-	t.Logf("%v", resp)
-	if err != nil {
+	if _, err := dingdingApp.SendGroupMarkdownMessage(chatID, "hello master", content); err != nil {
 		t.Fatalf(err.Error())
 	}
+	expectFields(t, server, DingDingAppSendGroupMessageAPI, map[string]interface{}{"msg.msgtype": "markdown", "msg.markdown.text": content})
+}
+
+func TestDingDingApp_ReplayOnTokenExpired(t *testing.T) {
+	dingdingApp, server := newTestDingDingApp(t)
+	if _, err := dingdingApp.SendGroupTextMessage(chatID, "hello, master"); err != nil {
+		t.Fatal(err)
+	}
+	server.ExpireTokens()
+	if _, err := dingdingApp.SendGroupTextMessage(chatID, "hello, master"); err != nil {
+		t.Fatal(err)
+	}
+	if server.TokenCount() != 2 || len(server.RequestsTo(DingDingAppSendGroupMessageAPI)) != 3 {
+		t.Fatalf("expect the token refreshed and message replayed, got %d tokens", server.TokenCount())
+	}
+
+	server.Fail(DingDingAppSendGroupMessageAPI, chatapitest.Fault{ErrCode: DingDingCodeAPIFreqOutOfLimit})
+	_, err := dingdingApp.SendGroupTextMessageCtx(chatapi.WithoutRetry(context.Background()), chatID, "hello, master")
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrCode != DingDingCodeAPIFreqOutOfLimit || !apiErr.Retryable {
+		t.Fatalf("expect the rate limit error, got %v", err)
+	}
+}
+
+func TestDingDingApp_DryRun(t *testing.T) {
+	recorder := chatapi.NewDryRunRecorder()
+	app, server := newTestDingDingApp(t, chatapi.WithDryRun(recorder))
+	if _, err := app.SendMarkdownMessage(userIDList, nil, false, "hello master", "# hello, master"); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect no request sent in the dry run, got %d", len(server.Requests()))
+	}
+	requests := recorder.Requests()
+	if len(requests) != 1 || requests[0].Operation != "DingDingApp.SendMessage" ||
+		requests[0].URL != server.URL+"/topapi/message/corpconversation/asyncsend_v2?access_token=REDACTED" {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	if !strings.Contains(string(requests[0].Body), `"msgtype":"markdown"`) {
+		t.Fatalf("unexpected body %s", requests[0].Body)
+	}
 }
@@ -0,0 +1,168 @@
+package dingtalk
+
+// See doc https://ding-doc.dingtalk.com/doc#/serverapi2/skn8ld
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/duoland/chatapi"
+)
+
+// DingDingCallbackSuccess is the reply encrypted to acknowledge the event
+const DingDingCallbackSuccess = "success"
+
+// DingDingCallbackCrypto sign, encrypt and decrypt the callback events of dingding
+type DingDingCallbackCrypto struct {
+	*chatapi.CallbackCrypto
+}
+
+// NewDingDingCallbackCrypto create the crypto by the token and the aes key set on the event subscription, the key is
+// the app key of the app, the corp id of the corp or the suite key of the isv suite receiving the events
+func NewDingDingCallbackCrypto(token, encodingAESKey, key string) (crypto *DingDingCallbackCrypto, err error) {
+	callbackCrypto, err := chatapi.NewCallbackCrypto(token, encodingAESKey, key)
+	if err != nil {
+		return
+	}
+	crypto = &DingDingCallbackCrypto{CallbackCrypto: callbackCrypto}
+	return
+}
+
+// DingDingCallbackReply is the encrypted and signed reply of the callback
+type DingDingCallbackReply struct {
+	MsgSignature string `json:"msg_signature"`
+	TimeStamp    string `json:"timeStamp"`
+	Nonce        string `json:"nonce"`
+	Encrypt      string `json:"encrypt"`
+}
+
+// EncryptReply returns the reply of the message encrypted and signed by the timestamp and the nonce,
+// the message is DingDingCallbackSuccess to acknowledge the event
+func (c *DingDingCallbackCrypto) EncryptReply(message, timestamp, nonce string) (reply DingDingCallbackReply, err error) {
+	encrypted, err := c.Encrypt([]byte(message))
+	if err != nil {
+		return
+	}
+	reply = DingDingCallbackReply{MsgSignature: c.Signature(timestamp, nonce, encrypted), TimeStamp: timestamp, Nonce: nonce, Encrypt: encrypted}
+	return
+}
+
+// DingDingEventHandler is the http.Handler of the event subscription url of dingding, it decrypts the events,
+// dispatches them to the handlers registered by the event type and acknowledges them with the encrypted success
+type DingDingEventHandler struct {
+	crypto   *DingDingCallbackCrypto
+	lock     sync.RWMutex
+	handlers map[string]func(ctx context.Context, data []byte) error
+}
+
+// NewDingDingEventHandler create the handler of the event subscription url, the url verification
+// is answered and the events without a registered handler are acknowledged
+func NewDingDingEventHandler(crypto *DingDingCallbackCrypto) *DingDingEventHandler {
+	return &DingDingEventHandler{crypto: crypto, handlers: make(map[string]func(ctx context.Context, data []byte) error)}
+}
+
+// Handle register the handler of the events of the types with the decrypted json, e.g. the events without a typed struct yet
+func (h *DingDingEventHandler) Handle(handle func(ctx context.Context, event *DingDingEvent) error, eventTypes ...string) {
+	h.register(eventTypes, func(ctx context.Context, data []byte) error {
+		event := DingDingEvent{Raw: data}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		return handle(ctx, &event)
+	})
+}
+
+// OnChatMemberChange register the handler of the member and setting changes of the group chats
+func (h *DingDingEventHandler) OnChatMemberChange(handle func(ctx context.Context, event *DingDingChatEvent) error) {
+	h.register(dingDingChatEventTypes, func(ctx context.Context, data []byte) error {
+		var event DingDingChatEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		return handle(ctx, &event)
+	})
+}
+
+// OnUserChange register the handler of the changes of the users of the corp
+func (h *DingDingEventHandler) OnUserChange(handle func(ctx context.Context, event *DingDingUserEvent) error) {
+	h.register(dingDingUserEventTypes, func(ctx context.Context, data []byte) error {
+		var event DingDingUserEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		return handle(ctx, &event)
+	})
+}
+
+// OnMessageRead register the handler of the messages read by the users
+func (h *DingDingEventHandler) OnMessageRead(handle func(ctx context.Context, event *DingDingMessageReadEvent) error) {
+	h.register([]string{DingDingEventMessageRead}, func(ctx context.Context, data []byte) error {
+		var event DingDingMessageReadEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		return handle(ctx, &event)
+	})
+}
+
+func (h *DingDingEventHandler) register(eventTypes []string, handle func(ctx context.Context, data []byte) error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, eventType := range eventTypes {
+		h.handlers[eventType] = handle
+	}
+}
+
+func (h *DingDingEventHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	signature, timestamp, nonce := query.Get("signature"), query.Get("timestamp"), query.Get("nonce")
+	var body struct {
+		Encrypt string `json:"encrypt"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, chatapi.CallbackMaxBodyBytes)).Decode(&body); err != nil || body.Encrypt == "" {
+		http.Error(w, chatapi.ErrInvalidCallback.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.crypto.VerifySignature(signature, timestamp, nonce, body.Encrypt) {
+		http.Error(w, chatapi.ErrInvalidSignature.Error(), http.StatusForbidden)
+		return
+	}
+	data, err := h.crypto.Decrypt(body.Encrypt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var head DingDingEvent
+	if err = json.Unmarshal(data, &head); err != nil {
+		http.Error(w, fmt.Sprintf("%v, decode event error, %v", chatapi.ErrInvalidCallback, err), http.StatusBadRequest)
+		return
+	}
+	h.lock.RLock()
+	handle := h.handlers[head.EventType]
+	h.lock.RUnlock()
+	if handle != nil {
+		if err = handle(r.Context(), data); err != nil {
+			// the event not acknowledged is pushed again by the platform
+			http.Error(w, fmt.Sprintf("handle event %s error, %v", head.EventType, err), http.StatusInternalServerError)
+			return
+		}
+	}
+	reply, err := h.crypto.EncryptReply(DingDingCallbackSuccess, strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10), nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(&reply)
+}
@@ -0,0 +1,135 @@
+package dingtalk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+var callbackToken = "dingcallbacktoken"
+var callbackAESKey = "4g5j64qlyl3zvetqxz5jiocdr586fn2zvjpa8zls3ij"
+
+func newTestDingDingCallbackCrypto(t *testing.T) *DingDingCallbackCrypto {
+	crypto, err := NewDingDingCallbackCrypto(callbackToken, callbackAESKey, appKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto
+}
+
+func TestDingDingCallbackCrypto(t *testing.T) {
+	if _, err := NewDingDingCallbackCrypto(callbackToken, "short", appKey); err == nil {
+		t.Fatal("expect the invalid aes key rejected")
+	}
+	crypto := newTestDingDingCallbackCrypto(t)
+	encrypted, err := crypto.Encrypt([]byte(`{"EventType":"check_url"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event, err := crypto.Decrypt(encrypted); err != nil || string(event) != `{"EventType":"check_url"}` {
+		t.Fatalf("expect the event encrypted and decrypted, got %q, %v", event, err)
+	}
+	otherCrypto, _ := NewDingDingCallbackCrypto(callbackToken, callbackAESKey, "otherappkey")
+	if _, err := otherCrypto.Decrypt(encrypted); !errors.Is(err, chatapi.ErrInvalidCallback) {
+		t.Fatalf("expect the event of the other app rejected, got %v", err)
+	}
+	if _, err := crypto.Decrypt("bm90IGVuY3J5cHRlZA=="); !errors.Is(err, chatapi.ErrInvalidCallback) {
+		t.Fatalf("expect the invalid cipher text rejected, got %v", err)
+	}
+
+	signature := crypto.Signature("1605695694141", "WelUQl6bCqcBa2fM", encrypted)
+	if !crypto.VerifySignature(signature, "1605695694141", "WelUQl6bCqcBa2fM", encrypted) ||
+		crypto.VerifySignature(signature, "1605695694142", "WelUQl6bCqcBa2fM", encrypted) {
+		t.Fatal("expect the signature over the timestamp and the nonce")
+	}
+}
+
+// newDingDingEventRequest returns the event request encrypted and signed as the platform
+func newDingDingEventRequest(t *testing.T, crypto *DingDingCallbackCrypto, event string) *http.Request {
+	encrypted, err := crypto.Encrypt([]byte(event))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]string{"encrypt": encrypted})
+	query := url.Values{"signature": {crypto.Signature("1605695694141", "WelUQl6bCqcBa2fM", encrypted)},
+		"timestamp": {"1605695694141"}, "nonce": {"WelUQl6bCqcBa2fM"}}
+	return httptest.NewRequest(http.MethodPost, "/events?"+query.Encode(), strings.NewReader(string(body)))
+}
+
+// expectDingDingSuccess check the encrypted success reply
+func expectDingDingSuccess(t *testing.T, crypto *DingDingCallbackCrypto, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+	var reply DingDingCallbackReply
+	if err := json.Unmarshal(recorder.Body.Bytes(), &reply); recorder.Code != http.StatusOK || err != nil {
+		t.Fatalf("expect the reply, got %d %s", recorder.Code, recorder.Body)
+	}
+	if !crypto.VerifySignature(reply.MsgSignature, reply.TimeStamp, reply.Nonce, reply.Encrypt) || reply.Nonce != "WelUQl6bCqcBa2fM" {
+		t.Fatalf("expect the reply signed, got %+v", reply)
+	}
+	if message, err := crypto.Decrypt(reply.Encrypt); err != nil || string(message) != DingDingCallbackSuccess {
+		t.Fatalf("expect the success encrypted, got %q, %v", message, err)
+	}
+}
+
+func TestDingDingEventHandler(t *testing.T) {
+	crypto := newTestDingDingCallbackCrypto(t)
+	handler := NewDingDingEventHandler(crypto)
+	var chatEvents []*DingDingChatEvent
+	handler.OnChatMemberChange(func(ctx context.Context, event *DingDingChatEvent) error {
+		chatEvents = append(chatEvents, event)
+		return nil
+	})
+	var rawEvent *DingDingEvent
+	handler.Handle(func(ctx context.Context, event *DingDingEvent) error {
+		rawEvent = event
+		return nil
+	}, "bpms_task_change")
+
+	// the url verification
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newDingDingEventRequest(t, crypto, `{"EventType":"check_url"}`))
+	expectDingDingSuccess(t, crypto, recorder)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newDingDingEventRequest(t, crypto,
+		`{"EventType":"chat_add_member","ChatId":"chat52999a8e1bdedfe94bb6e9841d581c9e","Operator":"manager2159","UserId":["user1","user2"],"TimeStamp":1605695694141}`))
+	expectDingDingSuccess(t, crypto, recorder)
+	if len(chatEvents) != 1 || chatEvents[0].ChatID != chatID || len(chatEvents[0].UserIDs) != 2 || chatEvents[0].TimeStamp != "1605695694141" {
+		t.Fatalf("unexpected chat events %+v", chatEvents)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newDingDingEventRequest(t, crypto, `{"EventType":"bpms_task_change","processInstanceId":"ad253df6"}`))
+	expectDingDingSuccess(t, crypto, recorder)
+	if rawEvent == nil || !strings.Contains(string(rawEvent.Raw), `"processInstanceId":"ad253df6"`) {
+		t.Fatalf("expect the event dispatched with the json, got %+v", rawEvent)
+	}
+
+	request := newDingDingEventRequest(t, crypto, `{"EventType":"chat_disband"}`)
+	request.URL.RawQuery = strings.Replace(request.URL.RawQuery, "nonce=WelUQl6bCqcBa2fM", "nonce=other", 1)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden || len(chatEvents) != 1 {
+		t.Fatalf("expect the event of the invalid signature forbidden, got %d", recorder.Code)
+	}
+}
+
+func TestDingDingEventHandler_Error(t *testing.T) {
+	crypto := newTestDingDingCallbackCrypto(t)
+	handler := NewDingDingEventHandler(crypto)
+	handler.OnUserChange(func(ctx context.Context, event *DingDingUserEvent) error {
+		return errors.New("database is down")
+	})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newDingDingEventRequest(t, crypto, `{"EventType":"user_leave_org","UserId":["user1"]}`))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("expect the failed event not acknowledged, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,27 @@
+package dingtalk
+
+import (
+	"context"
+
+	"github.com/duoland/chatapi"
+)
+
+// NewDingDingCommandHandler returns the outgoing robot func running the commands of the messages mentioning the robot,
+// the replies are sent to the session webhook of the message by the robot. The sender id is the staff id of the
+// sender, or the dingtalk id for the senders of the other corps.
+func NewDingDingCommandHandler(router *chatapi.CommandRouter, robot *DingDingRobot) DingDingOutgoingFunc {
+	return func(ctx context.Context, message *DingDingOutgoingMessage) error {
+		request := chatapi.CommandRequest{Provider: chatapi.ProviderDingTalk, SenderID: message.SenderStaffID, SenderName: message.SenderNick,
+			Text: message.Content(), Mentioned: true, Message: message}
+		if request.SenderID == "" {
+			request.SenderID = message.SenderID
+		}
+		if message.ConversationType == DingDingOutgoingConversationGroup {
+			request.ChatID = message.ConversationID
+		}
+		router.Serve(ctx, &request, func(ctx context.Context, content string) error {
+			return robot.ReplyTextMessageCtx(ctx, message, content)
+		})
+		return nil
+	}
+}
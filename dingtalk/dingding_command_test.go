@@ -0,0 +1,31 @@
+package dingtalk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestDingDingCommandHandler(t *testing.T) {
+	ddRobot, server := newTestDingDingRobot(t)
+	router := chatapi.NewCommandRouter()
+	router.Register(chatapi.Command{Name: "deploy", Args: []string{"service", "env"}, AllowedUsers: []string{"manager4521"},
+		Run: func(ctx context.Context, request *chatapi.CommandRequest) (string, error) {
+			return "deploying " + request.Args[0] + " to " + request.Args[1] + " in " + request.ChatID, nil
+		}})
+	handler := NewDingDingOutgoingHandler(outgoingAppSecret, NewDingDingCommandHandler(router, ddRobot))
+	body, _ := json.Marshal(newDingDingOutgoingMessage(time.Hour))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newDingDingOutgoingRequest(string(body), time.Now()))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect the message acknowledged, got %d %s", recorder.Code, recorder.Body)
+	}
+	router.Wait()
+	expectFields(t, server, DingDingRobotSessionAPI, map[string]interface{}{"msgtype": "text",
+		"text.content": "deploying api to prod in cidnrCjJZ", "at.atUserIds.0": "manager4521"})
+}
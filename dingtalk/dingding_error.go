@@ -0,0 +1,46 @@
+package dingtalk
+
+import (
+	"net/http"
+
+	"github.com/duoland/chatapi"
+)
+
+// See doc https://ding-doc.dingtalk.com/doc#/faquestions/rftpfg
+const (
+	DingDingCodeSystemBusy         = -1
+	DingDingCodeInvalidCredential  = 40001
+	DingDingCodeAccessTokenInvalid = 40014
+	DingDingCodeAPIFreqOutOfLimit  = 90018
+	DingDingCodeRobotSendTooFast   = 130101
+)
+
+// newDingDingCodeError create the api error of the errcode returned by dingtalk
+func newDingDingCodeError(endpoint string, errCode int, errMessage string) *chatapi.APIError {
+	return &chatapi.APIError{
+		Provider:   chatapi.ProviderDingTalk,
+		Endpoint:   endpoint,
+		HTTPStatus: http.StatusOK,
+		ErrCode:    errCode,
+		ErrMessage: errMessage,
+		Retryable:  isDingDingRetryableCode(errCode),
+	}
+}
+
+// isDingDingTokenRejectedCode report whether the server rejects the access token,
+// the token should be refreshed and the request replayed
+func isDingDingTokenRejectedCode(errCode int) bool {
+	switch errCode {
+	case DingDingCodeAccessTokenExpired, DingDingCodeAccessTokenInvalid, DingDingCodeInvalidCredential:
+		return true
+	}
+	return false
+}
+
+func isDingDingRetryableCode(errCode int) bool {
+	switch errCode {
+	case DingDingCodeSystemBusy, DingDingCodeAPIFreqOutOfLimit, DingDingCodeRobotSendTooFast:
+		return true
+	}
+	return false
+}
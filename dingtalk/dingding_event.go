@@ -0,0 +1,74 @@
+package dingtalk
+
+// See doc https://ding-doc.dingtalk.com/doc#/serverapi2/skn8ld
+
+import (
+	"encoding/json"
+)
+
+// The types of the events of dingding, the check_url event verifies the subscription url
+const (
+	DingDingEventCheckURL = "check_url"
+
+	DingDingEventChatAddMember    = "chat_add_member"
+	DingDingEventChatRemoveMember = "chat_remove_member"
+	DingDingEventChatQuit         = "chat_quit"
+	DingDingEventChatUpdateOwner  = "chat_update_owner"
+	DingDingEventChatUpdateTitle  = "chat_update_title"
+	DingDingEventChatDisband      = "chat_disband"
+
+	DingDingEventUserAddOrg    = "user_add_org"
+	DingDingEventUserModifyOrg = "user_modify_org"
+	DingDingEventUserLeaveOrg  = "user_leave_org"
+	DingDingEventUserActiveOrg = "user_active_org"
+
+	DingDingEventMessageRead = "im_message_read"
+)
+
+// dingDingChatEventTypes is the events handled by DingDingEventHandler.OnChatMemberChange
+var dingDingChatEventTypes = []string{DingDingEventChatAddMember, DingDingEventChatRemoveMember, DingDingEventChatQuit,
+	DingDingEventChatUpdateOwner, DingDingEventChatUpdateTitle, DingDingEventChatDisband}
+
+// dingDingUserEventTypes is the events handled by DingDingEventHandler.OnUserChange
+var dingDingUserEventTypes = []string{DingDingEventUserAddOrg, DingDingEventUserModifyOrg, DingDingEventUserLeaveOrg,
+	DingDingEventUserActiveOrg}
+
+// DingDingEvent is the fields shared by all the events, with the decrypted json of the event
+type DingDingEvent struct {
+	EventType string          `json:"EventType"`
+	CorpID    string          `json:"CorpId"`
+	TimeStamp json.Number     `json:"TimeStamp"` // the milliseconds of the event
+	Raw       json.RawMessage `json:"-"`
+}
+
+// DingDingChatEvent is the member and setting changes of the group chat, the user ids are the members
+// added or removed, the title and the owner are set on the title and owner changes
+type DingDingChatEvent struct {
+	EventType string      `json:"EventType"`
+	CorpID    string      `json:"CorpId"`
+	TimeStamp json.Number `json:"TimeStamp"`
+	ChatID    string      `json:"ChatId"`
+	Operator  string      `json:"Operator"`
+	UserIDs   []string    `json:"UserId"`
+	Title     string      `json:"Title"`
+	Owner     string      `json:"Owner"`
+}
+
+// DingDingUserEvent is the users added to, changed in, left or activated in the corp
+type DingDingUserEvent struct {
+	EventType string      `json:"EventType"`
+	CorpID    string      `json:"CorpId"`
+	TimeStamp json.Number `json:"TimeStamp"`
+	UserIDs   []string    `json:"UserId"`
+}
+
+// DingDingMessageReadEvent is the message read by the user in the chat
+type DingDingMessageReadEvent struct {
+	EventType string      `json:"EventType"`
+	CorpID    string      `json:"CorpId"`
+	TimeStamp json.Number `json:"TimeStamp"`
+	ChatID    string      `json:"ChatId"`
+	MessageID string      `json:"MsgId"`
+	UserID    string      `json:"UserId"`
+	ReadTime  json.Number `json:"ReadTime"`
+}
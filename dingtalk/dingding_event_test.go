@@ -0,0 +1,19 @@
+package dingtalk
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDingDingEvent(t *testing.T) {
+	var userEvent DingDingUserEvent
+	err := json.Unmarshal([]byte(`{"EventType":"user_modify_org","CorpId":"dingcorp","UserId":["user1"],"TimeStamp":"1605695694141"}`), &userEvent)
+	if err != nil || userEvent.CorpID != "dingcorp" || len(userEvent.UserIDs) != 1 || userEvent.TimeStamp != "1605695694141" {
+		t.Fatalf("unexpected user event %+v, %v", userEvent, err)
+	}
+	var readEvent DingDingMessageReadEvent
+	err = json.Unmarshal([]byte(`{"EventType":"im_message_read","ChatId":"chat1","MsgId":"msg1","UserId":"user1","ReadTime":1605695694141}`), &readEvent)
+	if err != nil || readEvent.MessageID != "msg1" || readEvent.UserID != "user1" || readEvent.ReadTime != "1605695694141" {
+		t.Fatalf("unexpected message read event %+v, %v", readEvent, err)
+	}
+}
@@ -0,0 +1,144 @@
+package dingtalk
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/duoland/chatapi/chatapitest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden payload files in testdata")
+
+func TestDingDingApp_GoldenPayloads(t *testing.T) {
+	app, server := newTestDingDingApp(t)
+	server.AddGroup(chatapitest.Group{ChatID: chatID, Name: "一个简单的测试群", Owner: userIDList[0], Users: userIDList})
+	taskID := 240072433293
+	linkMessage := DingDingAppLinkMessage{Text: "bla bla bla", Title: "i am a demo blog title",
+		PicURL: "https://gw.alicdn.com/tfs/TB1ut3xxbsrBKNjSZFpXXcXhFXa-846-786.png", MessageURL: "http://www.oschina.net"}
+	singleActionCard := DingDingAppActionCardMessage{Title: "乔布斯 20 年前想打造一间苹果咖啡厅", Markdown: "### 乔布斯 20 年前想打造的苹果咖啡厅",
+		SingleTitle: "Read More>>", SingleURL: "http://www.oschina.net"}
+	buttonsActionCard := DingDingAppActionCardMessage{Title: "乔布斯 20 年前想打造一间苹果咖啡厅", Markdown: "### 乔布斯 20 年前想打造的苹果咖啡厅",
+		ButtonOrientation: DingDingActionCardMessageButtonOrientationHorizontal,
+		Buttons:           []DingDingAppActionCardButton{{Title: "内容不错", ActionURL: "http://oschina.net"}, {Title: "不感兴趣", ActionURL: "http://www.baidu.com"}}}
+	chatapitest.RunGoldenCases(t, server, []chatapitest.GoldenCase{
+		{Name: "dingding_app_text", API: DingDingAppSendMessageAPI, Send: func() error {
+			_, err := app.SendTextMessage(userIDList, departmentIDList, false, "hello, master")
+			return err
+		}},
+		{Name: "dingding_app_text_to_all", API: DingDingAppSendMessageAPI, Send: func() error {
+			_, err := app.SendTextMessage(nil, nil, true, "hello, everyone")
+			return err
+		}},
+		{Name: "dingding_app_markdown", API: DingDingAppSendMessageAPI, Send: func() error {
+			_, err := app.SendMarkdownMessage(userIDList, nil, false, "hello master", "# hello, master")
+			return err
+		}},
+		{Name: "dingding_app_image", API: DingDingAppSendMessageAPI, Send: func() error {
+			_, err := app.SendImageMessage(userIDList, nil, false, "@lADOADmaWMzazQKA")
+			return err
+		}},
+		{Name: "dingding_app_voice", API: DingDingAppSendMessageAPI, Send: func() error {
+			_, err := app.SendVoiceMessage(userIDList, nil, false, "@lADOADmaWMzazQKA", 10)
+			return err
+		}},
+		{Name: "dingding_app_file", API: DingDingAppSendMessageAPI, Send: func() error {
+			_, err := app.SendFileMessage(userIDList, nil, false, "@lADOADmaWMzazQKA")
+			return err
+		}},
+		{Name: "dingding_app_link", API: DingDingAppSendMessageAPI, Send: func() error {
+			_, err := app.SendLinkMessage(userIDList, nil, false, &linkMessage)
+			return err
+		}},
+		{Name: "dingding_app_action_card_single", API: DingDingAppSendMessageAPI, Send: func() error {
+			_, err := app.SendActionCardMessage(userIDList, nil, false, &singleActionCard)
+			return err
+		}},
+		{Name: "dingding_app_action_card_buttons", API: DingDingAppSendMessageAPI, Send: func() error {
+			_, err := app.SendActionCardMessage(userIDList, nil, false, &buttonsActionCard)
+			return err
+		}},
+		{Name: "dingding_app_send_progress", API: DingDingAppGetMessageSendProgressAPI, Send: func() error {
+			_, err := app.GetMessageSendProgress(taskID)
+			return err
+		}},
+		{Name: "dingding_app_send_result", API: DingDingAppGetMessageSendResultAPI, Send: func() error {
+			_, err := app.GetMessageSendResult(taskID)
+			return err
+		}},
+		{Name: "dingding_app_recall", API: DingDingAppRecallMessageAPI, Send: func() error {
+			_, err := app.RecallMessage(taskID)
+			return err
+		}},
+		{Name: "dingding_app_group_text", API: DingDingAppSendGroupMessageAPI, Send: func() error {
+			_, err := app.SendGroupTextMessage(chatID, "hello, master")
+			return err
+		}},
+		{Name: "dingding_app_group_markdown", API: DingDingAppSendGroupMessageAPI, Send: func() error {
+			_, err := app.SendGroupMarkdownMessage(chatID, "hello master", "# hello, master")
+			return err
+		}},
+		{Name: "dingding_app_group_image", API: DingDingAppSendGroupMessageAPI, Send: func() error {
+			_, err := app.SendGroupImageMessage(chatID, "@lADOADmaWMzazQKA")
+			return err
+		}},
+		{Name: "dingding_app_group_voice", API: DingDingAppSendGroupMessageAPI, Send: func() error {
+			_, err := app.SendGroupVoiceMessage(chatID, "@lADOADmaWMzazQKA", 10)
+			return err
+		}},
+		{Name: "dingding_app_group_file", API: DingDingAppSendGroupMessageAPI, Send: func() error {
+			_, err := app.SendGroupFileMessage(chatID, "@lADOADmaWMzazQKA")
+			return err
+		}},
+		{Name: "dingding_app_group_link", API: DingDingAppSendGroupMessageAPI, Send: func() error {
+			_, err := app.SendGroupLinkMessage(chatID, &linkMessage)
+			return err
+		}},
+		{Name: "dingding_app_group_action_card", API: DingDingAppSendGroupMessageAPI, Send: func() error {
+			_, err := app.SendGroupActionCardMessage(chatID, &buttonsActionCard)
+			return err
+		}},
+		{Name: "dingding_app_create_group", API: DingDingAppCreateGroupAPI, Send: func() error {
+			_, err := app.CreateGroupChat("一个不简单的测试群", userIDList[0], userIDList, &DingDingAppCreateGroupOptions{Searchable: 1})
+			return err
+		}},
+		{Name: "dingding_app_update_group", API: DingDingAppUpdateGroupAPI, Send: func() error {
+			return app.UpdateGroupChat(chatID, &DingDingAppUpdateGroupOptions{Name: "一个更不简单的测试群", AddUserList: []string{"manager2160"}})
+		}},
+	}, *update)
+}
+
+func TestDingDingRobot_GoldenPayloads(t *testing.T) {
+	robot, server := newTestDingDingRobot(t)
+	chatapitest.RunGoldenCases(t, server, []chatapitest.GoldenCase{
+		{Name: "dingding_robot_text", API: DingDingRobotMessageAPI, Send: func() error {
+			return robot.SendTextMessage(&securitySettings, "hello, master")
+		}},
+		{Name: "dingding_robot_text_mention", API: DingDingRobotMessageAPI, Send: func() error {
+			return robot.SendTextMessageWithMention(&securitySettings, "hello, master", []string{"17817213491"}, true)
+		}},
+		{Name: "dingding_robot_markdown", API: DingDingRobotMessageAPI, Send: func() error {
+			return robot.SendMarkdownMessage(&securitySettings, &markdownMessage)
+		}},
+		{Name: "dingding_robot_markdown_mention", API: DingDingRobotMessageAPI, Send: func() error {
+			return robot.SendMarkdownMessageWithMention(&securitySettings, &markdownMessage, []string{"17817213491"}, false)
+		}},
+		{Name: "dingding_robot_link", API: DingDingRobotMessageAPI, Send: func() error {
+			return robot.SendLinkMessage(&securitySettings, &DingDingRobotLinkMessage{Text: "bla bla bla", Title: "i am a demo blog title",
+				PicURL: "https://gw.alicdn.com/tfs/TB1ut3xxbsrBKNjSZFpXXcXhFXa-846-786.png", MessageURL: "http://www.oschina.net"})
+		}},
+		{Name: "dingding_robot_action_card_single", API: DingDingRobotMessageAPI, Send: func() error {
+			return robot.SendActionCardMessage(&securitySettings, &DingDingRobotActionCardMessage{Title: "乔布斯 20 年前想打造一间苹果咖啡厅",
+				Text: "### 乔布斯 20 年前想打造的苹果咖啡厅", ButtonOrientation: DingDingActionCardMessageButtonOrientationVertical,
+				SingleTitle: "Read More>>", SingleURL: "http://www.oschina.net"})
+		}},
+		{Name: "dingding_robot_action_card_buttons", API: DingDingRobotMessageAPI, Send: func() error {
+			return robot.SendActionCardMessage(&securitySettings, &DingDingRobotActionCardMessage{Title: "乔布斯 20 年前想打造一间苹果咖啡厅",
+				Text: "### 乔布斯 20 年前想打造的苹果咖啡厅", ButtonOrientation: DingDingActionCardMessageButtonOrientationHorizontal,
+				Buttons: []DingDingRobotActionCardButton{{Title: "内容不错", ActionURL: "http://oschina.net"}, {Title: "不感兴趣", ActionURL: "http://www.baidu.com"}}})
+		}},
+		{Name: "dingding_robot_feed_card", API: DingDingRobotMessageAPI, Send: func() error {
+			return robot.SendFeedCardMessage(&securitySettings, []DingDingRobotFeedCardMessage{
+				{Title: "时代的火车向前开", MessageURL: "http://www.oschina.net", PicURL: "https://gw.alicdn.com/tfs/TB1ut3xxbsrBKNjSZFpXXcXhFXa-846-786.png"}})
+		}},
+	}, *update)
+}
@@ -0,0 +1,129 @@
+package dingtalk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/duoland/chatapi"
+)
+
+var _ chatapi.Notifier = (*DingDingRobotNotifier)(nil)
+var _ chatapi.Notifier = (*DingDingAppNotifier)(nil)
+
+// DingDingRobotNotifier adapts the DingDingRobot to the chatapi.Notifier interface
+type DingDingRobotNotifier struct {
+	robot            *DingDingRobot
+	securitySettings *DingDingSecuritySettings
+}
+
+// NewDingDingRobotNotifier create a notifier sending messages with the robot security settings
+func NewDingDingRobotNotifier(robot *DingDingRobot, securitySettings *DingDingSecuritySettings) *DingDingRobotNotifier {
+	return &DingDingRobotNotifier{robot: robot, securitySettings: securitySettings}
+}
+
+// SendText send the text message
+func (n *DingDingRobotNotifier) SendText(ctx context.Context, content string) error {
+	return n.robot.SendTextMessageCtx(ctx, n.securitySettings, content)
+}
+
+// SendMarkdown send the markdown message
+func (n *DingDingRobotNotifier) SendMarkdown(ctx context.Context, title, content string) error {
+	return n.robot.SendMarkdownMessageCtx(ctx, n.securitySettings, &DingDingRobotMarkdownMessage{Title: title, Text: content})
+}
+
+// SendLink send the link message
+func (n *DingDingRobotNotifier) SendLink(ctx context.Context, link *chatapi.Link) error {
+	return n.robot.SendLinkMessageCtx(ctx, n.securitySettings, &DingDingRobotLinkMessage{
+		Title:      link.Title,
+		Text:       link.Description,
+		MessageURL: link.URL,
+		PicURL:     link.PictureURL,
+	})
+}
+
+// SendImage send the image url as a markdown message, the robot has no image message
+func (n *DingDingRobotNotifier) SendImage(ctx context.Context, image *chatapi.Image) error {
+	if image.URL == "" {
+		return fmt.Errorf("%w, dingtalk robot requires the image url", chatapi.ErrUnsupported)
+	}
+	return n.SendMarkdown(ctx, image.Name, dingDingMarkdownImage(image))
+}
+
+// DingDingAppNotifyTarget is the receivers of the messages sent by DingDingAppNotifier
+type DingDingAppNotifyTarget struct {
+	UserIDList       []string
+	DepartmentIDList []string
+	ToAllUser        bool
+	ChatID           string // send to the group chat instead of the users when specified
+}
+
+// DingDingAppNotifier adapts the DingDingApp to the chatapi.Notifier interface
+type DingDingAppNotifier struct {
+	app    *DingDingApp
+	target DingDingAppNotifyTarget
+}
+
+// NewDingDingAppNotifier create a notifier sending messages to the target
+func NewDingDingAppNotifier(app *DingDingApp, target DingDingAppNotifyTarget) *DingDingAppNotifier {
+	return &DingDingAppNotifier{app: app, target: target}
+}
+
+// SendText send the text message
+func (n *DingDingAppNotifier) SendText(ctx context.Context, content string) (err error) {
+	t := &n.target
+	if t.ChatID != "" {
+		_, err = n.app.SendGroupTextMessageCtx(ctx, t.ChatID, content)
+		return
+	}
+	_, err = n.app.SendTextMessageCtx(ctx, t.UserIDList, t.DepartmentIDList, t.ToAllUser, content)
+	return
+}
+
+// SendMarkdown send the markdown message
+func (n *DingDingAppNotifier) SendMarkdown(ctx context.Context, title, content string) (err error) {
+	t := &n.target
+	if t.ChatID != "" {
+		_, err = n.app.SendGroupMarkdownMessageCtx(ctx, t.ChatID, title, content)
+		return
+	}
+	_, err = n.app.SendMarkdownMessageCtx(ctx, t.UserIDList, t.DepartmentIDList, t.ToAllUser, title, content)
+	return
+}
+
+// SendLink send the link message
+func (n *DingDingAppNotifier) SendLink(ctx context.Context, link *chatapi.Link) (err error) {
+	t := &n.target
+	linkMessage := DingDingAppLinkMessage{
+		Title:      link.Title,
+		Text:       link.Description,
+		MessageURL: link.URL,
+		PicURL:     link.PictureURL,
+	}
+	if t.ChatID != "" {
+		_, err = n.app.SendGroupLinkMessageCtx(ctx, t.ChatID, &linkMessage)
+		return
+	}
+	_, err = n.app.SendLinkMessageCtx(ctx, t.UserIDList, t.DepartmentIDList, t.ToAllUser, &linkMessage)
+	return
+}
+
+// SendImage send the image by media id, or the image url as a markdown message
+func (n *DingDingAppNotifier) SendImage(ctx context.Context, image *chatapi.Image) (err error) {
+	t := &n.target
+	if image.MediaID == "" {
+		if image.URL == "" {
+			return fmt.Errorf("%w, dingtalk app requires the image media id or url", chatapi.ErrUnsupported)
+		}
+		return n.SendMarkdown(ctx, image.Name, dingDingMarkdownImage(image))
+	}
+	if t.ChatID != "" {
+		_, err = n.app.SendGroupImageMessageCtx(ctx, t.ChatID, image.MediaID)
+		return
+	}
+	_, err = n.app.SendImageMessageCtx(ctx, t.UserIDList, t.DepartmentIDList, t.ToAllUser, image.MediaID)
+	return
+}
+
+func dingDingMarkdownImage(image *chatapi.Image) string {
+	return fmt.Sprintf("![%s](%s)", image.Name, image.URL)
+}
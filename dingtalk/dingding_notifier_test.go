@@ -0,0 +1,24 @@
+package dingtalk
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestDingDingRobotNotifier_SendLink(t *testing.T) {
+	robot, server := newTestDingDingRobot(t)
+
+	var notifier chatapi.Notifier = NewDingDingRobotNotifier(robot, &DingDingSecuritySettings{AccessToken: "token"})
+	link := chatapi.Link{Title: "title", Description: "description", URL: "https://example.com"}
+	if err := notifier.SendLink(context.Background(), &link); err != nil {
+		t.Fatal(err)
+	}
+	expectFields(t, server, DingDingRobotMessageAPI, map[string]interface{}{"msgtype": DingDingRobotMessageTypeLink,
+		"link.title": "title", "link.messageUrl": "https://example.com"})
+	if err := notifier.SendImage(context.Background(), &chatapi.Image{Data: []byte("png")}); !errors.Is(err, chatapi.ErrUnsupported) {
+		t.Fatalf("expect ErrUnsupported, got %v", err)
+	}
+}
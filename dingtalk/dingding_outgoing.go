@@ -0,0 +1,244 @@
+package dingtalk
+
+// See doc https://developers.dingtalk.com/document/app/develop-enterprise-internal-robots
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/duoland/chatapi"
+)
+
+// DingDingRobotSessionAPI is the api of the session webhooks to reply the messages to the outgoing robot
+const DingDingRobotSessionAPI = "https://oapi.dingtalk.com/robot/sendBySession"
+
+// DingDingOutgoingMaxSkew is the max difference between the timestamp of the message and the local clock
+var DingDingOutgoingMaxSkew = time.Hour
+
+// The types of the conversations of the messages to the outgoing robot
+const (
+	DingDingOutgoingConversationSingle = "1"
+	DingDingOutgoingConversationGroup  = "2"
+)
+
+// DingDingOutgoingAtUser is the user mentioned in the message, the staff id is set for the users of the corp of the robot
+type DingDingOutgoingAtUser struct {
+	DingTalkID string `json:"dingtalkId"`
+	StaffID    string `json:"staffId"`
+}
+
+// DingDingOutgoingMessage is the message sent to the outgoing robot by mentioning it in the group chat or in the single chat,
+// the replies are sent to the session webhook until it expires
+type DingDingOutgoingMessage struct {
+	MsgID             string                   `json:"msgId"`
+	MsgType           string                   `json:"msgtype"`
+	CreateAt          int64                    `json:"createAt"` // the milliseconds of the message
+	ConversationID    string                   `json:"conversationId"`
+	ConversationType  string                   `json:"conversationType"`
+	ConversationTitle string                   `json:"conversationTitle"`
+	SenderID          string                   `json:"senderId"`
+	SenderNick        string                   `json:"senderNick"`
+	SenderCorpID      string                   `json:"senderCorpId"`
+	SenderStaffID     string                   `json:"senderStaffId"`
+	IsAdmin           bool                     `json:"isAdmin"`
+	ChatbotCorpID     string                   `json:"chatbotCorpId"`
+	ChatbotUserID     string                   `json:"chatbotUserId"`
+	IsInAtList        bool                     `json:"isInAtList"`
+	AtUsers           []DingDingOutgoingAtUser `json:"atUsers"`
+	Text              struct {
+		Content string `json:"content"`
+	} `json:"text"`
+	SessionWebhook            string `json:"sessionWebhook"`
+	SessionWebhookExpiredTime int64  `json:"sessionWebhookExpiredTime"` // the milliseconds the session webhook expires
+	RobotCode                 string `json:"robotCode"`
+}
+
+// Content returns the text of the message without the leading and trailing spaces left by the mention
+func (m *DingDingOutgoingMessage) Content() string {
+	return strings.TrimSpace(m.Text.Content)
+}
+
+// SessionExpired check whether the session webhook expired at the time
+func (m *DingDingOutgoingMessage) SessionExpired(now time.Time) bool {
+	return m.SessionWebhookExpiredTime <= now.UnixNano()/int64(time.Millisecond)
+}
+
+// DingDingOutgoingSign returns the sign header of the messages to the outgoing robot, the same as the custom robot webhooks
+func DingDingOutgoingSign(timestamp, appSecret string) string {
+	return base64.StdEncoding.EncodeToString(HmacSha256([]byte(timestamp+"\n"+appSecret), []byte(appSecret)))
+}
+
+// DingDingOutgoingFunc handle the message to the outgoing robot, the error fails the request
+type DingDingOutgoingFunc func(ctx context.Context, message *DingDingOutgoingMessage) error
+
+// DingDingOutgoingHandler is the http.Handler of the message receiving address of the outgoing robot,
+// it checks the sign and the timestamp of the messages and parses them
+type DingDingOutgoingHandler struct {
+	appSecret string
+	handle    DingDingOutgoingFunc
+}
+
+// NewDingDingOutgoingHandler create the handler by the app secret of the robot
+func NewDingDingOutgoingHandler(appSecret string, handle DingDingOutgoingFunc) *DingDingOutgoingHandler {
+	return &DingDingOutgoingHandler{appSecret: appSecret, handle: handle}
+}
+
+func (h *DingDingOutgoingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	timestamp := r.Header.Get("timestamp")
+	millis, parseErr := strconv.ParseInt(timestamp, 10, 64)
+	if parseErr != nil {
+		http.Error(w, chatapi.ErrInvalidSignature.Error(), http.StatusForbidden)
+		return
+	}
+	if skew := time.Since(time.Unix(0, millis*int64(time.Millisecond))); skew > DingDingOutgoingMaxSkew || skew < -DingDingOutgoingMaxSkew {
+		http.Error(w, fmt.Sprintf("%v, timestamp skew %s", chatapi.ErrInvalidSignature, skew.Round(time.Second)), http.StatusForbidden)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("sign")), []byte(DingDingOutgoingSign(timestamp, h.appSecret))) != 1 {
+		http.Error(w, chatapi.ErrInvalidSignature.Error(), http.StatusForbidden)
+		return
+	}
+	var message DingDingOutgoingMessage
+	if err := json.NewDecoder(io.LimitReader(r.Body, chatapi.CallbackMaxBodyBytes)).Decode(&message); err != nil {
+		http.Error(w, fmt.Sprintf("%v, decode message error, %v", chatapi.ErrInvalidCallback, err), http.StatusBadRequest)
+		return
+	}
+	if err := h.handle(r.Context(), &message); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the empty body tells the platform no synchronous reply
+	w.WriteHeader(http.StatusOK)
+}
+
+// ReplyTextMessage reply the text message to the conversation of the message to the outgoing robot
+func (r *DingDingRobot) ReplyTextMessage(message *DingDingOutgoingMessage, content string) (err error) {
+	return r.ReplyTextMessageCtx(context.Background(), message, content)
+}
+
+// ReplyTextMessageCtx is ReplyTextMessage with the context to control the request, the sender is mentioned in the group chat
+func (r *DingDingRobot) ReplyTextMessageCtx(ctx context.Context, message *DingDingOutgoingMessage, content string) (err error) {
+	messageObj := make(map[string]interface{})
+	messageObj["msgtype"] = DingDingRobotMessageTypeText
+	messageObj["text"] = map[string]string{"content": content}
+	if message.ConversationType == DingDingOutgoingConversationGroup && message.SenderStaffID != "" {
+		messageObj["at"] = map[string]interface{}{"atUserIds": []string{message.SenderStaffID}}
+	}
+	return r.replyMessage(ctx, message, &messageObj)
+}
+
+// ReplyMarkdownMessage reply the markdown message to the conversation of the message to the outgoing robot
+func (r *DingDingRobot) ReplyMarkdownMessage(message *DingDingOutgoingMessage, markdownMessage *DingDingRobotMarkdownMessage) (err error) {
+	return r.ReplyMarkdownMessageCtx(context.Background(), message, markdownMessage)
+}
+
+// ReplyMarkdownMessageCtx is ReplyMarkdownMessage with the context to control the request
+func (r *DingDingRobot) ReplyMarkdownMessageCtx(ctx context.Context, message *DingDingOutgoingMessage, markdownMessage *DingDingRobotMarkdownMessage) (err error) {
+	messageObj := make(map[string]interface{})
+	messageObj["msgtype"] = DingDingRobotMessageTypeMarkdown
+	messageObj["markdown"] = markdownMessage
+	return r.replyMessage(ctx, message, &messageObj)
+}
+
+// ReplyActionCardMessage reply the action card message to the conversation of the message to the outgoing robot
+func (r *DingDingRobot) ReplyActionCardMessage(message *DingDingOutgoingMessage, actionCardMessage *DingDingRobotActionCardMessage) (err error) {
+	return r.ReplyActionCardMessageCtx(context.Background(), message, actionCardMessage)
+}
+
+// ReplyActionCardMessageCtx is ReplyActionCardMessage with the context to control the request
+func (r *DingDingRobot) ReplyActionCardMessageCtx(ctx context.Context, message *DingDingOutgoingMessage, actionCardMessage *DingDingRobotActionCardMessage) (err error) {
+	messageObj := make(map[string]interface{})
+	messageObj["msgtype"] = DingDingRobotMessageTypeActionCard
+	messageObj["actionCard"] = actionCardMessage
+	return r.replyMessage(ctx, message, &messageObj)
+}
+
+// replyMessage reply the message, or its parts in order when the long messages are split
+func (r *DingDingRobot) replyMessage(ctx context.Context, message *DingDingOutgoingMessage, messageObj interface{}) (err error) {
+	var parts []interface{}
+	switch {
+	case r.options.SplitLongMessages:
+		parts = splitDingDingRobotMessage(r.options.RecipientPolicy, messageObj)
+	case r.options.TruncateLongMessages:
+		truncateDingDingRobotMessage(&r.options, messageObj)
+	}
+	if len(parts) == 0 {
+		return r.replySingleMessage(ctx, message, messageObj)
+	}
+	for i, part := range parts {
+		if partErr := r.replySingleMessage(ctx, message, part); partErr != nil {
+			err = fmt.Errorf("reply part %d/%d error, %w", i+1, len(parts), partErr)
+			return
+		}
+	}
+	return
+}
+
+// replySingleMessage post the message to the session webhook before it expires, the reply goes to the redirect webhook
+// of the recipient policy if set
+func (r *DingDingRobot) replySingleMessage(ctx context.Context, message *DingDingOutgoingMessage, messageObj interface{}) (err error) {
+	if policy := r.options.RecipientPolicy; policy != nil && policy.RedirectWebhook != "" {
+		return r.sendSingleMessage(ctx, nil, messageObj)
+	}
+	if message.SessionWebhook == "" || message.SessionExpired(time.Now()) {
+		err = fmt.Errorf("reply message %s error, %w", message.MsgID, chatapi.ErrSessionExpired)
+		return
+	}
+	if r.options.RecipientPolicy != nil {
+		applyDingDingRobotBanner(r.options.RecipientPolicy, messageObj)
+	}
+	if !r.options.SkipValidation {
+		if err = validateDingDingRobotMessage(messageObj); err != nil {
+			return
+		}
+	}
+	return r.options.Retry.Do(ctx, false, func() error {
+		if err := r.options.RateLimiter.Wait(ctx, chatapi.ProviderDingTalk+":session:"+message.ConversationID); err != nil {
+			return err
+		}
+		return r.postSessionMessage(ctx, message.SessionWebhook, messageObj)
+	})
+}
+
+func (r *DingDingRobot) postSessionMessage(ctx context.Context, sessionWebhook string, messageObj interface{}) (err error) {
+	webhookURL, parseErr := url.Parse(sessionWebhook)
+	if parseErr != nil {
+		err = fmt.Errorf("parse session webhook error, %w", chatapi.RedactError(parseErr))
+		return
+	}
+	sessionQuery := webhookURL.RawQuery
+	webhookURL.RawQuery = ""
+	reqURL := fmt.Sprintf("%s?%s", r.options.URL(webhookURL.String()), sessionQuery)
+	reqBody, _ := json.Marshal(messageObj)
+
+	req, newErr := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(reqBody))
+	if newErr != nil {
+		err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
+		return
+	}
+	req.Header.Add("Content-Type", "application/json")
+	var dingdingMessageResp DingDingRobotMessageResp
+	call := chatapi.Call{Provider: chatapi.ProviderDingTalk, Operation: "DingDingRobot.ReplyMessage", Endpoint: DingDingRobotSessionAPI,
+		Header: req.Header, Request: messageObj, Response: &dingdingMessageResp}
+	return chatapi.Intercept(ctx, r.options.Interceptors, &call, func(ctx context.Context, call *chatapi.Call) error {
+		if r.options.DryRun != nil {
+			return chatapi.DryRun(r.options.DryRun, call, req, dingDingDryRunResp)
+		}
+		return doDingDingRequest(r.client, req.WithContext(ctx), DingDingRobotSessionAPI, &dingdingMessageResp)
+	})
+}
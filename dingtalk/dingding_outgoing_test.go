@@ -0,0 +1,146 @@
+package dingtalk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/duoland/chatapi"
+)
+
+var outgoingAppSecret = "outgoing-app-secret"
+
+// newDingDingOutgoingMessage returns the message to the outgoing robot with the session webhook expiring in the ttl
+func newDingDingOutgoingMessage(ttl time.Duration) *DingDingOutgoingMessage {
+	message := DingDingOutgoingMessage{MsgID: "msgZ9Rxy", MsgType: "text", ConversationID: "cidnrCjJZ", ConversationType: DingDingOutgoingConversationGroup,
+		SenderID: "$:LWCP_v1:$Oq", SenderNick: "alice", SenderStaffID: "manager4521",
+		SessionWebhook:            "https://oapi.dingtalk.com/robot/sendBySession?session=c4c8b9f2e0",
+		SessionWebhookExpiredTime: time.Now().Add(ttl).UnixNano() / int64(time.Millisecond)}
+	message.Text.Content = " /deploy api prod"
+	return &message
+}
+
+// newDingDingOutgoingRequest returns the message request signed as the platform at the time
+func newDingDingOutgoingRequest(body string, at time.Time) *http.Request {
+	timestamp := strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10)
+	request := httptest.NewRequest(http.MethodPost, "/robot", strings.NewReader(body))
+	request.Header.Set("timestamp", timestamp)
+	request.Header.Set("sign", DingDingOutgoingSign(timestamp, outgoingAppSecret))
+	return request
+}
+
+func TestDingDingOutgoingHandler(t *testing.T) {
+	var messages []*DingDingOutgoingMessage
+	handler := NewDingDingOutgoingHandler(outgoingAppSecret, func(ctx context.Context, message *DingDingOutgoingMessage) error {
+		messages = append(messages, message)
+		return nil
+	})
+	body := `{"conversationId":"cidnrCjJZ","atUsers":[{"dingtalkId":"$:LWCP_v1:$bot"},{"dingtalkId":"$:LWCP_v1:$bob","staffId":"bob01"}],
+"chatbotUserId":"$:LWCP_v1:$bot","msgId":"msgZ9Rxy","senderNick":"alice","isAdmin":true,"senderStaffId":"manager4521",
+"sessionWebhookExpiredTime":1613635652738,"createAt":1613630252678,"conversationType":"2","senderId":"$:LWCP_v1:$Oq",
+"conversationTitle":"ops","isInAtList":true,"sessionWebhook":"https://oapi.dingtalk.com/robot/sendBySession?session=c4c8b9f2e0",
+"text":{"content":" /deploy api prod "},"msgtype":"text"}`
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newDingDingOutgoingRequest(body, time.Now()))
+	if recorder.Code != http.StatusOK || len(messages) != 1 {
+		t.Fatalf("expect the message handled, got %d %s", recorder.Code, recorder.Body)
+	}
+	message := messages[0]
+	if message.Content() != "/deploy api prod" || message.SenderStaffID != "manager4521" || !message.IsAdmin ||
+		message.ConversationType != DingDingOutgoingConversationGroup || len(message.AtUsers) != 2 || message.AtUsers[1].StaffID != "bob01" {
+		t.Fatalf("unexpected message %+v", message)
+	}
+
+	request := newDingDingOutgoingRequest(body, time.Now())
+	request.Header.Set("sign", DingDingOutgoingSign(request.Header.Get("timestamp"), "wrong"))
+	for name, request := range map[string]*http.Request{
+		"wrong secret": request,
+		"stale":        newDingDingOutgoingRequest(body, time.Now().Add(-2*time.Hour)),
+		"future":       newDingDingOutgoingRequest(body, time.Now().Add(2*time.Hour)),
+	} {
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusForbidden {
+			t.Fatalf("expect the %s message rejected, got %d", name, recorder.Code)
+		}
+	}
+	if len(messages) != 1 {
+		t.Fatalf("expect the rejected messages not handled, got %d", len(messages))
+	}
+}
+
+func TestDingDingOutgoingHandler_Error(t *testing.T) {
+	handler := NewDingDingOutgoingHandler(outgoingAppSecret, func(ctx context.Context, message *DingDingOutgoingMessage) error {
+		return errors.New("database unavailable")
+	})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newDingDingOutgoingRequest(`{"msgId":"msgZ9Rxy"}`, time.Now()))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("expect the handler error failing the request, got %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newDingDingOutgoingRequest(`not json`, time.Now()))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expect the invalid message rejected, got %d", recorder.Code)
+	}
+}
+
+func TestDingDingRobot_ReplyMessage(t *testing.T) {
+	ddRobot, server := newTestDingDingRobot(t)
+	message := newDingDingOutgoingMessage(time.Hour)
+	if err := ddRobot.ReplyTextMessage(message, "deploying api to prod"); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := server.LastRequest(DingDingRobotSessionAPI)
+	if req.Query.Get("session") != "c4c8b9f2e0" {
+		t.Fatalf("expect the reply to the session, got %v", req.Query)
+	}
+	expectFields(t, server, DingDingRobotSessionAPI, map[string]interface{}{"msgtype": "text",
+		"text.content": "deploying api to prod", "at.atUserIds.0": "manager4521"})
+
+	if err := ddRobot.ReplyMarkdownMessage(message, &markdownMessage); err != nil {
+		t.Fatal(err)
+	}
+	expectFields(t, server, DingDingRobotSessionAPI, map[string]interface{}{"msgtype": "markdown", "markdown.title": markdownMessage.Title})
+
+	actionCard := DingDingRobotActionCardMessage{Title: "deploy api", Text: "deploy api to prod?", SingleTitle: "open", SingleURL: "https://deploy.example.com"}
+	if err := ddRobot.ReplyActionCardMessage(message, &actionCard); err != nil {
+		t.Fatal(err)
+	}
+	expectFields(t, server, DingDingRobotSessionAPI, map[string]interface{}{"msgtype": "actionCard", "actionCard.singleURL": "https://deploy.example.com"})
+	// the action card reply goes through the validation like the other replies
+	actionCard.SingleURL = "javascript:alert(1)"
+	expectFieldError(t, ddRobot.ReplyActionCardMessage(message, &actionCard), "actionCard.singleURL", chatapi.RuleURL)
+	if requests := server.RequestsTo(DingDingRobotSessionAPI); len(requests) != 3 {
+		t.Fatalf("expect 3 replies, got %d", len(requests))
+	}
+}
+
+func TestDingDingRobot_ReplyMessage_Expired(t *testing.T) {
+	ddRobot, server := newTestDingDingRobot(t)
+	err := ddRobot.ReplyTextMessage(newDingDingOutgoingMessage(-time.Minute), "deploying api to prod")
+	if !errors.Is(err, chatapi.ErrSessionExpired) {
+		t.Fatalf("expect the expired session refused, got %v", err)
+	}
+	if requests := server.RequestsTo(DingDingRobotSessionAPI); len(requests) != 0 {
+		t.Fatalf("expect no reply sent, got %d", len(requests))
+	}
+}
+
+func TestDingDingRobot_ReplyMessage_Policy(t *testing.T) {
+	policy := chatapi.RecipientPolicy{RedirectWebhook: accessToken, RedirectWebhookSecret: secretToken, Banner: "[staging]"}
+	ddRobot, server := newTestDingDingRobot(t, chatapi.WithRecipientPolicy(&policy))
+	if err := ddRobot.ReplyTextMessage(newDingDingOutgoingMessage(time.Hour), "deploying api to prod"); err != nil {
+		t.Fatal(err)
+	}
+	if requests := server.RequestsTo(DingDingRobotSessionAPI); len(requests) != 0 {
+		t.Fatalf("expect the reply redirected, got %d session replies", len(requests))
+	}
+	expectFields(t, server, DingDingRobotMessageAPI, map[string]interface{}{"text.content": fmt.Sprintf("%s\n%s", policy.Banner, "deploying api to prod")})
+}
@@ -0,0 +1,116 @@
+package dingtalk
+
+import (
+	"strings"
+
+	"github.com/duoland/chatapi"
+)
+
+// applyDingDingAppPolicy rewrite the recipients and the content of the app message to the users by the recipient policy,
+// the message redirected to the test chat is turned into a group message
+func applyDingDingAppPolicy(policy *chatapi.RecipientPolicy, messageObj interface{}) (redirected bool, err error) {
+	message, ok := messageObj.(*map[string]interface{})
+	if !ok {
+		return
+	}
+	messageMap := *message
+	switch {
+	case policy.RedirectChat != "":
+		*message = map[string]interface{}{"chatid": policy.RedirectChat, "msg": messageMap["msg"]}
+		messageMap = *message
+		redirected = true
+	case policy.Allowlist != nil:
+		userIDList := allowedDingDingRecipients(messageMap["userid_list"], policy.Allowlist.Users)
+		departmentIDList := allowedDingDingRecipients(messageMap["dept_id_list"], policy.Allowlist.Departments)
+		if userIDList == "" && departmentIDList == "" {
+			err = chatapi.ErrRecipientBlocked
+			return
+		}
+		delete(messageMap, "userid_list")
+		delete(messageMap, "dept_id_list")
+		if userIDList != "" {
+			messageMap["userid_list"] = userIDList
+		}
+		if departmentIDList != "" {
+			messageMap["dept_id_list"] = departmentIDList
+		}
+		// the allowlist never lets the message reach all the users
+		messageMap["to_all_user"] = false
+	}
+	if msg, ok := messageMap["msg"].(map[string]interface{}); ok {
+		applyDingDingBanner(policy, msg)
+	}
+	return
+}
+
+// applyDingDingGroupPolicy rewrite the chat and the content of the app group message by the recipient policy
+func applyDingDingGroupPolicy(policy *chatapi.RecipientPolicy, messageObj interface{}) (err error) {
+	message, ok := messageObj.(*map[string]interface{})
+	if !ok {
+		return
+	}
+	messageMap := *message
+	chatID, _ := messageMap["chatid"].(string)
+	switch {
+	case policy.RedirectChat != "":
+		messageMap["chatid"] = policy.RedirectChat
+	case !policy.AllowChat(chatID):
+		err = chatapi.ErrRecipientBlocked
+		return
+	}
+	if msg, ok := messageMap["msg"].(map[string]interface{}); ok {
+		applyDingDingBanner(policy, msg)
+	}
+	return
+}
+
+// applyDingDingBanner prefix the content of the text and markdown messages with the banner, the app messages and
+// the robot messages share the layout of these two types
+func applyDingDingBanner(policy *chatapi.RecipientPolicy, messageMap map[string]interface{}) {
+	msgType, _ := messageMap["msgtype"].(string)
+	switch msgType {
+	case DingDingAppMessageTypeText:
+		if body, ok := messageMap[msgType].(map[string]string); ok {
+			body["content"] = policy.WithBanner(body["content"])
+		}
+	case DingDingAppMessageTypeMarkdown:
+		switch body := messageMap[msgType].(type) {
+		case map[string]string:
+			body["text"] = policy.WithBanner(body["text"])
+		case *DingDingRobotMarkdownMessage:
+			// the markdown message belongs to the caller, so the banner goes to a copy
+			markdownMessage := *body
+			markdownMessage.Text = policy.WithBanner(markdownMessage.Text)
+			messageMap[msgType] = &markdownMessage
+		}
+	}
+}
+
+// allowedDingDingRecipients returns the allowed ones of the recipients joined by ","
+func allowedDingDingRecipients(recipients interface{}, allowed []string) string {
+	recipientList, _ := recipients.(string)
+	if recipientList == "" {
+		return ""
+	}
+	return strings.Join(chatapi.AllowedRecipients(strings.Split(recipientList, ","), allowed), ",")
+}
+
+// dingDingRobotSecuritySettings returns the webhook to send to by the recipient policy
+func dingDingRobotSecuritySettings(policy *chatapi.RecipientPolicy, securitySettings *DingDingSecuritySettings) (*DingDingSecuritySettings, error) {
+	switch {
+	case policy == nil:
+		return securitySettings, nil
+	case policy.RedirectWebhook != "":
+		return &DingDingSecuritySettings{AccessToken: policy.RedirectWebhook, SecureToken: policy.RedirectWebhookSecret}, nil
+	case !policy.AllowWebhook(securitySettings.AccessToken):
+		return nil, chatapi.ErrRecipientBlocked
+	}
+	return securitySettings, nil
+}
+
+// applyDingDingRobotBanner prefix the content of the text and markdown robot messages with the banner
+func applyDingDingRobotBanner(policy *chatapi.RecipientPolicy, messageObj interface{}) {
+	if message, ok := messageObj.(*map[string]interface{}); ok {
+		applyDingDingBanner(policy, *message)
+	}
+}
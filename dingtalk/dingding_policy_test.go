@@ -0,0 +1,71 @@
+package dingtalk
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/duoland/chatapi"
+	"github.com/duoland/chatapi/chatapitest"
+)
+
+func TestDingDingApp_RecipientAllowlist(t *testing.T) {
+	policy := chatapi.RecipientPolicy{Allowlist: &chatapi.RecipientAllowlist{Users: []string{"qa001"}, Departments: []string{"381323914"}},
+		Banner: "[staging]"}
+	app, server := newTestDingDingApp(t, chatapi.WithRecipientPolicy(&policy))
+	if _, err := app.SendMarkdownMessage([]string{"manager2159", "qa001"}, []string{"1"}, true, "hello master", "# hello, master"); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := server.LastRequest(DingDingAppSendMessageAPI)
+	if req.Field("userid_list") != "qa001" || req.Field("dept_id_list") != nil || req.Field("to_all_user") != false ||
+		req.Field("msg.markdown.text") != "[staging]\n# hello, master" {
+		t.Fatalf("unexpected message %s", req.Body)
+	}
+	if _, err := app.SendTextMessage(nil, nil, true, "hello, everyone"); !errors.Is(err, chatapi.ErrRecipientBlocked) {
+		t.Fatalf("expect the message to all the users blocked, got %v", err)
+	}
+	if _, err := app.SendGroupTextMessage(chatID, "hello, master"); !errors.Is(err, chatapi.ErrRecipientBlocked) {
+		t.Fatalf("expect the message to the unlisted chat blocked, got %v", err)
+	}
+	if len(server.RequestsTo(DingDingAppSendMessageAPI)) != 1 || len(server.RequestsTo(DingDingAppSendGroupMessageAPI)) != 0 {
+		t.Fatal("expect the blocked messages not sent")
+	}
+}
+
+func TestDingDingApp_RecipientRedirect(t *testing.T) {
+	policy := chatapi.RecipientPolicy{RedirectChat: "chat-qa"}
+	app, server := newTestDingDingApp(t, chatapi.WithRecipientPolicy(&policy))
+	server.AddGroup(chatapitest.Group{ChatID: "chat-qa", Name: "qa", Owner: "qa001", Users: []string{"qa001"}})
+	if _, err := app.SendTextMessage(userIDList, departmentIDList, false, "hello, master"); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.RequestsTo(DingDingAppSendMessageAPI)) != 0 {
+		t.Fatal("expect the message to the users redirected")
+	}
+	req, _ := server.LastRequest(DingDingAppSendGroupMessageAPI)
+	if req.Field("chatid") != "chat-qa" || req.Field("msg.text.content") != "hello, master" || req.Field("userid_list") != nil ||
+		req.Field("agent_id") != nil {
+		t.Fatalf("unexpected redirected message %s", req.Body)
+	}
+}
+
+func TestDingDingRobot_RecipientPolicy(t *testing.T) {
+	policy := chatapi.RecipientPolicy{RedirectWebhook: "qa-access-token", RedirectWebhookSecret: "qa-secret", Banner: "[staging]"}
+	robot, server := newTestDingDingRobot(t, chatapi.WithRecipientPolicy(&policy))
+	server.SetRobotSecret("qa-access-token", "qa-secret")
+	message := DingDingRobotMarkdownMessage{Title: "hello", Text: "# hello, master"}
+	if err := robot.SendMarkdownMessage(&securitySettings, &message); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := server.LastRequest(DingDingRobotMessageAPI)
+	if req.Query.Get("access_token") != "qa-access-token" || req.Field("markdown.text") != "[staging]\n# hello, master" {
+		t.Fatalf("unexpected redirected message %s %s", req.Query, req.Body)
+	}
+	if message.Text != "# hello, master" {
+		t.Fatalf("expect the message of the caller kept, got %q", message.Text)
+	}
+
+	policy = chatapi.RecipientPolicy{Allowlist: &chatapi.RecipientAllowlist{Webhooks: []string{"qa-access-token"}}}
+	if err := robot.SendTextMessage(&securitySettings, "hello, master"); !errors.Is(err, chatapi.ErrRecipientBlocked) {
+		t.Fatalf("expect the unlisted webhook blocked, got %v", err)
+	}
+}
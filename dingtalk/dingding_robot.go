@@ -3,21 +3,25 @@ package dingtalk
 // See doc at https://ding-doc.dingtalk.com/doc#/serverapi2/qf2nxq
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/duoland/chatapi"
 )
 
 // DingDingRobotMessageAPI is the api to send the robot messages
 const DingDingRobotMessageAPI = "https://oapi.dingtalk.com/robot/send"
 
+// DingDingRobotRateLimit is the rate limit of the dingding custom robot, 20 messages per minute for each webhook
+var DingDingRobotRateLimit = chatapi.RateLimit{Rate: 20, Period: time.Minute}
+
 // DingDingRobotTimeout is the dingding robot default timeout
 const DingDingRobotTimeout = time.Second * 10
 
@@ -43,24 +47,25 @@ type DingDingRobotMessageResp struct {
 }
 
 type DingDingRobot struct {
-	client *http.Client
+	client  *http.Client
+	options chatapi.Options
 }
 
 // NewDingDingRobot create a new dingding robot
-func NewDingDingRobot() *DingDingRobot {
-	return NewDingDingRobotWithTimeout(DingDingRobotTimeout)
+func NewDingDingRobot(opts ...chatapi.Option) *DingDingRobot {
+	return NewDingDingRobotWithTimeout(DingDingRobotTimeout, opts...)
 }
 
 // NewDingDingRobotWithTimeout create a new dingding robot with timeout
-func NewDingDingRobotWithTimeout(timeout time.Duration) *DingDingRobot {
+func NewDingDingRobotWithTimeout(timeout time.Duration, opts ...chatapi.Option) *DingDingRobot {
 	client := http.Client{}
 	client.Timeout = timeout
-	return &DingDingRobot{client: &client}
+	return NewDingDingRobotWithClient(&client, opts...)
 }
 
 // NewDingDingRobotWithClient create a new dingding robot with http.Client
-func NewDingDingRobotWithClient(client *http.Client) *DingDingRobot {
-	return &DingDingRobot{client: client}
+func NewDingDingRobotWithClient(client *http.Client, opts ...chatapi.Option) *DingDingRobot {
+	return &DingDingRobot{client: client, options: chatapi.NewOptions(opts...)}
 }
 
 type DingDingRobotMentionAt struct {
@@ -108,10 +113,20 @@ type DingDingRobotFeedCardMessage struct {
 }
 
 func (r *DingDingRobot) SendTextMessage(securitySettings *DingDingSecuritySettings, content string) (err error) {
-	return r.SendTextMessageWithMention(securitySettings, content, nil, false)
+	return r.SendTextMessageCtx(context.Background(), securitySettings, content)
+}
+
+// SendTextMessageCtx is SendTextMessage with the context to control the request
+func (r *DingDingRobot) SendTextMessageCtx(ctx context.Context, securitySettings *DingDingSecuritySettings, content string) (err error) {
+	return r.SendTextMessageWithMentionCtx(ctx, securitySettings, content, nil, false)
 }
 
 func (r *DingDingRobot) SendTextMessageWithMention(securitySettings *DingDingSecuritySettings, content string, mentionedMobileList []string, atAll bool) (err error) {
+	return r.SendTextMessageWithMentionCtx(context.Background(), securitySettings, content, mentionedMobileList, atAll)
+}
+
+// SendTextMessageWithMentionCtx is SendTextMessageWithMention with the context to control the request
+func (r *DingDingRobot) SendTextMessageWithMentionCtx(ctx context.Context, securitySettings *DingDingSecuritySettings, content string, mentionedMobileList []string, atAll bool) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["msgtype"] = DingDingRobotMessageTypeText
 	messageObj["text"] = map[string]string{"content": content}
@@ -119,14 +134,25 @@ func (r *DingDingRobot) SendTextMessageWithMention(securitySettings *DingDingSec
 		AtMobiles: mentionedMobileList,
 		IsAtAll:   atAll,
 	}
-	return r.sendMessage(securitySettings, &messageObj)
+	return r.sendMessage(ctx, securitySettings, &messageObj)
 }
 
 func (r *DingDingRobot) SendMarkdownMessage(securitySettings *DingDingSecuritySettings, markdownMessage *DingDingRobotMarkdownMessage) (err error) {
-	return r.SendMarkdownMessageWithMention(securitySettings, markdownMessage, nil, false)
+	return r.SendMarkdownMessageCtx(context.Background(), securitySettings, markdownMessage)
+}
+
+// SendMarkdownMessageCtx is SendMarkdownMessage with the context to control the request
+func (r *DingDingRobot) SendMarkdownMessageCtx(ctx context.Context, securitySettings *DingDingSecuritySettings, markdownMessage *DingDingRobotMarkdownMessage) (err error) {
+	return r.SendMarkdownMessageWithMentionCtx(ctx, securitySettings, markdownMessage, nil, false)
 }
 
 func (r *DingDingRobot) SendMarkdownMessageWithMention(securitySettings *DingDingSecuritySettings, markdownMessage *DingDingRobotMarkdownMessage,
+	mentionedMobileList []string, atAll bool) (err error) {
+	return r.SendMarkdownMessageWithMentionCtx(context.Background(), securitySettings, markdownMessage, mentionedMobileList, atAll)
+}
+
+// SendMarkdownMessageWithMentionCtx is SendMarkdownMessageWithMention with the context to control the request
+func (r *DingDingRobot) SendMarkdownMessageWithMentionCtx(ctx context.Context, securitySettings *DingDingSecuritySettings, markdownMessage *DingDingRobotMarkdownMessage,
 	mentionedMobileList []string, atAll bool) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["msgtype"] = DingDingRobotMessageTypeMarkdown
@@ -135,33 +161,91 @@ func (r *DingDingRobot) SendMarkdownMessageWithMention(securitySettings *DingDin
 		AtMobiles: mentionedMobileList,
 		IsAtAll:   atAll,
 	}
-	return r.sendMessage(securitySettings, &messageObj)
+	return r.sendMessage(ctx, securitySettings, &messageObj)
 }
 
 func (r *DingDingRobot) SendLinkMessage(securitySettings *DingDingSecuritySettings, linkMessage *DingDingRobotLinkMessage) (err error) {
+	return r.SendLinkMessageCtx(context.Background(), securitySettings, linkMessage)
+}
+
+// SendLinkMessageCtx is SendLinkMessage with the context to control the request
+func (r *DingDingRobot) SendLinkMessageCtx(ctx context.Context, securitySettings *DingDingSecuritySettings, linkMessage *DingDingRobotLinkMessage) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["msgtype"] = DingDingRobotMessageTypeLink
 	messageObj["link"] = linkMessage
-	return r.sendMessage(securitySettings, messageObj)
+	return r.sendMessage(ctx, securitySettings, &messageObj)
 }
 
 func (r *DingDingRobot) SendActionCardMessage(securitySettings *DingDingSecuritySettings, actionCardMessage *DingDingRobotActionCardMessage) (err error) {
+	return r.SendActionCardMessageCtx(context.Background(), securitySettings, actionCardMessage)
+}
+
+// SendActionCardMessageCtx is SendActionCardMessage with the context to control the request
+func (r *DingDingRobot) SendActionCardMessageCtx(ctx context.Context, securitySettings *DingDingSecuritySettings, actionCardMessage *DingDingRobotActionCardMessage) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["msgtype"] = DingDingRobotMessageTypeActionCard
 	messageObj["actionCard"] = actionCardMessage
-	return r.sendMessage(securitySettings, messageObj)
+	return r.sendMessage(ctx, securitySettings, &messageObj)
 }
 
 func (r *DingDingRobot) SendFeedCardMessage(securitySettings *DingDingSecuritySettings, feedCardMessages []DingDingRobotFeedCardMessage) (err error) {
+	return r.SendFeedCardMessageCtx(context.Background(), securitySettings, feedCardMessages)
+}
+
+// SendFeedCardMessageCtx is SendFeedCardMessage with the context to control the request
+func (r *DingDingRobot) SendFeedCardMessageCtx(ctx context.Context, securitySettings *DingDingSecuritySettings, feedCardMessages []DingDingRobotFeedCardMessage) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["msgtype"] = DingDingRobotMessageTypeFeedCard
 	messageObj["feedCard"] = map[string]interface{}{
 		"links": feedCardMessages,
 	}
-	return r.sendMessage(securitySettings, messageObj)
+	return r.sendMessage(ctx, securitySettings, &messageObj)
+}
+
+// sendMessage send the message, or its parts in order when the long messages are split
+func (r *DingDingRobot) sendMessage(ctx context.Context, securitySettings *DingDingSecuritySettings, messageObj interface{}) (err error) {
+	var parts []interface{}
+	switch {
+	case r.options.SplitLongMessages:
+		parts = splitDingDingRobotMessage(r.options.RecipientPolicy, messageObj)
+	case r.options.TruncateLongMessages:
+		truncateDingDingRobotMessage(&r.options, messageObj)
+	}
+	if len(parts) == 0 {
+		return r.sendSingleMessage(ctx, securitySettings, messageObj)
+	}
+	for i, part := range parts {
+		if partErr := r.sendSingleMessage(ctx, securitySettings, part); partErr != nil {
+			err = fmt.Errorf("send part %d/%d error, %w", i+1, len(parts), partErr)
+			return
+		}
+	}
+	return
+}
+
+// sendSingleMessage post the message under the rate limit of the webhook and retry it by the retry policy, the message is only resent
+// when the platform rejected it without handling, e.g. by the rate limits
+func (r *DingDingRobot) sendSingleMessage(ctx context.Context, securitySettings *DingDingSecuritySettings, messageObj interface{}) (err error) {
+	if securitySettings, err = dingDingRobotSecuritySettings(r.options.RecipientPolicy, securitySettings); err != nil {
+		return
+	}
+	if r.options.RecipientPolicy != nil {
+		applyDingDingRobotBanner(r.options.RecipientPolicy, messageObj)
+	}
+	if !r.options.SkipValidation {
+		if err = validateDingDingRobotMessage(messageObj); err != nil {
+			return
+		}
+	}
+	return r.options.Retry.Do(ctx, false, func() error {
+		if err := r.options.RateLimiter.Wait(ctx, chatapi.ProviderDingTalk+":robot:"+securitySettings.AccessToken); err != nil {
+			return err
+		}
+		return r.postMessage(ctx, securitySettings, messageObj)
+	})
 }
 
-func (r *DingDingRobot) sendMessage(securitySettings *DingDingSecuritySettings, messageObj interface{}) (err error) {
+func (r *DingDingRobot) postMessage(ctx context.Context, securitySettings *DingDingSecuritySettings, messageObj interface{}) (err error) {
 	reqParams := url.Values{}
 	reqParams.Add("access_token", securitySettings.AccessToken)
 	if securitySettings.SecureToken != "" {
@@ -172,39 +256,24 @@ func (r *DingDingRobot) sendMessage(securitySettings *DingDingSecuritySettings,
 		reqParams.Add("timestamp", fmt.Sprintf("%d", tsNow))
 		reqParams.Add("sign", sign)
 	}
-	reqURL := fmt.Sprintf("%s?%s", DingDingRobotMessageAPI, reqParams.Encode())
+	reqURL := fmt.Sprintf("%s?%s", r.options.URL(DingDingRobotMessageAPI), reqParams.Encode())
 	reqBody, _ := json.Marshal(messageObj)
 
-	req, newErr := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(reqBody))
+	req, newErr := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(reqBody))
 	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
+		err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
 		return
 	}
 	req.Header.Add("Content-Type", "application/json")
-	resp, getErr := r.client.Do(req)
-	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
-		return
-	}
-	defer resp.Body.Close()
-	// check http code
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("dingtalk request error, %s", resp.Status)
-		io.Copy(ioutil.Discard, resp.Body)
-		return
-	}
-	// parse response body
-	decoder := json.NewDecoder(resp.Body)
 	var dingdingMessageResp DingDingRobotMessageResp
-	if decodeErr := decoder.Decode(&dingdingMessageResp); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
-		return
-	}
-	if dingdingMessageResp.ErrCode != DingDingRobotStatusOK {
-		err = fmt.Errorf("call dingtalk robot api error, %d %s", dingdingMessageResp.ErrCode, dingdingMessageResp.ErrMessage)
-		return
-	}
-	return
+	call := chatapi.Call{Provider: chatapi.ProviderDingTalk, Operation: "DingDingRobot.SendMessage", Endpoint: DingDingRobotMessageAPI,
+		Header: req.Header, Request: messageObj, Response: &dingdingMessageResp}
+	return chatapi.Intercept(ctx, r.options.Interceptors, &call, func(ctx context.Context, call *chatapi.Call) error {
+		if r.options.DryRun != nil {
+			return chatapi.DryRun(r.options.DryRun, call, req, dingDingDryRunResp)
+		}
+		return doDingDingRequest(r.client, req.WithContext(ctx), DingDingRobotMessageAPI, &dingdingMessageResp)
+	})
 }
 
 // HmacSha256 hash the data using algorithm hmac-sha1
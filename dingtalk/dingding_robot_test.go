@@ -1,12 +1,18 @@
 package dingtalk
 
 import (
-	"os"
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/duoland/chatapi"
+	"github.com/duoland/chatapi/chatapitest"
 )
 
-var accessToken = os.Getenv("DINGDING_ROBOT_ACCESS_TOKEN")
-var secretToken = os.Getenv("DINGDING_ROBOT_SECRET_TOKEN")
+var accessToken = "robot-access-token"
+var secretToken = "robot-secret-token"
 var securitySettings = DingDingSecuritySettings{
 	AccessToken: accessToken,
 	SecureToken: secretToken,
@@ -20,34 +26,56 @@ var markdownMessage = DingDingRobotMarkdownMessage{
 	Title: "hello, this is a markdown test message",
 }
 
+// newTestDingDingRobot create the robot on a fake dingtalk server closed with the test,
+// the server checks the sign of the messages by the secret token
+func newTestDingDingRobot(t *testing.T, opts ...chatapi.Option) (*DingDingRobot, *chatapitest.Server) {
+	server := chatapitest.NewDingTalkServer()
+	t.Cleanup(server.Close)
+	server.SetRobotSecret(accessToken, secretToken)
+	opts = append([]chatapi.Option{chatapi.WithBaseURL(server.URL)}, opts...)
+	return NewDingDingRobot(opts...), server
+}
+
 func TestDingDingRobot_SendTextMessage(t *testing.T) {
-	ddRobot := NewDingDingRobot()
+	ddRobot, server := newTestDingDingRobot(t)
 	if err := ddRobot.SendTextMessage(&securitySettings, "hello, master"); err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, DingDingRobotMessageAPI, map[string]interface{}{"msgtype": "text", "text.content": "hello, master"})
+
+	// the sign by a wrong secret is refused
+	err := ddRobot.SendTextMessage(&DingDingSecuritySettings{AccessToken: accessToken, SecureToken: "wrong"}, "hello, master")
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrCode == 0 {
+		t.Fatalf("expect the sign refused, got %v", err)
+	}
 }
 func TestDingDingRobot_SendTextMessageWithMention(t *testing.T) {
-	ddRobot := NewDingDingRobot()
+	ddRobot, server := newTestDingDingRobot(t)
 	if err := ddRobot.SendTextMessageWithMention(&securitySettings, "hello, master", []string{"17817213491"}, true); err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, DingDingRobotMessageAPI, map[string]interface{}{"at.atMobiles.0": "17817213491", "at.isAtAll": true})
 }
 
 func TestDingDingRobot_SendMarkdownMessage(t *testing.T) {
-	ddRobot := NewDingDingRobot()
+	ddRobot, server := newTestDingDingRobot(t)
 	if err := ddRobot.SendMarkdownMessage(&securitySettings, &markdownMessage); err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, DingDingRobotMessageAPI, map[string]interface{}{"msgtype": "markdown",
+		"markdown.title": markdownMessage.Title, "markdown.text": markdownMessage.Text})
 }
 func TestDingDingRobot_SendMarkdownMessageWithMention(t *testing.T) {
-	ddRobot := NewDingDingRobot()
+	ddRobot, server := newTestDingDingRobot(t)
 	if err := ddRobot.SendMarkdownMessageWithMention(&securitySettings, &markdownMessage, []string{"17817213491"}, false); err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, DingDingRobotMessageAPI, map[string]interface{}{"msgtype": "markdown", "at.atMobiles.0": "17817213491"})
 }
 
 func TestDingDingRobot_SendLinkMessage(t *testing.T) {
-	ddRobot := NewDingDingRobot()
+	ddRobot, server := newTestDingDingRobot(t)
 	linkMsg := DingDingRobotLinkMessage{
 		Text:       "bla bla bla, a abstract bla bla bla",
 		Title:      "i am a demo blog title",
@@ -57,10 +85,11 @@ func TestDingDingRobot_SendLinkMessage(t *testing.T) {
 	if err := ddRobot.SendLinkMessage(&securitySettings, &linkMsg); err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, DingDingRobotMessageAPI, map[string]interface{}{"msgtype": "link", "link.messageUrl": linkMsg.MessageURL})
 }
 
 func TestDingDingRobot_SendActionCardMessage(t *testing.T) {
-	ddRobot := NewDingDingRobot()
+	ddRobot, server := newTestDingDingRobot(t)
 	actionCardMsg := DingDingRobotActionCardMessage{
 		Title: "乔布斯 20 年前想打造一间苹果咖啡厅，而它正是 Apple Store 的前身",
 		Text: `![screenshot](https://gw.alicdn.com/tfs/TB1ut3xxbsrBKNjSZFpXXcXhFXa-846-786.png) 
@@ -73,10 +102,12 @@ Apple Store 的设计正从原来满满的科技感走向生活化，而其生
 	if err := ddRobot.SendActionCardMessage(&securitySettings, &actionCardMsg); err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, DingDingRobotMessageAPI, map[string]interface{}{"msgtype": "actionCard",
+		"actionCard.singleTitle": "Read More>>", "actionCard.singleURL": "http://www.oschina.net"})
 }
 
 func TestDingDingRobot_SendActionCardMessage2(t *testing.T) {
-	ddRobot := NewDingDingRobot()
+	ddRobot, server := newTestDingDingRobot(t)
 	actionCardMsg := DingDingRobotActionCardMessage{
 		Title: "乔布斯 20 年前想打造一间苹果咖啡厅，而它正是 Apple Store 的前身",
 		Text: `![screenshot](https://gw.alicdn.com/tfs/TB1ut3xxbsrBKNjSZFpXXcXhFXa-846-786.png) 
@@ -97,10 +128,11 @@ Apple Store 的设计正从原来满满的科技感走向生活化，而其生
 	if err := ddRobot.SendActionCardMessage(&securitySettings, &actionCardMsg); err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, DingDingRobotMessageAPI, map[string]interface{}{"actionCard.btns.1.title": "不感兴趣"})
 }
 
 func TestDingDingRobot_SendFeedCardMessage(t *testing.T) {
-	ddRobot := NewDingDingRobot()
+	ddRobot, server := newTestDingDingRobot(t)
 	feedCardMsgs := []DingDingRobotFeedCardMessage{
 		{
 			Title:      "时代的火车向前开",
@@ -116,4 +148,53 @@ func TestDingDingRobot_SendFeedCardMessage(t *testing.T) {
 	if err := ddRobot.SendFeedCardMessage(&securitySettings, feedCardMsgs); err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, DingDingRobotMessageAPI, map[string]interface{}{"msgtype": "feedCard", "feedCard.links.1.title": "时代的火车向前开2"})
+}
+
+func TestDingDingRobot_RetrySendTooFast(t *testing.T) {
+	policy := chatapi.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	ddRobot, server := newTestDingDingRobot(t, chatapi.WithRetryPolicy(policy))
+	sendTooFast := chatapitest.Fault{ErrCode: DingDingCodeRobotSendTooFast, ErrMessage: "send too fast"}
+	server.Fail(DingDingRobotMessageAPI, sendTooFast)
+
+	if err := ddRobot.SendTextMessage(&securitySettings, "hello, master"); err != nil || len(server.Requests()) != 2 {
+		t.Fatalf("expect the message resent once, got %d calls, %v", len(server.Requests()), err)
+	}
+
+	// the retry can be disabled per call
+	server.Reset()
+	server.Fail(DingDingRobotMessageAPI, sendTooFast)
+	err := ddRobot.SendTextMessageCtx(chatapi.WithoutRetry(context.Background()), &securitySettings, "hello, master")
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrCode != DingDingCodeRobotSendTooFast || len(server.Requests()) != 1 {
+		t.Fatalf("expect the send too fast error without retry, got %d calls, %v", len(server.Requests()), err)
+	}
+}
+
+func TestDingDingRobot_RedactError(t *testing.T) {
+	ddRobot := NewDingDingRobot(chatapi.WithBaseURL("http://127.0.0.1:1"), chatapi.WithRetryPolicy(chatapi.RetryPolicy{}))
+	settings := DingDingSecuritySettings{AccessToken: "live-access-token", SecureToken: "live-secure-token"}
+	err := ddRobot.SendTextMessage(&settings, "hello, master")
+	if err == nil {
+		t.Fatal("expect the connection error")
+	}
+	if strings.Contains(err.Error(), "live-access-token") || strings.Contains(err.Error(), "sign=") && !strings.Contains(err.Error(), "sign=REDACTED") {
+		t.Fatalf("expect the credentials redacted, got %s", err.Error())
+	}
+}
+
+func TestDingDingRobot_DryRun(t *testing.T) {
+	recorder := chatapi.NewDryRunRecorder()
+	robot, server := newTestDingDingRobot(t, chatapi.WithDryRun(recorder))
+	if err := robot.SendTextMessage(&securitySettings, "hello, master"); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect no request sent in the dry run, got %d", len(server.Requests()))
+	}
+	requests := recorder.Requests()
+	if len(requests) != 1 || !strings.Contains(requests[0].URL, "access_token=REDACTED") || !strings.Contains(requests[0].URL, "sign=REDACTED") ||
+		strings.Contains(requests[0].URL, accessToken) {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
 }
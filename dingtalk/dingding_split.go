@@ -0,0 +1,96 @@
+package dingtalk
+
+import (
+	"github.com/duoland/chatapi"
+)
+
+// splitDingDingContent returns the copies of the text or markdown message with the parts of the long content, nil if it fits.
+// The app messages and the robot messages share the layout of these two types.
+func splitDingDingContent(policy *chatapi.RecipientPolicy, messageMap map[string]interface{}) (parts []map[string]interface{}) {
+	msgType, _ := messageMap["msgtype"].(string)
+	var splitter chatapi.Splitter
+	var content string
+	var withContent func(content string) interface{}
+	switch msgType {
+	case DingDingAppMessageTypeText:
+		body, ok := messageMap[msgType].(map[string]string)
+		if !ok {
+			return
+		}
+		splitter, content = chatapi.Splitter{MaxBytes: DingDingTextMaxBytes}, body["content"]
+		withContent = func(content string) interface{} {
+			return map[string]string{"content": content}
+		}
+	case DingDingAppMessageTypeMarkdown:
+		splitter = chatapi.Splitter{MaxRunes: DingDingMarkdownMaxRunes, Markdown: true}
+		switch body := messageMap[msgType].(type) {
+		case map[string]string:
+			content = body["text"]
+			withContent = func(content string) interface{} {
+				return map[string]string{"title": body["title"], "text": content}
+			}
+		case *DingDingRobotMarkdownMessage:
+			// the markdown message belongs to the caller, so every part gets a new one
+			content = body.Text
+			withContent = func(content string) interface{} {
+				return &DingDingRobotMarkdownMessage{Title: body.Title, Text: content}
+			}
+		default:
+			return
+		}
+	default:
+		return
+	}
+	if policy != nil {
+		splitter = splitter.Reserve(policy.WithBanner(""))
+	}
+	contents := splitter.Split(content)
+	if len(contents) < 2 {
+		return
+	}
+	for _, content := range contents {
+		partMap := make(map[string]interface{}, len(messageMap))
+		for key, value := range messageMap {
+			partMap[key] = value
+		}
+		partMap[msgType] = withContent(content)
+		parts = append(parts, partMap)
+	}
+	return
+}
+
+// splitDingDingAppMessage returns the messages of the parts of the long text and markdown app message, nil if it fits
+func splitDingDingAppMessage(policy *chatapi.RecipientPolicy, messageObj interface{}) (parts []interface{}) {
+	message, ok := messageObj.(*map[string]interface{})
+	if !ok {
+		return
+	}
+	msg, _ := (*message)["msg"].(map[string]interface{})
+	for _, partMsg := range splitDingDingContent(policy, msg) {
+		partMessage := make(map[string]interface{}, len(*message))
+		for key, value := range *message {
+			partMessage[key] = value
+		}
+		partMessage["msg"] = partMsg
+		parts = append(parts, &partMessage)
+	}
+	return
+}
+
+// splitDingDingRobotMessage returns the messages of the parts of the long text and markdown robot message, nil if it fits.
+// The mentions are kept in the first part only.
+func splitDingDingRobotMessage(policy *chatapi.RecipientPolicy, messageObj interface{}) (parts []interface{}) {
+	message, ok := messageObj.(*map[string]interface{})
+	if !ok {
+		return
+	}
+	for i, partMap := range splitDingDingContent(policy, *message) {
+		if i > 0 {
+			delete(partMap, "at")
+		}
+		// the parts are sent as the pointers like the other robot messages
+		part := partMap
+		parts = append(parts, &part)
+	}
+	return
+}
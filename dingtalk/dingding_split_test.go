@@ -0,0 +1,72 @@
+package dingtalk
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestDingDingApp_SplitLongMessages(t *testing.T) {
+	content := strings.Repeat("部署完成，服务运行正常。\n\n", 500)
+	app, server := newTestDingDingApp(t)
+	if _, err := app.SendMarkdownMessage(userIDList, nil, false, "deploy", content); !errors.Is(err, chatapi.ErrInvalidMessage) {
+		t.Fatalf("expect the long message rejected without the split, got %v", err)
+	}
+
+	app, server = newTestDingDingApp(t, chatapi.WithSplit())
+	resp, err := app.SendMarkdownMessage(userIDList, nil, false, "deploy", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := server.RequestsTo(DingDingAppSendMessageAPI)
+	if len(requests) != 2 || len(resp.TaskIDs) != 2 || resp.TaskID != resp.TaskIDs[1] {
+		t.Fatalf("expect the message sent in 2 parts with the task ids, got %d, %+v", len(requests), resp)
+	}
+	for i, req := range requests {
+		body := req.JSON()
+		markdown, _ := body["msg"].(map[string]interface{})["markdown"].(map[string]interface{})
+		text, _ := markdown["text"].(string)
+		if body["userid_list"] != userIDList[0] || markdown["title"] != "deploy" || utf8.RuneCountInString(text) > DingDingMarkdownMaxRunes {
+			t.Fatalf("expect every part sent to the same users within the limit, got %s", req.Body)
+		}
+		if i == 1 && !strings.HasSuffix(text, "\n(2/2)") {
+			t.Fatalf("unexpected last part %q", text)
+		}
+	}
+
+	groupResp, err := app.SendGroupMarkdownMessage(chatID, "deploy", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.RequestsTo(DingDingAppSendGroupMessageAPI)) != 2 || len(groupResp.MessageIDs) != 2 || groupResp.MessageIDs[0] == groupResp.MessageIDs[1] {
+		t.Fatalf("expect the group message sent in 2 parts with the message ids, got %+v", groupResp)
+	}
+}
+
+func TestDingDingRobot_SplitLongMessages(t *testing.T) {
+	ddRobot, server := newTestDingDingRobot(t, chatapi.WithSplit())
+	longMarkdown := DingDingRobotMarkdownMessage{Title: "logs", Text: "```\n" + strings.Repeat("日志\n", 2000) + "```"}
+	if err := ddRobot.SendMarkdownMessageWithMention(&securitySettings, &longMarkdown, []string{"13800000000"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(longMarkdown.Text, "```\n日志") || strings.Contains(longMarkdown.Text, "(1/") {
+		t.Fatal("expect the markdown message of the caller kept")
+	}
+	requests := server.RequestsTo(DingDingRobotMessageAPI)
+	if len(requests) != 2 {
+		t.Fatalf("expect the message sent in 2 parts, got %d", len(requests))
+	}
+	for i, req := range requests {
+		body := req.JSON()
+		text, _ := body["markdown"].(map[string]interface{})["text"].(string)
+		if req.Query.Get("access_token") != accessToken || strings.Count(text, "```") != 2 {
+			t.Fatalf("expect every part sent to the same webhook with the code fence closed, got %s", req.Body)
+		}
+		if _, mentioned := body["at"]; mentioned != (i == 0) {
+			t.Fatalf("expect the mentions in the first part only, got %s", req.Body)
+		}
+	}
+}
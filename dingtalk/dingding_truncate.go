@@ -0,0 +1,70 @@
+package dingtalk
+
+import (
+	"github.com/duoland/chatapi"
+)
+
+// DingDingTruncator returns the truncator of the content limit of the message type, the app messages and the robot
+// messages share the limits, false for the types without a content limit
+func DingDingTruncator(messageType, moreURL string) (truncator chatapi.Truncator, ok bool) {
+	switch messageType {
+	case DingDingAppMessageTypeText:
+		return chatapi.Truncator{MaxBytes: DingDingTextMaxBytes, MoreURL: moreURL}, true
+	case DingDingAppMessageTypeMarkdown:
+		return chatapi.Truncator{MaxRunes: DingDingMarkdownMaxRunes, Markdown: true, MoreURL: moreURL}, true
+	case DingDingAppMessageTypeActionCard, DingDingRobotMessageTypeActionCard:
+		return chatapi.Truncator{MaxRunes: DingDingActionCardMaxRunes, Markdown: true, MoreURL: moreURL}, true
+	}
+	return
+}
+
+// TruncateDingDingContent cut the content of the message to the limit of the message type,
+// the content of the types without a content limit is returned as is
+func TruncateDingDingContent(messageType, content, moreURL string) string {
+	if truncator, ok := DingDingTruncator(messageType, moreURL); ok {
+		return truncator.Truncate(content)
+	}
+	return content
+}
+
+// truncateDingDingContent cut the content of the text and markdown message to the limit, leaving room for the banner
+// of the policy. The app messages and the robot messages share the layout of these two types.
+func truncateDingDingContent(options *chatapi.Options, messageMap map[string]interface{}) {
+	msgType, _ := messageMap["msgtype"].(string)
+	if msgType != DingDingAppMessageTypeText && msgType != DingDingAppMessageTypeMarkdown {
+		return
+	}
+	truncator, _ := DingDingTruncator(msgType, options.TruncateMoreURL)
+	if options.RecipientPolicy != nil {
+		truncator = truncator.Reserve(options.RecipientPolicy.WithBanner(""))
+	}
+	switch body := messageMap[msgType].(type) {
+	case map[string]string:
+		if msgType == DingDingAppMessageTypeText {
+			body["content"] = truncator.Truncate(body["content"])
+		} else {
+			body["text"] = truncator.Truncate(body["text"])
+		}
+	case *DingDingRobotMarkdownMessage:
+		// the markdown message belongs to the caller, so the truncated text goes to a copy
+		markdownMessage := *body
+		markdownMessage.Text = truncator.Truncate(markdownMessage.Text)
+		messageMap[msgType] = &markdownMessage
+	}
+}
+
+// truncateDingDingAppMessage cut the content of the text and markdown app message to the limit
+func truncateDingDingAppMessage(options *chatapi.Options, messageObj interface{}) {
+	if message, ok := messageObj.(*map[string]interface{}); ok {
+		if msg, ok := (*message)["msg"].(map[string]interface{}); ok {
+			truncateDingDingContent(options, msg)
+		}
+	}
+}
+
+// truncateDingDingRobotMessage cut the content of the text and markdown robot message to the limit
+func truncateDingDingRobotMessage(options *chatapi.Options, messageObj interface{}) {
+	if message, ok := messageObj.(*map[string]interface{}); ok {
+		truncateDingDingContent(options, *message)
+	}
+}
@@ -0,0 +1,40 @@
+package dingtalk
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestTruncateDingDingContent(t *testing.T) {
+	content := strings.Repeat("告警", DingDingMarkdownMaxRunes)
+	truncated := TruncateDingDingContent(DingDingRobotMessageTypeMarkdown, content, "")
+	if utf8.RuneCountInString(truncated) > DingDingMarkdownMaxRunes || !strings.HasSuffix(truncated, chatapi.TruncateEllipsis) {
+		t.Fatalf("expect the markdown cut within %d characters, got %d", DingDingMarkdownMaxRunes, utf8.RuneCountInString(truncated))
+	}
+	if truncated = TruncateDingDingContent(DingDingRobotMessageTypeText, content, ""); len(truncated) > DingDingTextMaxBytes {
+		t.Fatalf("expect the text cut to %d bytes, got %d", DingDingTextMaxBytes, len(truncated))
+	}
+}
+
+func TestDingDingRobot_TruncateLongMessages(t *testing.T) {
+	ddRobot, server := newTestDingDingRobot(t, chatapi.WithTruncation(""))
+	longMarkdown := DingDingRobotMarkdownMessage{Title: "report", Text: strings.Repeat("**部署**完成 ", 1000)}
+	text := longMarkdown.Text
+	if err := ddRobot.SendMarkdownMessage(&securitySettings, &longMarkdown); err != nil {
+		t.Fatal(err)
+	}
+	if longMarkdown.Text != text {
+		t.Fatal("expect the markdown message of the caller kept")
+	}
+	req, ok := server.LastRequest(DingDingRobotMessageAPI)
+	if !ok {
+		t.Fatal("expect the message sent")
+	}
+	truncated, _ := req.JSON()["markdown"].(map[string]interface{})["text"].(string)
+	if utf8.RuneCountInString(truncated) > DingDingMarkdownMaxRunes || strings.Count(truncated, "**")%2 != 0 {
+		t.Fatalf("expect the truncated markdown within the limit with the bold closed, got %q", truncated[len(truncated)-40:])
+	}
+}
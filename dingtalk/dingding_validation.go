@@ -0,0 +1,104 @@
+package dingtalk
+
+import (
+	"github.com/duoland/chatapi"
+)
+
+// The limits of the dingding messages, see doc https://ding-doc.dingtalk.com/doc#/serverapi2/pgoxpy
+const (
+	DingDingTextMaxBytes       = 20000
+	DingDingMarkdownMaxRunes   = 5000
+	DingDingActionCardMaxRunes = 5000
+)
+
+// dingDingAppContentRules is the rules of the msg of the app messages to the users and to the group chats
+var dingDingAppContentRules = map[string][]chatapi.FieldRule{
+	DingDingAppMessageTypeText: {
+		{Field: "msg.text.content", Required: true, MaxBytes: DingDingTextMaxBytes},
+	},
+	DingDingAppMessageTypeMarkdown: {
+		{Field: "msg.markdown.title", Required: true},
+		{Field: "msg.markdown.text", Required: true, MaxRunes: DingDingMarkdownMaxRunes},
+	},
+	DingDingAppMessageTypeImage: {{Field: "msg.image.media_id", Required: true}},
+	DingDingAppMessageTypeVoice: {{Field: "msg.voice.media_id", Required: true}},
+	DingDingAppMessageTypeFile:  {{Field: "msg.file.media_id", Required: true}},
+	DingDingAppMessageTypeLink: {
+		{Field: "msg.link.title", Required: true},
+		{Field: "msg.link.text", Required: true},
+		{Field: "msg.link.messageUrl", Required: true, URL: true},
+		{Field: "msg.link.picUrl", Required: true},
+	},
+	DingDingAppMessageTypeActionCard: {
+		{Field: "msg.action_card.title", Required: true},
+		{Field: "msg.action_card.markdown", Required: true, MaxRunes: DingDingActionCardMaxRunes},
+		{Field: "msg.action_card.single_url", Required: true, Alternatives: []string{"msg.action_card.btn_json_list"}, URL: true},
+		{Field: "msg.action_card.btn_json_list.*.title", Required: true},
+		{Field: "msg.action_card.btn_json_list.*.action_url", Required: true, URL: true},
+	},
+}
+
+// dingDingAppRecipientRules is the rules of the recipients of the app messages to the users
+var dingDingAppRecipientRules = []chatapi.FieldRule{
+	{Field: "userid_list", Required: true, Alternatives: []string{"dept_id_list", "to_all_user"}},
+}
+
+// dingDingGroupRules is the rules of the chat of the app group messages
+var dingDingGroupRules = []chatapi.FieldRule{
+	{Field: "chatid", Required: true},
+}
+
+// dingDingRobotRules is the rules of the robot messages
+var dingDingRobotRules = map[string][]chatapi.FieldRule{
+	DingDingRobotMessageTypeText: {
+		{Field: "text.content", Required: true, MaxBytes: DingDingTextMaxBytes},
+	},
+	DingDingRobotMessageTypeMarkdown: {
+		{Field: "markdown.title", Required: true},
+		{Field: "markdown.text", Required: true, MaxRunes: DingDingMarkdownMaxRunes},
+	},
+	DingDingRobotMessageTypeLink: {
+		{Field: "link.title", Required: true},
+		{Field: "link.text", Required: true},
+		{Field: "link.messageUrl", Required: true, URL: true},
+		{Field: "link.picUrl", URL: true},
+	},
+	DingDingRobotMessageTypeActionCard: {
+		{Field: "actionCard.title", Required: true},
+		{Field: "actionCard.text", Required: true, MaxRunes: DingDingActionCardMaxRunes},
+		{Field: "actionCard.singleURL", Required: true, Alternatives: []string{"actionCard.btns"}, URL: true},
+		{Field: "actionCard.btns.*.title", Required: true},
+		{Field: "actionCard.btns.*.actionURL", Required: true, URL: true},
+	},
+	DingDingRobotMessageTypeFeedCard: {
+		{Field: "feedCard.links", Required: true},
+		{Field: "feedCard.links.*.title", Required: true},
+		{Field: "feedCard.links.*.messageURL", Required: true, URL: true},
+		{Field: "feedCard.links.*.picURL", Required: true, URL: true},
+	},
+}
+
+// validateDingDingAppMessage check the app message to the users or to the group chat against the limits of dingding
+func validateDingDingAppMessage(messageObj interface{}, groupMessage bool) error {
+	message, ok := messageObj.(*map[string]interface{})
+	if !ok {
+		return nil
+	}
+	msg, _ := (*message)["msg"].(map[string]interface{})
+	msgType, _ := msg["msgtype"].(string)
+	rules := dingDingAppRecipientRules
+	if groupMessage {
+		rules = dingDingGroupRules
+	}
+	rules = append(append([]chatapi.FieldRule(nil), rules...), dingDingAppContentRules[msgType]...)
+	return chatapi.ValidateMessage(chatapi.ProviderDingTalk, msgType, messageObj, rules)
+}
+
+// validateDingDingRobotMessage check the robot message against the limits of dingding
+func validateDingDingRobotMessage(messageObj interface{}) error {
+	var msgType string
+	if message, ok := messageObj.(*map[string]interface{}); ok {
+		msgType, _ = (*message)["msgtype"].(string)
+	}
+	return chatapi.ValidateMessage(chatapi.ProviderDingTalk, msgType, messageObj, dingDingRobotRules[msgType])
+}
@@ -0,0 +1,55 @@
+package dingtalk
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+// expectFieldError check the err is the validation error of the field and the rule
+func expectFieldError(t *testing.T, err error, field, rule string) {
+	t.Helper()
+	var validationErr *chatapi.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expect the validation error, got %v", err)
+	}
+	for _, fieldErr := range validationErr.Fields {
+		if fieldErr.Field == field && fieldErr.Rule == rule {
+			return
+		}
+	}
+	t.Fatalf("expect %s breaks %s, got %v", field, rule, validationErr)
+}
+
+func TestDingDingApp_Validation(t *testing.T) {
+	app, server := newTestDingDingApp(t)
+	_, err := app.SendMarkdownMessage(userIDList, nil, false, "hello", strings.Repeat("钉", DingDingMarkdownMaxRunes+1))
+	expectFieldError(t, err, "msg.markdown.text", chatapi.RuleMaxRunes)
+	_, err = app.SendTextMessage(nil, nil, false, "hello, master")
+	expectFieldError(t, err, "userid_list", chatapi.RuleRequired)
+	_, err = app.SendGroupLinkMessage(chatID, &DingDingAppLinkMessage{Title: "hello", Text: "bla", MessageURL: "www.oschina.net", PicURL: "@lADOADmaWMzazQKA"})
+	expectFieldError(t, err, "msg.link.messageUrl", chatapi.RuleURL)
+	_, err = app.SendActionCardMessage(userIDList, nil, false, &DingDingAppActionCardMessage{Title: "hello", Markdown: "# hello"})
+	expectFieldError(t, err, "msg.action_card.single_url", chatapi.RuleRequired)
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect the invalid messages not sent, got %d requests", len(server.Requests()))
+	}
+	if _, err = app.SendTextMessage(nil, nil, true, "hello, everyone"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDingDingRobot_Validation(t *testing.T) {
+	robot, server := newTestDingDingRobot(t)
+	err := robot.SendFeedCardMessage(&securitySettings, []DingDingRobotFeedCardMessage{{Title: "hello", MessageURL: "http://www.oschina.net"}})
+	expectFieldError(t, err, "feedCard.links.0.picURL", chatapi.RuleRequired)
+	err = robot.SendActionCardMessage(&securitySettings, &DingDingRobotActionCardMessage{Title: "hello", Text: "# hello",
+		Buttons: []DingDingRobotActionCardButton{{Title: "ok", ActionURL: "javascript:alert(1)"}}})
+	expectFieldError(t, err, "actionCard.btns.0.actionURL", chatapi.RuleURL)
+	expectFieldError(t, robot.SendTextMessage(&securitySettings, ""), "text.content", chatapi.RuleRequired)
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect the invalid messages not sent, got %d requests", len(server.Requests()))
+	}
+}
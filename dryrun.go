@@ -0,0 +1,116 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DryRunAccessToken is the placeholder access token of the requests built in the dry run, it is redacted in the captured url
+const DryRunAccessToken = "DRY_RUN_ACCESS_TOKEN"
+
+// DryRunRequest is a request built by a client in the dry run, captured instead of being sent
+type DryRunRequest struct {
+	Provider  string      // the platform, see the Provider constants
+	Operation string      // the logical operation, e.g. WxWorkApp.SendMessage
+	Endpoint  string      // the api url without the query string
+	Method    string      // the http method
+	URL       string      // the request url with the credentials redacted
+	Header    http.Header // the request header with the authorization redacted
+	Body      []byte      // the json body, nil for the file uploads and the requests without body
+	Upload    *FileUpload // the multipart metadata of the file uploads, nil for the others
+}
+
+// DryRunSink receives the requests built in the dry run
+type DryRunSink interface {
+	Capture(req DryRunRequest)
+}
+
+// DryRunRecorder is a DryRunSink keeping the captured requests in memory
+type DryRunRecorder struct {
+	lock     sync.Mutex
+	requests []DryRunRequest
+}
+
+// NewDryRunRecorder create a new dry run recorder
+func NewDryRunRecorder() *DryRunRecorder {
+	return &DryRunRecorder{}
+}
+
+// Capture append the request to the recorder
+func (r *DryRunRecorder) Capture(req DryRunRequest) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.requests = append(r.requests, req)
+}
+
+// Requests returns the captured requests in order
+func (r *DryRunRecorder) Requests() []DryRunRequest {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]DryRunRequest(nil), r.requests...)
+}
+
+// Reset drop the captured requests
+func (r *DryRunRecorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.requests = nil
+}
+
+// WithDryRun build the requests without sending them, each request is captured by the sink and answered by a synthetic
+// success. No access token is fetched, the apps build the requests with the DryRunAccessToken placeholder instead.
+func WithDryRun(sink DryRunSink) Option {
+	return func(o *Options) {
+		o.DryRun = sink
+	}
+}
+
+// DryRunTokenFetcher is the token fetcher of the apps in the dry run, it returns the placeholder token without any request
+func DryRunTokenFetcher(ctx context.Context) (accessToken string, expiresIn time.Duration, err error) {
+	accessToken = DryRunAccessToken
+	expiresIn = time.Hour * 24
+	return
+}
+
+// DryRun capture the request of the call into the sink and decode the synthetic success response into call.Response,
+// the clients invoke it in place of sending the request when the dry run is on
+func DryRun(sink DryRunSink, call *Call, req *http.Request, successResp string) (err error) {
+	captured := DryRunRequest{Provider: call.Provider, Operation: call.Operation, Endpoint: call.Endpoint,
+		Method: req.Method, URL: RedactURL(req.URL.String()), Header: redactHeader(req.Header)}
+	if upload, ok := call.Request.(*FileUpload); ok {
+		uploadCopy := *upload
+		captured.Upload = &uploadCopy
+	} else if req.Body != nil {
+		if captured.Body, err = ioutil.ReadAll(req.Body); err != nil {
+			err = fmt.Errorf("read request body error, %w", err)
+			return
+		}
+	}
+	sink.Capture(captured)
+	if call.Response != nil {
+		if err = json.Unmarshal([]byte(successResp), call.Response); err != nil {
+			err = fmt.Errorf("parse response error, %w", err)
+			return
+		}
+	}
+	return
+}
+
+// redactHeader returns a copy of the header with the credential of the Authorization replaced, the scheme is kept
+func redactHeader(header http.Header) http.Header {
+	header = header.Clone()
+	if authorization := header.Get("Authorization"); authorization != "" {
+		if i := strings.Index(authorization, " "); i >= 0 {
+			header.Set("Authorization", authorization[:i+1]+redactedValue)
+		} else {
+			header.Set("Authorization", redactedValue)
+		}
+	}
+	return header
+}
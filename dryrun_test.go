@@ -0,0 +1,68 @@
+package chatapi
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestDryRun_CaptureRequest(t *testing.T) {
+	recorder := NewDryRunRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token="+DryRunAccessToken,
+		bytes.NewReader([]byte(`{"msgtype":"text"}`)))
+	req.Header.Set("Authorization", "Bearer "+DryRunAccessToken)
+	var resp struct {
+		ErrCode    int    `json:"errcode"`
+		ErrMessage string `json:"errmsg"`
+	}
+	call := Call{Provider: ProviderWxWork, Operation: "WxWorkApp.SendMessage", Endpoint: "https://qyapi.weixin.qq.com/cgi-bin/message/send",
+		Request: map[string]string{"msgtype": "text"}, Response: &resp}
+	if err := DryRun(recorder, &call, req, `{"errcode":0,"errmsg":"ok"}`); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ErrMessage != "ok" {
+		t.Fatalf("expect the synthetic success decoded, got %+v", resp)
+	}
+	requests := recorder.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expect 1 request captured, got %d", len(requests))
+	}
+	captured := requests[0]
+	if captured.Method != http.MethodPost || captured.URL != "https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=REDACTED" ||
+		string(captured.Body) != `{"msgtype":"text"}` || captured.Upload != nil || captured.Operation != "WxWorkApp.SendMessage" {
+		t.Fatalf("unexpected capture %+v", captured)
+	}
+	if captured.Header.Get("Authorization") != "Bearer REDACTED" || req.Header.Get("Authorization") != "Bearer "+DryRunAccessToken {
+		t.Fatalf("expect the captured authorization redacted only, got %q", captured.Header.Get("Authorization"))
+	}
+
+	recorder.Reset()
+	if len(recorder.Requests()) != 0 {
+		t.Fatal("expect the recorder reset")
+	}
+}
+
+func TestDryRun_CaptureUpload(t *testing.T) {
+	recorder := NewDryRunRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "https://qyapi.weixin.qq.com/cgi-bin/webhook/upload_media?key=robot&type=file",
+		bytes.NewReader([]byte("--boundary")))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+	upload := FileUpload{FieldName: "media", FileName: "hello.txt", Size: 13}
+	if err := DryRun(recorder, &Call{Provider: ProviderWxWork, Request: &upload}, req, `{}`); err != nil {
+		t.Fatal(err)
+	}
+	captured := recorder.Requests()[0]
+	if captured.Upload == nil || *captured.Upload != upload || captured.Body != nil ||
+		captured.URL != "https://qyapi.weixin.qq.com/cgi-bin/webhook/upload_media?key=REDACTED&type=file" ||
+		captured.Header.Get("Content-Type") != "multipart/form-data; boundary=boundary" {
+		t.Fatalf("unexpected capture %+v", captured)
+	}
+}
+
+func TestDryRunTokenFetcher(t *testing.T) {
+	manager := NewTokenManager(DryRunTokenFetcher)
+	if token, err := manager.Token(context.Background()); err != nil || token != DryRunAccessToken {
+		t.Fatalf("expect the placeholder token, got %q %v", token, err)
+	}
+}
@@ -0,0 +1,87 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	ProviderWxWork   = "wxwork"
+	ProviderDingTalk = "dingtalk"
+	ProviderFeiShu   = "feishu"
+)
+
+// APIError is the error returned when a chat platform api call fails,
+// use errors.As to inspect it and errors.Is to match the underlying cause.
+type APIError struct {
+	Provider   string        // the platform, see the Provider constants
+	Endpoint   string        // the api url without the query string
+	HTTPStatus int           // the http status code, 0 if no response received
+	ErrCode    int           // the errcode returned by the platform
+	ErrMessage string        // the errmsg returned by the platform
+	Retryable  bool          // whether the same request may succeed if sent again
+	Replayed   bool          // whether the request was replayed after refreshing the rejected access token
+	Attempts   int           // the attempts made by the retry policy, 0 if the call is not retried
+	RetryAfter time.Duration // the delay asked by the Retry-After header, 0 if absent
+	Err        error         // the transport or decode error if any
+}
+
+func (e *APIError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("call %s api %s error, %s", e.Provider, e.Endpoint, e.Err.Error())
+	case e.ErrCode == 0 && e.HTTPStatus != http.StatusOK:
+		return fmt.Sprintf("call %s api %s error, http status %d", e.Provider, e.Endpoint, e.HTTPStatus)
+	default:
+		return fmt.Sprintf("call %s api %s error, %d %s", e.Provider, e.Endpoint, e.ErrCode, e.ErrMessage)
+	}
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// NewRequestError create the error of a request failed before the platform errcode is known,
+// the cause is the transport or decode error, and nil for an unexpected http status.
+// The credentials in the url of the cause are redacted.
+func NewRequestError(provider, endpoint string, httpStatus int, cause error) *APIError {
+	apiErr := APIError{Provider: provider, Endpoint: endpoint, HTTPStatus: httpStatus, Err: RedactError(cause)}
+	if cause == nil {
+		apiErr.Retryable = httpStatus == http.StatusTooManyRequests || httpStatus >= http.StatusInternalServerError
+	} else if httpStatus == 0 {
+		// the transport errors are retryable unless the caller gives up
+		apiErr.Retryable = !errors.Is(cause, context.Canceled) && !errors.Is(cause, context.DeadlineExceeded)
+	}
+	return &apiErr
+}
+
+// NewStatusError create the error of a response with an unexpected http status, the Retry-After header is kept
+func NewStatusError(provider, endpoint string, resp *http.Response) *APIError {
+	apiErr := NewRequestError(provider, endpoint, resp.StatusCode, nil)
+	apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	return apiErr
+}
+
+// parseRetryAfter parse the Retry-After header in seconds or http date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Second * time.Duration(seconds)
+	}
+	if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// IsRetryable report whether the err is an APIError which is safe to retry
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Retryable
+}
@@ -0,0 +1,55 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestNewRequestError(t *testing.T) {
+	cases := []struct {
+		httpStatus int
+		cause      error
+		retryable  bool
+	}{
+		{0, fmt.Errorf("get response error, %w", errors.New("connection reset by peer")), true},
+		{0, fmt.Errorf("get response error, %w", context.DeadlineExceeded), false},
+		{http.StatusBadGateway, nil, true},
+		{http.StatusTooManyRequests, nil, true},
+		{http.StatusNotFound, nil, false},
+		{http.StatusOK, fmt.Errorf("parse response error, %w", errors.New("invalid character")), false},
+	}
+	for _, c := range cases {
+		err := error(NewRequestError(ProviderWxWork, "https://qyapi.weixin.qq.com/cgi-bin/message/send", c.httpStatus, c.cause))
+		if IsRetryable(err) != c.retryable {
+			t.Fatalf("expect retryable %v for %v", c.retryable, err)
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.HTTPStatus != c.httpStatus {
+			t.Fatalf("unexpected error %#v", apiErr)
+		}
+	}
+}
+
+func TestAPIError_Unwrap(t *testing.T) {
+	err := fmt.Errorf("refresh access token error, %w",
+		NewRequestError(ProviderDingTalk, "https://oapi.dingtalk.com/gettoken", 0, fmt.Errorf("get response error, %w", context.DeadlineExceeded)))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	expected := "refresh access token error, call dingtalk api https://oapi.dingtalk.com/gettoken error, get response error, context deadline exceeded"
+	if err.Error() != expected {
+		t.Fatalf("unexpected error message %s", err.Error())
+	}
+}
+
+func TestAPIError_Error(t *testing.T) {
+	err := &APIError{Provider: ProviderFeiShu, Endpoint: "https://open.feishu.cn/open-apis/message/v4/send/", HTTPStatus: http.StatusOK,
+		ErrCode: 99991400, ErrMessage: "request trigger frequency limit"}
+	expected := "call feishu api https://open.feishu.cn/open-apis/message/v4/send/ error, 99991400 request trigger frequency limit"
+	if err.Error() != expected {
+		t.Fatalf("unexpected error message %s", err.Error())
+	}
+}
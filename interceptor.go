@@ -0,0 +1,63 @@
+package chatapi
+
+import (
+	"context"
+	"net/http"
+)
+
+// Call is one platform api request seen by the interceptors
+type Call struct {
+	Provider  string      // the platform, see the Provider constants
+	Operation string      // the logical operation, e.g. WxWorkApp.SendMessage or DingDingRobot.SendMessage
+	Endpoint  string      // the api url without the query string
+	Header    http.Header // the request header, the interceptors may add headers like the signatures
+	Request   interface{} // the request payload, *FileUpload for the file uploads, nil for none
+	Response  interface{} // the response payload, decoded when the invoker returns
+
+	TokenRefresh bool // whether the call fetches a new app access token
+	Replayed     bool // whether the call replays the request rejected for the access token, with the refreshed token
+}
+
+type replayKey struct{}
+
+// WithReplay mark the calls made with the returned context as the replays after refreshing the rejected access token
+func WithReplay(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replayKey{}, true)
+}
+
+// IsReplay report whether the calls made with the context are the replays, see WithReplay
+func IsReplay(ctx context.Context) bool {
+	replayed, _ := ctx.Value(replayKey{}).(bool)
+	return replayed
+}
+
+// FileUpload is the request payload of the file uploads
+type FileUpload struct {
+	FieldName string
+	FileName  string
+	Size      int
+}
+
+// Invoker sends the call to the platform and decodes the response
+type Invoker func(ctx context.Context, call *Call) error
+
+// Interceptor wraps the invocation of each call, it may inspect or change the call, skip the
+// invoker to inject a fault, and inspect the response and error after the invoker returns
+type Interceptor func(ctx context.Context, call *Call, invoker Invoker) error
+
+// WithInterceptors add the interceptors to the client, the first one is the outermost
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(o *Options) {
+		o.Interceptors = append(o.Interceptors, interceptors...)
+	}
+}
+
+// Intercept run the call through the interceptors in order and then the invoker
+func Intercept(ctx context.Context, interceptors []Interceptor, call *Call, invoker Invoker) error {
+	if len(interceptors) == 0 {
+		return invoker(ctx, call)
+	}
+	return interceptors[0](ctx, call, func(ctx context.Context, call *Call) error {
+		return Intercept(ctx, interceptors[1:], call, invoker)
+	})
+}
@@ -0,0 +1,46 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestIntercept(t *testing.T) {
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, call *Call, invoker Invoker) error {
+			order = append(order, name+" before")
+			err := invoker(ctx, call)
+			order = append(order, name+" after")
+			return err
+		}
+	}
+	options := NewOptions(WithInterceptors(trace("outer")), WithInterceptors(trace("inner")))
+	call := Call{Provider: ProviderWxWork, Operation: "WxWorkApp.SendMessage"}
+	err := Intercept(context.Background(), options.Interceptors, &call, func(ctx context.Context, call *Call) error {
+		order = append(order, "invoke")
+		return nil
+	})
+	expect := []string{"outer before", "inner before", "invoke", "inner after", "outer after"}
+	if err != nil || len(order) != len(expect) {
+		t.Fatalf("unexpected order %v, %v", order, err)
+	}
+	for i := range expect {
+		if order[i] != expect[i] {
+			t.Fatalf("expect %v, got %v", expect, order)
+		}
+	}
+
+	// the interceptor can skip the invoker to inject a fault
+	fault := errors.New("injected fault")
+	err = Intercept(context.Background(), []Interceptor{func(ctx context.Context, call *Call, invoker Invoker) error {
+		return fault
+	}}, &call, func(ctx context.Context, call *Call) error {
+		t.Fatal("expect the invoker skipped")
+		return nil
+	})
+	if err != fault {
+		t.Fatalf("expect the injected fault, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// WithLogger log the token refreshes, request dispatches, platform errors and retries of the client,
+// the access tokens and secrets are never logged
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+// Log returns the logger of the client, a logger discarding every record if not set
+func (o *Options) Log() *slog.Logger {
+	if o.Logger == nil {
+		return discardLogger
+	}
+	return o.Logger
+}
+
+var discardLogger = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// LoggingInterceptor returns the interceptor logging each call: the dispatch and upload size at debug,
+// the token refresh at info, and the platform errors at warn
+func LoggingInterceptor(logger *slog.Logger) Interceptor {
+	return func(ctx context.Context, call *Call, invoker Invoker) error {
+		attrs := []any{slog.String("provider", call.Provider), slog.String("operation", call.Operation), slog.String("endpoint", call.Endpoint)}
+		if call.Replayed {
+			attrs = append(attrs, slog.Bool("replayed", true))
+		}
+		if upload, ok := call.Request.(*FileUpload); ok {
+			logger.DebugContext(ctx, "upload file", append(attrs, slog.String("file_name", upload.FileName), slog.Int("size", upload.Size))...)
+		} else {
+			logger.DebugContext(ctx, "dispatch request", attrs...)
+		}
+		start := time.Now()
+		err := invoker(ctx, call)
+		attrs = append(attrs, slog.Duration("latency", time.Since(start)))
+		if err != nil {
+			logger.WarnContext(ctx, "platform api error", append(attrs, errorAttrs(err)...)...)
+			return err
+		}
+		if call.TokenRefresh {
+			logger.InfoContext(ctx, "access token refreshed", attrs...)
+		} else {
+			logger.DebugContext(ctx, "request done", attrs...)
+		}
+		return nil
+	}
+}
+
+// errorAttrs returns the log attributes of the err with the secrets redacted
+func errorAttrs(err error) []any {
+	attrs := []any{slog.String("errcode", ErrCodeLabel(err))}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		attrs = append(attrs, slog.Int("http_status", apiErr.HTTPStatus), slog.String("errmsg", apiErr.ErrMessage),
+			slog.Bool("retryable", apiErr.Retryable))
+	}
+	return append(attrs, slog.String("error", redactSecrets(err.Error())))
+}
@@ -0,0 +1,53 @@
+package chatapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggingInterceptor(t *testing.T) {
+	var output bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	interceptor := LoggingInterceptor(logger)
+
+	upload := Call{Provider: ProviderWxWork, Operation: "WxWorkApp.UploadMedia", Request: &FileUpload{FieldName: "media", FileName: "a.png", Size: 1024}}
+	interceptor(context.Background(), &upload, func(ctx context.Context, call *Call) error { return nil })
+	token := Call{Provider: ProviderWxWork, Operation: "WxWorkApp.GetAccessToken", TokenRefresh: true}
+	interceptor(context.Background(), &token, func(ctx context.Context, call *Call) error { return nil })
+	send := Call{Provider: ProviderWxWork, Operation: "WxWorkApp.SendMessage"}
+	interceptor(context.Background(), &send, func(ctx context.Context, call *Call) error {
+		return NewRequestError(ProviderWxWork, "https://qyapi.weixin.qq.com/cgi-bin/message/send", 0,
+			errors.New(`Post "https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=live-token": connection reset`))
+	})
+
+	logs := output.String()
+	for _, expect := range []string{`"msg":"upload file"`, `"size":1024`, `"level":"INFO","msg":"access token refreshed"`,
+		`"level":"WARN","msg":"platform api error"`, `"errcode":"transport"`} {
+		if !strings.Contains(logs, expect) {
+			t.Errorf("expect %s in the logs:\n%s", expect, logs)
+		}
+	}
+	if strings.Contains(logs, "live-token") {
+		t.Errorf("expect the access token redacted:\n%s", logs)
+	}
+}
+
+func TestRetryPolicy_Log(t *testing.T) {
+	var output bytes.Buffer
+	options := NewOptions(WithLogger(slog.New(slog.NewTextHandler(&output, nil))),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	options.Retry.Do(context.Background(), false, func() error {
+		return &APIError{ErrCode: 45009, Retryable: true}
+	})
+	if !strings.Contains(output.String(), "msg=\"retry call\" errcode=45009") || !strings.Contains(output.String(), "attempt=1") {
+		t.Fatalf("expect the retry logged, got %s", output.String())
+	}
+	if len(options.Interceptors) != 1 {
+		t.Fatalf("expect the logging interceptor added, got %d", len(options.Interceptors))
+	}
+}
@@ -0,0 +1,211 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics records the platform api calls, implement it to bridge the calls to Prometheus or the other systems
+type Metrics interface {
+	// ObserveCall count a finished call and observe its latency, errCode is "0" on success
+	ObserveCall(provider, operation, errCode string, latency time.Duration)
+	// ObserveTokenRefresh count an access token refresh of an app
+	ObserveTokenRefresh(provider, errCode string)
+}
+
+// ReplayMetrics is implemented by the metrics also counting the calls replayed after refreshing the rejected access token
+type ReplayMetrics interface {
+	// ObserveReplay count a finished replay, errCode is "0" on success
+	ObserveReplay(provider, operation, errCode string)
+}
+
+// WithMetrics record every call of the client in the metrics
+func WithMetrics(metrics Metrics) Option {
+	return WithInterceptors(MetricsInterceptor(metrics))
+}
+
+// MetricsInterceptor returns the interceptor recording the calls in the metrics
+func MetricsInterceptor(metrics Metrics) Interceptor {
+	return func(ctx context.Context, call *Call, invoker Invoker) error {
+		start := time.Now()
+		err := invoker(ctx, call)
+		errCode := ErrCodeLabel(err)
+		metrics.ObserveCall(call.Provider, call.Operation, errCode, time.Since(start))
+		if call.TokenRefresh {
+			metrics.ObserveTokenRefresh(call.Provider, errCode)
+		}
+		if replayMetrics, ok := metrics.(ReplayMetrics); ok && call.Replayed {
+			replayMetrics.ObserveReplay(call.Provider, call.Operation, errCode)
+		}
+		return err
+	}
+}
+
+// ErrCodeLabel returns the low cardinality label of the err: "0" for success, the platform errcode,
+// "http_<status>" for an unexpected http status, "transport" for the network errors and "error" for the others
+func ErrCodeLabel(err error) string {
+	if err == nil {
+		return "0"
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return "error"
+	}
+	switch {
+	case apiErr.ErrCode != 0:
+		return strconv.Itoa(apiErr.ErrCode)
+	case apiErr.HTTPStatus != 0 && apiErr.HTTPStatus != http.StatusOK:
+		return "http_" + strconv.Itoa(apiErr.HTTPStatus)
+	case apiErr.HTTPStatus == 0:
+		return "transport"
+	}
+	return "error"
+}
+
+// DefaultLatencyBuckets is the upper bounds of the latency histogram buckets in seconds
+var DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// ExpvarMetrics publish the metrics as expvar maps, served by the /debug/vars handler:
+// <name>.calls and <name>.latency keyed by provider:operation:errcode,
+// <name>.token_refreshes keyed by provider:errcode and <name>.replays keyed by provider:operation:errcode
+type ExpvarMetrics struct {
+	calls          *expvar.Map
+	latency        *expvar.Map
+	tokenRefreshes *expvar.Map
+	replays        *expvar.Map
+	lock           sync.Mutex
+}
+
+var defaultMetrics struct {
+	once    sync.Once
+	metrics *ExpvarMetrics
+}
+
+// DefaultMetrics returns the expvar metrics published under the name chatapi
+func DefaultMetrics() *ExpvarMetrics {
+	defaultMetrics.once.Do(func() {
+		defaultMetrics.metrics = NewExpvarMetrics("chatapi")
+	})
+	return defaultMetrics.metrics
+}
+
+// NewExpvarMetrics create the expvar metrics published under the name, it panics if the name is already published
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	root := expvar.NewMap(name)
+	metrics := ExpvarMetrics{calls: new(expvar.Map), latency: new(expvar.Map), tokenRefreshes: new(expvar.Map), replays: new(expvar.Map)}
+	root.Set("calls", metrics.calls)
+	root.Set("latency", metrics.latency)
+	root.Set("token_refreshes", metrics.tokenRefreshes)
+	root.Set("replays", metrics.replays)
+	return &metrics
+}
+
+// ObserveCall count the call and observe its latency
+func (m *ExpvarMetrics) ObserveCall(provider, operation, errCode string, latency time.Duration) {
+	key := provider + ":" + operation + ":" + errCode
+	m.calls.Add(key, 1)
+	histogram, ok := m.latency.Get(key).(*LatencyHistogram)
+	if !ok {
+		m.lock.Lock()
+		if histogram, ok = m.latency.Get(key).(*LatencyHistogram); !ok {
+			histogram = NewLatencyHistogram(DefaultLatencyBuckets)
+			m.latency.Set(key, histogram)
+		}
+		m.lock.Unlock()
+	}
+	histogram.Observe(latency)
+}
+
+// ObserveTokenRefresh count the token refresh
+func (m *ExpvarMetrics) ObserveTokenRefresh(provider, errCode string) {
+	m.tokenRefreshes.Add(provider+":"+errCode, 1)
+}
+
+// ObserveReplay count the replay of the call
+func (m *ExpvarMetrics) ObserveReplay(provider, operation, errCode string) {
+	m.replays.Add(provider+":"+operation+":"+errCode, 1)
+}
+
+// Calls returns the count of the calls with the labels
+func (m *ExpvarMetrics) Calls(provider, operation, errCode string) int64 {
+	if count, ok := m.calls.Get(provider + ":" + operation + ":" + errCode).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
+
+// TokenRefreshes returns the count of the token refreshes with the labels
+func (m *ExpvarMetrics) TokenRefreshes(provider, errCode string) int64 {
+	if count, ok := m.tokenRefreshes.Get(provider + ":" + errCode).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
+
+// Replays returns the count of the replays with the labels
+func (m *ExpvarMetrics) Replays(provider, operation, errCode string) int64 {
+	if count, ok := m.replays.Get(provider + ":" + operation + ":" + errCode).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
+
+// LatencyHistogram is a cumulative latency histogram published as an expvar.Var
+type LatencyHistogram struct {
+	lock    sync.Mutex
+	buckets []float64
+	counts  []int64 // the count of each bucket, the last one is +Inf
+	count   int64
+	sum     float64
+}
+
+// NewLatencyHistogram create a histogram with the bucket upper bounds in seconds
+func NewLatencyHistogram(buckets []float64) *LatencyHistogram {
+	return &LatencyHistogram{buckets: buckets, counts: make([]int64, len(buckets)+1)}
+}
+
+// Observe add the latency to the histogram
+func (h *LatencyHistogram) Observe(latency time.Duration) {
+	seconds := latency.Seconds()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	index := len(h.buckets)
+	for i, bound := range h.buckets {
+		if seconds <= bound {
+			index = i
+			break
+		}
+	}
+	h.counts[index]++
+	h.count++
+	h.sum += seconds
+}
+
+// Count returns the number of the observations
+func (h *LatencyHistogram) Count() int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.count
+}
+
+// String returns the histogram as json with the cumulative bucket counts, it implements expvar.Var
+func (h *LatencyHistogram) String() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var builder strings.Builder
+	fmt.Fprintf(&builder, `{"count":%d,"sum":%g,"buckets":{`, h.count, h.sum)
+	var cumulative int64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(&builder, `"%g":%d,`, bound, cumulative)
+	}
+	fmt.Fprintf(&builder, `"+Inf":%d}}`, h.count)
+	return builder.String()
+}
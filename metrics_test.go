@@ -0,0 +1,82 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestErrCodeLabel(t *testing.T) {
+	testCases := []struct {
+		err    error
+		expect string
+	}{
+		{nil, "0"},
+		{&APIError{HTTPStatus: http.StatusOK, ErrCode: 45009}, "45009"},
+		{NewRequestError(ProviderWxWork, "send", http.StatusBadGateway, nil), "http_502"},
+		{NewRequestError(ProviderWxWork, "send", 0, errors.New("connection reset")), "transport"},
+		{ErrRateLimited, "error"},
+	}
+	for _, testCase := range testCases {
+		if label := ErrCodeLabel(testCase.err); label != testCase.expect {
+			t.Errorf("expect %s, got %s", testCase.expect, label)
+		}
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	metrics := NewExpvarMetrics("chatapi_test")
+	interceptor := MetricsInterceptor(metrics)
+	ok := func(ctx context.Context, call *Call) error { return nil }
+	busy := func(ctx context.Context, call *Call) error { return &APIError{ErrCode: -1} }
+	send := Call{Provider: ProviderDingTalk, Operation: "DingDingApp.SendMessage"}
+	token := Call{Provider: ProviderDingTalk, Operation: "DingDingApp.GetAccessToken", TokenRefresh: true}
+	interceptor(context.Background(), &send, ok)
+	interceptor(context.Background(), &send, ok)
+	interceptor(context.Background(), &send, busy)
+	interceptor(context.Background(), &token, ok)
+	replay := Call{Provider: ProviderDingTalk, Operation: "DingDingApp.SendMessage", Replayed: true}
+	interceptor(context.Background(), &replay, ok)
+
+	if count := metrics.Calls(ProviderDingTalk, "DingDingApp.SendMessage", "0"); count != 3 {
+		t.Fatalf("expect 3 successful calls, got %d", count)
+	}
+	if count := metrics.Replays(ProviderDingTalk, "DingDingApp.SendMessage", "0"); count != 1 {
+		t.Fatalf("expect 1 successful replay, got %d", count)
+	}
+	if count := metrics.Calls(ProviderDingTalk, "DingDingApp.SendMessage", "-1"); count != 1 {
+		t.Fatalf("expect 1 failed call, got %d", count)
+	}
+	if count := metrics.TokenRefreshes(ProviderDingTalk, "0"); count != 1 {
+		t.Fatalf("expect 1 token refresh, got %d", count)
+	}
+	histogram := metrics.latency.Get("dingtalk:DingDingApp.SendMessage:0").(*LatencyHistogram)
+	if histogram.Count() != 3 {
+		t.Fatalf("expect 3 latency observations of the successful calls, got %d", histogram.Count())
+	}
+	if failed := metrics.latency.Get("dingtalk:DingDingApp.SendMessage:-1").(*LatencyHistogram); failed.Count() != 1 {
+		t.Fatalf("expect 1 latency observation of the failed calls, got %d", failed.Count())
+	}
+	var published map[string]interface{}
+	if err := json.Unmarshal([]byte(histogram.String()), &published); err != nil {
+		t.Fatalf("expect the histogram published as json, %v", err)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	histogram := NewLatencyHistogram([]float64{0.1, 1})
+	histogram.Observe(50 * time.Millisecond)
+	histogram.Observe(500 * time.Millisecond)
+	histogram.Observe(5 * time.Second)
+	var published struct {
+		Count   int64            `json:"count"`
+		Buckets map[string]int64 `json:"buckets"`
+	}
+	json.Unmarshal([]byte(histogram.String()), &published)
+	if published.Count != 3 || published.Buckets["0.1"] != 1 || published.Buckets["1"] != 2 || published.Buckets["+Inf"] != 3 {
+		t.Fatalf("unexpected histogram %s", histogram.String())
+	}
+}
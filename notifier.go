@@ -0,0 +1,69 @@
+// Package chatapi holds the provider-neutral parts of the chat api clients,
+// the provider packages wechat, dingtalk and bytedance build on top of it.
+package chatapi
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrUnsupported is returned when a client can not deliver the message type
+var ErrUnsupported = errors.New("message type not supported by the client")
+
+// Link is a provider-neutral link or card message
+type Link struct {
+	Title       string
+	Description string
+	URL         string
+	PictureURL  string
+}
+
+// Image is a provider-neutral image message, each client uses the fields its platform supports
+type Image struct {
+	Data    []byte // raw image data, uploaded first if the platform needs a media id
+	Name    string // file name used when the image data is uploaded
+	MediaID string // media id or image key of an already uploaded image
+	URL     string // public url of the image
+}
+
+// Notifier is the common interface implemented by the adapters of every chat client,
+// so the platforms can be swapped or combined without touching the caller code.
+type Notifier interface {
+	SendText(ctx context.Context, content string) error
+	SendMarkdown(ctx context.Context, title, content string) error
+	SendLink(ctx context.Context, link *Link) error
+	SendImage(ctx context.Context, image *Image) error
+}
+
+// MultiNotifier sends every message to all the notifiers, the errors are joined
+type MultiNotifier []Notifier
+
+// SendText send the text message to all notifiers
+func (m MultiNotifier) SendText(ctx context.Context, content string) error {
+	return m.each(func(n Notifier) error { return n.SendText(ctx, content) })
+}
+
+// SendMarkdown send the markdown message to all notifiers
+func (m MultiNotifier) SendMarkdown(ctx context.Context, title, content string) error {
+	return m.each(func(n Notifier) error { return n.SendMarkdown(ctx, title, content) })
+}
+
+// SendLink send the link message to all notifiers
+func (m MultiNotifier) SendLink(ctx context.Context, link *Link) error {
+	return m.each(func(n Notifier) error { return n.SendLink(ctx, link) })
+}
+
+// SendImage send the image message to all notifiers
+func (m MultiNotifier) SendImage(ctx context.Context, image *Image) error {
+	return m.each(func(n Notifier) error { return n.SendImage(ctx, image) })
+}
+
+func (m MultiNotifier) each(send func(n Notifier) error) error {
+	var errs []error
+	for _, n := range m {
+		if err := send(n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,57 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type recordNotifier struct {
+	sent []string
+	err  error
+}
+
+func (n *recordNotifier) SendText(ctx context.Context, content string) error {
+	n.sent = append(n.sent, content)
+	return n.err
+}
+
+func (n *recordNotifier) SendMarkdown(ctx context.Context, title, content string) error {
+	n.sent = append(n.sent, title+":"+content)
+	return n.err
+}
+
+func (n *recordNotifier) SendLink(ctx context.Context, link *Link) error {
+	n.sent = append(n.sent, link.URL)
+	return n.err
+}
+
+func (n *recordNotifier) SendImage(ctx context.Context, image *Image) error {
+	n.sent = append(n.sent, image.URL)
+	return n.err
+}
+
+func TestMultiNotifier_SendText(t *testing.T) {
+	first := &recordNotifier{}
+	second := &recordNotifier{err: ErrUnsupported}
+	third := &recordNotifier{}
+	err := MultiNotifier{first, second, third}.SendText(context.Background(), "hello, master")
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expect ErrUnsupported, got %v", err)
+	}
+	for _, n := range []*recordNotifier{first, second, third} {
+		if len(n.sent) != 1 || n.sent[0] != "hello, master" {
+			t.Fatalf("unexpected messages %v", n.sent)
+		}
+	}
+}
+
+func TestMultiNotifier_SendMarkdown(t *testing.T) {
+	n := &recordNotifier{}
+	if err := (MultiNotifier{n}).SendMarkdown(context.Background(), "title", "# body"); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.sent) != 1 || n.sent[0] != "title:# body" {
+		t.Fatalf("unexpected messages %v", n.sent)
+	}
+}
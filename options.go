@@ -0,0 +1,70 @@
+package chatapi
+
+import (
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// Options is the optional settings shared by all the clients, each client uses the fields it supports
+type Options struct {
+	TokenStore   TokenStore // share the app access token between processes
+	BaseURL      string     // replace the scheme, host and path prefix of the default api urls
+	Retry        RetryPolicy
+	RateLimiter  *RateLimiter // limit the calls on the client side, nil for no limit
+	Interceptors []Interceptor
+	Logger       *slog.Logger // log the calls, nil for no logging
+	DryRun       DryRunSink   // capture the built requests instead of sending them, nil for sending
+
+	RecipientPolicy      *RecipientPolicy // rewrite or block the recipients of the messages, nil for no policy
+	SkipValidation       bool             // send the messages without checking the limits of the platform
+	SplitLongMessages    bool             // send the content over the limit as several messages
+	TruncateLongMessages bool             // cut the content over the limit, ignored when the long messages are split
+	TruncateMoreURL      string           // the link to the full content appended to the truncated content, empty for none
+}
+
+// Option configures the optional settings of a client
+type Option func(*Options)
+
+// NewOptions apply the options in order over the defaults
+func NewOptions(opts ...Option) Options {
+	options := Options{Retry: DefaultRetryPolicy}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.Logger != nil {
+		// the logging runs closest to the platform, so every attempt is logged
+		options.Interceptors = append(options.Interceptors, LoggingInterceptor(options.Logger))
+	}
+	options.Retry.logger = options.Log()
+	return options
+}
+
+// URL returns the api url on the base url if set, e.g. the default api
+// https://qyapi.weixin.qq.com/cgi-bin/message/send on the base url https://gateway.example.com/wecom
+// becomes https://gateway.example.com/wecom/cgi-bin/message/send
+func (o *Options) URL(api string) string {
+	if o.BaseURL == "" {
+		return api
+	}
+	apiURL, err := url.Parse(api)
+	if err != nil {
+		return api
+	}
+	return strings.TrimSuffix(o.BaseURL, "/") + apiURL.Path
+}
+
+// WithBaseURL send the requests to the base url instead of the default platform host,
+// e.g. a private deployment, an egress proxy gateway or a httptest.Server
+func WithBaseURL(baseURL string) Option {
+	return func(o *Options) {
+		o.BaseURL = baseURL
+	}
+}
+
+// WithTokenStore share the app access token with the other processes through the store
+func WithTokenStore(store TokenStore) Option {
+	return func(o *Options) {
+		o.TokenStore = store
+	}
+}
@@ -0,0 +1,21 @@
+package chatapi
+
+import "testing"
+
+func TestOptions_URL(t *testing.T) {
+	api := "https://qyapi.weixin.qq.com/cgi-bin/message/send"
+	testCases := []struct {
+		baseURL string
+		expect  string
+	}{
+		{"", api},
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080/cgi-bin/message/send"},
+		{"https://gateway.example.com/wecom/", "https://gateway.example.com/wecom/cgi-bin/message/send"},
+	}
+	for _, testCase := range testCases {
+		options := NewOptions(WithBaseURL(testCase.baseURL))
+		if reqURL := options.URL(api); reqURL != testCase.expect {
+			t.Errorf("expect %s, got %s", testCase.expect, reqURL)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package chatapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// ErrRecipientBlocked is returned when the recipient policy leaves the message without any recipient
+var ErrRecipientBlocked = errors.New("message blocked by the recipient policy")
+
+// RecipientPolicy rewrites or blocks the recipients of the app and robot messages, e.g. to keep a non-production
+// environment from reaching the real users. It is plain data loaded from the configuration, see LoadRecipientPolicy.
+//
+// The redirection wins over the allowlist: a redirected message goes to the test chat or webhook whatever its recipients.
+type RecipientPolicy struct {
+	// Allowlist keeps only the listed recipients if set, the message left without any recipient is blocked
+	Allowlist *RecipientAllowlist `json:"allowlist,omitempty"`
+	// RedirectChat sends every app message to the group chat instead of its recipients
+	RedirectChat string `json:"redirect_chat,omitempty"`
+	// RedirectWebhook sends every robot message to the webhook instead, the key of the wxwork and feishu robots
+	// or the access token of the dingtalk robot
+	RedirectWebhook string `json:"redirect_webhook,omitempty"`
+	// RedirectWebhookSecret is the sign secret of the dingtalk redirect webhook, empty for none
+	RedirectWebhookSecret string `json:"redirect_webhook_secret,omitempty"`
+	// Banner prefixes the content of the text and markdown messages, e.g. "[staging]"
+	Banner string `json:"banner,omitempty"`
+}
+
+// RecipientAllowlist is the recipients allowed by the policy, each client checks the kinds its platform supports
+type RecipientAllowlist struct {
+	Users       []string `json:"users,omitempty"`       // the user ids, the feishu open ids and emails are checked here too
+	Parties     []string `json:"parties,omitempty"`     // the wxwork party ids
+	Tags        []string `json:"tags,omitempty"`        // the wxwork tag ids
+	Departments []string `json:"departments,omitempty"` // the dingtalk department ids
+	Chats       []string `json:"chats,omitempty"`       // the group chat ids
+	Webhooks    []string `json:"webhooks,omitempty"`    // the robot webhook keys or access tokens
+}
+
+// WithRecipientPolicy rewrite or block the recipients of the messages by the policy, nil for no policy
+func WithRecipientPolicy(policy *RecipientPolicy) Option {
+	return func(o *Options) {
+		o.RecipientPolicy = policy
+	}
+}
+
+// LoadRecipientPolicy load the policy from the json file, e.g.
+//
+//	{"allowlist": {"users": ["qa001"], "chats": ["chat-qa"]}, "banner": "[staging]"}
+func LoadRecipientPolicy(file string) (policy *RecipientPolicy, err error) {
+	data, readErr := ioutil.ReadFile(file)
+	if readErr != nil {
+		err = fmt.Errorf("read recipient policy error, %w", readErr)
+		return
+	}
+	policy = &RecipientPolicy{}
+	if decodeErr := json.Unmarshal(data, policy); decodeErr != nil {
+		policy = nil
+		err = fmt.Errorf("parse recipient policy error, %w", decodeErr)
+		return
+	}
+	return
+}
+
+// AllowedRecipients returns the ids in the allowed list, in their order
+func AllowedRecipients(ids, allowed []string) (kept []string) {
+	for _, id := range ids {
+		for _, allowedID := range allowed {
+			if id == allowedID {
+				kept = append(kept, id)
+				break
+			}
+		}
+	}
+	return
+}
+
+// AllowChat check the group chat against the allowlist, any chat is allowed without the allowlist
+func (p *RecipientPolicy) AllowChat(chatID string) bool {
+	return p.Allowlist == nil || len(AllowedRecipients([]string{chatID}, p.Allowlist.Chats)) > 0
+}
+
+// AllowWebhook check the robot webhook against the allowlist, any webhook is allowed without the allowlist
+func (p *RecipientPolicy) AllowWebhook(webhook string) bool {
+	return p.Allowlist == nil || len(AllowedRecipients([]string{webhook}, p.Allowlist.Webhooks)) > 0
+}
+
+// WithBanner returns the content prefixed with the banner line
+func (p *RecipientPolicy) WithBanner(content string) string {
+	if p.Banner == "" {
+		return content
+	}
+	return p.Banner + "\n" + content
+}
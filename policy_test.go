@@ -0,0 +1,49 @@
+package chatapi
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadRecipientPolicy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	config := `{"allowlist": {"users": ["qa001", "qa002"], "chats": ["chat-qa"]}, "redirect_webhook": "qa-key", "banner": "[staging]"}`
+	if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadRecipientPolicy(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := RecipientPolicy{Allowlist: &RecipientAllowlist{Users: []string{"qa001", "qa002"}, Chats: []string{"chat-qa"}},
+		RedirectWebhook: "qa-key", Banner: "[staging]"}
+	if !reflect.DeepEqual(*policy, expect) {
+		t.Fatalf("unexpected policy %+v", policy)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if policy, err := LoadRecipientPolicy(file); err == nil || policy != nil {
+		t.Fatalf("expect the broken config refused, got %+v", policy)
+	}
+}
+
+func TestRecipientPolicy_Allow(t *testing.T) {
+	if kept := AllowedRecipients([]string{"u3", "u1", "@all", "u2"}, []string{"u1", "u2"}); !reflect.DeepEqual(kept, []string{"u1", "u2"}) {
+		t.Fatalf("unexpected allowed recipients %v", kept)
+	}
+	policy := RecipientPolicy{}
+	if !policy.AllowChat("chat") || !policy.AllowWebhook("key") || policy.WithBanner("hello") != "hello" {
+		t.Fatal("expect everything allowed and kept without the allowlist and the banner")
+	}
+	policy = RecipientPolicy{Allowlist: &RecipientAllowlist{Chats: []string{"chat-qa"}, Webhooks: []string{"qa-key"}}, Banner: "[staging]"}
+	if !policy.AllowChat("chat-qa") || policy.AllowChat("chat") || !policy.AllowWebhook("qa-key") || policy.AllowWebhook("key") {
+		t.Fatal("expect only the listed chat and webhook allowed")
+	}
+	if content := policy.WithBanner("hello"); content != "[staging]\nhello" {
+		t.Fatalf("unexpected content %q", content)
+	}
+}
@@ -0,0 +1,123 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when the client side rate limit rejects a call
+var ErrRateLimited = errors.New("rate limited by the client")
+
+// RateLimitMode decides what a call does when the rate limit is reached
+type RateLimitMode int
+
+const (
+	// RateLimitBlock waits for the next token until the context ends
+	RateLimitBlock RateLimitMode = iota
+	// RateLimitFailFast returns ErrRateLimited at once
+	RateLimitFailFast
+	// RateLimitQueue waits for the next token in order, and returns ErrRateLimited if MaxQueue calls are already waiting
+	RateLimitQueue
+)
+
+// RateLimit is the token bucket limit of each key, e.g. 20 messages per minute for a robot webhook
+type RateLimit struct {
+	Rate     int           // the tokens added in each period
+	Period   time.Duration // the period to add the tokens
+	Burst    int           // the bucket size, Rate if 0
+	Mode     RateLimitMode // what to do when the bucket is empty
+	MaxQueue int           // the waiting calls allowed of each key in RateLimitQueue mode
+}
+
+// RateLimiter limits the calls by the token bucket of each key, it is safe for concurrent use
+// and can be shared by the clients sending to the same webhooks or apps
+type RateLimiter struct {
+	limit   RateLimit
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens  float64 // the available tokens, negative for the tokens reserved by the waiting calls
+	updated time.Time
+	waiting int
+}
+
+// NewRateLimiter create a rate limiter applying the limit to each key
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Rate
+	}
+	return &RateLimiter{limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+// WithRateLimiter limit the calls of the client by the limiter, the robots are limited by each webhook
+// and the apps by each app
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(o *Options) {
+		o.RateLimiter = limiter
+	}
+}
+
+// Wait takes a token of the key, the nil limiter never limits
+func (l *RateLimiter) Wait(ctx context.Context, key string) error {
+	if l == nil || l.limit.Rate <= 0 || l.limit.Period <= 0 {
+		return nil
+	}
+	l.lock.Lock()
+	now := time.Now()
+	bucket := l.bucket(key, now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		l.lock.Unlock()
+		return nil
+	}
+	if l.limit.Mode == RateLimitFailFast || (l.limit.Mode == RateLimitQueue && bucket.waiting >= l.limit.MaxQueue) {
+		l.lock.Unlock()
+		return ErrRateLimited
+	}
+	// reserve the next token, the reservations are served in order
+	bucket.tokens--
+	delay := time.Duration(-bucket.tokens * float64(l.limit.Period) / float64(l.limit.Rate))
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		bucket.tokens++
+		l.lock.Unlock()
+		return ErrRateLimited
+	}
+	bucket.waiting++
+	l.lock.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		l.lock.Lock()
+		bucket.waiting--
+		l.lock.Unlock()
+		return nil
+	case <-ctx.Done():
+		l.lock.Lock()
+		bucket.waiting--
+		bucket.tokens++
+		l.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// bucket returns the bucket of the key refilled to now, it must be called with the lock held
+func (l *RateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = bucket
+		return bucket
+	}
+	bucket.tokens += float64(now.Sub(bucket.updated)) * float64(l.limit.Rate) / float64(l.limit.Period)
+	if bucket.tokens > float64(l.limit.Burst) {
+		bucket.tokens = float64(l.limit.Burst)
+	}
+	bucket.updated = now
+	return bucket
+}
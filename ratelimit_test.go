@@ -0,0 +1,71 @@
+package chatapi
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_FailFast(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{Rate: 2, Period: time.Minute, Mode: RateLimitFailFast})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := limiter.Wait(ctx, "webhook-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := limiter.Wait(ctx, "webhook-1"); err != ErrRateLimited {
+		t.Fatalf("expect rate limited, got %v", err)
+	}
+	// each key has its own bucket
+	if err := limiter.Wait(ctx, "webhook-2"); err != nil {
+		t.Fatal(err)
+	}
+	var noLimit *RateLimiter
+	if err := noLimit.Wait(ctx, "webhook-1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimiter_Block(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{Rate: 1, Period: 20 * time.Millisecond})
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := limiter.Wait(ctx, "webhook"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("expect the calls spread by the rate, took %v", elapsed)
+	}
+
+	// the wait longer than the deadline fails at once
+	limiter = NewRateLimiter(RateLimit{Rate: 1, Period: time.Minute})
+	limiter.Wait(ctx, "webhook")
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := limiter.Wait(timeoutCtx, "webhook"); err != ErrRateLimited {
+		t.Fatalf("expect rate limited, got %v", err)
+	}
+}
+
+func TestRateLimiter_Queue(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{Rate: 1, Period: 50 * time.Millisecond, Mode: RateLimitQueue, MaxQueue: 1})
+	ctx := context.Background()
+	limiter.Wait(ctx, "webhook")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := limiter.Wait(ctx, "webhook"); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := limiter.Wait(ctx, "webhook"); err != ErrRateLimited {
+		t.Fatalf("expect the full queue rejects the call, got %v", err)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,99 @@
+package chatapi
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// redactedValue replaces the credentials in the urls, errors and logs
+const redactedValue = "REDACTED"
+
+// secretParamPattern matches the query parameters carrying the credentials in a string
+var secretParamPattern = regexp.MustCompile(`(?i)\b([a-z_]*(?:token|secret)[a-z_]*|sign|key|session)=[^&\s"]+`)
+
+// webhookPathPrefixes are the path prefixes followed by the webhook key
+var webhookPathPrefixes = []string{"/trigger-webhook/", "/bot/v2/hook/"}
+
+// RedactURL replace the credentials in the url: the access_token, sign, key, session and secret query values,
+// the webhook key in the path and the user password
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redactSecrets(rawURL)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			if isSecretParam(name) {
+				query.Set(name, redactedValue)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	for _, prefix := range webhookPathPrefixes {
+		if i := strings.Index(u.Path, prefix); i >= 0 && len(u.Path) > i+len(prefix) {
+			u.Path = u.Path[:i+len(prefix)] + redactedValue
+			u.RawPath = ""
+		}
+	}
+	return u.Redacted()
+}
+
+// RedactError scrub the credentials from the urls in the err, the *url.Error in the chain is redacted in place,
+// and the err is wrapped with the redacted message if the wrappers have formatted the url already
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = RedactURL(urlErr.URL)
+	}
+	if message := redactSecrets(err.Error()); message != err.Error() {
+		return &redactedError{message: message, err: err}
+	}
+	return err
+}
+
+// redactedError keeps the err in the chain for errors.Is and errors.As with the redacted message
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+func isSecretParam(name string) bool {
+	name = strings.ToLower(name)
+	return name == "sign" || name == "key" || name == "session" || strings.Contains(name, "token") || strings.Contains(name, "secret")
+}
+
+// redactSecrets replace the credential values in s
+func redactSecrets(s string) string {
+	s = secretParamPattern.ReplaceAllString(s, "$1="+redactedValue)
+	for _, prefix := range webhookPathPrefixes {
+		// every webhook in s is redacted, the search goes on after the redacted key
+		for from := 0; ; {
+			i := strings.Index(s[from:], prefix)
+			if i < 0 {
+				break
+			}
+			start := from + i + len(prefix)
+			end := start
+			for end < len(s) && !strings.ContainsRune("?\"' \t\n", rune(s[end])) {
+				end++
+			}
+			s = s[:start] + redactedValue + s[end:]
+			from = start + len(redactedValue)
+		}
+	}
+	return s
+}
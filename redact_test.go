@@ -0,0 +1,56 @@
+package chatapi
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactURL(t *testing.T) {
+	testCases := []struct {
+		rawURL string
+		expect string
+	}{
+		{"https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=live-token",
+			"https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=REDACTED"},
+		{"https://oapi.dingtalk.com/robot/send?access_token=abc&sign=xyz%3D&timestamp=1600000000000",
+			"https://oapi.dingtalk.com/robot/send?access_token=REDACTED&sign=REDACTED&timestamp=1600000000000"},
+		{"https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=ww1&corpsecret=s3cret",
+			"https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=ww1&corpsecret=REDACTED"},
+		{"https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=robot-key",
+			"https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=REDACTED"},
+		{"https://www.feishu.cn/flow/api/trigger-webhook/robot-key",
+			"https://www.feishu.cn/flow/api/trigger-webhook/REDACTED"},
+		{"https://open.feishu.cn/open-apis/message/v4/send/", "https://open.feishu.cn/open-apis/message/v4/send/"},
+		{"https://oapi.dingtalk.com/robot/sendBySession?session=c5b2a8d3e7f1",
+			"https://oapi.dingtalk.com/robot/sendBySession?session=REDACTED"},
+	}
+	for _, testCase := range testCases {
+		if redacted := RedactURL(testCase.rawURL); redacted != testCase.expect {
+			t.Errorf("expect %s, got %s", testCase.expect, redacted)
+		}
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	cause := &url.Error{Op: "Post", URL: "https://oapi.dingtalk.com/robot/send?access_token=abc&sign=xyz", Err: errors.New("connection refused")}
+	err := NewRequestError(ProviderDingTalk, "https://oapi.dingtalk.com/robot/send", 0, cause)
+	if strings.Contains(err.Error(), "abc") || strings.Contains(err.Error(), "xyz") {
+		t.Fatalf("expect the credentials redacted, got %s", err.Error())
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Fatal("expect the url error kept in the chain")
+	}
+	if redacted := redactSecrets(`Post "https://www.feishu.cn/flow/api/trigger-webhook/robot-key": EOF`); strings.Contains(redacted, "robot-key") {
+		t.Fatalf("expect the webhook key redacted, got %s", redacted)
+	}
+	redacted := redactSecrets("send https://www.feishu.cn/flow/api/trigger-webhook/key-1 error, fallback https://www.feishu.cn/flow/api/trigger-webhook/key-2 error")
+	if expect := "send https://www.feishu.cn/flow/api/trigger-webhook/REDACTED error, fallback https://www.feishu.cn/flow/api/trigger-webhook/REDACTED error"; redacted != expect {
+		t.Fatalf("expect both webhook keys redacted, got %s", redacted)
+	}
+	if redacted := redactSecrets(`Post "https://oapi.dingtalk.com/robot/sendBySession?session=c5b2a8d3e7f1": EOF`); strings.Contains(redacted, "c5b2a8d3e7f1") {
+		t.Fatalf("expect the session redacted, got %s", redacted)
+	}
+}
@@ -0,0 +1,131 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how the failed api calls are retried. Only the errors known as safe are retried:
+// the platform rate limits and busy errcodes, http 429 and 503, and the connections failed before sending
+// the request. The idempotent calls, e.g. the GET requests and uploads, are also retried on the other
+// transient errors like the connection reset and the 5xx status.
+type RetryPolicy struct {
+	MaxAttempts int           // the attempts including the first one, 1 or less disables the retry
+	BaseDelay   time.Duration // the delay before the first retry, doubled for each next retry
+	MaxDelay    time.Duration // the upper bound of the backoff delay
+	Jitter      float64       // the random fraction 0~1 of the delay to spread the retries of the clients
+
+	logger *slog.Logger // log the retry decisions, set by the client options
+}
+
+// DefaultRetryPolicy is the retry policy of the clients without the WithRetryPolicy option
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond * 200, MaxDelay: time.Second * 5, Jitter: 0.2}
+
+// WithRetryPolicy retry the failed calls with the policy, the zero RetryPolicy disables the retry
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *Options) {
+		o.Retry = policy
+	}
+}
+
+type noRetryKey struct{}
+
+// WithoutRetry disable the retry of the calls made with the returned context
+func WithoutRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+// Do run the call until it succeeds, fails with an error not safe to retry, or the attempts run out.
+// The Retry-After asked by the server is honored, the call is not retried if the context ends before it.
+func (p *RetryPolicy) Do(ctx context.Context, idempotent bool, call func() error) (err error) {
+	if disabled, _ := ctx.Value(noRetryKey{}).(bool); disabled {
+		return call()
+	}
+	for attempt := 1; ; attempt++ {
+		err = call()
+		if err == nil || attempt >= p.MaxAttempts || !isRetrySafe(err, idempotent) {
+			if attempt > 1 {
+				var apiErr *APIError
+				if errors.As(err, &apiErr) {
+					apiErr.Attempts = attempt
+				}
+			}
+			return
+		}
+		delay := p.Delay(attempt, err)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			p.log().InfoContext(ctx, "give up retry before the deadline", append(errorAttrs(err), slog.Int("attempt", attempt), slog.Duration("delay", delay))...)
+			return
+		}
+		p.log().InfoContext(ctx, "retry call", append(errorAttrs(err), slog.Int("attempt", attempt), slog.Duration("delay", delay))...)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (p *RetryPolicy) log() *slog.Logger {
+	if p.logger == nil {
+		return discardLogger
+	}
+	return p.logger
+}
+
+// Delay returns the delay before the next attempt, the Retry-After of the err is used if longer than the backoff
+func (p *RetryPolicy) Delay(attempt int, err error) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		if delay > math.MaxInt64/2 {
+			// the doubling would overflow
+			delay = math.MaxInt64
+			break
+		}
+		delay *= 2
+	}
+	if p.Jitter > 0 {
+		// the jitter is added in float64 and saturated, so the delay saturated above does not overflow
+		jittered := float64(delay) * (1 + (rand.Float64()*2-1)*p.Jitter)
+		if jittered >= math.MaxInt64 {
+			delay = math.MaxInt64
+		} else {
+			delay = time.Duration(jittered)
+		}
+	}
+	// the jittered delay is clamped too
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+		delay = apiErr.RetryAfter
+	}
+	return delay
+}
+
+// isRetrySafe report whether the err may succeed if sent again without the risk of duplicated messages
+func isRetrySafe(err error, idempotent bool) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.Retryable {
+		return false
+	}
+	if idempotent {
+		return true
+	}
+	// the platform rejected the request without handling it
+	if apiErr.ErrCode != 0 || apiErr.HTTPStatus == http.StatusTooManyRequests || apiErr.HTTPStatus == http.StatusServiceUnavailable {
+		return true
+	}
+	// the connection failed before the request was sent
+	var opErr *net.OpError
+	return apiErr.HTTPStatus == 0 && errors.As(apiErr.Err, &opErr) && opErr.Op == "dial"
+}
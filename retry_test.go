@@ -0,0 +1,130 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Do(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	rateLimitErr := &APIError{Provider: ProviderWxWork, HTTPStatus: http.StatusOK, ErrCode: 45009, Retryable: true}
+	resetErr := NewRequestError(ProviderWxWork, "send", 0, errors.New("connection reset by peer"))
+	dialErr := NewRequestError(ProviderWxWork, "send", 0, &net.OpError{Op: "dial", Err: errors.New("connection refused")})
+	testCases := []struct {
+		name       string
+		err        error
+		idempotent bool
+		ctx        context.Context
+		expect     int
+	}{
+		{"rate limit", rateLimitErr, false, context.Background(), 3},
+		{"reset not idempotent", resetErr, false, context.Background(), 1},
+		{"reset idempotent", resetErr, true, context.Background(), 3},
+		{"dial", dialErr, false, context.Background(), 3},
+		{"server error not idempotent", NewRequestError(ProviderWxWork, "send", http.StatusBadGateway, nil), false, context.Background(), 1},
+		{"service unavailable", NewRequestError(ProviderWxWork, "send", http.StatusServiceUnavailable, nil), false, context.Background(), 3},
+		{"not retryable", &APIError{ErrCode: 40003}, true, context.Background(), 1},
+		{"disabled", rateLimitErr, false, WithoutRetry(context.Background()), 1},
+	}
+	for _, testCase := range testCases {
+		var calls int
+		err := policy.Do(testCase.ctx, testCase.idempotent, func() error {
+			calls++
+			return testCase.err
+		})
+		if calls != testCase.expect || err != testCase.err {
+			t.Errorf("%s: expect %d calls, got %d, %v", testCase.name, testCase.expect, calls, err)
+		}
+	}
+	if rateLimitErr.Attempts != 3 {
+		t.Errorf("expect the attempts recorded, got %d", rateLimitErr.Attempts)
+	}
+
+	var calls int
+	err := policy.Do(context.Background(), false, func() error {
+		if calls++; calls < 2 {
+			return rateLimitErr
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("expect success on the second attempt, got %d calls, %v", calls, err)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	for attempt, expect := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond} {
+		if delay := policy.Delay(attempt+1, nil); delay != expect {
+			t.Errorf("attempt %d: expect %v, got %v", attempt+1, expect, delay)
+		}
+	}
+	if delay := policy.Delay(1, &APIError{RetryAfter: 2 * time.Second}); delay != 2*time.Second {
+		t.Errorf("expect the Retry-After honored, got %v", delay)
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.Delay(1, nil); delay < 50*time.Millisecond || delay > 150*time.Millisecond {
+			t.Fatalf("expect the jitter within the fraction, got %v", delay)
+		}
+	}
+
+	// no retry if the Retry-After passes the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var calls int
+	policy.Do(ctx, true, func() error {
+		calls++
+		return &APIError{Retryable: true, RetryAfter: time.Minute}
+	})
+	if calls != 1 {
+		t.Fatalf("expect the call not retried after the deadline, got %d calls", calls)
+	}
+}
+
+func TestRetryPolicy_DelayBackoff(t *testing.T) {
+	cases := []struct {
+		policy  RetryPolicy
+		attempt int
+		expect  time.Duration
+	}{
+		{RetryPolicy{BaseDelay: time.Second}, 1, time.Second},
+		{RetryPolicy{BaseDelay: time.Second}, 2, 2 * time.Second},
+		{RetryPolicy{BaseDelay: time.Second}, 4, 8 * time.Second},
+		{RetryPolicy{BaseDelay: time.Second}, 200, math.MaxInt64},
+		{RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}, 3, 4 * time.Second},
+		{RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}, 4, 5 * time.Second},
+		{RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}, 200, 5 * time.Second},
+	}
+	for _, c := range cases {
+		if delay := c.policy.Delay(c.attempt, nil); delay != c.expect {
+			t.Errorf("max delay %v, attempt %d: expect %v, got %v", c.policy.MaxDelay, c.attempt, c.expect, delay)
+		}
+	}
+}
+
+func TestRetryPolicy_DelayJitterBounds(t *testing.T) {
+	saturated := RetryPolicy{BaseDelay: time.Second, Jitter: 0.5}
+	capped := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if delay := saturated.Delay(200, nil); delay < math.MaxInt64/4 {
+			t.Fatalf("expect the saturated delay not overflowed by the jitter, got %v", delay)
+		}
+		if delay := capped.Delay(4, nil); delay > 5*time.Second || delay < 2500*time.Millisecond {
+			t.Fatalf("expect the jittered delay within the max delay, got %v", delay)
+		}
+	}
+}
+
+func TestNewStatusError(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"3"}}}
+	apiErr := NewStatusError(ProviderFeiShu, "send", resp)
+	if !apiErr.Retryable || apiErr.RetryAfter != 3*time.Second {
+		t.Fatalf("unexpected error %+v", apiErr)
+	}
+}
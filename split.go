@@ -0,0 +1,172 @@
+package chatapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fenceCloser closes the code fence left open at the end of a markdown part
+const fenceCloser = "\n```"
+
+// WithSplit send the text and markdown content over the limit of the platform as several messages in order,
+// each part ends with the "(1/3)" style marker, the results of the parts are aggregated
+func WithSplit() Option {
+	return func(o *Options) {
+		o.SplitLongMessages = true
+	}
+}
+
+// Splitter cut the long content into the parts fitting the limit of a message, the cuts are on the rune boundaries
+type Splitter struct {
+	MaxBytes int  // the max utf-8 bytes of each part with its marker, 0 for no limit
+	MaxRunes int  // the max characters of each part with its marker, 0 for no limit
+	Markdown bool // prefer the paragraph boundaries and keep the code fences balanced in every part
+}
+
+// Split returns the parts of the content with the "(1/3)" style markers, the content fitting the limit is returned as is
+func (s Splitter) Split(content string) []string {
+	if s.fits(content, 0) {
+		return []string{content}
+	}
+	// the marker grows with the count of the parts, so the content is cut again until the markers fit
+	for digits := 1; ; digits++ {
+		markerSize := len(partMarker(0, 0)) + 2*digits - 2
+		parts := s.cut(content, markerSize)
+		if len(strconv.Itoa(len(parts))) > digits {
+			continue
+		}
+		for i := range parts {
+			parts[i] += partMarker(i+1, len(parts))
+		}
+		return parts
+	}
+}
+
+// partMarker returns the marker of the index-th part of the total
+func partMarker(index, total int) string {
+	return fmt.Sprintf("\n(%d/%d)", index, total)
+}
+
+// fits check the content with the reserved ascii bytes against the limits
+func (s Splitter) fits(content string, reserve int) bool {
+	return (s.MaxBytes <= 0 || len(content)+reserve <= s.MaxBytes) &&
+		(s.MaxRunes <= 0 || utf8.RuneCountInString(content)+reserve <= s.MaxRunes)
+}
+
+// cut the content into the parts, each fitting the limit with the reserved ascii bytes of the marker
+func (s Splitter) cut(content string, reserve int) (parts []string) {
+	if s.Markdown {
+		reserve += len(fenceCloser)
+	}
+	rest := content
+	for rest != "" {
+		if s.fits(rest, reserve) {
+			parts = append(parts, rest)
+			break
+		}
+		window := s.window(rest, reserve)
+		cut, skip := s.boundary(window)
+		part, next := rest[:cut], rest[cut+skip:]
+		part = strings.TrimRight(part, "\n")
+		next = strings.TrimLeft(next, "\n")
+		if s.Markdown {
+			// the code fence open at the cut is closed in this part and opened again in the next one
+			if opener, open := openFence(part); open && strings.TrimSpace(part) != opener {
+				part += fenceCloser
+				next = opener + "\n" + next
+			}
+		}
+		parts = append(parts, part)
+		rest = next
+	}
+	return
+}
+
+// window returns the longest prefix of the content fitting the limit with the reserved bytes, at least one rune
+func (s Splitter) window(content string, reserve int) string {
+	return fitPrefix(content, s.MaxBytes, s.MaxRunes, reserve, 1)
+}
+
+// fitPrefix returns the longest prefix of the content on the rune boundaries fitting the limits with the reserved
+// ascii bytes, but at least the min runes
+func fitPrefix(content string, maxBytes, maxRunes, reserve, minRunes int) string {
+	size, runes := 0, 0
+	for i, r := range content {
+		runeSize := utf8.RuneLen(r)
+		if runeSize < 0 {
+			runeSize = 1
+		}
+		if runes >= minRunes && ((maxBytes > 0 && size+runeSize+reserve > maxBytes) || (maxRunes > 0 && runes+1+reserve > maxRunes)) {
+			return content[:i]
+		}
+		size += runeSize
+		runes++
+	}
+	return content
+}
+
+// boundary returns where to cut the window and the size of the separator dropped there, the boundaries
+// in the second half of the window are preferred: the paragraphs and the lines outside the code fences
+// for markdown, then any line, then any space, and the end of the window at last
+func (s Splitter) boundary(window string) (cut, skip int) {
+	half := len(window) / 2
+	if s.Markdown {
+		if cut = lastBoundary(window, "\n\n", half, true); cut > 0 {
+			return cut, 2
+		}
+		if cut = lastBoundary(window, "\n", half, true); cut > 0 {
+			return cut, 1
+		}
+	}
+	if cut = lastBoundary(window, "\n", half, false); cut > 0 {
+		return cut, 1
+	}
+	if cut = lastBoundary(window, " ", half, false); cut > 0 {
+		return cut, 1
+	}
+	return len(window), 0
+}
+
+// lastBoundary returns the last index of the separator after the from index, outside the code fences if asked, 0 if none
+func lastBoundary(window, separator string, from int, outsideFence bool) int {
+	for end := len(window); end > from; {
+		i := strings.LastIndex(window[:end], separator)
+		if i <= from {
+			return 0
+		}
+		if _, open := openFence(window[:i]); !outsideFence || !open {
+			return i
+		}
+		end = i
+	}
+	return 0
+}
+
+// openFence returns the opener line of the code fence left open at the end of the markdown
+func openFence(markdown string) (opener string, open bool) {
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if !strings.HasPrefix(trimmed, "```") {
+			continue
+		}
+		if open {
+			opener, open = "", false
+		} else {
+			opener, open = trimmed, true
+		}
+	}
+	return
+}
+
+// Reserve returns the splitter leaving room in every part for the prefix added later, e.g. the banner of the recipient policy
+func (s Splitter) Reserve(prefix string) Splitter {
+	if s.MaxBytes > 0 {
+		s.MaxBytes -= len(prefix)
+	}
+	if s.MaxRunes > 0 {
+		s.MaxRunes -= utf8.RuneCountInString(prefix)
+	}
+	return s
+}
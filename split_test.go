@@ -0,0 +1,80 @@
+package chatapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitter_Text(t *testing.T) {
+	splitter := Splitter{MaxBytes: 30}
+	if parts := splitter.Split("hello, master"); !reflect.DeepEqual(parts, []string{"hello, master"}) {
+		t.Fatalf("expect the short content kept, got %q", parts)
+	}
+	parts := splitter.Split("first line of the log\nsecond line of the log\nthird line")
+	expect := []string{"first line of the log\n(1/3)", "second line of the log\n(2/3)", "third line\n(3/3)"}
+	if !reflect.DeepEqual(parts, expect) {
+		t.Fatalf("unexpected parts %q", parts)
+	}
+
+	// the chinese text without any space is cut on the rune boundaries
+	content := strings.Repeat("人民万岁", 10)
+	parts = splitter.Split(content)
+	var joined string
+	for _, part := range parts {
+		if len(part) > 30 || !utf8.ValidString(part) {
+			t.Fatalf("unexpected part %q", part)
+		}
+		joined += part[:strings.LastIndex(part, "\n(")]
+	}
+	if joined != content {
+		t.Fatalf("expect the parts joined to the content, got %q", joined)
+	}
+
+	splitter = Splitter{MaxRunes: 12}
+	for _, part := range splitter.Split(content) {
+		if utf8.RuneCountInString(part) > 12 {
+			t.Fatalf("expect the part within 12 characters, got %q", part)
+		}
+	}
+}
+
+func TestSplitter_Markdown(t *testing.T) {
+	splitter := Splitter{MaxBytes: 60, Markdown: true}
+	content := "# build failed\n\nthe step test failed\n\n```\nline 1\nline 2\nline 3\nline 4\nline 5\nline 6\nline 7\nline 8\nline 9\n```\n\ndone"
+	parts := splitter.Split(content)
+	for i, part := range parts {
+		if len(part) > 60 {
+			t.Fatalf("expect the part within 60 bytes, got %q", part)
+		}
+		if strings.Count(part, "```")%2 != 0 {
+			t.Fatalf("expect the code fence balanced in part %d, got %q", i, part)
+		}
+	}
+	expect := []string{
+		"# build failed\n\nthe step test failed\n(1/3)",
+		"```\nline 1\nline 2\nline 3\nline 4\nline 5\nline 6\n```\n(2/3)",
+		"```\nline 7\nline 8\nline 9\n```\n\ndone\n(3/3)",
+	}
+	if !reflect.DeepEqual(parts, expect) {
+		t.Fatalf("expect the code fence closed at the cut and opened again, got %q", parts)
+	}
+}
+
+func TestSplitter_MarkerDigits(t *testing.T) {
+	splitter := Splitter{MaxBytes: 12}
+	parts := splitter.Split(strings.Repeat("a", 60))
+	if len(parts) < 10 {
+		t.Fatalf("expect more than 9 parts, got %d", len(parts))
+	}
+	for _, part := range parts {
+		if len(part) > 12 {
+			t.Fatalf("expect the part with the 2 digits marker within 12 bytes, got %q", part)
+		}
+	}
+	if first := parts[0]; !strings.HasSuffix(first, fmt.Sprintf("\n(1/%d)", len(parts))) {
+		t.Fatalf("unexpected first part %q", first)
+	}
+}
@@ -0,0 +1,202 @@
+package chatapi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultTokenRefreshMargin is how long before the expiry the access token is refreshed
+const DefaultTokenRefreshMargin = time.Minute * 5
+
+// TokenFetcher fetch a new access token and its lifetime from the platform
+type TokenFetcher func(ctx context.Context) (accessToken string, expiresIn time.Duration, err error)
+
+// TokenManager caches the access token of an app, it is safe for concurrent use.
+// The token is refreshed ahead of the expiry, and the concurrent refreshes are coalesced into one fetch.
+type TokenManager struct {
+	fetch  TokenFetcher
+	margin time.Duration
+	store  TokenStore // optional store sharing the token with the other processes
+	key    string
+
+	lock      sync.Mutex
+	token     string
+	expiredAt time.Time
+	refreshAt time.Time     // time to refresh the token ahead of the expiry
+	inflight  *tokenRefresh // the refresh in progress shared by the concurrent callers
+	rejected  string        // the token rejected by the server, never reloaded from the store
+}
+
+type tokenRefresh struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// NewTokenManager create a token manager refreshing the token with the default margin
+func NewTokenManager(fetch TokenFetcher) *TokenManager {
+	return NewTokenManagerWithMargin(fetch, DefaultTokenRefreshMargin)
+}
+
+// NewTokenManagerWithMargin create a token manager refreshing the token the margin before the expiry
+func NewTokenManagerWithMargin(fetch TokenFetcher, margin time.Duration) *TokenManager {
+	return &TokenManager{fetch: fetch, margin: margin}
+}
+
+// NewTokenManagerWithStore create a token manager sharing the token of the key through the store,
+// the store is consulted before fetching and only the lease holder fetches a new token
+func NewTokenManagerWithStore(fetch TokenFetcher, store TokenStore, key string) *TokenManager {
+	return &TokenManager{fetch: fetch, margin: DefaultTokenRefreshMargin, store: store, key: key}
+}
+
+// Token returns the cached token, or fetches a new one if it is missing or about to expire.
+// If the early refresh fails, the cached token is still returned until it really expires.
+func (m *TokenManager) Token(ctx context.Context) (token string, err error) {
+	m.lock.Lock()
+	now := time.Now()
+	current := m.token
+	if current != "" && now.Before(m.refreshAt) {
+		m.lock.Unlock()
+		return current, nil
+	}
+	valid := current != "" && now.Before(m.expiredAt)
+	m.lock.Unlock()
+
+	token, err = m.Refresh(ctx)
+	if err != nil && valid {
+		return current, nil
+	}
+	return
+}
+
+// Refresh fetch a new token, the callers arriving during a refresh wait for its result
+func (m *TokenManager) Refresh(ctx context.Context) (token string, err error) {
+	m.lock.Lock()
+	if call := m.inflight; call != nil {
+		m.lock.Unlock()
+		select {
+		case <-call.done:
+			return call.token, call.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	call := &tokenRefresh{done: make(chan struct{})}
+	m.inflight = call
+	rejected := m.rejected
+	m.lock.Unlock()
+
+	token, expiredAt, err := m.obtain(ctx, rejected)
+
+	m.lock.Lock()
+	if err == nil {
+		m.setToken(token, expiredAt)
+		m.rejected = ""
+	}
+	m.inflight = nil
+	m.lock.Unlock()
+
+	call.token, call.err = token, err
+	close(call.done)
+	return
+}
+
+// Invalidate drop the cached token if it is still the given one, e.g. after the server rejects it
+func (m *TokenManager) Invalidate(token string) {
+	m.lock.Lock()
+	if m.token == token {
+		m.rejected = token
+		m.token = ""
+		m.expiredAt = time.Time{}
+		m.refreshAt = time.Time{}
+	}
+	m.lock.Unlock()
+}
+
+// Current returns the cached token and its expire time for diagnostics
+func (m *TokenManager) Current() (token string, expiredAt time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.token, m.expiredAt
+}
+
+// IsExpired report whether there is no cached token or it has expired
+func (m *TokenManager) IsExpired() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.token == "" || !time.Now().Before(m.expiredAt)
+}
+
+// obtain returns a fresh token, from the store if another process has refreshed it, or from the platform
+func (m *TokenManager) obtain(ctx context.Context, rejected string) (token string, expiredAt time.Time, err error) {
+	if m.store == nil {
+		return m.fetchToken(ctx)
+	}
+	if token, expiredAt, ok := m.load(ctx, rejected); ok {
+		return token, expiredAt, nil
+	}
+	for {
+		unlock, acquired, lockErr := m.store.TryLock(ctx, m.key, TokenLeaseTTL)
+		if lockErr != nil {
+			// the store is unavailable, fetch without the lease rather than failing the call
+			return m.fetchToken(ctx)
+		}
+		if acquired {
+			defer unlock()
+			// the previous lease holder may have saved the token just now
+			if token, expiredAt, ok := m.load(ctx, rejected); ok {
+				return token, expiredAt, nil
+			}
+			token, expiredAt, err = m.fetchToken(ctx)
+			if err == nil {
+				// a failed save only costs the other processes an extra fetch
+				m.store.Save(ctx, m.key, StoredToken{AccessToken: token, ExpiredAt: expiredAt})
+			}
+			return
+		}
+		// another process is refreshing, wait for the token it saves
+		select {
+		case <-ctx.Done():
+			return "", time.Time{}, ctx.Err()
+		case <-time.After(tokenLeasePollInterval):
+		}
+		if token, expiredAt, ok := m.load(ctx, rejected); ok {
+			return token, expiredAt, nil
+		}
+	}
+}
+
+// tokenLeasePollInterval is how often the store is checked while another process holds the lease
+const tokenLeasePollInterval = time.Millisecond * 100
+
+func (m *TokenManager) load(ctx context.Context, rejected string) (token string, expiredAt time.Time, ok bool) {
+	stored, err := m.store.Load(ctx, m.key)
+	if err != nil || stored.AccessToken == "" || stored.AccessToken == rejected {
+		return
+	}
+	if time.Until(stored.ExpiredAt) <= m.margin {
+		return
+	}
+	return stored.AccessToken, stored.ExpiredAt, true
+}
+
+func (m *TokenManager) fetchToken(ctx context.Context) (token string, expiredAt time.Time, err error) {
+	token, expiresIn, err := m.fetch(ctx)
+	if err != nil {
+		return
+	}
+	expiredAt = time.Now().Add(expiresIn)
+	return
+}
+
+func (m *TokenManager) setToken(token string, expiredAt time.Time) {
+	// never spend more than half of the lifetime in the refresh window
+	margin := m.margin
+	if lifetime := time.Until(expiredAt); margin > lifetime/2 {
+		margin = lifetime / 2
+	}
+	m.token = token
+	m.expiredAt = expiredAt
+	m.refreshAt = m.expiredAt.Add(-margin)
+}
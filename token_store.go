@@ -0,0 +1,187 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TokenLeaseTTL is how long a process can hold the lease to refresh the shared token
+const TokenLeaseTTL = time.Second * 30
+
+// StoredToken is the access token saved in the TokenStore
+type StoredToken struct {
+	AccessToken string    `json:"access_token"`
+	ExpiredAt   time.Time `json:"expired_at"`
+}
+
+// TokenStore shares the access tokens between processes, so only one of them calls the token api.
+//
+// A redis store maps Load and Save to GET and SET with the PXAT expiry, and TryLock to
+// SET key:lock owner NX PX ttl, the unlock function deletes the lock only if it still holds the owner.
+type TokenStore interface {
+	// Load returns the stored token of the key, the zero StoredToken if not found
+	Load(ctx context.Context, key string) (StoredToken, error)
+	// Save stores the token of the key until it expires
+	Save(ctx context.Context, key string, token StoredToken) error
+	// TryLock acquires the refresh lease of the key without blocking, the lease is
+	// released by calling unlock or when the ttl passes
+	TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(), acquired bool, err error)
+}
+
+// MemoryTokenStore keeps the tokens in memory, it shares the tokens between the clients of one process
+type MemoryTokenStore struct {
+	lock   sync.Mutex
+	tokens map[string]StoredToken
+	leases map[string]memoryLease
+	owners uint64
+}
+
+type memoryLease struct {
+	owner     uint64
+	expiredAt time.Time
+}
+
+// NewMemoryTokenStore create a new memory token store
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string]StoredToken), leases: make(map[string]memoryLease)}
+}
+
+// Load returns the stored token of the key
+func (s *MemoryTokenStore) Load(ctx context.Context, key string) (StoredToken, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tokens[key], nil
+}
+
+// Save stores the token of the key
+func (s *MemoryTokenStore) Save(ctx context.Context, key string, token StoredToken) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokens[key] = token
+	return nil
+}
+
+// TryLock acquires the refresh lease of the key
+func (s *MemoryTokenStore) TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(), acquired bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if lease, exists := s.leases[key]; exists && time.Now().Before(lease.expiredAt) {
+		return nil, false, nil
+	}
+	s.owners++
+	owner := s.owners
+	s.leases[key] = memoryLease{owner: owner, expiredAt: time.Now().Add(ttl)}
+	unlock = func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.leases[key].owner == owner {
+			delete(s.leases, key)
+		}
+	}
+	return unlock, true, nil
+}
+
+// FileTokenStore keeps the tokens as json files in a directory, it shares the tokens between
+// the processes on the same host or a shared volume
+type FileTokenStore struct {
+	dir string
+}
+
+var fileTokenKeyReplacer = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+var fileTokenLeaseOwner uint64
+
+// NewFileTokenStore create a file token store in the dir, the dir is created if not exists
+func NewFileTokenStore(dir string) (*FileTokenStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create token store dir error, %w", err)
+	}
+	return &FileTokenStore{dir: dir}, nil
+}
+
+// Load returns the stored token of the key
+func (s *FileTokenStore) Load(ctx context.Context, key string) (token StoredToken, err error) {
+	data, readErr := os.ReadFile(s.path(key, ".json"))
+	if readErr != nil {
+		if errors.Is(readErr, os.ErrNotExist) {
+			return
+		}
+		err = fmt.Errorf("read token file error, %w", readErr)
+		return
+	}
+	if decodeErr := json.Unmarshal(data, &token); decodeErr != nil {
+		err = fmt.Errorf("parse token file error, %w", decodeErr)
+	}
+	return
+}
+
+// Save stores the token of the key, the file is replaced atomically
+func (s *FileTokenStore) Save(ctx context.Context, key string, token StoredToken) (err error) {
+	data, _ := json.Marshal(&token)
+	tmpFile, createErr := os.CreateTemp(s.dir, ".token-*")
+	if createErr != nil {
+		return fmt.Errorf("create token file error, %w", createErr)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, writeErr := tmpFile.Write(data); writeErr != nil {
+		tmpFile.Close()
+		return fmt.Errorf("write token file error, %w", writeErr)
+	}
+	if closeErr := tmpFile.Close(); closeErr != nil {
+		return fmt.Errorf("write token file error, %w", closeErr)
+	}
+	if renameErr := os.Rename(tmpFile.Name(), s.path(key, ".json")); renameErr != nil {
+		return fmt.Errorf("write token file error, %w", renameErr)
+	}
+	return
+}
+
+// TryLock acquires the refresh lease of the key by creating the lock file exclusively,
+// the lock file left by a crashed process is reclaimed after the ttl
+func (s *FileTokenStore) TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(), acquired bool, err error) {
+	lockPath := s.path(key, ".lock")
+	if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > ttl {
+		reclaimFileLock(lockPath, ttl)
+	}
+	lockFile, openErr := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if openErr != nil {
+		if errors.Is(openErr, os.ErrExist) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("create lock file error, %w", openErr)
+	}
+	owner := fmt.Sprintf("%d-%d", os.Getpid(), atomic.AddUint64(&fileTokenLeaseOwner, 1))
+	lockFile.WriteString(owner)
+	lockFile.Close()
+	unlock = func() {
+		if data, _ := os.ReadFile(lockPath); string(data) == owner {
+			os.Remove(lockPath)
+		}
+	}
+	return unlock, true, nil
+}
+
+// reclaimFileLock remove the stale lock file. The file is renamed to a unique name first so only one process takes
+// it, and its mtime is checked again in case the stale lock was replaced by a fresh one after the stat, the fresh
+// lock is put back by the link failing if another lock is created meanwhile.
+func reclaimFileLock(lockPath string, ttl time.Duration) {
+	stalePath := fmt.Sprintf("%s.%d-%d", lockPath, os.Getpid(), atomic.AddUint64(&fileTokenLeaseOwner, 1))
+	if os.Rename(lockPath, stalePath) != nil {
+		return
+	}
+	if info, statErr := os.Stat(stalePath); statErr == nil && time.Since(info.ModTime()) <= ttl {
+		os.Link(stalePath, lockPath)
+	}
+	os.Remove(stalePath)
+}
+
+func (s *FileTokenStore) path(key, ext string) string {
+	return filepath.Join(s.dir, fileTokenKeyReplacer.ReplaceAllString(key, "_")+ext)
+}
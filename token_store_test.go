@@ -0,0 +1,136 @@
+package chatapi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryTokenStore_Lease(t *testing.T) {
+	store := NewMemoryTokenStore()
+	ctx := context.Background()
+	unlock, acquired, _ := store.TryLock(ctx, "app", time.Minute)
+	if !acquired {
+		t.Fatal("expect the lease acquired")
+	}
+	if _, acquired, _ := store.TryLock(ctx, "app", time.Minute); acquired {
+		t.Fatal("expect the lease held by the first owner")
+	}
+	unlock()
+	if _, acquired, _ := store.TryLock(ctx, "app", time.Millisecond); !acquired {
+		t.Fatal("expect the lease acquired after unlock")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, acquired, _ := store.TryLock(ctx, "app", time.Minute); !acquired {
+		t.Fatal("expect the expired lease taken over")
+	}
+}
+
+func TestFileTokenStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileTokenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if token, err := store.Load(ctx, "wxwork:corp:1000002"); err != nil || token.AccessToken != "" {
+		t.Fatalf("expect no token, got %v, %v", token, err)
+	}
+	expiredAt := time.Now().Add(time.Hour).Round(time.Second)
+	if err := store.Save(ctx, "wxwork:corp:1000002", StoredToken{AccessToken: "token", ExpiredAt: expiredAt}); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := store.Load(ctx, "wxwork:corp:1000002"); err != nil || token.AccessToken != "token" || !token.ExpiredAt.Equal(expiredAt) {
+		t.Fatalf("unexpected token %v, %v", token, err)
+	}
+
+	unlock, acquired, err := store.TryLock(ctx, "wxwork:corp:1000002", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("expect the lease acquired, %v", err)
+	}
+	if _, acquired, _ := store.TryLock(ctx, "wxwork:corp:1000002", time.Minute); acquired {
+		t.Fatal("expect the lease held by the first owner")
+	}
+	unlock()
+	// the lock file left by a crashed process is taken over after the ttl
+	lockPath := filepath.Join(dir, "wxwork_corp_1000002.lock")
+	os.WriteFile(lockPath, []byte("crashed"), 0600)
+	os.Chtimes(lockPath, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	if _, acquired, _ := store.TryLock(ctx, "wxwork:corp:1000002", time.Minute); !acquired {
+		t.Fatal("expect the stale lease taken over")
+	}
+}
+
+func TestFileTokenStore_ReclaimLock(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileTokenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockPath := filepath.Join(dir, "app.lock")
+	// the fresh lock replacing the stale one after the stat is put back
+	os.WriteFile(lockPath, []byte("fresh"), 0600)
+	reclaimFileLock(lockPath, time.Minute)
+	if data, _ := os.ReadFile(lockPath); string(data) != "fresh" {
+		t.Fatalf("expect the fresh lock kept, got %q", data)
+	}
+	// the stale lock is taken over by only one of the processes
+	os.Chtimes(lockPath, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	var acquired int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, _ := store.TryLock(context.Background(), "app", time.Minute); ok {
+				atomic.AddInt32(&acquired, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if entries, _ := os.ReadDir(dir); acquired != 1 || len(entries) != 1 {
+		t.Fatalf("expect the stale lease taken over once, got %d acquired and %d files", acquired, len(entries))
+	}
+}
+
+func TestTokenManager_SharedStore(t *testing.T) {
+	store := NewMemoryTokenStore()
+	var fetches int32
+	fetch := func(ctx context.Context) (string, time.Duration, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		time.Sleep(20 * time.Millisecond)
+		return fmt.Sprintf("token-%d", n), time.Hour, nil
+	}
+	// each manager plays a process sharing the store
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		manager := NewTokenManagerWithStore(fetch, store, "app")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := manager.Token(context.Background()); err != nil || token != "token-1" {
+				t.Errorf("unexpected token %s, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Fatalf("expect 1 fetch, got %d", fetches)
+	}
+
+	// the rejected token is not reloaded from the store
+	manager := NewTokenManagerWithStore(fetch, store, "app")
+	token, _ := manager.Token(context.Background())
+	manager.Invalidate(token)
+	if token, _ := manager.Token(context.Background()); token != "token-2" {
+		t.Fatalf("expect a new token after the rejection, got %s", token)
+	}
+	if stored, _ := store.Load(context.Background(), "app"); stored.AccessToken != "token-2" {
+		t.Fatalf("expect the new token saved, got %s", stored.AccessToken)
+	}
+}
@@ -0,0 +1,92 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenManager_CoalesceRefresh(t *testing.T) {
+	var fetches int32
+	manager := NewTokenManager(func(ctx context.Context) (string, time.Duration, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		time.Sleep(20 * time.Millisecond)
+		return fmt.Sprintf("token-%d", n), time.Hour, nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := manager.Token(context.Background()); err != nil || token != "token-1" {
+				t.Errorf("unexpected token %s, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Fatalf("expect 1 fetch, got %d", fetches)
+	}
+	if token, expiredAt := manager.Current(); token != "token-1" || time.Until(expiredAt) < 59*time.Minute {
+		t.Fatalf("unexpected current token %s expired at %v", token, expiredAt)
+	}
+}
+
+func TestTokenManager_RefreshAhead(t *testing.T) {
+	var fetches int
+	var fetchErr error
+	manager := NewTokenManagerWithMargin(func(ctx context.Context) (string, time.Duration, error) {
+		fetches++
+		if fetchErr != nil {
+			return "", 0, fetchErr
+		}
+		return fmt.Sprintf("token-%d", fetches), time.Hour, nil
+	}, time.Hour)
+	// the margin is clamped to half of the lifetime, so the token is refreshed but still valid
+	manager.Token(context.Background())
+	manager.lock.Lock()
+	manager.refreshAt = time.Now().Add(-time.Second)
+	manager.lock.Unlock()
+	if token, _ := manager.Token(context.Background()); token != "token-2" {
+		t.Fatalf("expect the token refreshed ahead, got %s", token)
+	}
+
+	// the early refresh failure keeps the valid token
+	fetchErr = errors.New("system busy")
+	manager.lock.Lock()
+	manager.refreshAt = time.Now().Add(-time.Second)
+	manager.lock.Unlock()
+	if token, err := manager.Token(context.Background()); err != nil || token != "token-2" {
+		t.Fatalf("expect the cached token, got %s, %v", token, err)
+	}
+
+	// no token to fallback after invalidated
+	manager.Invalidate("token-2")
+	if _, err := manager.Token(context.Background()); !errors.Is(err, fetchErr) {
+		t.Fatalf("expect the fetch error, got %v", err)
+	}
+	if !manager.IsExpired() {
+		t.Fatal("expect the token expired")
+	}
+}
+
+func TestTokenManager_WaitCanceled(t *testing.T) {
+	release := make(chan struct{})
+	manager := NewTokenManager(func(ctx context.Context) (string, time.Duration, error) {
+		<-release
+		return "token", time.Hour, nil
+	})
+	go manager.Token(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := manager.Token(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	close(release)
+}
@@ -0,0 +1,135 @@
+package chatapi
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The suffixes of the truncated content
+const (
+	TruncateEllipsis  = "…"
+	TruncateMoreLabel = "view more"
+)
+
+// truncateCloserReserve is the room kept for the closers of the markdown constructs left open by the cut,
+// the longest being the code fence closer or the inline code, bold and strikethrough closers together
+const truncateCloserReserve = 5
+
+// WithTruncation cut the text and markdown content over the limit of the platform instead of rejecting the message,
+// the content ends with an ellipsis and a link to the full content if the more url is not empty
+func WithTruncation(moreURL string) Option {
+	return func(o *Options) {
+		o.TruncateLongMessages = true
+		o.TruncateMoreURL = moreURL
+	}
+}
+
+// Truncator cut the long content to the limit of a message, the cut is on the rune boundaries
+type Truncator struct {
+	MaxBytes  int    // the max utf-8 bytes of the content with the suffixes, 0 for no limit
+	MaxRunes  int    // the max characters of the content with the suffixes, 0 for no limit
+	Markdown  bool   // close the code fence, inline code, bold and strikethrough left open and drop the link cut in half
+	MoreURL   string // the link to the full content appended after the ellipsis, empty for none
+	MoreLabel string // the text of the link, TruncateMoreLabel if empty
+}
+
+// Truncate returns the content cut to the limit with the ellipsis and the more link, the content fitting the limit is returned as is
+func (t Truncator) Truncate(content string) string {
+	if t.fits(content) {
+		return content
+	}
+	suffix := t.suffix()
+	reserve := utf8.RuneCountInString(suffix)
+	if t.MaxBytes > 0 {
+		reserve = len(suffix)
+	}
+	if t.Markdown {
+		reserve += truncateCloserReserve
+	}
+	truncated := fitPrefix(content, t.MaxBytes, t.MaxRunes, reserve, 0)
+	if t.Markdown {
+		truncated = closeMarkdown(truncated)
+	}
+	return truncated + suffix
+}
+
+// Reserve returns the truncator leaving room in the content for the prefix added later, e.g. the banner of the recipient policy
+func (t Truncator) Reserve(prefix string) Truncator {
+	if t.MaxBytes > 0 {
+		t.MaxBytes -= len(prefix)
+	}
+	if t.MaxRunes > 0 {
+		t.MaxRunes -= utf8.RuneCountInString(prefix)
+	}
+	return t
+}
+
+func (t Truncator) fits(content string) bool {
+	return (t.MaxBytes <= 0 || len(content) <= t.MaxBytes) && (t.MaxRunes <= 0 || utf8.RuneCountInString(content) <= t.MaxRunes)
+}
+
+// suffix returns the ellipsis and the more link in the markdown or the plain text
+func (t Truncator) suffix() string {
+	if t.MoreURL == "" {
+		return TruncateEllipsis
+	}
+	label := t.MoreLabel
+	if label == "" {
+		label = TruncateMoreLabel
+	}
+	if t.Markdown {
+		return TruncateEllipsis + "\n[" + label + "](" + t.MoreURL + ")"
+	}
+	return TruncateEllipsis + "\n" + label + ": " + t.MoreURL
+}
+
+// closeMarkdown close the markdown constructs left open at the end of the cut content
+func closeMarkdown(markdown string) string {
+	// the markup cut in half at the end is dropped, and closed again below if it was a closer
+	markdown = strings.TrimRight(markdown, " \t\n*~`")
+	if _, open := openFence(markdown); open {
+		return markdown + fenceCloser
+	}
+	markdown = dropCutLink(markdown)
+	// the inline constructs do not span the paragraphs, and the code fences are closed before the last paragraph
+	paragraph := markdown
+	if i := strings.LastIndex(markdown, "\n\n"); i >= 0 {
+		paragraph = markdown[i:]
+	}
+	if i := strings.LastIndex(paragraph, "```"); i >= 0 {
+		paragraph = paragraph[i+3:]
+	}
+	var closers string
+	if strings.Count(paragraph, "`")%2 == 1 {
+		closers = "`"
+		// the bold and strikethrough markers in the open inline code are literal
+		paragraph = paragraph[:strings.LastIndex(paragraph, "`")]
+	}
+	// the construct opened last is closed first
+	bold, strikethrough := strings.Count(paragraph, "**")%2 == 1, strings.Count(paragraph, "~~")%2 == 1
+	switch {
+	case bold && strikethrough && strings.LastIndex(paragraph, "**") > strings.LastIndex(paragraph, "~~"):
+		closers += "**~~"
+	case bold && strikethrough:
+		closers += "~~**"
+	case bold:
+		closers += "**"
+	case strikethrough:
+		closers += "~~"
+	}
+	return markdown + closers
+}
+
+// dropCutLink returns the markdown without the link or image cut in half at the end
+func dropCutLink(markdown string) string {
+	i := strings.LastIndex(markdown, "[")
+	if i < 0 {
+		return markdown
+	}
+	rest := markdown[i:]
+	target := strings.Index(rest, "](")
+	if strings.Contains(rest, "]") && (target < 0 || strings.Contains(rest[target:], ")")) {
+		return markdown
+	}
+	return strings.TrimRight(strings.TrimSuffix(markdown[:i], "!"), " \t\n")
+}
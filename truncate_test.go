@@ -0,0 +1,56 @@
+package chatapi
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncator_Text(t *testing.T) {
+	truncator := Truncator{MaxBytes: 20}
+	if truncated := truncator.Truncate("hello, master"); truncated != "hello, master" {
+		t.Fatalf("expect the short content kept, got %q", truncated)
+	}
+	// 5 chinese characters of 3 bytes and the ellipsis of 3 bytes
+	truncated := truncator.Truncate("人民万岁人民万岁")
+	if truncated != "人民万岁人"+TruncateEllipsis || !utf8.ValidString(truncated) {
+		t.Fatalf("expect the cut on the rune boundary, got %q", truncated)
+	}
+
+	truncator = Truncator{MaxBytes: 60, MoreURL: "https://ci.example.com/1"}
+	truncated = truncator.Truncate(strings.Repeat("日志", 20))
+	if len(truncated) > 60 || !strings.HasSuffix(truncated, "…\nview more: https://ci.example.com/1") {
+		t.Fatalf("unexpected truncated content %q", truncated)
+	}
+
+	truncator = Truncator{MaxRunes: 5, MoreLabel: "更多"}
+	if truncated = truncator.Truncate("人民万岁人民万岁"); truncated != "人民万岁"+TruncateEllipsis {
+		t.Fatalf("expect the content cut to 5 characters, got %q", truncated)
+	}
+}
+
+func TestTruncator_Markdown(t *testing.T) {
+	cases := []struct {
+		content string
+		expect  string
+	}{
+		{"# report\n```\nline 1\nline 2\nline 3\nline 4\n```", "# report\n```\nline 1\nline 2\n```…"},
+		{"# report\n\nrun `go test ./...` again", "# report\n\nrun `go test ./.`…"},
+		{"# report\n\n**failed step** is the test", "# report\n\n**failed step**…"},
+		{"# report\n\nthe **failed step is the test", "# report\n\nthe **failed ste**…"},
+		{"# report\n\nsee ~~the **old** log~~ the log", "# report\n\nsee ~~the **old**~~…"},
+		{"# report\n\nsee [the log](https://ci.example.com/1)", "# report\n\nsee…"},
+	}
+	for _, c := range cases {
+		truncator := Truncator{MaxBytes: 34, Markdown: true}
+		if truncated := truncator.Truncate(c.content); truncated != c.expect || len(truncated) > 34 {
+			t.Fatalf("expect %q truncated to %q, got %q", c.content, c.expect, truncated)
+		}
+	}
+
+	truncator := Truncator{MaxRunes: 40, Markdown: true, MoreURL: "https://ci.example.com/1"}
+	truncated := truncator.Truncate(strings.Repeat("构建失败，", 20))
+	if utf8.RuneCountInString(truncated) > 40 || !strings.HasSuffix(truncated, "…\n[view more](https://ci.example.com/1)") {
+		t.Fatalf("unexpected truncated content %q", truncated)
+	}
+}
@@ -0,0 +1,237 @@
+package chatapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidMessage is matched by errors.Is on every ValidationError
+var ErrInvalidMessage = errors.New("invalid message")
+
+// The rules a field of the message may break
+const (
+	RuleRequired = "required"
+	RuleMaxBytes = "max_bytes"
+	RuleMaxRunes = "max_runes"
+	RuleMinItems = "min_items"
+	RuleMaxItems = "max_items"
+	RuleURL      = "url"
+)
+
+// FieldRule is the platform limit of a field of the message
+type FieldRule struct {
+	Field        string   // the dotted json path, * for every item of an array or object, e.g. news.articles.*.url
+	Required     bool     // the field must not be empty, any of the alternatives is enough if set
+	Alternatives []string // the json paths which may be set instead of the required field, e.g. the other recipients
+	MaxBytes     int      // the max utf-8 bytes of the string, 0 for no limit
+	MaxRunes     int      // the max characters of the string, 0 for no limit
+	MinItems     int      // the min items of the array, 0 for no limit
+	MaxItems     int      // the max items of the array, 0 for no limit
+	URL          bool     // the string must be an absolute http or https url if set
+}
+
+// FieldError is a field of the message breaking a rule
+type FieldError struct {
+	Field  string // the json path of the field, e.g. news.articles.8.url
+	Rule   string // the broken rule, see the Rule constants
+	Limit  int    // the limit of the size and count rules
+	Actual int    // the actual size or count of the field
+}
+
+func (e FieldError) Error() string {
+	switch e.Rule {
+	case RuleRequired:
+		return fmt.Sprintf("%s is required", e.Field)
+	case RuleMaxBytes:
+		return fmt.Sprintf("%s exceeds %d bytes, got %d", e.Field, e.Limit, e.Actual)
+	case RuleMaxRunes:
+		return fmt.Sprintf("%s exceeds %d characters, got %d", e.Field, e.Limit, e.Actual)
+	case RuleMinItems:
+		return fmt.Sprintf("%s needs at least %d items, got %d", e.Field, e.Limit, e.Actual)
+	case RuleMaxItems:
+		return fmt.Sprintf("%s exceeds %d items, got %d", e.Field, e.Limit, e.Actual)
+	case RuleURL:
+		return fmt.Sprintf("%s is not an http or https url", e.Field)
+	}
+	return fmt.Sprintf("%s breaks the rule %s", e.Field, e.Rule)
+}
+
+// ValidationError is returned before any request when the message breaks the limits of the platform
+type ValidationError struct {
+	Provider    string       // the platform, see the Provider constants
+	MessageType string       // the msgtype of the message
+	Fields      []FieldError // the broken rules in the order of the rules
+}
+
+func (e *ValidationError) Error() string {
+	fieldErrs := make([]string, 0, len(e.Fields))
+	for _, fieldErr := range e.Fields {
+		fieldErrs = append(fieldErrs, fieldErr.Error())
+	}
+	return fmt.Sprintf("invalid %s %s message, %s", e.Provider, e.MessageType, strings.Join(fieldErrs, ", "))
+}
+
+// Is match the ErrInvalidMessage
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidMessage
+}
+
+// WithoutValidation send the messages without checking them against the limits of the platform first,
+// e.g. when the platform has raised a limit
+func WithoutValidation() Option {
+	return func(o *Options) {
+		o.SkipValidation = true
+	}
+}
+
+// ValidateMessage check the json payload of the message against the rules, the *ValidationError
+// lists every broken rule, nil if the message is valid
+func ValidateMessage(provider, messageType string, message interface{}, rules []FieldRule) error {
+	payload, err := toJSONValue(message)
+	if err != nil {
+		return fmt.Errorf("encode message error, %w", err)
+	}
+	validationErr := ValidationError{Provider: provider, MessageType: messageType}
+	for _, rule := range rules {
+		validationErr.Fields = append(validationErr.Fields, checkFieldRule(payload, rule)...)
+	}
+	if len(validationErr.Fields) > 0 {
+		return &validationErr
+	}
+	return nil
+}
+
+// toJSONValue returns the message as the decoded json value
+func toJSONValue(message interface{}) (value interface{}, err error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&value)
+	return
+}
+
+func checkFieldRule(payload interface{}, rule FieldRule) (fieldErrs []FieldError) {
+	for _, field := range lookupField(payload, rule.Field) {
+		if isEmptyJSONValue(field.value) {
+			if rule.Required && !hasAlternative(payload, rule.Alternatives) {
+				fieldErrs = append(fieldErrs, FieldError{Field: field.path, Rule: RuleRequired})
+			}
+			continue
+		}
+		switch value := field.value.(type) {
+		case string:
+			if size := len(value); rule.MaxBytes > 0 && size > rule.MaxBytes {
+				fieldErrs = append(fieldErrs, FieldError{Field: field.path, Rule: RuleMaxBytes, Limit: rule.MaxBytes, Actual: size})
+			}
+			if size := utf8.RuneCountInString(value); rule.MaxRunes > 0 && size > rule.MaxRunes {
+				fieldErrs = append(fieldErrs, FieldError{Field: field.path, Rule: RuleMaxRunes, Limit: rule.MaxRunes, Actual: size})
+			}
+			if rule.URL && !isHTTPURL(value) {
+				fieldErrs = append(fieldErrs, FieldError{Field: field.path, Rule: RuleURL})
+			}
+		case []interface{}:
+			if count := len(value); count < rule.MinItems {
+				fieldErrs = append(fieldErrs, FieldError{Field: field.path, Rule: RuleMinItems, Limit: rule.MinItems, Actual: count})
+			}
+			if count := len(value); rule.MaxItems > 0 && count > rule.MaxItems {
+				fieldErrs = append(fieldErrs, FieldError{Field: field.path, Rule: RuleMaxItems, Limit: rule.MaxItems, Actual: count})
+			}
+		}
+	}
+	return
+}
+
+// jsonField is a field found at a json path
+type jsonField struct {
+	path  string
+	value interface{}
+}
+
+// lookupField returns the fields at the dotted path, the * segment expands to every item of the array or object.
+// A missing field is returned with the nil value unless it is under a * segment.
+func lookupField(payload interface{}, path string) []jsonField {
+	fields := []jsonField{{value: payload}}
+	for _, segment := range strings.Split(path, ".") {
+		var next []jsonField
+		for _, field := range fields {
+			prefix := field.path
+			if prefix != "" {
+				prefix += "."
+			}
+			switch value := field.value.(type) {
+			case map[string]interface{}:
+				if segment != "*" {
+					next = append(next, jsonField{path: prefix + segment, value: value[segment]})
+					continue
+				}
+				keys := make([]string, 0, len(value))
+				for key := range value {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				for _, key := range keys {
+					next = append(next, jsonField{path: prefix + key, value: value[key]})
+				}
+			case []interface{}:
+				if segment != "*" {
+					if index, err := strconv.Atoi(segment); err == nil && index >= 0 && index < len(value) {
+						next = append(next, jsonField{path: prefix + segment, value: value[index]})
+					}
+					continue
+				}
+				for index, item := range value {
+					next = append(next, jsonField{path: prefix + strconv.Itoa(index), value: item})
+				}
+			default:
+				if segment != "*" {
+					next = append(next, jsonField{path: prefix + segment})
+				}
+			}
+		}
+		fields = next
+	}
+	return fields
+}
+
+func hasAlternative(payload interface{}, alternatives []string) bool {
+	for _, alternative := range alternatives {
+		for _, field := range lookupField(payload, alternative) {
+			if !isEmptyJSONValue(field.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isEmptyJSONValue check for the missing field, the empty string, array and object, and false
+func isEmptyJSONValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
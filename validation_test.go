@@ -0,0 +1,58 @@
+package chatapi
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateMessage(t *testing.T) {
+	rules := []FieldRule{
+		{Field: "touser", Required: true, Alternatives: []string{"toparty"}},
+		{Field: "text.content", Required: true, MaxBytes: 6},
+		{Field: "news.articles", MaxItems: 2},
+		{Field: "news.articles.*.title", Required: true, MaxRunes: 3},
+		{Field: "news.articles.*.url", URL: true},
+	}
+	message := map[string]interface{}{
+		"toparty": "2",
+		"text":    map[string]string{"content": "hello, master"},
+		"news": map[string]interface{}{"articles": []map[string]string{
+			{"title": "人民", "url": "https://oschina.net"},
+			{"title": "伟大的人民", "url": "oschina.net"},
+			{"url": "ftp://oschina.net"},
+		}},
+	}
+	err := ValidateMessage(ProviderWxWork, "text", message, rules)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expect the validation error, got %v", err)
+	}
+	expect := []FieldError{
+		{Field: "text.content", Rule: RuleMaxBytes, Limit: 6, Actual: 13},
+		{Field: "news.articles", Rule: RuleMaxItems, Limit: 2, Actual: 3},
+		{Field: "news.articles.1.title", Rule: RuleMaxRunes, Limit: 3, Actual: 5},
+		{Field: "news.articles.2.title", Rule: RuleRequired},
+		{Field: "news.articles.1.url", Rule: RuleURL},
+		{Field: "news.articles.2.url", Rule: RuleURL},
+	}
+	if validationErr.Provider != ProviderWxWork || validationErr.MessageType != "text" || !reflect.DeepEqual(validationErr.Fields, expect) {
+		t.Fatalf("unexpected validation error %+v", validationErr)
+	}
+	if !strings.Contains(err.Error(), "invalid wxwork text message, text.content exceeds 6 bytes, got 13") {
+		t.Fatalf("unexpected error message %s", err)
+	}
+
+	delete(message, "toparty")
+	message["text"] = map[string]string{"content": "hello"}
+	message["news"] = map[string]interface{}{"articles": []map[string]string{{"title": "人民"}}}
+	err = ValidateMessage(ProviderWxWork, "text", message, rules)
+	if !errors.As(err, &validationErr) || !reflect.DeepEqual(validationErr.Fields, []FieldError{{Field: "touser", Rule: RuleRequired}}) {
+		t.Fatalf("expect the missing recipient only, got %v", err)
+	}
+	message["touser"] = "jinxinxin001"
+	if err = ValidateMessage(ProviderWxWork, "text", message, rules); err != nil {
+		t.Fatal(err)
+	}
+}
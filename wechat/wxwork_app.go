@@ -2,7 +2,9 @@ package wechat
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,8 +13,9 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+
+	"github.com/duoland/chatapi"
 )
 
 // WxWorkAppGroupMessageAPI is the api to get the app access token
@@ -63,6 +66,18 @@ const (
 	WxWorkAppMessageTypeTaskCard          = "taskcard"
 )
 
+// wxWorkAppOperations is the logical operation of each api seen by the interceptors
+var wxWorkAppOperations = map[string]string{
+	WxWorkAppTokenAPI:        "WxWorkApp.GetAccessToken",
+	WxWorkAppUploadMediaAPI:  "WxWorkApp.UploadMedia",
+	WxWorkAppUploadImageAPI:  "WxWorkApp.UploadImage",
+	WxWorkAppMessageAPI:      "WxWorkApp.SendMessage",
+	WxWorkAppGroupMessageAPI: "WxWorkApp.SendGroupMessage",
+	WxWorkAppCreateGroupAPI:  "WxWorkApp.CreateGroupChat",
+	WxWorkAppUpdateGroupAPI:  "WxWorkApp.UpdateGroupChat",
+	WxWorkAppGetGroupAPI:     "WxWorkApp.GetGroupChat",
+}
+
 const (
 	WxWorkAppMediaTypeImage = "image"
 	WxWorkAppMediaTypeVoice = "voice"
@@ -85,6 +100,14 @@ type WxWorkAppMessageResp struct {
 	InvalidTag   string `json:"invalidtag"`
 }
 
+// wxWorkDryRunResp is the synthetic success response of the requests captured in the dry run
+const wxWorkDryRunResp = `{"errcode":0,"errmsg":"ok"}`
+
+type wxWorkStatusResp struct {
+	ErrCode    int    `json:"errcode"`
+	ErrMessage string `json:"errmsg"`
+}
+
 type WxWorkAppGroupMessageResp struct {
 	ErrCode    int    `json:"errcode"`
 	ErrMessage string `json:"errmsg"`
@@ -176,37 +199,57 @@ type WxWorkAppTaskCardMessageButton struct {
 }
 
 type WxWorkApp struct {
-	agentID          string
-	corpID           string // see doc https://work.weixin.qq.com/api/doc/90000/90135/91039
-	corpSecret       string // see doc https://work.weixin.qq.com/api/doc/90000/90135/90665#secret
-	client           *http.Client
-	tokenRefreshLock sync.RWMutex // lock to refresh the access token which can expire in a period of time
-	accessToken      string       // cached access token
-	expiredAt        time.Time    // token expire time
+	agentID      string
+	corpID       string // see doc https://work.weixin.qq.com/api/doc/90000/90135/91039
+	corpSecret   string // see doc https://work.weixin.qq.com/api/doc/90000/90135/90665#secret
+	client       *http.Client
+	tokenManager *chatapi.TokenManager // cached access token refreshed ahead of the expiry
+	options      chatapi.Options
 }
 
 func (r *WxWorkApp) IsAccessTokenExpired() bool {
-	return time.Now().After(r.expiredAt)
+	return r.tokenManager.IsExpired()
+}
+
+// TokenManager returns the manager of the app access token, e.g. to inspect the current token and expiry
+func (r *WxWorkApp) TokenManager() *chatapi.TokenManager {
+	return r.tokenManager
 }
 
 // NewWxWorkApp create a new wxwork app
-func NewWxWorkApp(corpID, corpSecret, agentID string) *WxWorkApp {
-	return NewWxWorkAppWithTimeout(corpID, corpSecret, agentID, WxWorkAppTimeout)
+func NewWxWorkApp(corpID, corpSecret, agentID string, opts ...chatapi.Option) *WxWorkApp {
+	return NewWxWorkAppWithTimeout(corpID, corpSecret, agentID, WxWorkAppTimeout, opts...)
 }
 
 // NewWxWorkAppWithTimeout create a new wxwork app with timeout
-func NewWxWorkAppWithTimeout(corpID, corpSecret, agentID string, timeout time.Duration) *WxWorkApp {
+func NewWxWorkAppWithTimeout(corpID, corpSecret, agentID string, timeout time.Duration, opts ...chatapi.Option) *WxWorkApp {
 	client := http.Client{}
 	client.Timeout = timeout
-	return &WxWorkApp{corpID: corpID, corpSecret: corpSecret, agentID: agentID, client: &client, tokenRefreshLock: sync.RWMutex{}}
+	return NewWxWorkAppWithClient(corpID, corpSecret, agentID, &client, opts...)
 }
 
 // NewWxWorkAppWithClient create a new wxwork app with http.Client
-func NewWxWorkAppWithClient(corpID, corpSecret, agentID string, client *http.Client) *WxWorkApp {
-	return &WxWorkApp{corpID: corpID, corpSecret: corpSecret, agentID: agentID, client: client, tokenRefreshLock: sync.RWMutex{}}
+func NewWxWorkAppWithClient(corpID, corpSecret, agentID string, client *http.Client, opts ...chatapi.Option) *WxWorkApp {
+	app := WxWorkApp{corpID: corpID, corpSecret: corpSecret, agentID: agentID, client: client, options: chatapi.NewOptions(opts...)}
+	switch {
+	case app.options.DryRun != nil:
+		// the dry run builds the requests with the placeholder token instead of fetching one
+		app.tokenManager = chatapi.NewTokenManager(chatapi.DryRunTokenFetcher)
+	case app.options.TokenStore != nil:
+		app.tokenManager = chatapi.NewTokenManagerWithStore(app.fetchAccessToken, app.options.TokenStore, app.clientKey())
+	default:
+		app.tokenManager = chatapi.NewTokenManager(app.fetchAccessToken)
+	}
+	return &app
 }
 
 func (r *WxWorkApp) SendTextMessage(userIDList []string, partyIDList []string, tagIDList []string, content string,
+	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendTextMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, content, options)
+}
+
+// SendTextMessageCtx is SendTextMessage with the context to control the request
+func (r *WxWorkApp) SendTextMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, content string,
 	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -232,10 +275,16 @@ func (r *WxWorkApp) SendTextMessage(userIDList []string, partyIDList []string, t
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendMarkdownMessage(userIDList []string, partyIDList []string, tagIDList []string, content string,
+	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendMarkdownMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, content, options)
+}
+
+// SendMarkdownMessageCtx is SendMarkdownMessage with the context to control the request
+func (r *WxWorkApp) SendMarkdownMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, content string,
 	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -261,10 +310,16 @@ func (r *WxWorkApp) SendMarkdownMessage(userIDList []string, partyIDList []strin
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendImageMessage(userIDList []string, partyIDList []string, tagIDList []string, mediaID string,
+	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendImageMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, mediaID, options)
+}
+
+// SendImageMessageCtx is SendImageMessage with the context to control the request
+func (r *WxWorkApp) SendImageMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, mediaID string,
 	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -290,10 +345,16 @@ func (r *WxWorkApp) SendImageMessage(userIDList []string, partyIDList []string,
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendVoiceMessage(userIDList []string, partyIDList []string, tagIDList []string, mediaID string,
+	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendVoiceMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, mediaID, options)
+}
+
+// SendVoiceMessageCtx is SendVoiceMessage with the context to control the request
+func (r *WxWorkApp) SendVoiceMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, mediaID string,
 	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -319,10 +380,16 @@ func (r *WxWorkApp) SendVoiceMessage(userIDList []string, partyIDList []string,
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendVideoMessage(userIDList []string, partyIDList []string, tagIDList []string, mediaID, mediaTitle, mediaDescription string,
+	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendVideoMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, mediaID, mediaTitle, mediaDescription, options)
+}
+
+// SendVideoMessageCtx is SendVideoMessage with the context to control the request
+func (r *WxWorkApp) SendVideoMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, mediaID, mediaTitle, mediaDescription string,
 	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -350,10 +417,16 @@ func (r *WxWorkApp) SendVideoMessage(userIDList []string, partyIDList []string,
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendFileMessage(userIDList []string, partyIDList []string, tagIDList []string, mediaID string,
+	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendFileMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, mediaID, options)
+}
+
+// SendFileMessageCtx is SendFileMessage with the context to control the request
+func (r *WxWorkApp) SendFileMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, mediaID string,
 	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -379,10 +452,16 @@ func (r *WxWorkApp) SendFileMessage(userIDList []string, partyIDList []string, t
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendTextCardMessage(userIDList []string, partyIDList []string, tagIDList []string, title, description, url, btnText string,
+	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendTextCardMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, title, description, url, btnText, options)
+}
+
+// SendTextCardMessageCtx is SendTextCardMessage with the context to control the request
+func (r *WxWorkApp) SendTextCardMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, title, description, url, btnText string,
 	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -411,10 +490,16 @@ func (r *WxWorkApp) SendTextCardMessage(userIDList []string, partyIDList []strin
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendNewsMessage(userIDList []string, partyIDList []string, tagIDList []string, articles []WxWorkAppNewsMessageArticle,
+	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendNewsMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, articles, options)
+}
+
+// SendNewsMessageCtx is SendNewsMessage with the context to control the request
+func (r *WxWorkApp) SendNewsMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, articles []WxWorkAppNewsMessageArticle,
 	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -440,10 +525,16 @@ func (r *WxWorkApp) SendNewsMessage(userIDList []string, partyIDList []string, t
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendMpNewsMessage(userIDList []string, partyIDList []string, tagIDList []string, articles []WxWorkAppMpNewsMessageArticle,
+	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendMpNewsMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, articles, options)
+}
+
+// SendMpNewsMessageCtx is SendMpNewsMessage with the context to control the request
+func (r *WxWorkApp) SendMpNewsMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, articles []WxWorkAppMpNewsMessageArticle,
 	options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -469,10 +560,16 @@ func (r *WxWorkApp) SendMpNewsMessage(userIDList []string, partyIDList []string,
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendMiniProgramNoticeMessage(userIDList []string, partyIDList []string, tagIDList []string, appID, page, title, description string,
+	emphisFirstItem bool, contentItems []WxWorkAppMiniProgramNoticeMessageItem, options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendMiniProgramNoticeMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, appID, page, title, description, emphisFirstItem, contentItems, options)
+}
+
+// SendMiniProgramNoticeMessageCtx is SendMiniProgramNoticeMessage with the context to control the request
+func (r *WxWorkApp) SendMiniProgramNoticeMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, appID, page, title, description string,
 	emphisFirstItem bool, contentItems []WxWorkAppMiniProgramNoticeMessageItem, options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -503,10 +600,16 @@ func (r *WxWorkApp) SendMiniProgramNoticeMessage(userIDList []string, partyIDLis
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendTaskCardMessage(userIDList []string, partyIDList []string, tagIDList []string, taskID, title, description, url string,
+	buttons []WxWorkAppTaskCardMessageButton, options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
+	return r.SendTaskCardMessageCtx(context.Background(), userIDList, partyIDList, tagIDList, taskID, title, description, url, buttons, options)
+}
+
+// SendTaskCardMessageCtx is SendTaskCardMessage with the context to control the request
+func (r *WxWorkApp) SendTaskCardMessageCtx(ctx context.Context, userIDList []string, partyIDList []string, tagIDList []string, taskID, title, description, url string,
 	buttons []WxWorkAppTaskCardMessageButton, options *WxWorkAppMessageSendOptions) (resp WxWorkAppMessageResp, err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["touser"] = strings.Join(userIDList, "|")
@@ -536,28 +639,65 @@ func (r *WxWorkApp) SendTaskCardMessage(userIDList []string, partyIDList []strin
 			messageObj["duplicate_check_interval"] = options.DuplicateCheckInterval
 		}
 	}
-	return r.sendMessage(&messageObj)
+	return r.sendMessage(ctx, &messageObj)
 }
 
 // See doc https://work.weixin.qq.com/api/doc/90000/90135/90236
-func (r *WxWorkApp) sendMessage(messageObj interface{}) (messageResp WxWorkAppMessageResp, err error) {
-	err = r.fireRequest(http.MethodPost, WxWorkAppMessageAPI, nil, messageObj, &messageResp)
-	if err != nil {
-		return
+func (r *WxWorkApp) sendMessage(ctx context.Context, messageObj interface{}) (messageResp WxWorkAppMessageResp, err error) {
+	var parts []interface{}
+	switch {
+	case r.options.SplitLongMessages:
+		parts = splitWxWorkAppMessage(r.options.RecipientPolicy, messageObj)
+	case r.options.TruncateLongMessages:
+		truncateWxWorkAppMessage(&r.options, messageObj)
+	}
+	if len(parts) == 0 {
+		return r.sendSingleMessage(ctx, messageObj)
+	}
+	// the invalid recipients of all the parts are returned
+	for i, part := range parts {
+		partResp, partErr := r.sendSingleMessage(ctx, part)
+		if partErr != nil {
+			err = fmt.Errorf("send part %d/%d error, %w", i+1, len(parts), partErr)
+			return
+		}
+		messageResp.ErrCode, messageResp.ErrMessage = partResp.ErrCode, partResp.ErrMessage
+		messageResp.InvalidUser = mergeWxWorkRecipients(messageResp.InvalidUser, partResp.InvalidUser)
+		messageResp.InvalidParty = mergeWxWorkRecipients(messageResp.InvalidParty, partResp.InvalidParty)
+		messageResp.InvalidTag = mergeWxWorkRecipients(messageResp.InvalidTag, partResp.InvalidTag)
+	}
+	return
+}
+
+func (r *WxWorkApp) sendSingleMessage(ctx context.Context, messageObj interface{}) (messageResp WxWorkAppMessageResp, err error) {
+	var redirected bool
+	if policy := r.options.RecipientPolicy; policy != nil {
+		if redirected, err = applyWxWorkAppPolicy(policy, messageObj); err != nil {
+			return
+		}
 	}
-	if messageResp.ErrCode != WxWorkAppStatusOK {
-		if messageResp.ErrCode == WxWorkCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
+	if !r.options.SkipValidation {
+		if err = validateWxWorkAppMessage(messageObj, redirected); err != nil {
+			return
 		}
-		err = fmt.Errorf("call wxwork app message api error, %d %s", messageResp.ErrCode, messageResp.ErrMessage)
+	}
+	if redirected {
+		// the message redirected to the test chat has no result of the users
+		var groupMessageResp WxWorkAppGroupMessageResp
+		err = r.fireRequest(ctx, http.MethodPost, WxWorkAppGroupMessageAPI, nil, messageObj, &groupMessageResp)
 		return
 	}
+	err = r.fireRequest(ctx, http.MethodPost, WxWorkAppMessageAPI, nil, messageObj, &messageResp)
 	return
 }
 
 // CreateGroupChat create a new group chat
 func (r *WxWorkApp) CreateGroupChat(name, ownerID string, userIDList []string, options *WxWorkAppCreateGroupOptions) (newChatID string, err error) {
+	return r.CreateGroupChatCtx(context.Background(), name, ownerID, userIDList, options)
+}
+
+// CreateGroupChatCtx is CreateGroupChat with the context to control the request
+func (r *WxWorkApp) CreateGroupChatCtx(ctx context.Context, name, ownerID string, userIDList []string, options *WxWorkAppCreateGroupOptions) (newChatID string, err error) {
 	createGroupReqObject := make(map[string]interface{})
 	createGroupReqObject["name"] = name
 	createGroupReqObject["owner"] = ownerID
@@ -566,23 +706,20 @@ func (r *WxWorkApp) CreateGroupChat(name, ownerID string, userIDList []string, o
 		createGroupReqObject["chatid"] = options.ChatID
 	}
 	var createGroupResp WxWorkAppCreateGroupResp
-	err = r.fireRequest(http.MethodPost, WxWorkAppCreateGroupAPI, nil, &createGroupReqObject, &createGroupResp)
+	err = r.fireRequest(ctx, http.MethodPost, WxWorkAppCreateGroupAPI, nil, &createGroupReqObject, &createGroupResp)
 	if err != nil {
 		return
 	}
-	if createGroupResp.ErrCode != WxWorkAppStatusOK {
-		if createGroupResp.ErrCode == WxWorkCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call wxwork app create group api error, %d %s", createGroupResp.ErrCode, createGroupResp.ErrMessage)
-		return
-	}
 	newChatID = createGroupResp.ChatID
 	return
 }
 
 func (r *WxWorkApp) UpdateGroupChat(chatID string, options *WxWorkAppUpdateGroupOptions) (err error) {
+	return r.UpdateGroupChatCtx(context.Background(), chatID, options)
+}
+
+// UpdateGroupChatCtx is UpdateGroupChat with the context to control the request
+func (r *WxWorkApp) UpdateGroupChatCtx(ctx context.Context, chatID string, options *WxWorkAppUpdateGroupOptions) (err error) {
 	updateGroupReqObject := make(map[string]interface{})
 	updateGroupReqObject["chatid"] = chatID
 	if options != nil {
@@ -592,40 +729,31 @@ func (r *WxWorkApp) UpdateGroupChat(chatID string, options *WxWorkAppUpdateGroup
 		updateGroupReqObject["del_user_list"] = options.DelUserList
 	}
 	var updateGroupResp WxWorkAppUpdateGroupResp
-	err = r.fireRequest(http.MethodPost, WxWorkAppUpdateGroupAPI, nil, &updateGroupReqObject, &updateGroupResp)
-	if err != nil {
-		return
-	}
-	if updateGroupResp.ErrCode != WxWorkAppStatusOK {
-		if updateGroupResp.ErrCode == WxWorkCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call wxwork app update group api error, %d %s", updateGroupResp.ErrCode, updateGroupResp.ErrMessage)
-		return
-	}
+	err = r.fireRequest(ctx, http.MethodPost, WxWorkAppUpdateGroupAPI, nil, &updateGroupReqObject, &updateGroupResp)
 	return
 }
 
 func (r *WxWorkApp) GetGroupChat(chatID string) (group WxWorkAppGroup, err error) {
+	return r.GetGroupChatCtx(context.Background(), chatID)
+}
+
+// GetGroupChatCtx is GetGroupChat with the context to control the request
+func (r *WxWorkApp) GetGroupChatCtx(ctx context.Context, chatID string) (group WxWorkAppGroup, err error) {
 	var getGroupResp WxWorkAppGetGroupResp
-	err = r.fireRequest(http.MethodGet, WxWorkAppGetGroupAPI, map[string]string{"chatid": chatID}, nil, &getGroupResp)
+	err = r.fireRequest(ctx, http.MethodGet, WxWorkAppGetGroupAPI, map[string]string{"chatid": chatID}, nil, &getGroupResp)
 	if err != nil {
 		return
 	}
-	if getGroupResp.ErrCode != WxWorkAppStatusOK {
-		if getGroupResp.ErrCode == WxWorkCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call wxwork app get group api error, %d %s", getGroupResp.ErrCode, getGroupResp.ErrMessage)
-		return
-	}
 	group = getGroupResp.ChatInfo
 	return
 }
 
 func (r *WxWorkApp) SendGroupTextMessage(chatID, content string, options *WxWorkAppMessageSendOptions) (err error) {
+	return r.SendGroupTextMessageCtx(context.Background(), chatID, content, options)
+}
+
+// SendGroupTextMessageCtx is SendGroupTextMessage with the context to control the request
+func (r *WxWorkApp) SendGroupTextMessageCtx(ctx context.Context, chatID, content string, options *WxWorkAppMessageSendOptions) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
 	messageObj["msgtype"] = WxWorkAppMessageTypeText
@@ -635,10 +763,15 @@ func (r *WxWorkApp) SendGroupTextMessage(chatID, content string, options *WxWork
 	if options != nil && options.Safe {
 		messageObj["safe"] = 1
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendGroupMarkdownMessage(chatID, content string, options *WxWorkAppMessageSendOptions) (err error) {
+	return r.SendGroupMarkdownMessageCtx(context.Background(), chatID, content, options)
+}
+
+// SendGroupMarkdownMessageCtx is SendGroupMarkdownMessage with the context to control the request
+func (r *WxWorkApp) SendGroupMarkdownMessageCtx(ctx context.Context, chatID, content string, options *WxWorkAppMessageSendOptions) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
 	messageObj["msgtype"] = WxWorkAppMessageTypeMarkdown
@@ -648,10 +781,15 @@ func (r *WxWorkApp) SendGroupMarkdownMessage(chatID, content string, options *Wx
 	if options != nil && options.Safe {
 		messageObj["safe"] = 1
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendGroupImageMessage(chatID, mediaID string, options *WxWorkAppMessageSendOptions) (err error) {
+	return r.SendGroupImageMessageCtx(context.Background(), chatID, mediaID, options)
+}
+
+// SendGroupImageMessageCtx is SendGroupImageMessage with the context to control the request
+func (r *WxWorkApp) SendGroupImageMessageCtx(ctx context.Context, chatID, mediaID string, options *WxWorkAppMessageSendOptions) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
 	messageObj["msgtype"] = WxWorkAppMessageTypeImage
@@ -661,10 +799,15 @@ func (r *WxWorkApp) SendGroupImageMessage(chatID, mediaID string, options *WxWor
 	if options != nil && options.Safe {
 		messageObj["safe"] = 1
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendGroupVoiceMessage(chatID, mediaID string, options *WxWorkAppMessageSendOptions) (err error) {
+	return r.SendGroupVoiceMessageCtx(context.Background(), chatID, mediaID, options)
+}
+
+// SendGroupVoiceMessageCtx is SendGroupVoiceMessage with the context to control the request
+func (r *WxWorkApp) SendGroupVoiceMessageCtx(ctx context.Context, chatID, mediaID string, options *WxWorkAppMessageSendOptions) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
 	messageObj["msgtype"] = WxWorkAppMessageTypeVoice
@@ -674,10 +817,15 @@ func (r *WxWorkApp) SendGroupVoiceMessage(chatID, mediaID string, options *WxWor
 	if options != nil && options.Safe {
 		messageObj["safe"] = 1
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendGroupVideoMessage(chatID, mediaID, mediaTitle, mediaDescription string, options *WxWorkAppMessageSendOptions) (err error) {
+	return r.SendGroupVideoMessageCtx(context.Background(), chatID, mediaID, mediaTitle, mediaDescription, options)
+}
+
+// SendGroupVideoMessageCtx is SendGroupVideoMessage with the context to control the request
+func (r *WxWorkApp) SendGroupVideoMessageCtx(ctx context.Context, chatID, mediaID, mediaTitle, mediaDescription string, options *WxWorkAppMessageSendOptions) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
 	messageObj["msgtype"] = WxWorkAppMessageTypeVideo
@@ -689,10 +837,15 @@ func (r *WxWorkApp) SendGroupVideoMessage(chatID, mediaID, mediaTitle, mediaDesc
 	if options != nil && options.Safe {
 		messageObj["safe"] = 1
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendGroupFileMessage(chatID, mediaID string, options *WxWorkAppMessageSendOptions) (err error) {
+	return r.SendGroupFileMessageCtx(context.Background(), chatID, mediaID, options)
+}
+
+// SendGroupFileMessageCtx is SendGroupFileMessage with the context to control the request
+func (r *WxWorkApp) SendGroupFileMessageCtx(ctx context.Context, chatID, mediaID string, options *WxWorkAppMessageSendOptions) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
 	messageObj["msgtype"] = WxWorkAppMessageTypeFile
@@ -702,10 +855,15 @@ func (r *WxWorkApp) SendGroupFileMessage(chatID, mediaID string, options *WxWork
 	if options != nil && options.Safe {
 		messageObj["safe"] = 1
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendGroupTextCardMessage(chatID, title, description, url, btnText string, options *WxWorkAppMessageSendOptions) (err error) {
+	return r.SendGroupTextCardMessageCtx(context.Background(), chatID, title, description, url, btnText, options)
+}
+
+// SendGroupTextCardMessageCtx is SendGroupTextCardMessage with the context to control the request
+func (r *WxWorkApp) SendGroupTextCardMessageCtx(ctx context.Context, chatID, title, description, url, btnText string, options *WxWorkAppMessageSendOptions) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
 	messageObj["msgtype"] = WxWorkAppMessageTypeTextCard
@@ -713,15 +871,20 @@ func (r *WxWorkApp) SendGroupTextCardMessage(chatID, title, description, url, bt
 		"title":       title,
 		"description": description,
 		"url":         url,
-		"btntext":     btnText,
+		"btntxt":      btnText,
 	}
 	if options != nil && options.Safe {
 		messageObj["safe"] = 1
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendGroupNewsMessage(chatID string, articles []WxWorkAppNewsMessageArticle, options *WxWorkAppMessageSendOptions) (err error) {
+	return r.SendGroupNewsMessageCtx(context.Background(), chatID, articles, options)
+}
+
+// SendGroupNewsMessageCtx is SendGroupNewsMessage with the context to control the request
+func (r *WxWorkApp) SendGroupNewsMessageCtx(ctx context.Context, chatID string, articles []WxWorkAppNewsMessageArticle, options *WxWorkAppMessageSendOptions) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
 	messageObj["msgtype"] = WxWorkAppMessageTypeNews
@@ -731,10 +894,15 @@ func (r *WxWorkApp) SendGroupNewsMessage(chatID string, articles []WxWorkAppNews
 	if options != nil && options.Safe {
 		messageObj["safe"] = 1
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
 func (r *WxWorkApp) SendGroupMpNewsMessage(chatID string, articles []WxWorkAppMpNewsMessageArticle, options *WxWorkAppMessageSendOptions) (err error) {
+	return r.SendGroupMpNewsMessageCtx(context.Background(), chatID, articles, options)
+}
+
+// SendGroupMpNewsMessageCtx is SendGroupMpNewsMessage with the context to control the request
+func (r *WxWorkApp) SendGroupMpNewsMessageCtx(ctx context.Context, chatID string, articles []WxWorkAppMpNewsMessageArticle, options *WxWorkAppMessageSendOptions) (err error) {
 	messageObj := make(map[string]interface{})
 	messageObj["chatid"] = chatID
 	messageObj["msgtype"] = WxWorkAppMessageTypeMpNews
@@ -744,77 +912,73 @@ func (r *WxWorkApp) SendGroupMpNewsMessage(chatID string, articles []WxWorkAppMp
 	if options != nil && options.Safe {
 		messageObj["safe"] = 1
 	}
-	return r.sendGroupMessage(&messageObj)
+	return r.sendGroupMessage(ctx, &messageObj)
 }
 
-func (r *WxWorkApp) refreshAccessToken() (err error) {
-	reqURL := fmt.Sprintf("%s?corpid=%s&corpsecret=%s", WxWorkAppTokenAPI, r.corpID, r.corpSecret)
-	req, newErr := http.NewRequest(http.MethodGet, reqURL, nil)
+func (r *WxWorkApp) fetchAccessToken(ctx context.Context) (accessToken string, expiresIn time.Duration, err error) {
+	reqURL := fmt.Sprintf("%s?corpid=%s&corpsecret=%s", r.options.URL(WxWorkAppTokenAPI), r.corpID, r.corpSecret)
+	req, newErr := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
+		err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
 		return
 	}
-	resp, getErr := r.client.Do(req)
-	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
-		return
-	}
-	defer resp.Body.Close()
-	// check http code
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("wxwork request error, %s", resp.Status)
-		io.Copy(ioutil.Discard, resp.Body)
-		return
-	}
-	// parse response body
-	decoder := json.NewDecoder(resp.Body)
 	var wxTokenResp WxWorkAppTokenResp
-	if decodeErr := decoder.Decode(&wxTokenResp); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
-		return
-	}
-	if wxTokenResp.ErrCode != WxWorkAppStatusOK {
-		err = fmt.Errorf("call wxwork app api error, %d %s", wxTokenResp.ErrCode, wxTokenResp.ErrMessage)
+	if err = r.invoke(req, WxWorkAppTokenAPI, nil, &wxTokenResp); err != nil {
 		return
 	}
-	// set access token and expired at
-	r.accessToken = wxTokenResp.AccessToken
-	r.expiredAt = time.Now().Add(time.Second * time.Duration(wxTokenResp.ExpiresIn))
+	accessToken = wxTokenResp.AccessToken
+	expiresIn = time.Second * time.Duration(wxTokenResp.ExpiresIn)
 	return
 }
 
 // See doc https://work.weixin.qq.com/api/doc/90000/90135/90248
-func (r *WxWorkApp) sendGroupMessage(messageObj interface{}) (err error) {
-	var messageResp WxWorkAppGroupMessageResp
-	err = r.fireRequest(http.MethodPost, WxWorkAppGroupMessageAPI, nil, messageObj, &messageResp)
-	if err != nil {
-		return
+func (r *WxWorkApp) sendGroupMessage(ctx context.Context, messageObj interface{}) (err error) {
+	var parts []interface{}
+	switch {
+	case r.options.SplitLongMessages:
+		parts = splitWxWorkAppMessage(r.options.RecipientPolicy, messageObj)
+	case r.options.TruncateLongMessages:
+		truncateWxWorkAppMessage(&r.options, messageObj)
+	}
+	if len(parts) == 0 {
+		return r.sendSingleGroupMessage(ctx, messageObj)
+	}
+	for i, part := range parts {
+		if partErr := r.sendSingleGroupMessage(ctx, part); partErr != nil {
+			err = fmt.Errorf("send part %d/%d error, %w", i+1, len(parts), partErr)
+			return
+		}
 	}
-	if messageResp.ErrCode != WxWorkAppStatusOK {
-		if messageResp.ErrCode == WxWorkCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
+	return
+}
+
+func (r *WxWorkApp) sendSingleGroupMessage(ctx context.Context, messageObj interface{}) (err error) {
+	if policy := r.options.RecipientPolicy; policy != nil {
+		if err = applyWxWorkGroupPolicy(policy, messageObj); err != nil {
+			return
+		}
+	}
+	if !r.options.SkipValidation {
+		if err = validateWxWorkAppMessage(messageObj, true); err != nil {
+			return
 		}
-		err = fmt.Errorf("call wxwork app group message api error, %d %s", messageResp.ErrCode, messageResp.ErrMessage)
-		return
 	}
+	var messageResp WxWorkAppGroupMessageResp
+	err = r.fireRequest(ctx, http.MethodPost, WxWorkAppGroupMessageAPI, nil, messageObj, &messageResp)
 	return
 }
 
 func (r *WxWorkApp) UploadMedia(fileBody []byte, fileName, fileType string) (mediaID string, createdAt int64, err error) {
+	return r.UploadMediaCtx(context.Background(), fileBody, fileName, fileType)
+}
+
+// UploadMediaCtx is UploadMedia with the context to control the request
+func (r *WxWorkApp) UploadMediaCtx(ctx context.Context, fileBody []byte, fileName, fileType string) (mediaID string, createdAt int64, err error) {
 	var uploadMediaResp WxWorkAppUploadMediaResp
-	err = r.uploadFile(http.MethodPost, WxWorkAppUploadMediaAPI, map[string]string{"type": fileType}, fileBody, fileName, &uploadMediaResp)
+	err = r.uploadFile(ctx, http.MethodPost, WxWorkAppUploadMediaAPI, map[string]string{"type": fileType}, fileBody, fileName, &uploadMediaResp)
 	if err != nil {
 		return
 	}
-	if uploadMediaResp.ErrCode != WxWorkAppStatusOK {
-		if uploadMediaResp.ErrCode == WxWorkCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call wxwork app upload media api error, %d %s", uploadMediaResp.ErrCode, uploadMediaResp.ErrMessage)
-		return
-	}
 	// set fields
 	mediaID = uploadMediaResp.MediaID
 	createdAt, _ = strconv.ParseInt(uploadMediaResp.CreatedAt, 10, 64)
@@ -822,146 +986,175 @@ func (r *WxWorkApp) UploadMedia(fileBody []byte, fileName, fileType string) (med
 }
 
 func (r *WxWorkApp) UploadImage(fileBody []byte, fileName string) (imageURL string, err error) {
+	return r.UploadImageCtx(context.Background(), fileBody, fileName)
+}
+
+// UploadImageCtx is UploadImage with the context to control the request
+func (r *WxWorkApp) UploadImageCtx(ctx context.Context, fileBody []byte, fileName string) (imageURL string, err error) {
 	var uploadImageResp WxWorkAppUploadImageResp
-	err = r.uploadFile(http.MethodPost, WxWorkAppUploadImageAPI, nil, fileBody, fileName, &uploadImageResp)
+	err = r.uploadFile(ctx, http.MethodPost, WxWorkAppUploadImageAPI, nil, fileBody, fileName, &uploadImageResp)
 	if err != nil {
 		return
 	}
-	if uploadImageResp.ErrCode != WxWorkAppStatusOK {
-		if uploadImageResp.ErrCode == WxWorkCodeAccessTokenExpired {
-			// reset the access token
-			r.accessToken = ""
-		}
-		err = fmt.Errorf("call wxwork app upload image api error, %d %s", uploadImageResp.ErrCode, uploadImageResp.ErrMessage)
-		return
-	}
 	// set fields
 	imageURL = uploadImageResp.URL
 	return
 }
 
-func (r *WxWorkApp) uploadFile(reqMethod, reqURL string, reqParams map[string]string, fileBody []byte, fileName string, wxUploadFileResp interface{}) (err error) {
-	// check the token expired or not
-	if r.accessToken == "" || r.IsAccessTokenExpired() {
-		r.tokenRefreshLock.Lock()
-		if r.accessToken == "" || r.IsAccessTokenExpired() {
-			err = r.refreshAccessToken()
+func (r *WxWorkApp) uploadFile(ctx context.Context, reqMethod, reqURL string, reqParams map[string]string, fileBody []byte, fileName string, wxUploadFileResp interface{}) (err error) {
+	// a repeated upload only creates another media, so it is retried as an idempotent call
+	return r.callWithRetry(ctx, true, func(ctx context.Context, accessToken string) (err error) {
+		// create body
+		respBodyBuffer := bytes.NewBuffer(nil)
+		defer respBodyBuffer.Reset()
+		multipartWriter := multipart.NewWriter(respBodyBuffer)
+		// add form data
+		formFileWriter, createErr := multipartWriter.CreateFormFile("media", fileName)
+		if createErr != nil {
+			err = fmt.Errorf("create form file error, %w", createErr)
+			return
+		}
+		if _, writeErr := formFileWriter.Write(fileBody); writeErr != nil {
+			err = fmt.Errorf("write form file error, %w", writeErr)
+			return
+		}
+		if closeErr := multipartWriter.Close(); closeErr != nil {
+			err = fmt.Errorf("close form file error, %w", closeErr)
+			return
 		}
-		r.tokenRefreshLock.Unlock()
-		if err != nil {
-			err = fmt.Errorf("refresh access token error, %s", err.Error())
+		// create new request
+		req, newErr := http.NewRequestWithContext(ctx, reqMethod, wxWorkAppRequestURL(r.options.URL(reqURL), accessToken, reqParams), respBodyBuffer)
+		if newErr != nil {
+			err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
 			return
 		}
+		// set multi-part header
+		req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+		return r.invoke(req, reqURL, &chatapi.FileUpload{FieldName: "media", FileName: fileName, Size: len(fileBody)}, wxUploadFileResp)
+	})
+}
+
+func (r *WxWorkApp) fireRequest(ctx context.Context, reqMethod, reqURL string, reqParams map[string]string, reqBodyObject interface{}, respObject interface{}) (err error) {
+	var reqBody []byte
+	if reqBodyObject != nil {
+		reqBody, _ = json.Marshal(reqBodyObject)
 	}
-	// check params
-	queryString := url.Values{}
-	queryString.Add("access_token", r.accessToken)
-	if reqParams != nil {
-		for k, v := range reqParams {
-			queryString.Add(k, v)
-		}
-	}
-
-	reqURL = fmt.Sprintf("%s?%s", reqURL, queryString.Encode())
-	// create body
-	respBodyBuffer := bytes.NewBuffer(nil)
-	defer respBodyBuffer.Reset()
-	multipartWriter := multipart.NewWriter(respBodyBuffer)
-	// add form data
-	formFileWriter, createErr := multipartWriter.CreateFormFile("media", fileName)
-	if createErr != nil {
-		err = fmt.Errorf("create form file error, %s", createErr.Error())
+	return r.callWithRetry(ctx, reqMethod == http.MethodGet, func(ctx context.Context, accessToken string) (err error) {
+		var reqBodyReader io.Reader
+		if reqBody != nil {
+			reqBodyReader = bytes.NewReader(reqBody)
+		}
+		req, newErr := http.NewRequestWithContext(ctx, reqMethod, wxWorkAppRequestURL(r.options.URL(reqURL), accessToken, reqParams), reqBodyReader)
+		if newErr != nil {
+			err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
+			return
+		}
+		req.Header.Add("Content-Type", "application/json")
+		return r.invoke(req, reqURL, reqBodyObject, respObject)
+	})
+}
+
+// callWithRetry run the api call with the access token under the rate limit and retry it by the retry policy,
+// the idempotent call is also retried on the errors which may have reached the server
+func (r *WxWorkApp) callWithRetry(ctx context.Context, idempotent bool, call func(ctx context.Context, accessToken string) error) error {
+	return r.options.Retry.Do(ctx, idempotent, func() error {
+		if err := r.options.RateLimiter.Wait(ctx, r.clientKey()); err != nil {
+			return err
+		}
+		return r.callWithToken(ctx, call)
+	})
+}
+
+// clientKey identify the app in the token store and the rate limiter
+func (r *WxWorkApp) clientKey() string {
+	return chatapi.ProviderWxWork + ":" + r.corpID + ":" + r.agentID
+}
+
+// callWithToken run the api call with the access token, if the token is rejected by the server,
+// it is refreshed and the call is replayed once
+func (r *WxWorkApp) callWithToken(ctx context.Context, call func(ctx context.Context, accessToken string) error) (err error) {
+	accessToken, err := r.tokenManager.Token(ctx)
+	if err != nil {
+		err = fmt.Errorf("refresh access token error, %w", err)
 		return
 	}
-	if _, writeErr := formFileWriter.Write(fileBody); writeErr != nil {
-		err = fmt.Errorf("write form file error, %s", writeErr.Error())
+	err = call(ctx, accessToken)
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) || !isWxWorkTokenRejectedCode(apiErr.ErrCode) {
 		return
 	}
-	if closeErr := multipartWriter.Close(); closeErr != nil {
-		err = fmt.Errorf("close form file error, %s", closeErr.Error())
+	r.options.Log().InfoContext(ctx, "access token rejected, replay the call", "provider", chatapi.ProviderWxWork,
+		"endpoint", apiErr.Endpoint, "errcode", apiErr.ErrCode)
+	r.tokenManager.Invalidate(accessToken)
+	if accessToken, err = r.tokenManager.Token(ctx); err != nil {
+		err = fmt.Errorf("replay the call error, refresh access token error, %w", err)
 		return
 	}
-	// create new request
-	req, newErr := http.NewRequest(reqMethod, reqURL, respBodyBuffer)
-	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
-		return
+	// the replay is marked on the call seen by the interceptors and on the returned error
+	err = call(chatapi.WithReplay(ctx), accessToken)
+	switch {
+	case errors.As(err, &apiErr):
+		apiErr.Replayed = true
+	case err != nil:
+		err = fmt.Errorf("replay the call error, %w", err)
 	}
-	// set multi-part header
-	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
-	resp, getErr := r.client.Do(req)
+	return
+}
+
+// invoke send the request through the interceptors and decode the response into respObject
+func (r *WxWorkApp) invoke(req *http.Request, endpoint string, reqObject, respObject interface{}) error {
+	call := chatapi.Call{Provider: chatapi.ProviderWxWork, Operation: wxWorkAppOperations[endpoint], Endpoint: endpoint,
+		Header: req.Header, Request: reqObject, Response: respObject, TokenRefresh: endpoint == WxWorkAppTokenAPI,
+		Replayed: chatapi.IsReplay(req.Context())}
+	return chatapi.Intercept(req.Context(), r.options.Interceptors, &call, func(ctx context.Context, call *chatapi.Call) error {
+		if r.options.DryRun != nil {
+			return chatapi.DryRun(r.options.DryRun, call, req, wxWorkDryRunResp)
+		}
+		return doWxWorkRequest(r.client, req.WithContext(ctx), endpoint, respObject)
+	})
+}
+
+// doWxWorkRequest send the request and parse the response, the errcode other than ok is returned as error
+func doWxWorkRequest(client *http.Client, req *http.Request, endpoint string, respObject interface{}) (err error) {
+	resp, getErr := client.Do(req)
 	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
+		err = chatapi.NewRequestError(chatapi.ProviderWxWork, endpoint, 0, fmt.Errorf("get response error, %w", getErr))
 		return
 	}
 	defer resp.Body.Close()
 	// check http code
 	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("wxwork app request error, %s", resp.Status)
+		err = chatapi.NewStatusError(chatapi.ProviderWxWork, endpoint, resp)
 		io.Copy(ioutil.Discard, resp.Body)
 		return
 	}
 	// parse response body
-	decoder := json.NewDecoder(resp.Body)
-	if decodeErr := decoder.Decode(&wxUploadFileResp); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
-		return
-	}
-	return
-}
-
-func (r *WxWorkApp) fireRequest(reqMethod, reqURL string, reqParams map[string]string, reqBodyObject interface{}, respObject interface{}) (err error) {
-	// check the token expired or not
-	if r.accessToken == "" || r.IsAccessTokenExpired() {
-		r.tokenRefreshLock.Lock()
-		if r.accessToken == "" || r.IsAccessTokenExpired() {
-			err = r.refreshAccessToken()
-		}
-		r.tokenRefreshLock.Unlock()
-		if err != nil {
-			err = fmt.Errorf("refresh access token error, %s", err.Error())
-			return
-		}
-	}
-
-	queryString := url.Values{}
-	queryString.Add("access_token", r.accessToken)
-	if reqParams != nil {
-		for k, v := range reqParams {
-			queryString.Add(k, v)
-		}
-	}
-
-	reqURL = fmt.Sprintf("%s?%s", reqURL, queryString.Encode())
-	var reqBodyReader io.Reader
-	if reqBodyObject != nil {
-		reqBody, _ := json.Marshal(reqBodyObject)
-		reqBodyReader = bytes.NewReader(reqBody)
-	}
-
-	req, newErr := http.NewRequest(reqMethod, reqURL, reqBodyReader)
-	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
+	respBody, readErr := ioutil.ReadAll(resp.Body)
+	if readErr != nil {
+		err = chatapi.NewRequestError(chatapi.ProviderWxWork, endpoint, 0, fmt.Errorf("get response error, %w", readErr))
 		return
 	}
-	req.Header.Add("Content-Type", "application/json")
-	resp, getErr := r.client.Do(req)
-	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
+	var statusResp wxWorkStatusResp
+	if decodeErr := json.Unmarshal(respBody, &statusResp); decodeErr != nil {
+		err = chatapi.NewRequestError(chatapi.ProviderWxWork, endpoint, resp.StatusCode, fmt.Errorf("parse response error, %w", decodeErr))
 		return
 	}
-	defer resp.Body.Close()
-	// check http code
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("wxwork request error, %s", resp.Status)
-		io.Copy(ioutil.Discard, resp.Body)
+	if decodeErr := json.Unmarshal(respBody, respObject); decodeErr != nil {
+		err = chatapi.NewRequestError(chatapi.ProviderWxWork, endpoint, resp.StatusCode, fmt.Errorf("parse response error, %w", decodeErr))
 		return
 	}
-	// parse response body
-	decoder := json.NewDecoder(resp.Body)
-	if decodeErr := decoder.Decode(respObject); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
+	if statusResp.ErrCode != WxWorkAppStatusOK {
+		err = newWxWorkCodeError(endpoint, statusResp.ErrCode, statusResp.ErrMessage)
 		return
 	}
 	return
 }
+
+func wxWorkAppRequestURL(reqURL, accessToken string, reqParams map[string]string) string {
+	queryString := url.Values{}
+	queryString.Add("access_token", accessToken)
+	for k, v := range reqParams {
+		queryString.Add(k, v)
+	}
+	return fmt.Sprintf("%s?%s", reqURL, queryString.Encode())
+}
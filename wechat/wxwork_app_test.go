@@ -1,15 +1,26 @@
 package wechat
 
 import (
+	"context"
 	"encoding/base64"
-	"os"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/duoland/chatapi"
+	"github.com/duoland/chatapi/chatapitest"
 )
 
 var chatID = "citest"
-var corpID = os.Getenv("WXWORK_APP_CORP_ID")
-var corpSecret = os.Getenv("WXWORK_APP_CORP_SECRET")
-var agentID = os.Getenv("WXWORK_APP_AGENT_ID")
+var corpID = "wwcorp"
+var corpSecret = "corp-secret"
+var agentID = "1000002"
 var userIDList = []string{"jinxinxin001", "jinchengxi001"}
 var partyIDList = []string{}
 var tagIDList = []string{}
@@ -29,26 +40,52 @@ var mpNewsArticle = WxWorkAppMpNewsMessageArticle{
 	Digest:           "这是一个技术网站",
 }
 
+// newTestWxWorkApp create the app on a fake wxwork server closed with the test
+func newTestWxWorkApp(t *testing.T, opts ...chatapi.Option) (*WxWorkApp, *chatapitest.Server) {
+	server := chatapitest.NewWxWorkServer()
+	t.Cleanup(server.Close)
+	server.SetCredential(corpID, corpSecret)
+	opts = append([]chatapi.Option{chatapi.WithBaseURL(server.URL)}, opts...)
+	return NewWxWorkApp(corpID, corpSecret, agentID, opts...), server
+}
+
+// expectFields check the fields of the json body of the last request to the api
+func expectFields(t *testing.T, server *chatapitest.Server, api string, fields map[string]interface{}) {
+	t.Helper()
+	req, ok := server.LastRequest(api)
+	if !ok {
+		t.Fatalf("expect a request to %s", api)
+	}
+	for path, expect := range fields {
+		if value := req.Field(path); fmt.Sprint(value) != fmt.Sprint(expect) {
+			t.Errorf("expect %s to be %v, got %v", path, expect, value)
+		}
+	}
+}
+
 func TestWxWorkApp_SendTextMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	_, err := wxworkApp.SendTextMessage(userIDList, nil, nil, "hello, master",
 		&WxWorkAppMessageSendOptions{EnableIDTrans: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"touser": "jinxinxin001|jinchengxi001",
+		"msgtype": "text", "agentid": agentID, "text.content": "hello, master", "enable_id_trans": 1})
 }
 
 func TestWxWorkApp_SendImageMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	_, err := wxworkApp.SendImageMessage(userIDList, nil, nil, mediaID,
 		&WxWorkAppMessageSendOptions{EnableIDTrans: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"msgtype": "image", "image.media_id": mediaID})
 }
 
 func TestWxWorkApp_SendMarkdownMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	_, err := wxworkApp.SendMarkdownMessage(userIDList, nil, nil, `# hello
 > big brother, i love you!
 `,
@@ -56,65 +93,77 @@ func TestWxWorkApp_SendMarkdownMessage(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"msgtype": "markdown",
+		"markdown.content": "# hello\n> big brother, i love you!\n"})
 }
 
 func TestWxWorkApp_SendFileMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	_, err := wxworkApp.SendFileMessage(userIDList, nil, nil, mediaID,
 		&WxWorkAppMessageSendOptions{EnableIDTrans: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"msgtype": "file", "file.media_id": mediaID})
 }
 
 func TestWxWorkApp_SendVoiceMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	_, err := wxworkApp.SendVoiceMessage(userIDList, nil, nil, mediaID,
 		&WxWorkAppMessageSendOptions{EnableIDTrans: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"msgtype": "voice", "voice.media_id": mediaID})
 }
 
 func TestWxWorkApp_SendVideoMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	_, err := wxworkApp.SendVideoMessage(userIDList, nil, nil, mediaID, "人民", "伟大的人民",
 		&WxWorkAppMessageSendOptions{EnableIDTrans: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"msgtype": "video", "video.media_id": mediaID,
+		"video.title": "人民", "video.description": "伟大的人民"})
 }
 
 func TestWxWorkApp_SendTextCardMessageC(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	_, err := wxworkApp.SendTextCardMessage(userIDList, nil, nil, "人民", "伟大的人民",
 		"https://oschina.net", "看看",
 		&WxWorkAppMessageSendOptions{EnableIDTrans: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"msgtype": "textcard", "textcard.title": "人民",
+		"textcard.url": "https://oschina.net", "textcard.btntxt": "看看"})
 }
 
 func TestWxWorkApp_SendNewsMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	_, err := wxworkApp.SendNewsMessage(userIDList, nil, nil, []WxWorkAppNewsMessageArticle{newsArticle},
 		&WxWorkAppMessageSendOptions{EnableIDTrans: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"msgtype": "news",
+		"news.articles.0.title": newsArticle.Title, "news.articles.0.picurl": newsArticle.PictureURL})
 }
 
 func TestWxWorkApp_SendMpNewsMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	_, err := wxworkApp.SendMpNewsMessage(userIDList, nil, nil, []WxWorkAppMpNewsMessageArticle{mpNewsArticle},
 		&WxWorkAppMessageSendOptions{EnableIDTrans: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"msgtype": "mpnews",
+		"mpnews.articles.0.thumb_media_id": mediaID, "mpnews.articles.0.author": mpNewsArticle.Author})
 }
 
 func TestWxWorkApp_SendTaskCardMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	_, err := wxworkApp.SendTaskCardMessage(userIDList, nil, nil, "task123", "我要请假", "回家休息",
 		"http://oschina.net", []WxWorkAppTaskCardMessageButton{
 			{
@@ -132,78 +181,104 @@ func TestWxWorkApp_SendTaskCardMessage(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"msgtype": "taskcard", "taskcard.task_id": "task123",
+		"taskcard.btn.1.key": "keyNo", "taskcard.btn.1.color": "red"})
 }
 
 //// Upload Media & Image ///
 func TestWxWorkApp_UploadImage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	var imageData, _ = base64.StdEncoding.DecodeString(imageBase64Data)
 	imageURL, err := wxworkApp.UploadImage(imageData, "golang.png")
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Logf("Image URL: %s\n", imageURL)
+	req, _ := server.LastRequest(WxWorkAppUploadImageAPI)
+	if imageURL == "" || req.FieldName != "media" || req.FileName != "golang.png" || string(req.Body) != string(imageData) {
+		t.Fatalf("unexpected upload %s of %s %s", imageURL, req.FieldName, req.FileName)
+	}
 }
 
 func TestWxWorkApp_UploadMedia(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	var imageData, _ = base64.StdEncoding.DecodeString(imageBase64Data)
-	mediaID, _, err := wxworkApp.UploadMedia(imageData, "golang.png", WxWorkAppMediaTypeImage)
+	mediaID, createdAt, err := wxworkApp.UploadMedia(imageData, "golang.png", WxWorkAppMediaTypeImage)
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Logf("Media ID: %s\n", mediaID)
+	req, _ := server.LastRequest(WxWorkAppUploadMediaAPI)
+	if mediaID == "" || createdAt == 0 || req.Query.Get("type") != WxWorkAppMediaTypeImage || req.FileName != "golang.png" {
+		t.Fatalf("unexpected upload %s at %d, %v", mediaID, createdAt, req.Query)
+	}
 }
 
 //// Group ////
 
 func TestWxWorkApp_CreateGroupChat(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	newChatID, err := wxworkApp.CreateGroupChat("一个简单的测试群", "jinxinxin001", []string{"jinchengxi001", "jinxinxin001"},
 		&WxWorkAppCreateGroupOptions{ChatID: chatID})
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Logf("ChatID: %s\n", newChatID)
+	group, ok := server.Group(newChatID)
+	if newChatID != chatID || !ok || group.Name != "一个简单的测试群" || group.Owner != "jinxinxin001" || len(group.Users) != 2 {
+		t.Fatalf("unexpected group %s, %+v", newChatID, group)
+	}
 }
 
 func TestWxWorkApp_UpdateGroupChat(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
+	server.AddGroup(chatapitest.Group{ChatID: chatID, Name: "一个简单的测试群", Owner: "jinxinxin001", Users: userIDList})
 	name := "一个不简单的测试群"
-	err := wxworkApp.UpdateGroupChat(chatID, &WxWorkAppUpdateGroupOptions{Name: name})
+	err := wxworkApp.UpdateGroupChat(chatID, &WxWorkAppUpdateGroupOptions{Name: name, DelUserList: []string{"jinchengxi001"}})
 	if err != nil {
 		t.Fatal(err)
 	}
+	if group, _ := server.Group(chatID); group.Name != name || fmt.Sprint(group.Users) != "[jinxinxin001]" {
+		t.Fatalf("unexpected group %+v", group)
+	}
+	err = wxworkApp.UpdateGroupChat("nochat", &WxWorkAppUpdateGroupOptions{Name: name})
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) || apiErr.Endpoint != WxWorkAppUpdateGroupAPI {
+		t.Fatalf("expect the chat not found, got %v", err)
+	}
 }
 
 func TestWxWorkApp_GetGroupChat(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
+	server.AddGroup(chatapitest.Group{ChatID: chatID, Name: "一个简单的测试群", Owner: "jinxinxin001", Users: userIDList})
 	group, err := wxworkApp.GetGroupChat(chatID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Logf("GroupInfo: %v\n", group)
+	if group.ChatID != chatID || group.Owner != "jinxinxin001" || len(group.UserList) != 2 {
+		t.Fatalf("unexpected group %+v", group)
+	}
 }
 
 func TestWxWorkApp_SendGroupTextMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	err := wxworkApp.SendGroupTextMessage(chatID, "hello, master", &WxWorkAppMessageSendOptions{Safe: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"chatid": chatID, "msgtype": "text",
+		"text.content": "hello, master", "safe": 1})
 }
 
 func TestWxWorkApp_SendGroupImageMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	err := wxworkApp.SendGroupImageMessage(chatID, mediaID, &WxWorkAppMessageSendOptions{Safe: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"msgtype": "image", "image.media_id": mediaID})
 }
 
 // must be Safe=false
 func TestWxWorkApp_SendGroupMarkdownMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	err := wxworkApp.SendGroupMarkdownMessage(chatID, `# hello
 > big brother, i love you!
 `,
@@ -211,60 +286,261 @@ func TestWxWorkApp_SendGroupMarkdownMessage(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"msgtype": "markdown", "safe": nil})
 }
 
 func TestWxWorkApp_SendGroupFileMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	err := wxworkApp.SendGroupFileMessage(chatID, mediaID,
 		&WxWorkAppMessageSendOptions{Safe: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"msgtype": "file", "file.media_id": mediaID})
 }
 
 func TestWxWorkApp_SendGroupVoiceMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	err := wxworkApp.SendGroupVoiceMessage(chatID, mediaID,
 		&WxWorkAppMessageSendOptions{Safe: false})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"msgtype": "voice", "voice.media_id": mediaID})
 }
 
 func TestWxWorkApp_SendGroupVideoMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	err := wxworkApp.SendGroupVideoMessage(chatID, mediaID, "人民", "伟大的人民",
 		&WxWorkAppMessageSendOptions{Safe: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"msgtype": "video", "video.title": "人民"})
 }
 
 func TestWxWorkApp_SendGroupTextCardMessageC(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	err := wxworkApp.SendGroupTextCardMessage(chatID, "人民", "伟大的人民",
 		"https://wework.qpic.cn/wwpic/12732_8Z9RVL3rS7-S472_1595229725/0", "看看",
 		&WxWorkAppMessageSendOptions{Safe: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"msgtype": "textcard", "textcard.btntxt": "看看"})
 }
 
 // must be Safe=false
 func TestWxWorkApp_SendGroupNewsMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	err := wxworkApp.SendGroupNewsMessage(chatID, []WxWorkAppNewsMessageArticle{newsArticle},
 		&WxWorkAppMessageSendOptions{Safe: false})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"msgtype": "news", "news.articles.0.url": newsArticle.URL})
 }
 
 func TestWxWorkApp_SendGroupMpNewsMessage(t *testing.T) {
-	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID)
+	wxworkApp, server := newTestWxWorkApp(t)
 	err := wxworkApp.SendGroupMpNewsMessage(chatID, []WxWorkAppMpNewsMessageArticle{mpNewsArticle},
 		&WxWorkAppMessageSendOptions{Safe: true})
 	if err != nil {
 		t.Fatal(err)
 	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"msgtype": "mpnews", "mpnews.articles.0.digest": mpNewsArticle.Digest})
+}
+
+func TestWxWorkApp_SendTextMessageCtx(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// block the token refresh until the client gives up
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID, chatapi.WithBaseURL(server.URL))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := wxworkApp.SendTextMessageCtx(ctx, userIDList, nil, nil, "hello, master", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded error, got %v", err)
+	}
+}
+
+func TestWxWorkApp_SendTextMessageError(t *testing.T) {
+	wxworkApp, server := newTestWxWorkApp(t, chatapi.WithRetryPolicy(chatapi.RetryPolicy{}))
+	server.Fail(WxWorkAppMessageAPI, chatapitest.Fault{ErrCode: WxWorkCodeAPIFreqOutOfLimit, ErrMessage: "api freq out of limit"})
+
+	_, err := wxworkApp.SendTextMessage(userIDList, nil, nil, "hello, master", nil)
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expect api error, got %v", err)
+	}
+	if apiErr.ErrCode != WxWorkCodeAPIFreqOutOfLimit || apiErr.Endpoint != WxWorkAppMessageAPI || !apiErr.Retryable {
+		t.Fatalf("unexpected api error %#v", apiErr)
+	}
+}
+
+func TestWxWorkApp_WrongCredential(t *testing.T) {
+	server := chatapitest.NewWxWorkServer()
+	defer server.Close()
+	server.SetCredential(corpID, corpSecret)
+	wxworkApp := NewWxWorkApp(corpID, "wrong-secret", agentID, chatapi.WithBaseURL(server.URL))
+
+	_, err := wxworkApp.SendTextMessage(userIDList, nil, nil, "hello, master", nil)
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) || apiErr.Endpoint != WxWorkAppTokenAPI || apiErr.ErrCode != WxWorkCodeInvalidCredential {
+		t.Fatalf("expect the token refused, got %v", err)
+	}
+	if len(server.RequestsTo(WxWorkAppMessageAPI)) != 0 {
+		t.Fatal("expect the message not sent without token")
+	}
+}
+
+func TestWxWorkApp_ReplayOnTokenRejected(t *testing.T) {
+	var replayed []bool
+	wxworkApp, server := newTestWxWorkApp(t, chatapi.WithInterceptors(func(ctx context.Context, call *chatapi.Call, invoker chatapi.Invoker) error {
+		if call.Endpoint == WxWorkAppMessageAPI {
+			replayed = append(replayed, call.Replayed)
+		}
+		return invoker(ctx, call)
+	}))
+	if _, err := wxworkApp.SendTextMessage(userIDList, nil, nil, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	// the token cached by the client is expired by the server
+	server.ExpireTokens()
+	if _, err := wxworkApp.SendTextMessage(userIDList, nil, nil, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	requests := server.RequestsTo(WxWorkAppMessageAPI)
+	if server.TokenCount() != 2 || len(requests) != 3 {
+		t.Fatalf("expect the token refreshed and message replayed, got %d tokens and %d message calls", server.TokenCount(), len(requests))
+	}
+	if token := requests[2].Query.Get("access_token"); token != "token-2" {
+		t.Fatalf("expect the message replayed with the new token, got %s", token)
+	}
+	if fmt.Sprint(replayed) != "[false false true]" {
+		t.Fatalf("expect the successful replay seen by the interceptors, got %v", replayed)
+	}
+}
+
+func TestWxWorkApp_ReplayTokenError(t *testing.T) {
+	wxworkApp, server := newTestWxWorkApp(t)
+	if _, err := wxworkApp.SendTextMessage(userIDList, nil, nil, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	server.ExpireTokens()
+	server.Fail(WxWorkAppTokenAPI, chatapitest.Fault{ErrCode: WxWorkCodeInvalidCredential, ErrMessage: "invalid credential"})
+	_, err := wxworkApp.SendTextMessage(userIDList, nil, nil, "hello, master", nil)
+	if err == nil || !strings.Contains(err.Error(), "replay the call error") {
+		t.Fatalf("expect the failed replay told by the error, got %v", err)
+	}
+}
+
+func TestWxWorkApp_ReplayOnlyOnce(t *testing.T) {
+	wxworkApp, server := newTestWxWorkApp(t)
+	expired := chatapitest.Fault{ErrCode: WxWorkCodeAccessTokenExpired, ErrMessage: "access_token expired"}
+	server.Fail(WxWorkAppGroupMessageAPI, expired, expired)
+
+	err := wxworkApp.SendGroupTextMessage(chatID, "hello, master", nil)
+	var apiErr *chatapi.APIError
+	if !errors.As(err, &apiErr) || !apiErr.Replayed || apiErr.ErrCode != WxWorkCodeAccessTokenExpired {
+		t.Fatalf("expect replayed api error, got %v", err)
+	}
+	if calls := len(server.RequestsTo(WxWorkAppGroupMessageAPI)); calls != 2 {
+		t.Fatalf("expect the message replayed once, got %d calls", calls)
+	}
+}
+
+func TestWxWorkApp_ConcurrentTokenRefresh(t *testing.T) {
+	wxworkApp, server := newTestWxWorkApp(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := wxworkApp.SendGroupTextMessage(chatID, "hello, master", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if tokenCalls := server.TokenCount(); tokenCalls != 1 {
+		t.Fatalf("expect the token fetched once, got %d", tokenCalls)
+	}
+	if token, expiredAt := wxworkApp.TokenManager().Current(); token != "token-1" || wxworkApp.IsAccessTokenExpired() || expiredAt.IsZero() {
+		t.Fatalf("unexpected token %s expired at %v", token, expiredAt)
+	}
+}
+
+func TestWxWorkApp_SharedTokenStore(t *testing.T) {
+	server := chatapitest.NewWxWorkServer()
+	defer server.Close()
+	store := chatapi.NewMemoryTokenStore()
+	for i := 0; i < 3; i++ {
+		wxworkApp := NewWxWorkApp(corpID, corpSecret, agentID, chatapi.WithBaseURL(server.URL), chatapi.WithTokenStore(store))
+		if err := wxworkApp.SendGroupTextMessage(chatID, "hello, master", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tokenCalls := server.TokenCount(); tokenCalls != 1 {
+		t.Fatalf("expect the token fetched once, got %d", tokenCalls)
+	}
+}
+
+func TestWxWorkApp_Interceptors(t *testing.T) {
+	var operations []string
+	var messageResp *WxWorkAppMessageResp
+	interceptor := func(ctx context.Context, call *chatapi.Call, invoker chatapi.Invoker) error {
+		operations = append(operations, call.Operation)
+		call.Header.Set("X-Signature", "signed")
+		err := invoker(ctx, call)
+		if resp, ok := call.Response.(*WxWorkAppMessageResp); ok {
+			messageResp = resp
+		}
+		return err
+	}
+	wxworkApp, server := newTestWxWorkApp(t, chatapi.WithInterceptors(interceptor))
+
+	if _, err := wxworkApp.SendTextMessage(userIDList, nil, nil, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(operations) != "[WxWorkApp.GetAccessToken WxWorkApp.SendMessage]" {
+		t.Fatalf("unexpected operations %v", operations)
+	}
+	req, _ := server.LastRequest(WxWorkAppMessageAPI)
+	if signature := req.Header.Get("X-Signature"); signature != "signed" || messageResp == nil || messageResp.ErrMessage != "ok" {
+		t.Fatalf("expect the header added and the response seen, got %s, %v", signature, messageResp)
+	}
+}
+
+func TestWxWorkApp_DryRun(t *testing.T) {
+	recorder := chatapi.NewDryRunRecorder()
+	app, server := newTestWxWorkApp(t, chatapi.WithDryRun(recorder))
+	if _, err := app.SendTextMessage(userIDList, nil, nil, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := app.UploadMedia([]byte("hello, master"), "hello.txt", "file"); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect no request sent in the dry run, got %d", len(server.Requests()))
+	}
+	requests := recorder.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("expect the message and the upload captured without the token fetch, got %+v", requests)
+	}
+	if requests[0].Operation != "WxWorkApp.SendMessage" || requests[0].Method != http.MethodPost ||
+		requests[0].URL != server.URL+"/cgi-bin/message/send?access_token=REDACTED" {
+		t.Fatalf("unexpected message request %+v", requests[0])
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(requests[0].Body, &body); err != nil || body["msgtype"] != "text" || body["agentid"] != agentID {
+		t.Fatalf("unexpected message body %s", requests[0].Body)
+	}
+	if upload := requests[1].Upload; upload == nil || upload.FileName != "hello.txt" || upload.Size != 13 || requests[1].Body != nil {
+		t.Fatalf("unexpected upload request %+v", requests[1])
+	}
 }
@@ -0,0 +1,149 @@
+package wechat
+
+// See doc https://work.weixin.qq.com/api/doc/90000/90135/90930
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/duoland/chatapi"
+)
+
+// WxWorkCallbackCrypto sign, encrypt and decrypt the callback messages of the wxwork app
+type WxWorkCallbackCrypto struct {
+	*chatapi.CallbackCrypto
+}
+
+// NewWxWorkCallbackCrypto create the crypto by the token and the EncodingAESKey set on the callback settings of the app,
+// the receiver id is the corp id for the apps built by the corp
+func NewWxWorkCallbackCrypto(token, encodingAESKey, receiverID string) (crypto *WxWorkCallbackCrypto, err error) {
+	callbackCrypto, err := chatapi.NewCallbackCrypto(token, encodingAESKey, receiverID)
+	if err != nil {
+		return
+	}
+	crypto = &WxWorkCallbackCrypto{CallbackCrypto: callbackCrypto}
+	return
+}
+
+// WxWorkCallbackEnvelope is the xml of the encrypted callback messages and passive replies
+type WxWorkCallbackEnvelope struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   string   `xml:"ToUserName,omitempty"`
+	AgentID      string   `xml:"AgentID,omitempty"`
+	Encrypt      string   `xml:"Encrypt"`
+	MsgSignature string   `xml:"MsgSignature,omitempty"`
+	TimeStamp    string   `xml:"TimeStamp,omitempty"`
+	Nonce        string   `xml:"Nonce,omitempty"`
+}
+
+// EncryptReply returns the xml envelope of the passive reply encrypted and signed by the timestamp and the nonce
+func (c *WxWorkCallbackCrypto) EncryptReply(reply []byte, timestamp, nonce string) (envelope []byte, err error) {
+	encrypted, err := c.Encrypt(reply)
+	if err != nil {
+		return
+	}
+	envelope, err = xml.Marshal(WxWorkCallbackEnvelope{
+		Encrypt:      encrypted,
+		MsgSignature: c.Signature(timestamp, nonce, encrypted),
+		TimeStamp:    timestamp,
+		Nonce:        nonce,
+	})
+	return
+}
+
+// WxWorkCallbackFunc handle the inbound message or event parsed by ParseWxWorkCallbackMessage, the reply is
+// returned passively if not nil, e.g. a *WxWorkCallbackTextReply. The error fails the callback, so the platform retries it.
+type WxWorkCallbackFunc func(ctx context.Context, message interface{}) (reply interface{}, err error)
+
+// WxWorkCallbackHandler is the http.Handler of the callback url of the wxwork app,
+// it answers the url verification and handles the encrypted messages and events
+type WxWorkCallbackHandler struct {
+	crypto *WxWorkCallbackCrypto
+	handle WxWorkCallbackFunc
+}
+
+// NewWxWorkCallbackHandler create the handler of the callback url
+func NewWxWorkCallbackHandler(crypto *WxWorkCallbackCrypto, handle WxWorkCallbackFunc) *WxWorkCallbackHandler {
+	return &WxWorkCallbackHandler{crypto: crypto, handle: handle}
+}
+
+func (h *WxWorkCallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	signature, timestamp, nonce := query.Get("msg_signature"), query.Get("timestamp"), query.Get("nonce")
+	switch r.Method {
+	case http.MethodGet:
+		// the url verification when the callback settings are saved
+		echoStr := query.Get("echostr")
+		if !h.crypto.VerifySignature(signature, timestamp, nonce, echoStr) {
+			http.Error(w, chatapi.ErrInvalidSignature.Error(), http.StatusForbidden)
+			return
+		}
+		echo, err := h.crypto.Decrypt(echoStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(echo)
+	case http.MethodPost:
+		h.serveMessage(w, r, signature, timestamp, nonce)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *WxWorkCallbackHandler) serveMessage(w http.ResponseWriter, r *http.Request, signature, timestamp, nonce string) {
+	body, readErr := ioutil.ReadAll(io.LimitReader(r.Body, chatapi.CallbackMaxBodyBytes))
+	if readErr != nil {
+		http.Error(w, readErr.Error(), http.StatusBadRequest)
+		return
+	}
+	var envelope WxWorkCallbackEnvelope
+	if err := xml.Unmarshal(body, &envelope); err != nil || envelope.Encrypt == "" {
+		http.Error(w, chatapi.ErrInvalidCallback.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.crypto.VerifySignature(signature, timestamp, nonce, envelope.Encrypt) {
+		http.Error(w, chatapi.ErrInvalidSignature.Error(), http.StatusForbidden)
+		return
+	}
+	data, err := h.crypto.Decrypt(envelope.Encrypt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	message, err := ParseWxWorkCallbackMessage(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reply, err := h.handle(r.Context(), message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if reply == nil {
+		// the empty body tells the platform no passive reply
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	replyXML, err := xml.Marshal(reply)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("encode reply error, %v", err), http.StatusInternalServerError)
+		return
+	}
+	envelopeXML, err := h.crypto.EncryptReply(replyXML, strconv.FormatInt(time.Now().Unix(), 10), nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(envelopeXML)
+}
@@ -0,0 +1,228 @@
+package wechat
+
+// See doc https://work.weixin.qq.com/api/doc/90000/90135/90239
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/duoland/chatapi"
+)
+
+// The types of the inbound messages of the wxwork app, the text, image, voice and video types
+// share the values of the WxWorkAppMessageType constants
+const (
+	WxWorkCallbackMessageTypeLocation = "location"
+	WxWorkCallbackMessageTypeLink     = "link"
+	WxWorkCallbackMessageTypeEvent    = "event"
+)
+
+// The events of the wxwork app
+const (
+	WxWorkCallbackEventSubscribe         = "subscribe"
+	WxWorkCallbackEventUnsubscribe       = "unsubscribe"
+	WxWorkCallbackEventEnterAgent        = "enter_agent"
+	WxWorkCallbackEventLocation          = "LOCATION"
+	WxWorkCallbackEventClick             = "click"
+	WxWorkCallbackEventView              = "view"
+	WxWorkCallbackEventTaskCardClick     = "taskcard_click"
+	WxWorkCallbackEventTemplateCardEvent = "template_card_event"
+)
+
+// WxWorkCallbackHeader is the fields shared by all the inbound messages and events
+type WxWorkCallbackHeader struct {
+	ToUserName   string `xml:"ToUserName"`   // the corp id
+	FromUserName string `xml:"FromUserName"` // the user id of the member
+	CreateTime   int64  `xml:"CreateTime"`
+	MsgType      string `xml:"MsgType"`
+	AgentID      int64  `xml:"AgentID"`
+}
+
+type WxWorkCallbackTextMessage struct {
+	WxWorkCallbackHeader
+	MsgID   int64  `xml:"MsgId"`
+	Content string `xml:"Content"`
+}
+
+type WxWorkCallbackImageMessage struct {
+	WxWorkCallbackHeader
+	MsgID   int64  `xml:"MsgId"`
+	PicURL  string `xml:"PicUrl"`
+	MediaID string `xml:"MediaId"`
+}
+
+type WxWorkCallbackVoiceMessage struct {
+	WxWorkCallbackHeader
+	MsgID   int64  `xml:"MsgId"`
+	MediaID string `xml:"MediaId"`
+	Format  string `xml:"Format"`
+}
+
+type WxWorkCallbackVideoMessage struct {
+	WxWorkCallbackHeader
+	MsgID        int64  `xml:"MsgId"`
+	MediaID      string `xml:"MediaId"`
+	ThumbMediaID string `xml:"ThumbMediaId"`
+}
+
+type WxWorkCallbackLocationMessage struct {
+	WxWorkCallbackHeader
+	MsgID     int64   `xml:"MsgId"`
+	Latitude  float64 `xml:"Location_X"`
+	Longitude float64 `xml:"Location_Y"`
+	Scale     int     `xml:"Scale"`
+	Label     string  `xml:"Label"`
+}
+
+type WxWorkCallbackLinkMessage struct {
+	WxWorkCallbackHeader
+	MsgID       int64  `xml:"MsgId"`
+	Title       string `xml:"Title"`
+	Description string `xml:"Description"`
+	URL         string `xml:"Url"`
+	PicURL      string `xml:"PicUrl"`
+}
+
+// WxWorkCallbackEvent is the subscribe, unsubscribe, enter_agent, click and view events, the event key
+// is the key of the clicked menu or the url of the viewed menu
+type WxWorkCallbackEvent struct {
+	WxWorkCallbackHeader
+	Event    string `xml:"Event"`
+	EventKey string `xml:"EventKey"`
+}
+
+type WxWorkCallbackLocationEvent struct {
+	WxWorkCallbackHeader
+	Event     string  `xml:"Event"`
+	Latitude  float64 `xml:"Latitude"`
+	Longitude float64 `xml:"Longitude"`
+	Precision float64 `xml:"Precision"`
+}
+
+// WxWorkCallbackTaskCardEvent is the button pressed on the task card message
+type WxWorkCallbackTaskCardEvent struct {
+	WxWorkCallbackHeader
+	Event    string `xml:"Event"`
+	EventKey string `xml:"EventKey"` // the key of the pressed button
+	TaskID   string `xml:"TaskId"`
+}
+
+// WxWorkCallbackTemplateCardEvent is the button pressed on the template card message
+type WxWorkCallbackTemplateCardEvent struct {
+	WxWorkCallbackHeader
+	Event        string `xml:"Event"`
+	EventKey     string `xml:"EventKey"` // the key of the pressed button
+	TaskID       string `xml:"TaskId"`
+	CardType     string `xml:"CardType"`
+	ResponseCode string `xml:"ResponseCode"` // the code to update the card, valid for 72 hours
+}
+
+// WxWorkCallbackUnknownMessage is the message or event of the types not parsed yet, with the decrypted xml
+type WxWorkCallbackUnknownMessage struct {
+	WxWorkCallbackHeader
+	Event string
+	XML   []byte
+}
+
+// ParseWxWorkCallbackMessage returns the typed message or event of the decrypted xml, e.g. a *WxWorkCallbackTextMessage
+// or a *WxWorkCallbackEvent, the types not parsed yet are returned as a *WxWorkCallbackUnknownMessage
+func ParseWxWorkCallbackMessage(data []byte) (message interface{}, err error) {
+	var head struct {
+		WxWorkCallbackHeader
+		Event string `xml:"Event"`
+	}
+	if err = xml.Unmarshal(data, &head); err != nil {
+		err = fmt.Errorf("%w, decode message error, %v", chatapi.ErrInvalidCallback, err)
+		return
+	}
+	switch head.MsgType {
+	case WxWorkAppMessageTypeText:
+		message = &WxWorkCallbackTextMessage{}
+	case WxWorkAppMessageTypeImage:
+		message = &WxWorkCallbackImageMessage{}
+	case WxWorkAppMessageTypeVoice:
+		message = &WxWorkCallbackVoiceMessage{}
+	case WxWorkAppMessageTypeVideo:
+		message = &WxWorkCallbackVideoMessage{}
+	case WxWorkCallbackMessageTypeLocation:
+		message = &WxWorkCallbackLocationMessage{}
+	case WxWorkCallbackMessageTypeLink:
+		message = &WxWorkCallbackLinkMessage{}
+	case WxWorkCallbackMessageTypeEvent:
+		switch head.Event {
+		case WxWorkCallbackEventSubscribe, WxWorkCallbackEventUnsubscribe, WxWorkCallbackEventEnterAgent,
+			WxWorkCallbackEventClick, WxWorkCallbackEventView:
+			message = &WxWorkCallbackEvent{}
+		case WxWorkCallbackEventLocation:
+			message = &WxWorkCallbackLocationEvent{}
+		case WxWorkCallbackEventTaskCardClick:
+			message = &WxWorkCallbackTaskCardEvent{}
+		case WxWorkCallbackEventTemplateCardEvent:
+			message = &WxWorkCallbackTemplateCardEvent{}
+		}
+	}
+	if message == nil {
+		message = &WxWorkCallbackUnknownMessage{WxWorkCallbackHeader: head.WxWorkCallbackHeader, Event: head.Event, XML: data}
+		return
+	}
+	if err = xml.Unmarshal(data, message); err != nil {
+		err = fmt.Errorf("%w, decode %s message error, %v", chatapi.ErrInvalidCallback, head.MsgType, err)
+		message = nil
+	}
+	return
+}
+
+// WxWorkCallbackReplyHeader is the fields shared by all the passive replies
+type WxWorkCallbackReplyHeader struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   string   `xml:"ToUserName"`
+	FromUserName string   `xml:"FromUserName"`
+	CreateTime   int64    `xml:"CreateTime"`
+	MsgType      string   `xml:"MsgType"`
+}
+
+// newWxWorkCallbackReplyHeader returns the header of the reply to the member sending the message
+func newWxWorkCallbackReplyHeader(message WxWorkCallbackHeader, msgType string) WxWorkCallbackReplyHeader {
+	return WxWorkCallbackReplyHeader{ToUserName: message.FromUserName, FromUserName: message.ToUserName,
+		CreateTime: time.Now().Unix(), MsgType: msgType}
+}
+
+type WxWorkCallbackTextReply struct {
+	WxWorkCallbackReplyHeader
+	Content string `xml:"Content"`
+}
+
+// NewWxWorkCallbackTextReply create the text reply to the member sending the message
+func NewWxWorkCallbackTextReply(message WxWorkCallbackHeader, content string) *WxWorkCallbackTextReply {
+	return &WxWorkCallbackTextReply{WxWorkCallbackReplyHeader: newWxWorkCallbackReplyHeader(message, WxWorkAppMessageTypeText), Content: content}
+}
+
+type WxWorkCallbackImageReply struct {
+	WxWorkCallbackReplyHeader
+	MediaID string `xml:"Image>MediaId"`
+}
+
+// NewWxWorkCallbackImageReply create the image reply to the member sending the message
+func NewWxWorkCallbackImageReply(message WxWorkCallbackHeader, mediaID string) *WxWorkCallbackImageReply {
+	return &WxWorkCallbackImageReply{WxWorkCallbackReplyHeader: newWxWorkCallbackReplyHeader(message, WxWorkAppMessageTypeImage), MediaID: mediaID}
+}
+
+type WxWorkCallbackNewsReply struct {
+	WxWorkCallbackReplyHeader
+	ArticleCount int                              `xml:"ArticleCount"`
+	Articles     []WxWorkCallbackNewsReplyArticle `xml:"Articles>item"`
+}
+
+type WxWorkCallbackNewsReplyArticle struct {
+	Title       string `xml:"Title"`
+	Description string `xml:"Description"`
+	PicURL      string `xml:"PicUrl"`
+	URL         string `xml:"Url"`
+}
+
+// NewWxWorkCallbackNewsReply create the news reply to the member sending the message
+func NewWxWorkCallbackNewsReply(message WxWorkCallbackHeader, articles []WxWorkCallbackNewsReplyArticle) *WxWorkCallbackNewsReply {
+	return &WxWorkCallbackNewsReply{WxWorkCallbackReplyHeader: newWxWorkCallbackReplyHeader(message, WxWorkAppMessageTypeNews),
+		ArticleCount: len(articles), Articles: articles}
+}
@@ -0,0 +1,56 @@
+package wechat
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestParseWxWorkCallbackMessage(t *testing.T) {
+	message, err := ParseWxWorkCallbackMessage([]byte(`<xml><ToUserName><![CDATA[toUser]]></ToUserName>
+<FromUserName><![CDATA[FromUser]]></FromUserName><CreateTime>1408091189</CreateTime><MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[taskcard_click]]></Event><EventKey><![CDATA[approve]]></EventKey><TaskId><![CDATA[task-1]]></TaskId>
+<AgentId>1</AgentId></xml>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskCardEvent, ok := message.(*WxWorkCallbackTaskCardEvent)
+	if !ok || taskCardEvent.FromUserName != "FromUser" || taskCardEvent.EventKey != "approve" || taskCardEvent.TaskID != "task-1" {
+		t.Fatalf("unexpected task card event %#v", message)
+	}
+
+	message, err = ParseWxWorkCallbackMessage([]byte(`<xml><ToUserName>toUser</ToUserName><FromUserName>FromUser</FromUserName>
+<MsgType>location</MsgType><Location_X>23.134521</Location_X><Location_Y>113.358803</Location_Y><Scale>20</Scale>
+<Label><![CDATA[位置信息]]></Label><MsgId>1234567890123456</MsgId><AgentID>1</AgentID></xml>`))
+	if location, ok := message.(*WxWorkCallbackLocationMessage); err != nil || !ok || location.Latitude != 23.134521 ||
+		location.Label != "位置信息" || location.AgentID != 1 {
+		t.Fatalf("unexpected location message %#v, %v", message, err)
+	}
+
+	message, err = ParseWxWorkCallbackMessage([]byte(`<xml><MsgType>event</MsgType><Event>change_contact</Event></xml>`))
+	if unknown, ok := message.(*WxWorkCallbackUnknownMessage); err != nil || !ok || unknown.Event != "change_contact" {
+		t.Fatalf("expect the unknown event kept, got %#v, %v", message, err)
+	}
+
+	if _, err = ParseWxWorkCallbackMessage([]byte("not xml")); err == nil {
+		t.Fatal("expect the invalid xml rejected")
+	}
+}
+
+func TestWxWorkCallbackReply(t *testing.T) {
+	header := WxWorkCallbackHeader{ToUserName: "wwcorp", FromUserName: "jinxinxin001"}
+	replyXML, err := xml.Marshal(NewWxWorkCallbackNewsReply(header, []WxWorkCallbackNewsReplyArticle{{Title: "构建", URL: "https://ci.example.com/1"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{"<ToUserName>jinxinxin001</ToUserName>", "<FromUserName>wwcorp</FromUserName>", "<MsgType>news</MsgType>",
+		"<ArticleCount>1</ArticleCount>", "<Articles><item><Title>构建</Title>"} {
+		if !strings.Contains(string(replyXML), expect) {
+			t.Fatalf("expect %s in the reply, got %s", expect, replyXML)
+		}
+	}
+	replyXML, _ = xml.Marshal(NewWxWorkCallbackImageReply(header, mediaID))
+	if !strings.Contains(string(replyXML), "<Image><MediaId>"+mediaID+"</MediaId></Image>") {
+		t.Fatalf("unexpected image reply %s", replyXML)
+	}
+}
@@ -0,0 +1,149 @@
+package wechat
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+// the sample of the callback doc
+var callbackToken = "QDG6eK"
+var callbackAESKey = "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C"
+var callbackReceiverID = "wx5823bf96d3bd56c7"
+
+func newTestWxWorkCallbackCrypto(t *testing.T) *WxWorkCallbackCrypto {
+	crypto, err := NewWxWorkCallbackCrypto(callbackToken, callbackAESKey, callbackReceiverID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto
+}
+
+func TestWxWorkCallbackCrypto(t *testing.T) {
+	if _, err := NewWxWorkCallbackCrypto(callbackToken, "short", callbackReceiverID); err == nil {
+		t.Fatal("expect the invalid aes key rejected")
+	}
+	crypto := newTestWxWorkCallbackCrypto(t)
+	echoStr := "P9nAzCzyDtyTWESHep1vC5X9xho/qYX3Zpb4yKa9SKld1DsH3Iyt3tP3zNdtp+4RPcs8TgAE7OaBO+FZXvnaqQ=="
+	if !crypto.VerifySignature("5c45ff5e21c57e6ad56bac8758b79b1d9ac89fd3", "1409659589", "263014780", echoStr) {
+		t.Fatal("expect the signature of the doc sample verified")
+	}
+	if echo, err := crypto.Decrypt(echoStr); err != nil || string(echo) != "1616140317555161061" {
+		t.Fatalf("expect the echo string of the doc sample decrypted, got %q, %v", echo, err)
+	}
+
+	encrypted, err := crypto.Encrypt([]byte("<xml>你好</xml>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message, err := crypto.Decrypt(encrypted); err != nil || string(message) != "<xml>你好</xml>" {
+		t.Fatalf("expect the message encrypted and decrypted, got %q, %v", message, err)
+	}
+	otherCrypto, _ := NewWxWorkCallbackCrypto(callbackToken, callbackAESKey, "wwother")
+	if _, err := otherCrypto.Decrypt(encrypted); !errors.Is(err, chatapi.ErrInvalidCallback) {
+		t.Fatalf("expect the message of the other receiver rejected, got %v", err)
+	}
+}
+
+func TestWxWorkCallbackHandler_VerifyURL(t *testing.T) {
+	handler := NewWxWorkCallbackHandler(newTestWxWorkCallbackCrypto(t), nil)
+	query := url.Values{
+		"msg_signature": {"5c45ff5e21c57e6ad56bac8758b79b1d9ac89fd3"},
+		"timestamp":     {"1409659589"},
+		"nonce":         {"263014780"},
+		"echostr":       {"P9nAzCzyDtyTWESHep1vC5X9xho/qYX3Zpb4yKa9SKld1DsH3Iyt3tP3zNdtp+4RPcs8TgAE7OaBO+FZXvnaqQ=="},
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/callback?"+query.Encode(), nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "1616140317555161061" {
+		t.Fatalf("expect the echo string answered, got %d %s", recorder.Code, recorder.Body)
+	}
+
+	query.Set("msg_signature", "0000")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/callback?"+query.Encode(), nil))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expect the invalid signature forbidden, got %d", recorder.Code)
+	}
+}
+
+// newWxWorkCallbackRequest returns the callback request of the message encrypted and signed as the platform
+func newWxWorkCallbackRequest(t *testing.T, crypto *WxWorkCallbackCrypto, message string) *http.Request {
+	encrypted, err := crypto.Encrypt([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := xml.Marshal(WxWorkCallbackEnvelope{ToUserName: callbackReceiverID, AgentID: "218", Encrypt: encrypted})
+	query := url.Values{"msg_signature": {crypto.Signature("1409659813", "1372623149", encrypted)},
+		"timestamp": {"1409659813"}, "nonce": {"1372623149"}}
+	return httptest.NewRequest(http.MethodPost, "/callback?"+query.Encode(), strings.NewReader(string(body)))
+}
+
+func TestWxWorkCallbackHandler_Reply(t *testing.T) {
+	crypto := newTestWxWorkCallbackCrypto(t)
+	handler := NewWxWorkCallbackHandler(crypto, func(ctx context.Context, message interface{}) (reply interface{}, err error) {
+		textMessage, ok := message.(*WxWorkCallbackTextMessage)
+		if !ok {
+			return nil, nil
+		}
+		return NewWxWorkCallbackTextReply(textMessage.WxWorkCallbackHeader, "收到 "+textMessage.Content), nil
+	})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newWxWorkCallbackRequest(t, crypto, `<xml><ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName>
+<FromUserName><![CDATA[mycreate]]></FromUserName><CreateTime>1409659813</CreateTime><MsgType><![CDATA[text]]></MsgType>
+<Content><![CDATA[hello]]></Content><MsgId>4561255354251345929</MsgId><AgentID>218</AgentID></xml>`))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect the message handled, got %d %s", recorder.Code, recorder.Body)
+	}
+	var envelope WxWorkCallbackEnvelope
+	if err := xml.Unmarshal(recorder.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Nonce != "1372623149" || !crypto.VerifySignature(envelope.MsgSignature, envelope.TimeStamp, envelope.Nonce, envelope.Encrypt) {
+		t.Fatalf("expect the reply signed, got %+v", envelope)
+	}
+	replyXML, err := crypto.Decrypt(envelope.Encrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply WxWorkCallbackTextReply
+	if err := xml.Unmarshal(replyXML, &reply); err != nil || reply.ToUserName != "mycreate" || reply.FromUserName != callbackReceiverID ||
+		reply.MsgType != WxWorkAppMessageTypeText || reply.Content != "收到 hello" {
+		t.Fatalf("unexpected reply %s", replyXML)
+	}
+
+	// no passive reply to the events
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newWxWorkCallbackRequest(t, crypto, `<xml><ToUserName>wx5823bf96d3bd56c7</ToUserName>
+<FromUserName>mycreate</FromUserName><MsgType>event</MsgType><Event>enter_agent</Event><AgentID>218</AgentID></xml>`))
+	if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 {
+		t.Fatalf("expect the empty answer, got %d %s", recorder.Code, recorder.Body)
+	}
+
+	request := newWxWorkCallbackRequest(t, crypto, "<xml></xml>")
+	request.URL.RawQuery = strings.Replace(request.URL.RawQuery, "timestamp=1409659813", "timestamp=1409659814", 1)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expect the message of the invalid signature forbidden, got %d", recorder.Code)
+	}
+}
+
+func TestWxWorkCallbackHandler_Error(t *testing.T) {
+	crypto := newTestWxWorkCallbackCrypto(t)
+	handler := NewWxWorkCallbackHandler(crypto, func(ctx context.Context, message interface{}) (interface{}, error) {
+		return nil, errors.New("database is down")
+	})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newWxWorkCallbackRequest(t, crypto, "<xml><MsgType>text</MsgType></xml>"))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("expect the failed handling answered for the retry, got %d", recorder.Code)
+	}
+}
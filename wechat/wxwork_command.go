@@ -0,0 +1,25 @@
+package wechat
+
+import (
+	"context"
+
+	"github.com/duoland/chatapi"
+)
+
+// NewWxWorkCommandHandler returns the callback func running the commands of the text messages sent to the app,
+// the messages to the app are direct so the commands need no prefix. The replies are sent to the sender by the app.
+func NewWxWorkCommandHandler(router *chatapi.CommandRouter, app *WxWorkApp) WxWorkCallbackFunc {
+	return func(ctx context.Context, message interface{}) (reply interface{}, err error) {
+		textMessage, ok := message.(*WxWorkCallbackTextMessage)
+		if !ok {
+			return
+		}
+		request := chatapi.CommandRequest{Provider: chatapi.ProviderWxWork, SenderID: textMessage.FromUserName,
+			Text: textMessage.Content, Mentioned: true, Message: textMessage}
+		router.Serve(ctx, &request, func(ctx context.Context, content string) error {
+			_, sendErr := app.SendTextMessageCtx(ctx, []string{textMessage.FromUserName}, nil, nil, content, nil)
+			return sendErr
+		})
+		return
+	}
+}
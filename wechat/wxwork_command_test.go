@@ -0,0 +1,30 @@
+package wechat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestWxWorkCommandHandler(t *testing.T) {
+	wxApp, server := newTestWxWorkApp(t)
+	router := chatapi.NewCommandRouter()
+	router.Register(chatapi.Command{Name: "status", Run: func(ctx context.Context, request *chatapi.CommandRequest) (string, error) {
+		return "all green for " + request.SenderID, nil
+	}})
+	crypto := newTestWxWorkCallbackCrypto(t)
+	handler := NewWxWorkCallbackHandler(crypto, NewWxWorkCommandHandler(router, wxApp))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newWxWorkCallbackRequest(t, crypto, `<xml><ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName>
+<FromUserName><![CDATA[mycreate]]></FromUserName><CreateTime>1409659813</CreateTime><MsgType><![CDATA[text]]></MsgType>
+<Content><![CDATA[status]]></Content><MsgId>4561255354251345929</MsgId><AgentID>218</AgentID></xml>`))
+	if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 {
+		t.Fatalf("expect the message acknowledged without a passive reply, got %d %s", recorder.Code, recorder.Body)
+	}
+	router.Wait()
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"touser": "mycreate", "msgtype": "text",
+		"text.content": "all green for mycreate"})
+}
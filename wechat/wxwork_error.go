@@ -0,0 +1,46 @@
+package wechat
+
+import (
+	"net/http"
+
+	"github.com/duoland/chatapi"
+)
+
+// See doc https://work.weixin.qq.com/api/doc/90000/90139/90313
+const (
+	WxWorkCodeSystemBusy         = -1
+	WxWorkCodeInvalidCredential  = 40001
+	WxWorkCodeAccessTokenInvalid = 40014
+	WxWorkCodeAPIFreqOutOfLimit  = 45009
+	WxWorkCodeConcurrentLimit    = 45033
+)
+
+// newWxWorkCodeError create the api error of the errcode returned by wxwork
+func newWxWorkCodeError(endpoint string, errCode int, errMessage string) *chatapi.APIError {
+	return &chatapi.APIError{
+		Provider:   chatapi.ProviderWxWork,
+		Endpoint:   endpoint,
+		HTTPStatus: http.StatusOK,
+		ErrCode:    errCode,
+		ErrMessage: errMessage,
+		Retryable:  isWxWorkRetryableCode(errCode),
+	}
+}
+
+// isWxWorkTokenRejectedCode report whether the server rejects the access token,
+// the token should be refreshed and the request replayed
+func isWxWorkTokenRejectedCode(errCode int) bool {
+	switch errCode {
+	case WxWorkCodeAccessTokenExpired, WxWorkCodeAccessTokenInvalid, WxWorkCodeInvalidCredential:
+		return true
+	}
+	return false
+}
+
+func isWxWorkRetryableCode(errCode int) bool {
+	switch errCode {
+	case WxWorkCodeSystemBusy, WxWorkCodeAPIFreqOutOfLimit, WxWorkCodeConcurrentLimit:
+		return true
+	}
+	return false
+}
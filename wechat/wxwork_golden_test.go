@@ -0,0 +1,128 @@
+package wechat
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/duoland/chatapi/chatapitest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden payload files in testdata")
+
+func TestWxWorkApp_GoldenPayloads(t *testing.T) {
+	app, server := newTestWxWorkApp(t)
+	options := &WxWorkAppMessageSendOptions{Safe: true, EnableIDTrans: true, EnableDuplicateCheck: true, DuplicateCheckInterval: 600}
+	buttons := []WxWorkAppTaskCardMessageButton{{Key: "keyOk", Name: "批准", Color: "blue", IsBold: true}, {Key: "keyNo", Name: "驳回"}}
+	noticeItems := []WxWorkAppMiniProgramNoticeMessageItem{{Key: "会议室", Value: "402"}}
+	chatapitest.RunGoldenCases(t, server, []chatapitest.GoldenCase{
+		{Name: "wxwork_app_text", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendTextMessage(userIDList, []string{"2"}, []string{"3"}, "hello, master", options)
+			return err
+		}},
+		{Name: "wxwork_app_markdown", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendMarkdownMessage(userIDList, nil, nil, "# hello\n> big brother", nil)
+			return err
+		}},
+		{Name: "wxwork_app_image", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendImageMessage(userIDList, nil, nil, mediaID, nil)
+			return err
+		}},
+		{Name: "wxwork_app_voice", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendVoiceMessage(userIDList, nil, nil, mediaID, nil)
+			return err
+		}},
+		{Name: "wxwork_app_video", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendVideoMessage(userIDList, nil, nil, mediaID, "人民", "伟大的人民", nil)
+			return err
+		}},
+		{Name: "wxwork_app_file", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendFileMessage(userIDList, nil, nil, mediaID, nil)
+			return err
+		}},
+		{Name: "wxwork_app_textcard", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendTextCardMessage(userIDList, nil, nil, "人民", "伟大的人民", "https://oschina.net", "看看", nil)
+			return err
+		}},
+		{Name: "wxwork_app_news", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendNewsMessage(userIDList, nil, nil, []WxWorkAppNewsMessageArticle{newsArticle}, nil)
+			return err
+		}},
+		{Name: "wxwork_app_mpnews", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendMpNewsMessage(userIDList, nil, nil, []WxWorkAppMpNewsMessageArticle{mpNewsArticle}, nil)
+			return err
+		}},
+		{Name: "wxwork_app_miniprogram_notice", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendMiniProgramNoticeMessage(userIDList, nil, nil, "wx123123123123123", "pages/index", "会议室预订成功通知",
+				"4月27日 16:16", true, noticeItems, nil)
+			return err
+		}},
+		{Name: "wxwork_app_taskcard", API: WxWorkAppMessageAPI, Send: func() error {
+			_, err := app.SendTaskCardMessage(userIDList, nil, nil, "task123", "我要请假", "回家休息", "https://oschina.net", buttons, nil)
+			return err
+		}},
+		{Name: "wxwork_app_group_text", API: WxWorkAppGroupMessageAPI, Send: func() error {
+			return app.SendGroupTextMessage(chatID, "hello, master", options)
+		}},
+		{Name: "wxwork_app_group_markdown", API: WxWorkAppGroupMessageAPI, Send: func() error {
+			return app.SendGroupMarkdownMessage(chatID, "# hello\n> big brother", nil)
+		}},
+		{Name: "wxwork_app_group_image", API: WxWorkAppGroupMessageAPI, Send: func() error {
+			return app.SendGroupImageMessage(chatID, mediaID, nil)
+		}},
+		{Name: "wxwork_app_group_voice", API: WxWorkAppGroupMessageAPI, Send: func() error {
+			return app.SendGroupVoiceMessage(chatID, mediaID, nil)
+		}},
+		{Name: "wxwork_app_group_video", API: WxWorkAppGroupMessageAPI, Send: func() error {
+			return app.SendGroupVideoMessage(chatID, mediaID, "人民", "伟大的人民", nil)
+		}},
+		{Name: "wxwork_app_group_file", API: WxWorkAppGroupMessageAPI, Send: func() error {
+			return app.SendGroupFileMessage(chatID, mediaID, nil)
+		}},
+		{Name: "wxwork_app_group_textcard", API: WxWorkAppGroupMessageAPI, Send: func() error {
+			return app.SendGroupTextCardMessage(chatID, "人民", "伟大的人民", "https://oschina.net", "看看", nil)
+		}},
+		{Name: "wxwork_app_group_news", API: WxWorkAppGroupMessageAPI, Send: func() error {
+			return app.SendGroupNewsMessage(chatID, []WxWorkAppNewsMessageArticle{newsArticle}, nil)
+		}},
+		{Name: "wxwork_app_group_mpnews", API: WxWorkAppGroupMessageAPI, Send: func() error {
+			return app.SendGroupMpNewsMessage(chatID, []WxWorkAppMpNewsMessageArticle{mpNewsArticle}, nil)
+		}},
+		{Name: "wxwork_app_create_group", API: WxWorkAppCreateGroupAPI, Send: func() error {
+			_, err := app.CreateGroupChat("一个简单的测试群", "jinxinxin001", userIDList, &WxWorkAppCreateGroupOptions{ChatID: chatID})
+			return err
+		}},
+		{Name: "wxwork_app_update_group", API: WxWorkAppUpdateGroupAPI, Send: func() error {
+			return app.UpdateGroupChat(chatID, &WxWorkAppUpdateGroupOptions{Name: "一个不简单的测试群", Owner: "jinchengxi001",
+				AddUserList: []string{"jinxinxin002"}, DelUserList: []string{"jinxinxin001"}})
+		}},
+	}, *update)
+}
+
+func TestWxWorkRobot_GoldenPayloads(t *testing.T) {
+	robot, server := newTestWxWorkRobot(t)
+	articles := []WxWorkRobotNewsMessageArticle{{Title: "i am a demo blog title", Description: "i serve my master",
+		URL: "http://www.oschina.net", PictureURL: "http://res.mail.qq.com/node/ww/wwopenmng/images/independent/doc/test_pic_msg1.png"}}
+	chatapitest.RunGoldenCases(t, server, []chatapitest.GoldenCase{
+		{Name: "wxwork_robot_text", API: WxWorkRobotMessageAPI, Send: func() error {
+			return robot.SendTextMessage(key, "hello, master")
+		}},
+		{Name: "wxwork_robot_text_mention", API: WxWorkRobotMessageAPI, Send: func() error {
+			return robot.SendTextMessageWithMention(key, "hello, master", []string{"@all"}, []string{"13800001111"})
+		}},
+		{Name: "wxwork_robot_markdown", API: WxWorkRobotMessageAPI, Send: func() error {
+			return robot.SendMarkdownMessage(key, "# profile\n> i come, i see")
+		}},
+		{Name: "wxwork_robot_markdown_mention", API: WxWorkRobotMessageAPI, Send: func() error {
+			return robot.SendMarkdownMessageWithMention(key, "# profile\n> i come, i see", []string{"jinxinxin001"}, nil)
+		}},
+		{Name: "wxwork_robot_image", API: WxWorkRobotMessageAPI, Send: func() error {
+			return robot.SendImageMessage(key, []byte("golang.png"))
+		}},
+		{Name: "wxwork_robot_news", API: WxWorkRobotMessageAPI, Send: func() error {
+			return robot.SendNewsMessage(key, articles)
+		}},
+		{Name: "wxwork_robot_file", API: WxWorkRobotMessageAPI, Send: func() error {
+			return robot.SendFileMessage(key, mediaID)
+		}},
+	}, *update)
+}
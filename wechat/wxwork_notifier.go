@@ -0,0 +1,133 @@
+package wechat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/duoland/chatapi"
+)
+
+var _ chatapi.Notifier = (*WxWorkRobotNotifier)(nil)
+var _ chatapi.Notifier = (*WxWorkAppNotifier)(nil)
+
+// WxWorkRobotNotifier adapts the WxWorkRobot to the chatapi.Notifier interface
+type WxWorkRobotNotifier struct {
+	robot *WxWorkRobot
+	key   string
+}
+
+// NewWxWorkRobotNotifier create a notifier sending messages with the robot webhook key
+func NewWxWorkRobotNotifier(robot *WxWorkRobot, key string) *WxWorkRobotNotifier {
+	return &WxWorkRobotNotifier{robot: robot, key: key}
+}
+
+// SendText send the text message
+func (n *WxWorkRobotNotifier) SendText(ctx context.Context, content string) error {
+	return n.robot.SendTextMessageCtx(ctx, n.key, content)
+}
+
+// SendMarkdown send the markdown message, the title is rendered as a heading
+func (n *WxWorkRobotNotifier) SendMarkdown(ctx context.Context, title, content string) error {
+	return n.robot.SendMarkdownMessageCtx(ctx, n.key, wxWorkMarkdownWithTitle(title, content))
+}
+
+// SendLink send the link as a news message with a single article
+func (n *WxWorkRobotNotifier) SendLink(ctx context.Context, link *chatapi.Link) error {
+	return n.robot.SendNewsMessageCtx(ctx, n.key, []WxWorkRobotNewsMessageArticle{
+		{
+			Title:       link.Title,
+			Description: link.Description,
+			URL:         link.URL,
+			PictureURL:  link.PictureURL,
+		},
+	})
+}
+
+// SendImage send the image message, only the image data is supported
+func (n *WxWorkRobotNotifier) SendImage(ctx context.Context, image *chatapi.Image) error {
+	if len(image.Data) == 0 {
+		return fmt.Errorf("%w, wxwork robot requires the image data", chatapi.ErrUnsupported)
+	}
+	return n.robot.SendImageMessageCtx(ctx, n.key, image.Data)
+}
+
+// WxWorkAppNotifyTarget is the receivers of the messages sent by WxWorkAppNotifier
+type WxWorkAppNotifyTarget struct {
+	UserIDList  []string
+	PartyIDList []string
+	TagIDList   []string
+	ChatID      string // send to the app group chat instead of the users when specified
+	Options     *WxWorkAppMessageSendOptions
+}
+
+// WxWorkAppNotifier adapts the WxWorkApp to the chatapi.Notifier interface
+type WxWorkAppNotifier struct {
+	app    *WxWorkApp
+	target WxWorkAppNotifyTarget
+}
+
+// NewWxWorkAppNotifier create a notifier sending messages to the target
+func NewWxWorkAppNotifier(app *WxWorkApp, target WxWorkAppNotifyTarget) *WxWorkAppNotifier {
+	return &WxWorkAppNotifier{app: app, target: target}
+}
+
+// SendText send the text message
+func (n *WxWorkAppNotifier) SendText(ctx context.Context, content string) (err error) {
+	t := &n.target
+	if t.ChatID != "" {
+		return n.app.SendGroupTextMessageCtx(ctx, t.ChatID, content, t.Options)
+	}
+	_, err = n.app.SendTextMessageCtx(ctx, t.UserIDList, t.PartyIDList, t.TagIDList, content, t.Options)
+	return
+}
+
+// SendMarkdown send the markdown message, the title is rendered as a heading
+func (n *WxWorkAppNotifier) SendMarkdown(ctx context.Context, title, content string) (err error) {
+	t := &n.target
+	content = wxWorkMarkdownWithTitle(title, content)
+	if t.ChatID != "" {
+		return n.app.SendGroupMarkdownMessageCtx(ctx, t.ChatID, content, t.Options)
+	}
+	_, err = n.app.SendMarkdownMessageCtx(ctx, t.UserIDList, t.PartyIDList, t.TagIDList, content, t.Options)
+	return
+}
+
+// SendLink send the link as a text card message
+func (n *WxWorkAppNotifier) SendLink(ctx context.Context, link *chatapi.Link) (err error) {
+	t := &n.target
+	if t.ChatID != "" {
+		return n.app.SendGroupTextCardMessageCtx(ctx, t.ChatID, link.Title, link.Description, link.URL, "", t.Options)
+	}
+	_, err = n.app.SendTextCardMessageCtx(ctx, t.UserIDList, t.PartyIDList, t.TagIDList, link.Title, link.Description, link.URL, "", t.Options)
+	return
+}
+
+// SendImage send the image message, the image data is uploaded first if no media id specified
+func (n *WxWorkAppNotifier) SendImage(ctx context.Context, image *chatapi.Image) (err error) {
+	t := &n.target
+	mediaID := image.MediaID
+	if mediaID == "" {
+		if len(image.Data) == 0 {
+			return fmt.Errorf("%w, wxwork app requires the image media id or data", chatapi.ErrUnsupported)
+		}
+		fileName := image.Name
+		if fileName == "" {
+			fileName = "image.png"
+		}
+		if mediaID, _, err = n.app.UploadMediaCtx(ctx, image.Data, fileName, WxWorkAppMediaTypeImage); err != nil {
+			return
+		}
+	}
+	if t.ChatID != "" {
+		return n.app.SendGroupImageMessageCtx(ctx, t.ChatID, mediaID, t.Options)
+	}
+	_, err = n.app.SendImageMessageCtx(ctx, t.UserIDList, t.PartyIDList, t.TagIDList, mediaID, t.Options)
+	return
+}
+
+func wxWorkMarkdownWithTitle(title, content string) string {
+	if title == "" {
+		return content
+	}
+	return fmt.Sprintf("# %s\n%s", title, content)
+}
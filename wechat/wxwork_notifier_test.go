@@ -0,0 +1,27 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestWxWorkRobotNotifier_SendMarkdown(t *testing.T) {
+	robot, server := newTestWxWorkRobot(t)
+
+	var notifier chatapi.Notifier = NewWxWorkRobotNotifier(robot, "robot-key")
+	if err := notifier.SendMarkdown(context.Background(), "title", "> content"); err != nil {
+		t.Fatal(err)
+	}
+	var received WxWorkRobotMarkdownMessage
+	req, _ := server.LastRequest(WxWorkRobotMessageAPI)
+	req.Decode(&received)
+	if received.MessageType != WxWorkRobotMessageTypeMarkdown || received.MessageBody.Content != "# title\n> content" {
+		t.Fatalf("unexpected message %+v", received)
+	}
+	if err := notifier.SendImage(context.Background(), &chatapi.Image{URL: "https://example.com/a.png"}); !errors.Is(err, chatapi.ErrUnsupported) {
+		t.Fatalf("expect ErrUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package wechat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/duoland/chatapi"
+)
+
+// wxWorkAppUserOnlyFields are the fields of the app message to the users which the group message does not accept
+var wxWorkAppUserOnlyFields = []string{"touser", "toparty", "totag", "agentid", "enable_id_trans", "enable_duplicate_check",
+	"duplicate_check_interval"}
+
+// applyWxWorkAppPolicy rewrite the recipients and the content of the app message to the users by the recipient policy,
+// the message redirected to the test chat is turned into a group message
+func applyWxWorkAppPolicy(policy *chatapi.RecipientPolicy, messageObj interface{}) (redirected bool, err error) {
+	message, ok := messageObj.(*map[string]interface{})
+	if !ok {
+		return
+	}
+	messageMap := *message
+	switch {
+	case policy.RedirectChat != "":
+		msgType, _ := messageMap["msgtype"].(string)
+		if msgType == WxWorkAppMessageTypeMiniProgramNotice || msgType == WxWorkAppMessageTypeTaskCard {
+			err = fmt.Errorf("%w, the %s message can not be redirected to a group chat", chatapi.ErrRecipientBlocked, msgType)
+			return
+		}
+		for _, field := range wxWorkAppUserOnlyFields {
+			delete(messageMap, field)
+		}
+		messageMap["chatid"] = policy.RedirectChat
+		redirected = true
+	case policy.Allowlist != nil:
+		messageMap["touser"] = allowedWxWorkRecipients(messageMap["touser"], policy.Allowlist.Users)
+		messageMap["toparty"] = allowedWxWorkRecipients(messageMap["toparty"], policy.Allowlist.Parties)
+		messageMap["totag"] = allowedWxWorkRecipients(messageMap["totag"], policy.Allowlist.Tags)
+		if messageMap["touser"] == "" && messageMap["toparty"] == "" && messageMap["totag"] == "" {
+			err = chatapi.ErrRecipientBlocked
+			return
+		}
+	}
+	applyWxWorkAppBanner(policy, messageMap)
+	return
+}
+
+// applyWxWorkGroupPolicy rewrite the chat and the content of the app group message by the recipient policy
+func applyWxWorkGroupPolicy(policy *chatapi.RecipientPolicy, messageObj interface{}) (err error) {
+	message, ok := messageObj.(*map[string]interface{})
+	if !ok {
+		return
+	}
+	messageMap := *message
+	chatID, _ := messageMap["chatid"].(string)
+	switch {
+	case policy.RedirectChat != "":
+		messageMap["chatid"] = policy.RedirectChat
+	case !policy.AllowChat(chatID):
+		err = chatapi.ErrRecipientBlocked
+		return
+	}
+	applyWxWorkAppBanner(policy, messageMap)
+	return
+}
+
+// applyWxWorkAppBanner prefix the content of the text and markdown app messages with the banner
+func applyWxWorkAppBanner(policy *chatapi.RecipientPolicy, messageMap map[string]interface{}) {
+	msgType, _ := messageMap["msgtype"].(string)
+	if msgType != WxWorkAppMessageTypeText && msgType != WxWorkAppMessageTypeMarkdown {
+		return
+	}
+	if body, ok := messageMap[msgType].(map[string]string); ok {
+		body["content"] = policy.WithBanner(body["content"])
+	}
+}
+
+// allowedWxWorkRecipients returns the allowed ones of the recipients joined by "|"
+func allowedWxWorkRecipients(recipients interface{}, allowed []string) string {
+	recipientList, _ := recipients.(string)
+	if recipientList == "" {
+		return ""
+	}
+	return strings.Join(chatapi.AllowedRecipients(strings.Split(recipientList, "|"), allowed), "|")
+}
+
+// wxWorkRobotKey returns the webhook key to send to by the recipient policy
+func wxWorkRobotKey(policy *chatapi.RecipientPolicy, key string) (string, error) {
+	switch {
+	case policy == nil:
+		return key, nil
+	case policy.RedirectWebhook != "":
+		return policy.RedirectWebhook, nil
+	case !policy.AllowWebhook(key):
+		return "", chatapi.ErrRecipientBlocked
+	}
+	return key, nil
+}
+
+// applyWxWorkRobotBanner prefix the content of the text and markdown robot messages with the banner
+func applyWxWorkRobotBanner(policy *chatapi.RecipientPolicy, messageObj interface{}) {
+	switch message := messageObj.(type) {
+	case *WxWorkRobotTextMessage:
+		message.MessageBody.Content = policy.WithBanner(message.MessageBody.Content)
+	case *WxWorkRobotMarkdownMessage:
+		message.MessageBody.Content = policy.WithBanner(message.MessageBody.Content)
+	}
+}
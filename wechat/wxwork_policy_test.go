@@ -0,0 +1,79 @@
+package wechat
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestWxWorkApp_RecipientAllowlist(t *testing.T) {
+	policy := chatapi.RecipientPolicy{Allowlist: &chatapi.RecipientAllowlist{Users: []string{"qa001"}, Parties: []string{"2"},
+		Chats: []string{"chat-qa"}}, Banner: "[staging]"}
+	app, server := newTestWxWorkApp(t, chatapi.WithRecipientPolicy(&policy))
+	if _, err := app.SendTextMessage([]string{"jinxinxin001", "qa001", "@all"}, []string{"2", "3"}, []string{"4"}, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	expectFields(t, server, WxWorkAppMessageAPI, map[string]interface{}{"touser": "qa001", "toparty": "2", "totag": "",
+		"text.content": "[staging]\nhello, master"})
+
+	if _, err := app.SendMarkdownMessage([]string{"jinxinxin001"}, nil, nil, "# hello", nil); !errors.Is(err, chatapi.ErrRecipientBlocked) {
+		t.Fatalf("expect the message without allowed recipient blocked, got %v", err)
+	}
+	if err := app.SendGroupTextMessage(chatID, "hello, master", nil); !errors.Is(err, chatapi.ErrRecipientBlocked) {
+		t.Fatalf("expect the message to the unlisted chat blocked, got %v", err)
+	}
+	if err := app.SendGroupMarkdownMessage("chat-qa", "# hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"chatid": "chat-qa", "markdown.content": "[staging]\n# hello"})
+	if len(server.RequestsTo(WxWorkAppMessageAPI)) != 1 || len(server.RequestsTo(WxWorkAppGroupMessageAPI)) != 1 {
+		t.Fatal("expect the blocked messages not sent")
+	}
+}
+
+func TestWxWorkApp_RecipientRedirect(t *testing.T) {
+	policy := chatapi.RecipientPolicy{RedirectChat: "chat-qa"}
+	app, server := newTestWxWorkApp(t, chatapi.WithRecipientPolicy(&policy))
+	options := WxWorkAppMessageSendOptions{Safe: true, EnableIDTrans: true}
+	if _, err := app.SendTextMessage(userIDList, []string{"2"}, nil, "hello, master", &options); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.RequestsTo(WxWorkAppMessageAPI)) != 0 {
+		t.Fatal("expect the message to the users redirected")
+	}
+	req, _ := server.LastRequest(WxWorkAppGroupMessageAPI)
+	if req.Field("chatid") != "chat-qa" || req.Field("text.content") != "hello, master" || req.Field("touser") != nil ||
+		req.Field("agentid") != nil || req.Field("enable_id_trans") != nil || req.Field("safe") == nil {
+		t.Fatalf("unexpected redirected message %s", req.Body)
+	}
+	if err := app.SendGroupTextMessage(chatID, "hello, master", nil); err != nil {
+		t.Fatal(err)
+	}
+	expectFields(t, server, WxWorkAppGroupMessageAPI, map[string]interface{}{"chatid": "chat-qa"})
+
+	_, err := app.SendTaskCardMessage(userIDList, nil, nil, "task123", "我要请假", "回家休息", "https://oschina.net", nil, nil)
+	if !errors.Is(err, chatapi.ErrRecipientBlocked) {
+		t.Fatalf("expect the task card not redirected, got %v", err)
+	}
+}
+
+func TestWxWorkRobot_RecipientPolicy(t *testing.T) {
+	policy := chatapi.RecipientPolicy{Allowlist: &chatapi.RecipientAllowlist{Webhooks: []string{"qa-key"}}, Banner: "[staging]"}
+	wxRobot, server := newTestWxWorkRobot(t, chatapi.WithRecipientPolicy(&policy))
+	if err := wxRobot.SendTextMessage(key, "hello, master"); !errors.Is(err, chatapi.ErrRecipientBlocked) {
+		t.Fatalf("expect the unlisted webhook blocked, got %v", err)
+	}
+	if err := wxRobot.SendMarkdownMessage("qa-key", "# profile"); err != nil {
+		t.Fatal(err)
+	}
+	expectFields(t, server, WxWorkRobotMessageAPI, map[string]interface{}{"markdown.content": "[staging]\n# profile"})
+
+	policy.RedirectWebhook = "qa-key"
+	if err := wxRobot.SendTextMessage(key, "hello, master"); err != nil {
+		t.Fatal(err)
+	}
+	if req, _ := server.LastRequest(WxWorkRobotMessageAPI); req.Query.Get("key") != "qa-key" {
+		t.Fatalf("expect the message redirected, got key %s", req.Query.Get("key"))
+	}
+}
@@ -4,16 +4,17 @@ package wechat
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/duoland/chatapi"
 )
 
 // WxWorkRobotMessageAPI is the api to send the robot messages
@@ -22,6 +23,15 @@ const WxWorkRobotMessageAPI = "https://qyapi.weixin.qq.com/cgi-bin/webhook/send"
 // WxWorkRobotUploadFileAPI is the api to upload file
 const WxWorkRobotUploadFileAPI = "https://qyapi.weixin.qq.com/cgi-bin/webhook/upload_media"
 
+// wxWorkRobotOperations is the logical operation of each api seen by the interceptors
+var wxWorkRobotOperations = map[string]string{
+	WxWorkRobotMessageAPI:    "WxWorkRobot.SendMessage",
+	WxWorkRobotUploadFileAPI: "WxWorkRobot.UploadFile",
+}
+
+// WxWorkRobotRateLimit is the rate limit of the wxwork group robot, 20 messages per minute for each key
+var WxWorkRobotRateLimit = chatapi.RateLimit{Rate: 20, Period: time.Minute}
+
 // WxWorkRobotTimeout is the wxwork robot default timeout
 const WxWorkRobotTimeout = time.Second * 10
 const WxWorkRobotStatusOK = 0
@@ -87,7 +97,8 @@ type WxWorkRobotFileMessageBody struct {
 
 // WxWorkRobot is a robot to send wxwork messages
 type WxWorkRobot struct {
-	client *http.Client
+	client  *http.Client
+	options chatapi.Options
 }
 
 type WxWorkRobotMessageResp struct {
@@ -104,35 +115,45 @@ type WxWorkRobotUploadFileResp struct {
 }
 
 // NewWxWorkRobot create a new wxwork robot
-func NewWxWorkRobot() *WxWorkRobot {
-	return NewWxWorkRobotWithTimeout(WxWorkRobotTimeout)
+func NewWxWorkRobot(opts ...chatapi.Option) *WxWorkRobot {
+	return NewWxWorkRobotWithTimeout(WxWorkRobotTimeout, opts...)
 }
 
 // NewWxWorkRobotWithTimeout create a new wxwork robot with timeout
-func NewWxWorkRobotWithTimeout(timeout time.Duration) *WxWorkRobot {
+func NewWxWorkRobotWithTimeout(timeout time.Duration, opts ...chatapi.Option) *WxWorkRobot {
 	client := http.Client{}
 	client.Timeout = timeout
-	return &WxWorkRobot{client: &client}
+	return NewWxWorkRobotWithClient(&client, opts...)
 }
 
 // NewWxWorkRobotWithClient create a new wxwork robot with http.Client
-func NewWxWorkRobotWithClient(client *http.Client) *WxWorkRobot {
-	return &WxWorkRobot{client: client}
+func NewWxWorkRobotWithClient(client *http.Client, opts ...chatapi.Option) *WxWorkRobot {
+	return &WxWorkRobot{client: client, options: chatapi.NewOptions(opts...)}
 }
 
 // SendTextMessage send the text message
 func (r *WxWorkRobot) SendTextMessage(key, text string) (err error) {
+	return r.SendTextMessageCtx(context.Background(), key, text)
+}
+
+// SendTextMessageCtx is SendTextMessage with the context to control the request
+func (r *WxWorkRobot) SendTextMessageCtx(ctx context.Context, key, text string) (err error) {
 	textMessage := WxWorkRobotTextMessage{
 		MessageType: WxWorkRobotMessageTypeText,
 		MessageBody: WxWorkRobotTextMessageBody{
 			Content: text,
 		},
 	}
-	return r.sendMessage(key, &textMessage)
+	return r.sendMessage(ctx, key, &textMessage)
 }
 
 // SendTextMessage send the text message with specified mentioned users
 func (r *WxWorkRobot) SendTextMessageWithMention(key, content string, mentionedList []string, mentionedMobileList []string) (err error) {
+	return r.SendTextMessageWithMentionCtx(context.Background(), key, content, mentionedList, mentionedMobileList)
+}
+
+// SendTextMessageWithMentionCtx is SendTextMessageWithMention with the context to control the request
+func (r *WxWorkRobot) SendTextMessageWithMentionCtx(ctx context.Context, key, content string, mentionedList []string, mentionedMobileList []string) (err error) {
 	textMessage := WxWorkRobotTextMessage{
 		MessageType: WxWorkRobotMessageTypeText,
 		MessageBody: WxWorkRobotTextMessageBody{
@@ -141,22 +162,32 @@ func (r *WxWorkRobot) SendTextMessageWithMention(key, content string, mentionedL
 			MentionedMobileList: mentionedMobileList,
 		},
 	}
-	return r.sendMessage(key, &textMessage)
+	return r.sendMessage(ctx, key, &textMessage)
 }
 
 // SendMarkdownMessage send the markdown message
 func (r *WxWorkRobot) SendMarkdownMessage(key, content string) (err error) {
+	return r.SendMarkdownMessageCtx(context.Background(), key, content)
+}
+
+// SendMarkdownMessageCtx is SendMarkdownMessage with the context to control the request
+func (r *WxWorkRobot) SendMarkdownMessageCtx(ctx context.Context, key, content string) (err error) {
 	markdownMessage := WxWorkRobotMarkdownMessage{
 		MessageType: WxWorkRobotMessageTypeMarkdown,
 		MessageBody: WxWorkRobotMarkdownMessageBody{
 			Content: content,
 		},
 	}
-	return r.sendMessage(key, &markdownMessage)
+	return r.sendMessage(ctx, key, &markdownMessage)
 }
 
 // SendMarkdownMessageWithMention send the markdown message with specified mentioned users
 func (r *WxWorkRobot) SendMarkdownMessageWithMention(key, content string, mentionedList []string, mentionedMobileList []string) (err error) {
+	return r.SendMarkdownMessageWithMentionCtx(context.Background(), key, content, mentionedList, mentionedMobileList)
+}
+
+// SendMarkdownMessageWithMentionCtx is SendMarkdownMessageWithMention with the context to control the request
+func (r *WxWorkRobot) SendMarkdownMessageWithMentionCtx(ctx context.Context, key, content string, mentionedList []string, mentionedMobileList []string) (err error) {
 	markdownMessage := WxWorkRobotMarkdownMessage{
 		MessageType: WxWorkRobotMessageTypeMarkdown,
 		MessageBody: WxWorkRobotMarkdownMessageBody{
@@ -165,11 +196,16 @@ func (r *WxWorkRobot) SendMarkdownMessageWithMention(key, content string, mentio
 			MentionedMobileList: mentionedMobileList,
 		},
 	}
-	return r.sendMessage(key, &markdownMessage)
+	return r.sendMessage(ctx, key, &markdownMessage)
 }
 
 // SendImageMessage send the markdown message
 func (r *WxWorkRobot) SendImageMessage(key string, imageData []byte) (err error) {
+	return r.SendImageMessageCtx(context.Background(), key, imageData)
+}
+
+// SendImageMessageCtx is SendImageMessage with the context to control the request
+func (r *WxWorkRobot) SendImageMessageCtx(ctx context.Context, key string, imageData []byte) (err error) {
 	imageHash := md5.Sum(imageData)
 	imageMessage := WxWorkRobotImagMessage{
 		MessageType: WxWorkRobotMessageTypeImage,
@@ -178,116 +214,135 @@ func (r *WxWorkRobot) SendImageMessage(key string, imageData []byte) (err error)
 			MD5:    fmt.Sprintf("%x", imageHash),
 		},
 	}
-	return r.sendMessage(key, &imageMessage)
+	return r.sendMessage(ctx, key, &imageMessage)
 }
 
 // SendNewsMessage send the news message
 func (r *WxWorkRobot) SendNewsMessage(key string, articles []WxWorkRobotNewsMessageArticle) (err error) {
+	return r.SendNewsMessageCtx(context.Background(), key, articles)
+}
+
+// SendNewsMessageCtx is SendNewsMessage with the context to control the request
+func (r *WxWorkRobot) SendNewsMessageCtx(ctx context.Context, key string, articles []WxWorkRobotNewsMessageArticle) (err error) {
 	newsMessage := WxWorkRobotNewsMessage{
 		MessageType: WxWorkRobotMessageTypeNews,
 		MessageBody: WxWorkRobotNewsMessageBody{
 			Articles: articles,
 		},
 	}
-	return r.sendMessage(key, &newsMessage)
+	return r.sendMessage(ctx, key, &newsMessage)
 }
 
 // SendFileMessage send the file message
 func (r *WxWorkRobot) SendFileMessage(key, mediaID string) (err error) {
+	return r.SendFileMessageCtx(context.Background(), key, mediaID)
+}
+
+// SendFileMessageCtx is SendFileMessage with the context to control the request
+func (r *WxWorkRobot) SendFileMessageCtx(ctx context.Context, key, mediaID string) (err error) {
 	fileMessage := WxWorkRobotFileMessage{
 		MessageType: WxWorkRobotMessageTypeFile,
 		MessageBody: WxWorkRobotFileMessageBody{
 			MediaID: mediaID,
 		},
 	}
-	return r.sendMessage(key, &fileMessage)
+	return r.sendMessage(ctx, key, &fileMessage)
 }
 
-func (r *WxWorkRobot) sendMessage(key string, messageObj interface{}) (err error) {
-	reqURL := fmt.Sprintf("%s?key=%s", WxWorkRobotMessageAPI, key)
-	reqBody, _ := json.Marshal(messageObj)
-
-	req, newErr := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(reqBody))
-	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
-		return
+// sendMessage send the message, or its parts in order when the long messages are split
+func (r *WxWorkRobot) sendMessage(ctx context.Context, key string, messageObj interface{}) (err error) {
+	var parts []interface{}
+	switch {
+	case r.options.SplitLongMessages:
+		parts = splitWxWorkRobotMessage(r.options.RecipientPolicy, messageObj)
+	case r.options.TruncateLongMessages:
+		truncateWxWorkRobotMessage(&r.options, messageObj)
 	}
-	req.Header.Add("Content-Type", "application/json")
-	resp, getErr := r.client.Do(req)
-	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
-		return
+	if len(parts) == 0 {
+		return r.sendSingleMessage(ctx, key, messageObj)
 	}
-	defer resp.Body.Close()
-	// check http code
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("wxwork request error, %s", resp.Status)
-		io.Copy(ioutil.Discard, resp.Body)
-		return
+	for i, part := range parts {
+		if partErr := r.sendSingleMessage(ctx, key, part); partErr != nil {
+			err = fmt.Errorf("send part %d/%d error, %w", i+1, len(parts), partErr)
+			return
+		}
 	}
-	// parse response body
-	decoder := json.NewDecoder(resp.Body)
-	var wxMessageResp WxWorkRobotMessageResp
-	if decodeErr := decoder.Decode(&wxMessageResp); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
+	return
+}
+
+// sendSingleMessage post the message under the rate limit of the webhook and retry it by the retry policy, the message is only resent
+// when the platform rejected it without handling, e.g. by the rate limits
+func (r *WxWorkRobot) sendSingleMessage(ctx context.Context, key string, messageObj interface{}) (err error) {
+	if key, err = wxWorkRobotKey(r.options.RecipientPolicy, key); err != nil {
 		return
 	}
-	if wxMessageResp.ErrCode != WxWorkRobotStatusOK {
-		err = fmt.Errorf("call wxwork robot api error, %d %s", wxMessageResp.ErrCode, wxMessageResp.ErrMessage)
+	if r.options.RecipientPolicy != nil {
+		applyWxWorkRobotBanner(r.options.RecipientPolicy, messageObj)
+	}
+	if !r.options.SkipValidation {
+		if err = validateWxWorkRobotMessage(messageObj); err != nil {
+			return
+		}
+	}
+	return r.options.Retry.Do(ctx, false, func() error {
+		if err := r.options.RateLimiter.Wait(ctx, chatapi.ProviderWxWork+":robot:"+key); err != nil {
+			return err
+		}
+		return r.postMessage(ctx, key, messageObj)
+	})
+}
+
+func (r *WxWorkRobot) postMessage(ctx context.Context, key string, messageObj interface{}) (err error) {
+	reqURL := fmt.Sprintf("%s?key=%s", r.options.URL(WxWorkRobotMessageAPI), key)
+	reqBody, _ := json.Marshal(messageObj)
+
+	req, newErr := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(reqBody))
+	if newErr != nil {
+		err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
 		return
 	}
+	req.Header.Add("Content-Type", "application/json")
+	var wxMessageResp WxWorkRobotMessageResp
+	err = r.invoke(req, WxWorkRobotMessageAPI, messageObj, &wxMessageResp)
 	return
 }
 
 // UploadFile upload the media file
 func (r *WxWorkRobot) UploadFile(key string, fileBody []byte, fileName string) (mediaID string, createdAt int64, err error) {
+	return r.UploadFileCtx(context.Background(), key, fileBody, fileName)
+}
+
+// UploadFileCtx is UploadFile with the context to control the request
+func (r *WxWorkRobot) UploadFileCtx(ctx context.Context, key string, fileBody []byte, fileName string) (mediaID string, createdAt int64, err error) {
+	// the media is uploaded to the webhook the messages are sent to
+	if key, err = wxWorkRobotKey(r.options.RecipientPolicy, key); err != nil {
+		return
+	}
 	respBodyBuffer := bytes.NewBuffer(nil)
 	defer respBodyBuffer.Reset()
 	multipartWriter := multipart.NewWriter(respBodyBuffer)
 	// add form data
 	formFileWriter, createErr := multipartWriter.CreateFormFile("media", fileName)
 	if createErr != nil {
-		err = fmt.Errorf("create form file error, %s", createErr.Error())
+		err = fmt.Errorf("create form file error, %w", createErr)
 		return
 	}
 	if _, writeErr := formFileWriter.Write(fileBody); writeErr != nil {
-		err = fmt.Errorf("write form file error, %s", writeErr.Error())
+		err = fmt.Errorf("write form file error, %w", writeErr)
 		return
 	}
 	if closeErr := multipartWriter.Close(); closeErr != nil {
-		err = fmt.Errorf("close form file error, %s", closeErr.Error())
+		err = fmt.Errorf("close form file error, %w", closeErr)
 		return
 	}
 
-	reqURL := fmt.Sprintf("%s?key=%s&type=%s", WxWorkRobotUploadFileAPI, key, WxWorkRobotMessageTypeFile)
-	req, newErr := http.NewRequest(http.MethodPost, reqURL, respBodyBuffer)
-	if newErr != nil {
-		err = fmt.Errorf("create request error, %s", newErr.Error())
-		return
-	}
-	// set multi-part header
-	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
-	resp, getErr := r.client.Do(req)
-	if getErr != nil {
-		err = fmt.Errorf("get response error, %s", getErr.Error())
-		return
-	}
-	defer resp.Body.Close()
-	// check http code
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("wxwork robot request error, %s", resp.Status)
-		io.Copy(ioutil.Discard, resp.Body)
-		return
-	}
-	// parse response body
-	decoder := json.NewDecoder(resp.Body)
+	// a repeated upload only creates another media, so it is retried as an idempotent call
+	upload := chatapi.FileUpload{FieldName: "media", FileName: fileName, Size: len(fileBody)}
 	var wxUploadFileResp WxWorkRobotUploadFileResp
-	if decodeErr := decoder.Decode(&wxUploadFileResp); decodeErr != nil {
-		err = fmt.Errorf("parse response error, %s", decodeErr.Error())
-		return
-	}
-	if wxUploadFileResp.ErrCode != WxWorkRobotStatusOK {
-		err = fmt.Errorf("call wxwork robot api error, %d %s", wxUploadFileResp.ErrCode, wxUploadFileResp.ErrMessage)
+	err = r.options.Retry.Do(ctx, true, func() error {
+		return r.postFile(ctx, key, respBodyBuffer.Bytes(), multipartWriter.FormDataContentType(), &upload, &wxUploadFileResp)
+	})
+	if err != nil {
 		return
 	}
 
@@ -296,3 +351,29 @@ func (r *WxWorkRobot) UploadFile(key string, fileBody []byte, fileName string) (
 	createdAt, _ = strconv.ParseInt(wxUploadFileResp.CreatedAt, 10, 64)
 	return
 }
+
+func (r *WxWorkRobot) postFile(ctx context.Context, key string, reqBody []byte, contentType string, upload *chatapi.FileUpload,
+	wxUploadFileResp *WxWorkRobotUploadFileResp) (err error) {
+	reqURL := fmt.Sprintf("%s?key=%s&type=%s", r.options.URL(WxWorkRobotUploadFileAPI), key, WxWorkRobotMessageTypeFile)
+	req, newErr := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(reqBody))
+	if newErr != nil {
+		err = fmt.Errorf("create request error, %w", chatapi.RedactError(newErr))
+		return
+	}
+	// set multi-part header
+	req.Header.Set("Content-Type", contentType)
+	err = r.invoke(req, WxWorkRobotUploadFileAPI, upload, wxUploadFileResp)
+	return
+}
+
+// invoke send the request through the interceptors and decode the response into respObject
+func (r *WxWorkRobot) invoke(req *http.Request, endpoint string, reqObject, respObject interface{}) error {
+	call := chatapi.Call{Provider: chatapi.ProviderWxWork, Operation: wxWorkRobotOperations[endpoint], Endpoint: endpoint,
+		Header: req.Header, Request: reqObject, Response: respObject}
+	return chatapi.Intercept(req.Context(), r.options.Interceptors, &call, func(ctx context.Context, call *chatapi.Call) error {
+		if r.options.DryRun != nil {
+			return chatapi.DryRun(r.options.DryRun, call, req, wxWorkDryRunResp)
+		}
+		return doWxWorkRequest(r.client, req.WithContext(ctx), endpoint, respObject)
+	})
+}
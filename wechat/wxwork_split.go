@@ -0,0 +1,98 @@
+package wechat
+
+import (
+	"strings"
+
+	"github.com/duoland/chatapi"
+)
+
+// wxWorkSplitter returns the splitter of the content limit, leaving room for the banner of the policy
+func wxWorkSplitter(policy *chatapi.RecipientPolicy, maxBytes int, markdown bool) chatapi.Splitter {
+	splitter := chatapi.Splitter{MaxBytes: maxBytes, Markdown: markdown}
+	if policy != nil {
+		splitter = splitter.Reserve(policy.WithBanner(""))
+	}
+	return splitter
+}
+
+// splitWxWorkAppMessage returns the messages of the parts of the long text and markdown app message, nil if it fits
+func splitWxWorkAppMessage(policy *chatapi.RecipientPolicy, messageObj interface{}) (parts []interface{}) {
+	message, ok := messageObj.(*map[string]interface{})
+	if !ok {
+		return
+	}
+	msgType, _ := (*message)["msgtype"].(string)
+	var splitter chatapi.Splitter
+	switch msgType {
+	case WxWorkAppMessageTypeText:
+		splitter = wxWorkSplitter(policy, WxWorkTextMaxBytes, false)
+	case WxWorkAppMessageTypeMarkdown:
+		splitter = wxWorkSplitter(policy, WxWorkAppMarkdownMaxBytes, true)
+	default:
+		return
+	}
+	body, ok := (*message)[msgType].(map[string]string)
+	if !ok {
+		return
+	}
+	contents := splitter.Split(body["content"])
+	if len(contents) < 2 {
+		return
+	}
+	for _, content := range contents {
+		partMessage := make(map[string]interface{}, len(*message))
+		for key, value := range *message {
+			partMessage[key] = value
+		}
+		partMessage[msgType] = map[string]string{"content": content}
+		parts = append(parts, &partMessage)
+	}
+	return
+}
+
+// splitWxWorkRobotMessage returns the messages of the parts of the long text and markdown robot message, nil if it fits.
+// The mentions are kept in the first part only.
+func splitWxWorkRobotMessage(policy *chatapi.RecipientPolicy, messageObj interface{}) (parts []interface{}) {
+	switch message := messageObj.(type) {
+	case *WxWorkRobotTextMessage:
+		contents := wxWorkSplitter(policy, WxWorkTextMaxBytes, false).Split(message.MessageBody.Content)
+		if len(contents) < 2 {
+			return
+		}
+		for i, content := range contents {
+			partMessage := WxWorkRobotTextMessage{MessageType: message.MessageType, MessageBody: WxWorkRobotTextMessageBody{Content: content}}
+			if i == 0 {
+				partMessage.MessageBody.MentionedList = message.MessageBody.MentionedList
+				partMessage.MessageBody.MentionedMobileList = message.MessageBody.MentionedMobileList
+			}
+			parts = append(parts, &partMessage)
+		}
+	case *WxWorkRobotMarkdownMessage:
+		contents := wxWorkSplitter(policy, WxWorkRobotMarkdownMaxBytes, true).Split(message.MessageBody.Content)
+		if len(contents) < 2 {
+			return
+		}
+		for i, content := range contents {
+			partMessage := WxWorkRobotMarkdownMessage{MessageType: message.MessageType, MessageBody: WxWorkRobotMarkdownMessageBody{Content: content}}
+			if i == 0 {
+				partMessage.MessageBody.MentionedList = message.MessageBody.MentionedList
+				partMessage.MessageBody.MentionedMobileList = message.MessageBody.MentionedMobileList
+			}
+			parts = append(parts, &partMessage)
+		}
+	}
+	return
+}
+
+// mergeWxWorkRecipients returns the union of the recipient lists joined by "|", in the order first seen
+func mergeWxWorkRecipients(recipients, more string) string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, recipient := range strings.Split(recipients+"|"+more, "|") {
+		if recipient != "" && !seen[recipient] {
+			seen[recipient] = true
+			merged = append(merged, recipient)
+		}
+	}
+	return strings.Join(merged, "|")
+}
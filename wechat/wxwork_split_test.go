@@ -0,0 +1,106 @@
+package wechat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/duoland/chatapi"
+	"github.com/duoland/chatapi/chatapitest"
+)
+
+// wxWorkContents returns the contents of the messages in the requests in order
+func wxWorkContents(t *testing.T, requests []chatapitest.Request, msgType string) (contents []string) {
+	t.Helper()
+	for _, req := range requests {
+		body, _ := req.JSON()[msgType].(map[string]interface{})
+		content, _ := body["content"].(string)
+		contents = append(contents, content)
+	}
+	return
+}
+
+func TestWxWorkApp_SplitLongMessages(t *testing.T) {
+	content := strings.TrimSuffix(strings.Repeat("构建日志的一行\n", 200), "\n")
+	app, server := newTestWxWorkApp(t)
+	if _, err := app.SendTextMessage(userIDList, nil, nil, content, nil); !errors.Is(err, chatapi.ErrInvalidMessage) {
+		t.Fatalf("expect the long message rejected without the split, got %v", err)
+	}
+
+	app, server = newTestWxWorkApp(t, chatapi.WithSplit())
+	if _, err := app.SendMarkdownMessage(userIDList, nil, nil, content, nil); err != nil {
+		t.Fatal(err)
+	}
+	requests := server.RequestsTo(WxWorkAppMessageAPI)
+	contents := wxWorkContents(t, requests, WxWorkAppMessageTypeMarkdown)
+	if len(contents) != 3 {
+		t.Fatalf("expect the message sent in 3 parts, got %d", len(contents))
+	}
+	var lines []string
+	for i, partContent := range contents {
+		marker := fmt.Sprintf("\n(%d/3)", i+1)
+		if len(partContent) > WxWorkAppMarkdownMaxBytes || !strings.HasSuffix(partContent, marker) {
+			t.Fatalf("unexpected part %d %q", i+1, partContent)
+		}
+		lines = append(lines, strings.TrimSuffix(partContent, marker))
+	}
+	if strings.Join(lines, "\n") != content {
+		t.Fatal("expect the parts joined to the content")
+	}
+	for _, req := range requests {
+		if body := req.JSON(); body["touser"] != strings.Join(userIDList, "|") || body["agentid"] != agentID {
+			t.Fatalf("expect every part sent to the same users, got %s", req.Body)
+		}
+	}
+
+	if err := app.SendGroupTextMessage(chatID, content, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range server.RequestsTo(WxWorkAppGroupMessageAPI) {
+		if req.JSON()["chatid"] != chatID {
+			t.Fatalf("expect every part sent to the same chat, got %s", req.Body)
+		}
+	}
+	if count := len(server.RequestsTo(WxWorkAppGroupMessageAPI)); count != 3 {
+		t.Fatalf("expect the text sent in 3 parts, got %d", count)
+	}
+}
+
+func TestWxWorkApp_SplitWithBanner(t *testing.T) {
+	content := strings.Repeat("a", WxWorkTextMaxBytes)
+	app, server := newTestWxWorkApp(t, chatapi.WithSplit(), chatapi.WithRecipientPolicy(&chatapi.RecipientPolicy{Banner: "[test]"}))
+	if _, err := app.SendTextMessage(userIDList, nil, nil, content, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, partContent := range wxWorkContents(t, server.RequestsTo(WxWorkAppMessageAPI), WxWorkAppMessageTypeText) {
+		if !strings.HasPrefix(partContent, "[test]\n") || len(partContent) > WxWorkTextMaxBytes {
+			t.Fatalf("expect the banner on every part within the limit, got %d bytes", len(partContent))
+		}
+	}
+}
+
+func TestWxWorkRobot_SplitLongMessages(t *testing.T) {
+	wxRobot, server := newTestWxWorkRobot(t, chatapi.WithSplit())
+	content := strings.Repeat("word ", WxWorkTextMaxBytes/5+1)
+	if err := wxRobot.SendTextMessageWithMention(key, content, []string{"@all"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	requests := server.RequestsTo(WxWorkRobotMessageAPI)
+	if len(requests) != 2 || requests[0].Query.Get("key") != key || requests[1].Query.Get("key") != key {
+		t.Fatalf("expect the message sent in 2 parts to the same webhook, got %d", len(requests))
+	}
+	var first, second WxWorkRobotTextMessage
+	if err := requests[0].Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests[1].Decode(&second); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(first.MessageBody.Content, "\n(1/2)") || !strings.HasSuffix(second.MessageBody.Content, "\n(2/2)") {
+		t.Fatalf("unexpected parts %q, %q", first.MessageBody.Content, second.MessageBody.Content)
+	}
+	if len(first.MessageBody.MentionedList) != 1 || second.MessageBody.MentionedList != nil {
+		t.Fatal("expect the mentions in the first part only")
+	}
+}
@@ -0,0 +1,89 @@
+package wechat
+
+import (
+	"github.com/duoland/chatapi"
+)
+
+// WxWorkAppTruncator returns the truncator of the content limit of the app message type, false for the types without
+// a content limit, e.g. the description of the text card keeps its own limit
+func WxWorkAppTruncator(messageType, moreURL string) (truncator chatapi.Truncator, ok bool) {
+	switch messageType {
+	case WxWorkAppMessageTypeText:
+		return chatapi.Truncator{MaxBytes: WxWorkTextMaxBytes, MoreURL: moreURL}, true
+	case WxWorkAppMessageTypeMarkdown:
+		return chatapi.Truncator{MaxBytes: WxWorkAppMarkdownMaxBytes, Markdown: true, MoreURL: moreURL}, true
+	case WxWorkAppMessageTypeTextCard:
+		return chatapi.Truncator{MaxBytes: WxWorkDescriptionMaxBytes, MoreURL: moreURL}, true
+	}
+	return
+}
+
+// WxWorkRobotTruncator returns the truncator of the content limit of the robot message type, false for the types without
+// a content limit
+func WxWorkRobotTruncator(messageType, moreURL string) (truncator chatapi.Truncator, ok bool) {
+	switch messageType {
+	case WxWorkRobotMessageTypeText:
+		return chatapi.Truncator{MaxBytes: WxWorkTextMaxBytes, MoreURL: moreURL}, true
+	case WxWorkRobotMessageTypeMarkdown:
+		return chatapi.Truncator{MaxBytes: WxWorkRobotMarkdownMaxBytes, Markdown: true, MoreURL: moreURL}, true
+	}
+	return
+}
+
+// TruncateWxWorkAppContent cut the content of the app message to the byte limit of the message type,
+// the content of the types without a content limit is returned as is
+func TruncateWxWorkAppContent(messageType, content, moreURL string) string {
+	if truncator, ok := WxWorkAppTruncator(messageType, moreURL); ok {
+		return truncator.Truncate(content)
+	}
+	return content
+}
+
+// TruncateWxWorkRobotContent cut the content of the robot message to the byte limit of the message type,
+// the content of the types without a content limit is returned as is
+func TruncateWxWorkRobotContent(messageType, content, moreURL string) string {
+	if truncator, ok := WxWorkRobotTruncator(messageType, moreURL); ok {
+		return truncator.Truncate(content)
+	}
+	return content
+}
+
+// truncateWxWorkAppMessage cut the content of the text and markdown app message to the limit, leaving room for the banner of the policy
+func truncateWxWorkAppMessage(options *chatapi.Options, messageObj interface{}) {
+	message, ok := messageObj.(*map[string]interface{})
+	if !ok {
+		return
+	}
+	msgType, _ := (*message)["msgtype"].(string)
+	if msgType != WxWorkAppMessageTypeText && msgType != WxWorkAppMessageTypeMarkdown {
+		return
+	}
+	body, ok := (*message)[msgType].(map[string]string)
+	if !ok {
+		return
+	}
+	truncator, _ := WxWorkAppTruncator(msgType, options.TruncateMoreURL)
+	if options.RecipientPolicy != nil {
+		truncator = truncator.Reserve(options.RecipientPolicy.WithBanner(""))
+	}
+	body["content"] = truncator.Truncate(body["content"])
+}
+
+// truncateWxWorkRobotMessage cut the content of the text and markdown robot message to the limit, leaving room for the banner of the policy
+func truncateWxWorkRobotMessage(options *chatapi.Options, messageObj interface{}) {
+	var msgType string
+	var content *string
+	switch message := messageObj.(type) {
+	case *WxWorkRobotTextMessage:
+		msgType, content = message.MessageType, &message.MessageBody.Content
+	case *WxWorkRobotMarkdownMessage:
+		msgType, content = message.MessageType, &message.MessageBody.Content
+	default:
+		return
+	}
+	truncator, _ := WxWorkRobotTruncator(msgType, options.TruncateMoreURL)
+	if options.RecipientPolicy != nil {
+		truncator = truncator.Reserve(options.RecipientPolicy.WithBanner(""))
+	}
+	*content = truncator.Truncate(*content)
+}
@@ -0,0 +1,46 @@
+package wechat
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/duoland/chatapi"
+)
+
+func TestTruncateWxWorkContent(t *testing.T) {
+	content := strings.Repeat("中文", WxWorkTextMaxBytes)
+	if truncated := TruncateWxWorkAppContent(WxWorkAppMessageTypeText, content, ""); len(truncated) > WxWorkTextMaxBytes ||
+		!utf8.ValidString(truncated) || !strings.HasSuffix(truncated, chatapi.TruncateEllipsis) {
+		t.Fatalf("unexpected truncated text of %d bytes", len(truncated))
+	}
+	if truncated := TruncateWxWorkRobotContent(WxWorkRobotMessageTypeMarkdown, content, ""); len(truncated) > WxWorkRobotMarkdownMaxBytes ||
+		len(truncated) <= WxWorkAppMarkdownMaxBytes {
+		t.Fatalf("expect the robot markdown cut to its own limit, got %d bytes", len(truncated))
+	}
+	if truncated := TruncateWxWorkAppContent(WxWorkAppMessageTypeImage, content, ""); truncated != content {
+		t.Fatal("expect the content of the type without a limit kept")
+	}
+}
+
+func TestWxWorkRobot_TruncateLongMessages(t *testing.T) {
+	wxRobot, server := newTestWxWorkRobot(t, chatapi.WithTruncation("https://ci.example.com/builds/1"),
+		chatapi.WithRecipientPolicy(&chatapi.RecipientPolicy{Banner: "[test]"}))
+	content := "# 构建日志\n```\n" + strings.Repeat("第一步完成\n", 400) + "```"
+	if err := wxRobot.SendMarkdownMessage(key, content); err != nil {
+		t.Fatal(err)
+	}
+	requests := server.RequestsTo(WxWorkRobotMessageAPI)
+	if len(requests) != 1 {
+		t.Fatalf("expect the message sent once, got %d", len(requests))
+	}
+	var message WxWorkRobotMarkdownMessage
+	if err := requests[0].Decode(&message); err != nil {
+		t.Fatal(err)
+	}
+	truncated := message.MessageBody.Content
+	if len(truncated) > WxWorkRobotMarkdownMaxBytes || !utf8.ValidString(truncated) || !strings.HasPrefix(truncated, "[test]\n# 构建日志") ||
+		!strings.HasSuffix(truncated, "\n```…\n[view more](https://ci.example.com/builds/1)") {
+		t.Fatalf("unexpected truncated content of %d bytes, %q", len(truncated), truncated[len(truncated)-80:])
+	}
+}
@@ -0,0 +1,136 @@
+package wechat
+
+import (
+	"github.com/duoland/chatapi"
+)
+
+// The limits of the wxwork messages, see doc https://work.weixin.qq.com/api/doc/90000/90135/90236
+const (
+	WxWorkTextMaxBytes          = 2048
+	WxWorkAppMarkdownMaxBytes   = 2048
+	WxWorkRobotMarkdownMaxBytes = 4096
+	WxWorkTitleMaxBytes         = 128
+	WxWorkDescriptionMaxBytes   = 512
+	WxWorkURLMaxBytes           = 2048
+	WxWorkNewsMaxArticles       = 8
+	WxWorkMpNewsMaxContentBytes = 666 * 1024
+	WxWorkTaskCardMaxButtons    = 2
+	WxWorkNoticeMaxItems        = 10
+)
+
+// wxWorkContentRules is the rules of the message content shared by the app messages to the users and to the group chats
+var wxWorkContentRules = map[string][]chatapi.FieldRule{
+	WxWorkAppMessageTypeText: {
+		{Field: "text.content", Required: true, MaxBytes: WxWorkTextMaxBytes},
+	},
+	WxWorkAppMessageTypeMarkdown: {
+		{Field: "markdown.content", Required: true, MaxBytes: WxWorkAppMarkdownMaxBytes},
+	},
+	WxWorkAppMessageTypeImage: {{Field: "image.media_id", Required: true}},
+	WxWorkAppMessageTypeVoice: {{Field: "voice.media_id", Required: true}},
+	WxWorkAppMessageTypeFile:  {{Field: "file.media_id", Required: true}},
+	WxWorkAppMessageTypeVideo: {
+		{Field: "video.media_id", Required: true},
+		{Field: "video.title", MaxBytes: WxWorkTitleMaxBytes},
+		{Field: "video.description", MaxBytes: WxWorkDescriptionMaxBytes},
+	},
+	WxWorkAppMessageTypeTextCard: {
+		{Field: "textcard.title", Required: true, MaxBytes: WxWorkTitleMaxBytes},
+		{Field: "textcard.description", Required: true, MaxBytes: WxWorkDescriptionMaxBytes},
+		{Field: "textcard.url", Required: true, MaxBytes: WxWorkURLMaxBytes, URL: true},
+	},
+	WxWorkAppMessageTypeNews: {
+		{Field: "news.articles", Required: true, MaxItems: WxWorkNewsMaxArticles},
+		{Field: "news.articles.*.title", Required: true, MaxBytes: WxWorkTitleMaxBytes},
+		{Field: "news.articles.*.description", MaxBytes: WxWorkDescriptionMaxBytes},
+		{Field: "news.articles.*.url", MaxBytes: WxWorkURLMaxBytes, URL: true},
+		{Field: "news.articles.*.picurl", MaxBytes: WxWorkURLMaxBytes, URL: true},
+	},
+	WxWorkAppMessageTypeMpNews: {
+		{Field: "mpnews.articles", Required: true, MaxItems: WxWorkNewsMaxArticles},
+		{Field: "mpnews.articles.*.title", Required: true, MaxBytes: WxWorkTitleMaxBytes},
+		{Field: "mpnews.articles.*.thumb_media_id", Required: true},
+		{Field: "mpnews.articles.*.content", Required: true, MaxBytes: WxWorkMpNewsMaxContentBytes},
+		{Field: "mpnews.articles.*.digest", MaxBytes: WxWorkDescriptionMaxBytes},
+		{Field: "mpnews.articles.*.content_source_url", URL: true},
+	},
+	WxWorkAppMessageTypeMiniProgramNotice: {
+		{Field: "miniprogram_notice.appid", Required: true},
+		{Field: "miniprogram_notice.title", Required: true},
+		{Field: "miniprogram_notice.content_item", MaxItems: WxWorkNoticeMaxItems},
+	},
+	WxWorkAppMessageTypeTaskCard: {
+		{Field: "taskcard.task_id", Required: true, MaxBytes: WxWorkTitleMaxBytes},
+		{Field: "taskcard.title", Required: true, MaxBytes: WxWorkTitleMaxBytes},
+		{Field: "taskcard.description", Required: true, MaxBytes: WxWorkDescriptionMaxBytes},
+		{Field: "taskcard.url", URL: true},
+		{Field: "taskcard.btn", Required: true, MaxItems: WxWorkTaskCardMaxButtons},
+		{Field: "taskcard.btn.*.key", Required: true},
+		{Field: "taskcard.btn.*.name", Required: true},
+	},
+}
+
+// wxWorkAppRecipientRules is the rules of the recipients of the app messages to the users
+var wxWorkAppRecipientRules = []chatapi.FieldRule{
+	{Field: "touser", Required: true, Alternatives: []string{"toparty", "totag"}},
+}
+
+// wxWorkGroupRules is the rules of the chat of the app group messages
+var wxWorkGroupRules = []chatapi.FieldRule{
+	{Field: "chatid", Required: true},
+}
+
+// wxWorkRobotRules is the rules of the robot messages
+var wxWorkRobotRules = map[string][]chatapi.FieldRule{
+	WxWorkRobotMessageTypeText: {
+		{Field: "text.content", Required: true, MaxBytes: WxWorkTextMaxBytes},
+	},
+	WxWorkRobotMessageTypeMarkdown: {
+		{Field: "markdown.content", Required: true, MaxBytes: WxWorkRobotMarkdownMaxBytes},
+	},
+	WxWorkRobotMessageTypeImage: {
+		{Field: "image.base64", Required: true},
+		{Field: "image.md5", Required: true},
+	},
+	WxWorkRobotMessageTypeNews: {
+		{Field: "news.articles", Required: true, MaxItems: WxWorkNewsMaxArticles},
+		{Field: "news.articles.*.title", Required: true, MaxBytes: WxWorkTitleMaxBytes},
+		{Field: "news.articles.*.description", MaxBytes: WxWorkDescriptionMaxBytes},
+		{Field: "news.articles.*.url", Required: true, MaxBytes: WxWorkURLMaxBytes, URL: true},
+		{Field: "news.articles.*.picurl", URL: true},
+	},
+	WxWorkRobotMessageTypeFile: {{Field: "file.media_id", Required: true}},
+}
+
+// validateWxWorkAppMessage check the app message to the users or to the group chat against the limits of wxwork
+func validateWxWorkAppMessage(messageObj interface{}, groupMessage bool) error {
+	message, ok := messageObj.(*map[string]interface{})
+	if !ok {
+		return nil
+	}
+	msgType, _ := (*message)["msgtype"].(string)
+	rules := wxWorkAppRecipientRules
+	if groupMessage {
+		rules = wxWorkGroupRules
+	}
+	rules = append(append([]chatapi.FieldRule(nil), rules...), wxWorkContentRules[msgType]...)
+	return chatapi.ValidateMessage(chatapi.ProviderWxWork, msgType, messageObj, rules)
+}
+
+// validateWxWorkRobotMessage check the robot message against the limits of wxwork
+func validateWxWorkRobotMessage(messageObj interface{}) error {
+	var msgType string
+	switch message := messageObj.(type) {
+	case *WxWorkRobotTextMessage:
+		msgType = message.MessageType
+	case *WxWorkRobotMarkdownMessage:
+		msgType = message.MessageType
+	case *WxWorkRobotImagMessage:
+		msgType = message.MessageType
+	case *WxWorkRobotNewsMessage:
+		msgType = message.MessageType
+	case *WxWorkRobotFileMessage:
+		msgType = message.MessageType
+	}
+	return chatapi.ValidateMessage(chatapi.ProviderWxWork, msgType, messageObj, wxWorkRobotRules[msgType])
+}
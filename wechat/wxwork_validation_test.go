@@ -0,0 +1,68 @@
+package wechat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/duoland/chatapi"
+)
+
+// expectFieldError check the err is the validation error of the field and the rule
+func expectFieldError(t *testing.T, err error, field, rule string) {
+	t.Helper()
+	var validationErr *chatapi.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expect the validation error, got %v", err)
+	}
+	for _, fieldErr := range validationErr.Fields {
+		if fieldErr.Field == field && fieldErr.Rule == rule {
+			return
+		}
+	}
+	t.Fatalf("expect %s breaks %s, got %v", field, rule, validationErr)
+}
+
+func TestWxWorkApp_Validation(t *testing.T) {
+	app, server := newTestWxWorkApp(t)
+	_, err := app.SendTextMessage(userIDList, nil, nil, strings.Repeat("人", 700), nil)
+	expectFieldError(t, err, "text.content", chatapi.RuleMaxBytes)
+	_, err = app.SendMarkdownMessage(nil, nil, nil, "# hello", nil)
+	expectFieldError(t, err, "touser", chatapi.RuleRequired)
+	articles := make([]WxWorkAppNewsMessageArticle, 9)
+	for i := range articles {
+		articles[i] = newsArticle
+	}
+	articles[8].URL = "oschina.net"
+	_, err = app.SendNewsMessage(userIDList, nil, nil, articles, nil)
+	expectFieldError(t, err, "news.articles", chatapi.RuleMaxItems)
+	expectFieldError(t, err, "news.articles.8.url", chatapi.RuleURL)
+	err = app.SendGroupTextCardMessage("", "人民", "伟大的人民", "https://oschina.net", "看看", nil)
+	expectFieldError(t, err, "chatid", chatapi.RuleRequired)
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect the invalid messages not sent, got %d requests", len(server.Requests()))
+	}
+
+	app, server = newTestWxWorkApp(t, chatapi.WithoutValidation())
+	if _, err = app.SendTextMessage(userIDList, nil, nil, strings.Repeat("人", 700), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.RequestsTo(WxWorkAppMessageAPI)) != 1 {
+		t.Fatal("expect the message sent without the validation")
+	}
+}
+
+func TestWxWorkRobot_Validation(t *testing.T) {
+	wxRobot, server := newTestWxWorkRobot(t)
+	err := wxRobot.SendMarkdownMessage(key, strings.Repeat("a", WxWorkRobotMarkdownMaxBytes+1))
+	expectFieldError(t, err, "markdown.content", chatapi.RuleMaxBytes)
+	err = wxRobot.SendNewsMessage(key, []WxWorkRobotNewsMessageArticle{{Title: "hello"}})
+	expectFieldError(t, err, "news.articles.0.url", chatapi.RuleRequired)
+	expectFieldError(t, wxRobot.SendFileMessage(key, ""), "file.media_id", chatapi.RuleRequired)
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expect the invalid messages not sent, got %d requests", len(server.Requests()))
+	}
+	if err = wxRobot.SendMarkdownMessage(key, strings.Repeat("a", WxWorkRobotMarkdownMaxBytes)); err != nil {
+		t.Fatal(err)
+	}
+}